@@ -0,0 +1,31 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+)
+
+// verifyAudit checks that no audit event was changed, removed or reordered. The head hash it
+// prints can be kept outside of the database to also detect the log being rewritten as a whole.
+func verifyAudit(ctx context.Context, env *environment, args []string) error {
+	flags := flag.NewFlagSet("verify-audit", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	verification, err := env.repo.Audit.Verify(ctx)
+	if err != nil {
+		return err
+	}
+	if !verification.Valid() {
+		return fmt.Errorf("audit log is broken at event %d after %d intact events: %s",
+			*verification.BrokenAt, verification.Events, verification.Reason)
+	}
+
+	if verification.PurgedThrough != nil {
+		fmt.Printf("events up to %d were purged by the retention policy\n", *verification.PurgedThrough)
+	}
+	fmt.Printf("audit log intact: %d events, head hash %s\n", verification.Events, verification.HeadHash)
+	return nil
+}
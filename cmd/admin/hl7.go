@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/google/uuid"
+	"github.com/yhwbach/makerble/internal/hl7"
+	"github.com/yhwbach/makerble/internal/models"
+)
+
+// replayHL7 processes HL7 messages again, either the one given with -id or, with -failed, the
+// messages that failed, oldest first. Run it once the cause of the failures is fixed.
+func replayHL7(ctx context.Context, env *environment, args []string) error {
+	flags := flag.NewFlagSet("hl7-replay", flag.ContinueOnError)
+	id := flags.String("id", "", "ID of the message to replay")
+	failed := flags.Bool("failed", false, "replay every failed message")
+	limit := flags.Int("limit", 1000, "maximum number of failed messages replayed")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if (*id == "") == !*failed {
+		return fmt.Errorf("either -id or -failed is required")
+	}
+
+	if env.cfg.HL7.ServiceAccount == "" {
+		return fmt.Errorf("HL7_SERVICE_ACCOUNT is not set")
+	}
+	processor, err := hl7.NewProcessor(ctx, env.cfg.HL7, env.repo)
+	if err != nil {
+		return err
+	}
+
+	var ids []uuid.UUID
+	if *id != "" {
+		messageID, err := uuid.Parse(*id)
+		if err != nil {
+			return fmt.Errorf("invalid -id: %w", err)
+		}
+		ids = append(ids, messageID)
+	} else {
+		messages, err := env.repo.HL7Messages.List(ctx, models.HL7MessageFailed, *limit)
+		if err != nil {
+			return err
+		}
+		for _, message := range messages {
+			ids = append(ids, message.ID)
+		}
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "MESSAGE\tTYPE\tCONTROL ID\tSTATUS\tERROR")
+	stillFailing := 0
+	for _, messageID := range ids {
+		message, err := processor.Replay(ctx, messageID)
+		if err != nil {
+			return err
+		}
+		if message.Status != models.HL7MessageProcessed {
+			stillFailing++
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+			message.ID, message.MessageType, message.ControlID, message.Status, message.Error)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if stillFailing > 0 {
+		return fmt.Errorf("%d of %d messages still fail", stillFailing, len(ids))
+	}
+	return nil
+}
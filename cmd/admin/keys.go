@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+)
+
+// encryptPatients encrypts the patients stored before field encryption was introduced, in batches
+// so that the API keeps serving them meanwhile. It can be interrupted and run again.
+func encryptPatients(ctx context.Context, env *environment, args []string) error {
+	flags := flag.NewFlagSet("encrypt-patients", flag.ContinueOnError)
+	batchSize := flags.Int("batch-size", 500, "number of patients encrypted per transaction")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	return inBatches(ctx, *batchSize, env.repo.Patients.EncryptPlaintext, "encrypted", "patients")
+}
+
+// rotateKeys re-wraps the data keys of patients and HL7 messages with the current master key. Run
+// it after making a new key the master key while keeping the old one in the previous keys, which
+// can be removed once it completes.
+func rotateKeys(ctx context.Context, env *environment, args []string) error {
+	flags := flag.NewFlagSet("rotate-keys", flag.ContinueOnError)
+	batchSize := flags.Int("batch-size", 500, "number of rows rotated per transaction")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if err := inBatches(ctx, *batchSize, env.repo.Patients.RotateKeys, "rotated", "patients"); err != nil {
+		return err
+	}
+	return inBatches(ctx, *batchSize, env.repo.HL7Messages.RotateKeys, "rotated", "HL7 messages")
+}
+
+// inBatches runs a batch until it has nothing left to process
+func inBatches(ctx context.Context, batchSize int, batch func(context.Context, int) (int, error), done, rows string) error {
+	if batchSize < 1 {
+		return fmt.Errorf("-batch-size must be positive")
+	}
+
+	total := 0
+	for {
+		n, err := batch(ctx, batchSize)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			break
+		}
+		total += n
+		fmt.Printf("%s %d %s\n", done, total, rows)
+	}
+
+	fmt.Printf("%s %d %s in total\n", done, total, rows)
+	return nil
+}
@@ -0,0 +1,125 @@
+// Command admin provides administrative tasks that run directly against the database.
+//
+// Usage:
+//
+//	go run ./cmd/admin <command> [flags]
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+
+	"github.com/yhwbach/makerble/internal/config"
+	"github.com/yhwbach/makerble/internal/database"
+	"github.com/yhwbach/makerble/internal/encryption"
+	"github.com/yhwbach/makerble/internal/repository"
+)
+
+// command is an administrative subcommand
+type command struct {
+	description string
+	run         func(ctx context.Context, env *environment, args []string) error
+}
+
+// environment holds the dependencies shared by all commands
+type environment struct {
+	cfg  *config.Config
+	db   *sql.DB
+	repo repository.RepoStorage
+}
+
+var commands = map[string]command{
+	"create-admin": {
+		description: "Create an admin user",
+		run:         createAdmin,
+	},
+	"encrypt-patients": {
+		description: "Encrypt the sensitive fields of patients stored before field encryption",
+		run:         encryptPatients,
+	},
+	"hl7-replay": {
+		description: "Process failed HL7 messages again",
+		run:         replayHL7,
+	},
+	"research-export": {
+		description: "Export the patients consenting to research as a de-identified dataset",
+		run:         researchExport,
+	},
+	"retention": {
+		description: "Apply the data retention policies once and print the report",
+		run:         applyRetention,
+	},
+	"rotate-keys": {
+		description: "Re-wrap the data keys of patients and HL7 messages with the current master key",
+		run:         rotateKeys,
+	},
+	"verify-audit": {
+		description: "Verify the hash chain of the audit log",
+		run:         verifyAudit,
+	},
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", os.Args[1])
+		usage()
+		os.Exit(2)
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatal("failed to load config:", err)
+	}
+
+	db, err := database.New(
+		cfg.Database.DatabaseURL(),
+		cfg.Database.MaxOpenConns,
+		cfg.Database.MaxIdleConns,
+		cfg.Database.MaxIdleTime,
+	)
+	if err != nil {
+		log.Fatal("failed to connect to database:", err)
+	}
+	defer db.Close()
+
+	keys, err := encryption.Load(cfg.Encryption)
+	if err != nil {
+		log.Fatal("failed to load encryption keys:", err)
+	}
+
+	env := &environment{
+		cfg:  cfg,
+		db:   db,
+		repo: repository.NewRepoStorage(db, cfg.Database.RowLevelSecurity, keys),
+	}
+
+	if err := cmd.run(context.Background(), env, os.Args[2:]); err != nil {
+		log.Fatalf("%s: %v", os.Args[1], err)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: admin <command> [flags]")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "commands:")
+
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-20s %s\n", name, commands[name].description)
+	}
+}
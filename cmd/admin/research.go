@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/yhwbach/makerble/internal/models"
+	"github.com/yhwbach/makerble/internal/repository"
+	"github.com/yhwbach/makerble/internal/research"
+	"github.com/yhwbach/makerble/internal/schemas"
+)
+
+// researchExport writes the patients consenting to research as a de-identified dataset. Unlike the
+// API endpoint it covers every clinic unless one is given.
+func researchExport(ctx context.Context, env *environment, args []string) error {
+	flags := flag.NewFlagSet("research-export", flag.ContinueOnError)
+	format := flags.String("format", "csv", "csv or parquet")
+	output := flags.String("output", "", "file to write the dataset to, standard output by default")
+	clinic := flags.String("clinic", "", "only export the patients of this clinic ID")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if !research.IsValidFormat(*format) {
+		return fmt.Errorf("-format must be one of %v", research.Formats)
+	}
+
+	if *clinic != "" {
+		clinicID, err := uuid.Parse(*clinic)
+		if err != nil {
+			return fmt.Errorf("invalid -clinic: %w", err)
+		}
+		ctx = repository.WithClinic(ctx, clinicID)
+	}
+
+	deidentifier, err := research.Load(env.cfg.Research)
+	if err != nil {
+		return err
+	}
+
+	patients, err := env.repo.Patients.FindAll(ctx, schemas.PatientQuery{Consent: models.ConsentResearch})
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	records := make([]research.Record, 0, len(patients))
+	patientIDs := make([]string, 0, len(patients))
+	for _, patient := range patients {
+		records = append(records, deidentifier.Record(patient.Patient, now))
+		patientIDs = append(patientIDs, patient.ID.String())
+	}
+
+	var out io.Writer = os.Stdout
+	if *output != "" {
+		f, err := os.OpenFile(*output, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+
+	// The export is recorded before anything is written
+	err = env.repo.Audit.Record(ctx, &models.AuditEvent{
+		Severity: models.AuditSeverityInfo,
+		Action:   models.AuditActionResearchExport,
+		Fields:   deidentifier.Fields(),
+		Details: map[string]interface{}{
+			"patient_ids": patientIDs,
+			"count":       len(patients),
+			"format":      *format,
+			"source":      "admin command",
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := deidentifier.Write(out, *format, records); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "exported %d patients\n", len(records))
+	return nil
+}
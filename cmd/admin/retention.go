@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/yhwbach/makerble/internal/retention"
+)
+
+// applyRetention runs the data retention policies once and prints the report. With -dry-run it
+// only reports what the policies would archive or delete.
+func applyRetention(ctx context.Context, env *environment, args []string) error {
+	flags := flag.NewFlagSet("retention", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", env.cfg.Retention.DryRun, "only report what would be archived or deleted")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	run := retention.NewScheduler(env.cfg.Retention, env.repo).RunOnce(ctx, *dryRun)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "POLICY\tACTION\tBEFORE\tCOUNT\tERROR")
+	for _, result := range run.Results {
+		action := result.Action
+		if run.DryRun {
+			action = "would be " + action
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\n",
+			result.Policy, action, result.Before.Format("2006-01-02 15:04"), result.Count, result.Error)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if run.Failed() {
+		return fmt.Errorf("some retention policies failed")
+	}
+	return nil
+}
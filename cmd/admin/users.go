@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/yhwbach/makerble/internal/models"
+	"github.com/yhwbach/makerble/internal/schemas"
+	"github.com/yhwbach/makerble/internal/server"
+)
+
+// createAdmin creates an admin account, typically the first one on a new deployment. With
+// -operator it creates an operator instead, who manages the clinics and roles. The password is read from MAKERBLE_ADMIN_PASSWORD when not passed as a flag so it
+// does not end up in the shell history.
+func createAdmin(ctx context.Context, env *environment, args []string) error {
+	flags := flag.NewFlagSet("create-admin", flag.ContinueOnError)
+	username := flags.String("username", "", "username of the admin")
+	email := flags.String("email", "", "email address of the admin")
+	fullName := flags.String("full-name", "Administrator", "full name of the admin")
+	password := flags.String("password", os.Getenv("MAKERBLE_ADMIN_PASSWORD"), "password of the admin (defaults to $MAKERBLE_ADMIN_PASSWORD)")
+	operator := flags.Bool("operator", false, "create an operator, who manages clinics and roles, instead of a clinic admin")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *username == "" || *email == "" {
+		return fmt.Errorf("-username and -email are required")
+	}
+
+	policy := server.NewPasswordPolicy(env.cfg.Password)
+
+	violations, err := policy.Validate(*password, *username, *email)
+	if err != nil {
+		return err
+	}
+	if len(violations) > 0 {
+		return fmt.Errorf("password does not meet the password policy: %s", strings.Join(violations, "; "))
+	}
+
+	if exists, err := env.repo.Users.UsernameExists(ctx, *username); err != nil {
+		return err
+	} else if exists {
+		return fmt.Errorf("username %q already exists", *username)
+	}
+
+	if exists, err := env.repo.Users.EmailExists(ctx, *email); err != nil {
+		return err
+	} else if exists {
+		return fmt.Errorf("email %q already exists", *email)
+	}
+
+	hashedPassword, err := server.NewPasswordHasher(env.cfg.Password).Hash(*password)
+	if err != nil {
+		return err
+	}
+
+	userType := models.Admin
+	if *operator {
+		userType = models.Operator
+	}
+
+	id, err := env.repo.Users.Create(ctx, &schemas.UserRegister{
+		Username: *username,
+		Email:    *email,
+		FullName: *fullName,
+		UserType: userType,
+	}, hashedPassword)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("created %s %s (%s)\n", userType, *username, id)
+	return nil
+}
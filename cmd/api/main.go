@@ -3,12 +3,18 @@ package main
 import (
 	"context"
 	"fmt"
-	"log"
-	"time"
+	"log/slog"
+	"os"
 
+	"github.com/yhwbach/makerble/internal/auth"
 	"github.com/yhwbach/makerble/internal/config"
 	"github.com/yhwbach/makerble/internal/database"
+	"github.com/yhwbach/makerble/internal/encryption"
+	"github.com/yhwbach/makerble/internal/hl7"
+	"github.com/yhwbach/makerble/internal/logging"
 	"github.com/yhwbach/makerble/internal/repository"
+	"github.com/yhwbach/makerble/internal/research"
+	"github.com/yhwbach/makerble/internal/retention"
 	"github.com/yhwbach/makerble/internal/server"
 	"github.com/yhwbach/makerble/internal/utils"
 
@@ -32,9 +38,15 @@ import (
 func main() {
 	cfg, err := config.Load()
 	if err != nil {
-		log.Fatal("failed to load config:", err)
+		fatal("failed to load config", err)
 	}
 
+	logger, err := logging.New(cfg.Log, os.Stderr)
+	if err != nil {
+		fatal("failed to configure logging", err)
+	}
+	slog.SetDefault(logger)
+
 	db, err := database.New(
 		cfg.Database.DatabaseURL(),
 		cfg.Database.MaxOpenConns,
@@ -42,52 +54,118 @@ func main() {
 		cfg.Database.MaxIdleTime,
 	)
 	if err != nil {
-		log.Fatal("failed to connect to database:", err)
+		fatal("failed to connect to database", err)
 	}
 	defer db.Close()
 
 	driver, err := postgres.WithInstance(db, &postgres.Config{})
 	if err != nil {
-		log.Panic(err)
+		fatal("failed to set up migrations", err)
 	}
 
 	root, err := server.GetProjectRoot()
 	if err != nil {
-		log.Panic("failed to get project root:", err)
+		fatal("failed to get project root", err)
 	}
 
 	m, err := migrate.NewWithDatabaseInstance(fmt.Sprintf("file:%s/migrations", root), "postgres", driver)
 	if err != nil {
-		log.Panic("migration setup error %w ", err.Error())
+		fatal("failed to set up migrations", err)
 	}
 
 	err = m.Up()
 	if err != nil {
 
 		if err != migrate.ErrNoChange {
-			log.Printf("migration error %s ", err.Error())
+			slog.Error("migration failed", "error", err)
 		} else {
-			log.Printf("database is already up to date")
+			slog.Info("database is already up to date")
 		}
 	}
 
-	repo := repository.NewRepoStorage(db)
+	keys, err := encryption.Load(cfg.Encryption)
+	if err != nil {
+		fatal("failed to load encryption keys", err)
+	}
+
+	repo := repository.NewRepoStorage(db, cfg.Database.RowLevelSecurity, keys)
+	repo.Tokens = repository.NewCachedTokenRepository(
+		repo.Tokens,
+		cfg.JWT.RevocationCacheSize,
+		cfg.JWT.RevocationCacheTTL,
+		cfg.JWT.Expiry,
+	)
+	repo.Sessions = repository.NewCachedSessionRepository(repo.Sessions, cfg.JWT.RevocationCacheSize, cfg.JWT.RevocationCacheTTL)
+	repo.Roles = repository.NewCachedRoleRepository(repo.Roles, cfg.Auth.RoleCacheTTL)
 	jwtManager := utils.NewJWTManager(cfg.JWT.Secret, cfg.JWT.Expiry)
 
 	app := server.NewApplication(cfg, repo, jwtManager)
 
-	// Start token cleanup job
-	go func() {
-		ticker := time.NewTicker(1 * time.Hour)
-		for range ticker.C {
-			if err := repo.Tokens.CleanupExpiredTokens(context.Background()); err != nil {
-				log.Printf("Error cleaning up expired tokens: %v", err)
-			}
+	app.Authenticator, err = server.NewAuthenticator(cfg, repo, app.PasswordHasher)
+	if err != nil {
+		fatal("failed to configure authentication", err)
+	}
+
+	if cfg.OIDC.Enabled {
+		app.OIDC, err = auth.NewOIDCProvider(cfg.OIDC, nil)
+		if err != nil {
+			fatal("failed to configure single sign-on", err)
+		}
+	}
+
+	if cfg.Research.PseudonymKey != "" {
+		app.Research, err = research.Load(cfg.Research)
+		if err != nil {
+			fatal("failed to configure research exports", err)
 		}
-	}()
+	}
 
-	log.Printf("server is running at %s", cfg.Server.Port)
+	// Imports run in the background of the server, so the ones a restart interrupted never finish
+	interrupted, err := repo.PatientImports.FailUnfinished(context.Background(),
+		"The import was interrupted by a restart of the server, the rows imported until then are kept")
+	if err != nil {
+		fatal("failed to check for interrupted imports", err)
+	}
+	if interrupted > 0 {
+		slog.Warn("patient imports were interrupted", "count", interrupted)
+	}
+
+	interrupted, err = repo.PatientExports.FailUnfinished(context.Background(),
+		"The export was interrupted by a restart of the server, request it again")
+	if err != nil {
+		fatal("failed to check for interrupted exports", err)
+	}
+	if interrupted > 0 {
+		slog.Warn("patient exports were interrupted", "count", interrupted)
+	}
+
+	// Apply the data retention policies in the background
+	go retention.NewScheduler(cfg.Retention, repo).Start(context.Background())
+
+	// Receive the ADT messages of the hospital's registration system
+	if cfg.HL7.Addr != "" {
+		processor, err := hl7.NewProcessor(context.Background(), cfg.HL7, repo)
+		if err != nil {
+			fatal("failed to configure the HL7 interface", err)
+		}
+
+		listener := &hl7.Server{Addr: cfg.HL7.Addr, Handler: processor, IdleTimeout: cfg.HL7.IdleTimeout}
+		go func() {
+			if err := listener.ListenAndServe(context.Background()); err != nil {
+				fatal("HL7 listener error", err)
+			}
+		}()
+		slog.Info("HL7 interface is listening", "addr", cfg.HL7.Addr)
+	}
+
+	slog.Info("server is running", "port", cfg.Server.Port)
 	if err := app.Run(); err != nil {
-		log.Fatal("server error:", err)
+		fatal("server error", err)
 	}
 }
+
+// fatal logs the error and exits
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
+}
@@ -0,0 +1,89 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+
+	"github.com/yhwbach/makerble/internal/models"
+	"github.com/yhwbach/makerble/internal/repository"
+	"github.com/yhwbach/makerble/internal/utils"
+)
+
+// ErrInvalidCredentials is returned when the username or password is wrong
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// Authenticator checks a username and password and returns the matching account
+type Authenticator interface {
+	Authenticate(ctx context.Context, username, password string) (*models.User, error)
+}
+
+// Chain tries each authenticator in turn until one accepts the credentials
+type Chain []Authenticator
+
+// Authenticate moves on to the next authenticator when the credentials are rejected or the backend
+// fails, so local accounts keep working while a directory is unreachable. Once a backend accepted
+// the credentials its verdict is final.
+func (c Chain) Authenticate(ctx context.Context, username, password string) (*models.User, error) {
+	var backendErr error
+	for _, authenticator := range c {
+		user, err := authenticator.Authenticate(ctx, username, password)
+		switch {
+		case err == nil:
+			return user, nil
+		case errors.Is(err, ErrInvalidCredentials):
+			continue
+		case errors.Is(err, ErrNoRole), errors.Is(err, ErrNoEmail), errors.Is(err, ErrEmailTaken):
+			return nil, err
+		default:
+			slog.ErrorContext(ctx, "authentication backend failed", "error", err)
+			backendErr = err
+		}
+	}
+
+	if backendErr != nil {
+		return nil, backendErr
+	}
+	return nil, ErrInvalidCredentials
+}
+
+// LocalAuthenticator checks passwords against the hashes stored in the users table
+type LocalAuthenticator struct {
+	Users  repository.UserRepository
+	Hasher utils.PasswordHasher
+
+	// AdminsOnly restricts local sign-in to admins and operators, keeping local accounts as
+	// break-glass access when a directory is the primary backend. Directories never provision
+	// operators, so they only ever sign in locally.
+	AdminsOnly bool
+}
+
+// Authenticate verifies the password of a local account, upgrading outdated hashes on success
+func (l *LocalAuthenticator) Authenticate(ctx context.Context, username, password string) (*models.User, error) {
+	user, err := l.Users.FindByUsername(ctx, username)
+	if err != nil {
+		if errors.Is(err, repository.ErrUserNotFound) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, err
+	}
+
+	if user.AuthProvider != models.LocalProvider || (l.AdminsOnly && user.UserType != models.Admin && user.UserType != models.Operator) {
+		return nil, ErrInvalidCredentials
+	}
+
+	if err := l.Hasher.Verify(password, user.Password); err != nil {
+		return nil, ErrInvalidCredentials
+	}
+
+	// Upgrade hashes created with an outdated algorithm or parameters while the plaintext is at hand
+	if l.Hasher.NeedsRehash(user.Password) {
+		if hashedPassword, err := l.Hasher.Hash(password); err == nil {
+			if err := l.Users.UpdatePassword(ctx, user.ID, hashedPassword); err != nil {
+				slog.ErrorContext(ctx, "failed to rehash password", "user_id", user.ID, "error", err)
+			}
+		}
+	}
+
+	return user, nil
+}
@@ -0,0 +1,218 @@
+package auth
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/yhwbach/makerble/internal/config"
+	"github.com/yhwbach/makerble/internal/models"
+)
+
+// LDAPAuthenticator checks credentials by binding as the user against an LDAP or Active
+// Directory server. The user's groups decide the role.
+type LDAPAuthenticator struct {
+	cfg         config.LDAPConfig
+	tls         *tls.Config
+	roles       RoleMapping
+	provisioner *Provisioner
+}
+
+// NewLDAPAuthenticator creates an LDAP authenticator that provisions users through the provisioner
+func NewLDAPAuthenticator(cfg config.LDAPConfig, provisioner *Provisioner) (*LDAPAuthenticator, error) {
+	if cfg.URL == "" || cfg.BaseDN == "" {
+		return nil, errors.New("LDAP_URL and LDAP_BASE_DN are required for LDAP authentication")
+	}
+	if !strings.Contains(cfg.UserFilter, "{username}") {
+		return nil, errors.New("LDAP user filter must contain {username}")
+	}
+
+	roles, err := NewRoleMapping(cfg.RoleMapping)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read LDAP CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("LDAP CA file contains no certificates")
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	return &LDAPAuthenticator{cfg: cfg, tls: tlsConfig, roles: roles, provisioner: provisioner}, nil
+}
+
+// Authenticate binds as the user and provisions the matching account
+func (l *LDAPAuthenticator) Authenticate(ctx context.Context, username, password string) (*models.User, error) {
+	// An empty password would be an unauthenticated bind, which many servers accept
+	if username == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	conn, err := l.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if err := l.bindService(conn); err != nil {
+		return nil, err
+	}
+
+	entry, err := l.findUser(conn, username)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, fmt.Errorf("failed to bind as user: %w", err)
+	}
+
+	// Users may not be allowed to read group memberships themselves
+	if err := l.bindService(conn); err != nil {
+		return nil, err
+	}
+
+	groups, err := l.groups(conn, entry)
+	if err != nil {
+		return nil, err
+	}
+
+	role, ok := l.roles.Role(groups)
+	if !ok {
+		return nil, ErrNoRole
+	}
+
+	return l.provisioner.Provision(ctx, models.LDAPProvider, &Identity{
+		Subject:  l.externalID(entry),
+		Email:    entry.GetAttributeValue(l.cfg.EmailAttribute),
+		Name:     entry.GetAttributeValue(l.cfg.NameAttribute),
+		Username: username,
+		Groups:   groups,
+	}, role)
+}
+
+func (l *LDAPAuthenticator) dial() (*ldap.Conn, error) {
+	conn, err := ldap.DialURL(l.cfg.URL,
+		ldap.DialWithDialer(&net.Dialer{Timeout: l.cfg.Timeout}),
+		ldap.DialWithTLSConfig(l.tls),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to LDAP server: %w", err)
+	}
+	conn.SetTimeout(l.cfg.Timeout)
+
+	if l.cfg.StartTLS {
+		if err := conn.StartTLS(l.tls); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to start TLS: %w", err)
+		}
+	}
+
+	return conn, nil
+}
+
+// bindService binds as the service account, or stays anonymous when none is configured
+func (l *LDAPAuthenticator) bindService(conn *ldap.Conn) error {
+	if l.cfg.BindDN == "" {
+		return nil
+	}
+	if err := conn.Bind(l.cfg.BindDN, l.cfg.BindPassword); err != nil {
+		return fmt.Errorf("failed to bind as service account: %w", err)
+	}
+	return nil
+}
+
+func (l *LDAPAuthenticator) findUser(conn *ldap.Conn, username string) (*ldap.Entry, error) {
+	filter := strings.ReplaceAll(l.cfg.UserFilter, "{username}", ldap.EscapeFilter(username))
+	result, err := conn.Search(ldap.NewSearchRequest(
+		l.cfg.BaseDN,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases,
+		2, int(l.cfg.Timeout.Seconds()), false,
+		filter,
+		[]string{l.cfg.IDAttribute, l.cfg.EmailAttribute, l.cfg.NameAttribute, "memberOf"},
+		nil,
+	))
+	if err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, fmt.Errorf("failed to search for user: %w", err)
+	}
+
+	// An ambiguous filter must never pick one of several accounts
+	if len(result.Entries) != 1 {
+		return nil, ErrInvalidCredentials
+	}
+	return result.Entries[0], nil
+}
+
+// groups returns the common names of the groups the user belongs to
+func (l *LDAPAuthenticator) groups(conn *ldap.Conn, entry *ldap.Entry) ([]string, error) {
+	groupDNs := entry.GetAttributeValues("memberOf")
+
+	if l.cfg.GroupBaseDN != "" {
+		filter := strings.ReplaceAll(l.cfg.GroupFilter, "{dn}", ldap.EscapeFilter(entry.DN))
+		result, err := conn.Search(ldap.NewSearchRequest(
+			l.cfg.GroupBaseDN,
+			ldap.ScopeWholeSubtree, ldap.NeverDerefAliases,
+			0, int(l.cfg.Timeout.Seconds()), false,
+			filter,
+			[]string{"cn"},
+			nil,
+		))
+		if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+			return nil, fmt.Errorf("failed to search for groups: %w", err)
+		}
+		if result != nil {
+			for _, group := range result.Entries {
+				groupDNs = append(groupDNs, group.DN)
+			}
+		}
+	}
+
+	groups := make([]string, 0, len(groupDNs))
+	for _, groupDN := range groupDNs {
+		dn, err := ldap.ParseDN(groupDN)
+		if err != nil || len(dn.RDNs) == 0 {
+			continue
+		}
+		for _, attr := range dn.RDNs[0].Attributes {
+			if strings.EqualFold(attr.Type, "cn") {
+				groups = append(groups, attr.Value)
+			}
+		}
+	}
+	return groups, nil
+}
+
+// externalID returns a stable identifier for the entry. Active Directory's objectGUID is binary
+// and gets hex encoded; entries without the attribute fall back to their DN.
+func (l *LDAPAuthenticator) externalID(entry *ldap.Entry) string {
+	raw := entry.GetRawAttributeValue(l.cfg.IDAttribute)
+	switch {
+	case len(raw) == 0:
+		return entry.DN
+	case utf8.Valid(raw):
+		return string(raw)
+	default:
+		return hex.EncodeToString(raw)
+	}
+}
@@ -0,0 +1,154 @@
+package auth_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jimlambrt/gldap"
+	"github.com/jimlambrt/gldap/testdirectory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yhwbach/makerble/internal/auth"
+	"github.com/yhwbach/makerble/internal/config"
+	"github.com/yhwbach/makerble/internal/models"
+	"github.com/yhwbach/makerble/internal/repository/mock"
+	"github.com/yhwbach/makerble/internal/schemas"
+	"github.com/yhwbach/makerble/internal/utils"
+)
+
+func startDirectory(t *testing.T) *testdirectory.Directory {
+	groups := testdirectory.NewMemberOf(t, []string{"physicians"})
+	users := testdirectory.NewUsers(t, []string{"alice"}, testdirectory.WithMembersOf(t, groups...))
+	users = append(users, testdirectory.NewUsers(t, []string{"bob"})...)
+	users = append(users, gldap.NewEntry("cn=svc,ou=people,dc=example,dc=org", map[string][]string{
+		"password": {"service-password"},
+	}))
+
+	return testdirectory.Start(t,
+		testdirectory.WithNoTLS(t),
+		testdirectory.WithDefaults(t, &testdirectory.Defaults{
+			Users:  users,
+			Groups: []*gldap.Entry{testdirectory.NewGroup(t, "front-desk", []string{"bob"})},
+		}),
+	)
+}
+
+func ldapConfig(url string) config.LDAPConfig {
+	return config.LDAPConfig{
+		URL:            url,
+		Timeout:        5 * time.Second,
+		BindDN:         "cn=svc,ou=people,dc=example,dc=org",
+		BindPassword:   "service-password",
+		BaseDN:         testdirectory.DefaultUserDN,
+		UserFilter:     "(cn={username})",
+		IDAttribute:    "entryUUID",
+		EmailAttribute: "email",
+		NameAttribute:  "name",
+		GroupBaseDN:    testdirectory.DefaultGroupDN,
+		GroupFilter:    "(member={dn})",
+		RoleMapping: map[string]string{
+			"physicians": "doctor",
+			"front-desk": "receptionist",
+		},
+	}
+}
+
+func TestLDAPAuthenticator(t *testing.T) {
+	ctx := context.Background()
+	directory := startDirectory(t)
+	url := fmt.Sprintf("ldap://%s:%d", directory.Host(), directory.Port())
+	repo := mock.NewMockRepoStorage()
+
+	ldapAuth, err := auth.NewLDAPAuthenticator(ldapConfig(url), auth.NewProvisioner(repo))
+	require.NoError(t, err)
+
+	t.Run("MemberOfGrantsRole", func(t *testing.T) {
+		user, err := ldapAuth.Authenticate(ctx, "alice", "password")
+		require.NoError(t, err)
+		assert.Equal(t, models.Doctor, user.UserType)
+		assert.Equal(t, models.LDAPProvider, user.AuthProvider)
+		assert.Equal(t, "alice@example.com", user.Email)
+
+		// Signing in again finds the provisioned account
+		again, err := ldapAuth.Authenticate(ctx, "alice", "password")
+		require.NoError(t, err)
+		assert.Equal(t, user.ID, again.ID)
+	})
+
+	t.Run("GroupSearchGrantsRole", func(t *testing.T) {
+		user, err := ldapAuth.Authenticate(ctx, "bob", "password")
+		require.NoError(t, err)
+		assert.Equal(t, models.Receptionist, user.UserType)
+	})
+
+	t.Run("WrongPassword", func(t *testing.T) {
+		_, err := ldapAuth.Authenticate(ctx, "alice", "wrong")
+		assert.ErrorIs(t, err, auth.ErrInvalidCredentials)
+	})
+
+	t.Run("EmptyPassword", func(t *testing.T) {
+		_, err := ldapAuth.Authenticate(ctx, "alice", "")
+		assert.ErrorIs(t, err, auth.ErrInvalidCredentials)
+	})
+
+	t.Run("UnknownUser", func(t *testing.T) {
+		_, err := ldapAuth.Authenticate(ctx, "mallory", "password")
+		assert.ErrorIs(t, err, auth.ErrInvalidCredentials)
+	})
+
+	t.Run("NoMappedGroup", func(t *testing.T) {
+		cfg := ldapConfig(url)
+		cfg.RoleMapping = map[string]string{"it-admins": "admin"}
+		noRoles, err := auth.NewLDAPAuthenticator(cfg, auth.NewProvisioner(repo))
+		require.NoError(t, err)
+
+		_, err = noRoles.Authenticate(ctx, "alice", "password")
+		assert.ErrorIs(t, err, auth.ErrNoRole)
+	})
+}
+
+func TestChainFallsBackToLocalAccounts(t *testing.T) {
+	ctx := context.Background()
+	repo := mock.NewMockRepoStorage()
+	hasher := utils.NewPasswordHasher(utils.Bcrypt, utils.DefaultArgon2Params, 4)
+
+	hashed, err := hasher.Hash("Break-Glass-2024")
+	require.NoError(t, err)
+	_, err = repo.Users.Create(ctx, &schemas.UserRegister{
+		Username: "root",
+		Email:    "root@example.com",
+		FullName: "Break Glass",
+		UserType: models.Admin,
+	}, hashed)
+	require.NoError(t, err)
+	_, err = repo.Users.Create(ctx, &schemas.UserRegister{
+		Username: "doc",
+		Email:    "doc@example.com",
+		FullName: "Local Doctor",
+		UserType: models.Doctor,
+	}, hashed)
+	require.NoError(t, err)
+
+	// The directory is down: nothing listens on the port
+	cfg := ldapConfig("ldap://127.0.0.1:1")
+	cfg.Timeout = time.Second
+
+	ldapAuth, err := auth.NewLDAPAuthenticator(cfg, auth.NewProvisioner(repo))
+	require.NoError(t, err)
+
+	chain := auth.Chain{
+		ldapAuth,
+		&auth.LocalAuthenticator{Users: repo.Users, Hasher: hasher, AdminsOnly: true},
+	}
+
+	user, err := chain.Authenticate(ctx, "root", "Break-Glass-2024")
+	require.NoError(t, err)
+	assert.Equal(t, models.Admin, user.UserType)
+
+	// Non-admin local accounts are not usable as break-glass access, and the outage is reported
+	_, err = chain.Authenticate(ctx, "doc", "Break-Glass-2024")
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, auth.ErrInvalidCredentials)
+}
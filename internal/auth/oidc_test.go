@@ -0,0 +1,122 @@
+package auth_test
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yhwbach/makerble/internal/auth"
+	"github.com/yhwbach/makerble/internal/models"
+	"github.com/yhwbach/makerble/internal/testutils"
+)
+
+func TestPKCEChallenge(t *testing.T) {
+	// Example from RFC 7636 appendix B
+	assert.Equal(t, "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM",
+		auth.PKCEChallenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"))
+}
+
+func TestOIDCProvider(t *testing.T) {
+	ctx := context.Background()
+	mock := testutils.NewMockOIDCProvider(t)
+	mock.SetUser(testutils.MockOIDCUser{
+		Subject:  "subject-1",
+		Email:    "jane@hospital.example",
+		Name:     "Jane Doe",
+		Username: "jdoe",
+		Groups:   []string{"staff", "physicians"},
+	})
+
+	cfg := mock.Config(map[string]string{
+		"physicians": "doctor",
+		"front-desk": "receptionist",
+		"it-admins":  "admin",
+	})
+	cfg.RedirectURL = "http://localhost/callback"
+
+	provider, err := auth.NewOIDCProvider(cfg, nil)
+	require.NoError(t, err)
+
+	// authorize follows the login page to the redirect back to the client and returns the code
+	authorize := func(t *testing.T, state, nonce, verifier string) string {
+		authURL, err := provider.AuthCodeURL(ctx, state, nonce, verifier)
+		require.NoError(t, err)
+
+		client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		}}
+		resp, err := client.Get(authURL)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusFound, resp.StatusCode)
+
+		location, err := url.Parse(resp.Header.Get("Location"))
+		require.NoError(t, err)
+		assert.Equal(t, state, location.Query().Get("state"))
+		return location.Query().Get("code")
+	}
+
+	t.Run("Exchange", func(t *testing.T) {
+		code := authorize(t, "state", "nonce", "verifier-verifier-verifier-verifier-verif")
+
+		identity, err := provider.Exchange(ctx, code, "verifier-verifier-verifier-verifier-verif", "nonce")
+		require.NoError(t, err)
+		assert.Equal(t, "subject-1", identity.Subject)
+		assert.Equal(t, "jane@hospital.example", identity.Email)
+		assert.Equal(t, "Jane Doe", identity.Name)
+		assert.Equal(t, "jdoe", identity.Username)
+		assert.Equal(t, []string{"staff", "physicians"}, identity.Groups)
+	})
+
+	t.Run("WrongVerifier", func(t *testing.T) {
+		code := authorize(t, "state", "nonce", "verifier-verifier-verifier-verifier-verif")
+
+		_, err := provider.Exchange(ctx, code, "another-verifier-another-verifier-another", "nonce")
+		assert.Error(t, err)
+	})
+
+	t.Run("WrongNonce", func(t *testing.T) {
+		code := authorize(t, "state", "nonce", "verifier-verifier-verifier-verifier-verif")
+
+		_, err := provider.Exchange(ctx, code, "verifier-verifier-verifier-verifier-verif", "other-nonce")
+		assert.Error(t, err)
+	})
+
+	t.Run("Role", func(t *testing.T) {
+		role, ok := provider.Role([]string{"front-desk", "physicians"})
+		assert.True(t, ok)
+		assert.Equal(t, models.Doctor, role)
+
+		role, ok = provider.Role([]string{"front-desk", "it-admins"})
+		assert.True(t, ok)
+		assert.Equal(t, models.Admin, role)
+
+		_, ok = provider.Role([]string{"staff"})
+		assert.False(t, ok)
+	})
+}
+
+func TestNewOIDCProviderRoleMapping(t *testing.T) {
+	idp := testutils.NewMockOIDCProvider(t)
+
+	// Service accounts cannot be signed into
+	_, err := auth.NewOIDCProvider(idp.Config(map[string]string{"physicians": "service"}), nil)
+	assert.Error(t, err)
+
+	// Roles defined by admins rank below the built-in roles
+	provider, err := auth.NewOIDCProvider(idp.Config(map[string]string{
+		"nurses":     "nurse",
+		"physicians": "doctor",
+	}), nil)
+	require.NoError(t, err)
+
+	role, ok := provider.Role([]string{"nurses"})
+	assert.True(t, ok)
+	assert.Equal(t, models.UserType("nurse"), role)
+
+	role, _ = provider.Role([]string{"nurses", "physicians"})
+	assert.Equal(t, models.Doctor, role)
+}
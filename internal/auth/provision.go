@@ -0,0 +1,190 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/yhwbach/makerble/internal/models"
+	"github.com/yhwbach/makerble/internal/repository"
+	"github.com/yhwbach/makerble/internal/schemas"
+)
+
+var (
+	// ErrNoRole is returned when none of the user's groups is mapped to a user type
+	ErrNoRole = errors.New("no role is mapped to the user's groups")
+	// ErrNoEmail is returned when the identity has no email address to provision the account with
+	ErrNoEmail = errors.New("identity has no email address")
+	// ErrEmailTaken is returned when the email address already belongs to another account
+	ErrEmailTaken = errors.New("email address belongs to another account")
+)
+
+// RoleMapping maps directory or identity provider groups to user types
+type RoleMapping map[string]models.UserType
+
+// rolePrecedence decides which role wins when a user is in groups mapped to several roles.
+// Built-in roles win over roles defined by admins, which are ordered by name.
+var rolePrecedence = []models.UserType{models.Admin, models.Doctor, models.Receptionist}
+
+// NewRoleMapping validates a group to role mapping from the configuration. Roles defined by
+// admins may be mapped too; a role that does not exist grants no permissions.
+func NewRoleMapping(mapping map[string]string) (RoleMapping, error) {
+	roles := make(RoleMapping, len(mapping))
+	for group, role := range mapping {
+		userType := models.UserType(role)
+		if userType == "" || userType == models.Service {
+			return nil, fmt.Errorf("invalid role %q for group %q", role, group)
+		}
+		roles[group] = userType
+	}
+	return roles, nil
+}
+
+// Role returns the highest role granted by the groups. It reports false when none of the
+// groups is mapped, in which case the user must not be let in.
+func (m RoleMapping) Role(groups []string) (models.UserType, bool) {
+	var custom []models.UserType
+	granted := make(map[models.UserType]bool)
+	for _, group := range groups {
+		if role, ok := m[group]; ok {
+			granted[role] = true
+			if !role.IsValid() {
+				custom = append(custom, role)
+			}
+		}
+	}
+
+	for _, role := range rolePrecedence {
+		if granted[role] {
+			return role, true
+		}
+	}
+
+	if len(custom) > 0 {
+		return slices.Min(custom), true
+	}
+	return "", false
+}
+
+// Provisioner keeps accounts of users authenticated by an external provider in sync
+type Provisioner struct {
+	Users    repository.UserRepository
+	Sessions repository.SessionRepository
+}
+
+// NewProvisioner creates a provisioner backed by the repositories
+func NewProvisioner(repo repository.RepoStorage) *Provisioner {
+	return &Provisioner{Users: repo.Users, Sessions: repo.Sessions}
+}
+
+// Provision returns the account linked to the identity, creating it on first sign-in and
+// syncing the role, email and name from the provider otherwise
+func (p *Provisioner) Provision(ctx context.Context, provider string, identity *Identity, role models.UserType) (*models.User, error) {
+	email := strings.TrimSpace(identity.Email)
+	if email == "" {
+		return nil, ErrNoEmail
+	}
+
+	fullName := identity.Name
+	if fullName == "" {
+		fullName = email
+	}
+
+	user, err := p.Users.FindByExternalID(ctx, provider, identity.Subject)
+	if err != nil && !errors.Is(err, repository.ErrUserNotFound) {
+		return nil, err
+	}
+
+	if user == nil {
+		// Never link to a local account by email: whoever controls the email at the provider
+		// would take over the account
+		exists, err := p.Users.EmailExists(ctx, email)
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			return nil, ErrEmailTaken
+		}
+
+		username, err := p.freeUsername(ctx, identity, email)
+		if err != nil {
+			return nil, err
+		}
+
+		user = &models.User{
+			Username:     username,
+			Email:        email,
+			FullName:     fullName,
+			UserType:     role,
+			AuthProvider: provider,
+			ExternalID:   identity.Subject,
+		}
+		if err := p.Users.CreateExternal(ctx, user); err != nil {
+			return nil, err
+		}
+		return user, nil
+	}
+
+	var update schemas.UserUpdate
+	if user.UserType != role {
+		update.UserType = &role
+	}
+	if user.FullName != fullName {
+		update.FullName = &fullName
+	}
+	if !strings.EqualFold(user.Email, email) {
+		exists, err := p.Users.EmailExists(ctx, email)
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			slog.WarnContext(ctx, "not syncing email of user, it is already in use", "user_id", user.ID)
+		} else {
+			update.Email = &email
+		}
+	}
+
+	if update.UserType == nil && update.FullName == nil && update.Email == nil {
+		return user, nil
+	}
+
+	updated, err := p.Users.UpdateByID(ctx, user.ID, &update)
+	if err != nil {
+		return nil, err
+	}
+
+	// Tokens carry the role, so sessions opened with the previous role must not outlive the change
+	if update.UserType != nil {
+		if _, err := p.Sessions.RevokeAllForUser(ctx, user.ID, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	return updated, nil
+}
+
+// freeUsername picks a free username for a newly provisioned user
+func (p *Provisioner) freeUsername(ctx context.Context, identity *Identity, email string) (string, error) {
+	base := identity.Username
+	if base == "" {
+		base, _, _ = strings.Cut(email, "@")
+	}
+
+	username := base
+	for i := 0; i < 5; i++ {
+		exists, err := p.Users.UsernameExists(ctx, username)
+		if err != nil {
+			return "", err
+		}
+		if !exists {
+			return username, nil
+		}
+		username = base + "-" + uuid.NewString()[:6]
+	}
+
+	return "", errors.New("could not find a free username")
+}
@@ -3,8 +3,10 @@ package config
 
 import (
 	"fmt"
+	"log/slog"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/joho/godotenv"
@@ -13,8 +15,22 @@ import (
 // Config holds all configuration for the application
 type Config struct {
 	Server   ServerConfig
+	Log      LogConfig
 	Database DatabaseConfig
 	JWT      JWTConfig
+	Password PasswordConfig
+	Auth     AuthConfig
+	OIDC     OIDCConfig
+	LDAP     LDAPConfig
+	SMTP     SMTPConfig
+
+	EmergencyAccess EmergencyAccessConfig
+	Encryption      EncryptionConfig
+	Research        ResearchConfig
+	Retention       RetentionConfig
+	HL7             HL7Config
+	Import          ImportConfig
+	Export          ExportConfig
 }
 
 // ServerConfig holds the server configuration
@@ -25,6 +41,14 @@ type ServerConfig struct {
 	IdleTimeout  time.Duration
 }
 
+// LogConfig holds the logging configuration
+type LogConfig struct {
+	// Level is the minimum level logged: debug, info, warn or error
+	Level string
+	// Format is json or text
+	Format string
+}
+
 // DatabaseConfig holds the database configuration
 type DatabaseConfig struct {
 	Host         string
@@ -36,12 +60,188 @@ type DatabaseConfig struct {
 	MaxOpenConns int
 	MaxIdleConns int
 	MaxIdleTime  time.Duration
+
+	// RowLevelSecurity sets the clinic of the request on the database session so the row-level
+	// security policies on patients and users apply. The application role must not be a superuser
+	// or have BYPASSRLS for the policies to take effect.
+	RowLevelSecurity bool
 }
 
 // JWTConfig holds JWT configuration
 type JWTConfig struct {
 	Secret string
 	Expiry time.Duration
+
+	// RevocationCacheSize and RevocationCacheTTL bound the in-process caches of token revocations
+	// and sessions checked on every request
+	RevocationCacheSize int
+	RevocationCacheTTL  time.Duration
+}
+
+// PasswordConfig holds the password policy configuration
+type PasswordConfig struct {
+	MinLength     int
+	MaxBytes      int
+	RequireUpper  bool
+	RequireLower  bool
+	RequireDigit  bool
+	RequireSymbol bool
+	BreachedDir   string
+
+	HashAlgorithm     string
+	Argon2Memory      int
+	Argon2Iterations  int
+	Argon2Parallelism int
+	BcryptCost        int
+}
+
+// AuthConfig holds the registration and authentication configuration
+type AuthConfig struct {
+	// OpenRegistration lets anyone register without an invitation. Only meant for local development.
+	OpenRegistration bool
+	InvitationExpiry time.Duration
+
+	// Backends lists the password authentication backends in the order they are tried
+	Backends []string
+	// LocalAdminsOnly restricts local password sign-in to admins and operators as break-glass access
+	LocalAdminsOnly bool
+
+	// RoleCacheTTL bounds how long role permissions are cached before edits made on another
+	// instance take effect
+	RoleCacheTTL time.Duration
+}
+
+// OIDCConfig holds the single sign-on configuration for an OpenID Connect identity provider
+type OIDCConfig struct {
+	Enabled      bool
+	IssuerURL    string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+
+	// GroupsClaim names the ID token claim holding the user's groups
+	GroupsClaim string
+	// RoleMapping maps identity provider groups to user types, e.g. "physicians=doctor,front-desk=receptionist"
+	RoleMapping map[string]string
+}
+
+// LDAPConfig holds the configuration for authenticating against an LDAP or Active Directory server
+type LDAPConfig struct {
+	URL      string
+	StartTLS bool
+	CAFile   string
+	Timeout  time.Duration
+
+	// BindDN and BindPassword are the service account used to look up users and groups
+	BindDN       string
+	BindPassword string
+
+	BaseDN string
+	// UserFilter finds the user entry, {username} is replaced with the escaped username
+	UserFilter     string
+	IDAttribute    string
+	EmailAttribute string
+	NameAttribute  string
+
+	// GroupBaseDN enables searching for groups listing the user as a member, in addition to the
+	// user's memberOf attribute. {dn} in GroupFilter is replaced with the escaped user DN.
+	GroupBaseDN string
+	GroupFilter string
+
+	// RoleMapping maps group common names to user types, e.g. "physicians=doctor,front-desk=receptionist"
+	RoleMapping map[string]string
+}
+
+// SMTPConfig holds the mail server used to send notifications. Notifications are only logged
+// when no host is configured.
+type SMTPConfig struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+// EmergencyAccessConfig holds the break-the-glass configuration
+type EmergencyAccessConfig struct {
+	// Duration is how long emergency access to a patient lasts
+	Duration time.Duration
+	// ComplianceMailbox is notified whenever emergency access is granted
+	ComplianceMailbox string
+}
+
+// EncryptionConfig holds the master keys protecting sensitive patient fields. Keys are base64
+// encoded 32 byte keys. To rotate, configure the new key as the master key, keep the old one in
+// the previous keys until the rotate-keys admin command has run, then remove it.
+type EncryptionConfig struct {
+	MasterKey    string
+	PreviousKeys []string
+	// KeyFile holds keys one per line, the first being the master key unless MasterKey is set
+	KeyFile string
+}
+
+// ResearchConfig holds the configuration of de-identified research exports
+type ResearchConfig struct {
+	// PseudonymKey is a base64 encoded key of at least 32 bytes deriving the pseudonymous IDs and
+	// date offsets of patients. Keeping it unchanged keeps them stable across exports.
+	PseudonymKey string
+	// IncludeMedicalHistory exports the medical history of patients. It is free text that can
+	// identify patients, so it is left out unless enabled.
+	IncludeMedicalHistory bool
+}
+
+// MinAuditLogYears is the shortest audit log retention allowed. HIPAA requires compliance
+// documentation to be kept for six years.
+const MinAuditLogYears = 6
+
+// RetentionConfig holds the data retention policies applied by the retention scheduler
+type RetentionConfig struct {
+	// Interval is the time between runs of the policies
+	Interval time.Duration
+	// DryRun only reports what the policies would archive or delete
+	DryRun bool
+
+	// InvalidTokenDays is how long token revocations are kept after the token expired
+	InvalidTokenDays int
+	// SessionDays is how long sessions are kept after they expired
+	SessionDays int
+	// InactivePatientYears archives patients neither updated nor accessed for that long, 0 never does
+	InactivePatientYears int
+	// AuditLogYears deletes audit events older than that, 0 keeps them forever. It cannot be less
+	// than MinAuditLogYears.
+	AuditLogYears int
+	// PatientExportHours is how long FHIR bulk exports can be downloaded after they started
+	PatientExportHours int
+}
+
+// HL7Config holds the configuration of the HL7 v2 interface receiving ADT messages over MLLP
+type HL7Config struct {
+	// Addr is the address the MLLP listener binds to, empty disables the interface
+	Addr string
+	// ServiceAccount is the username of the service account patients are registered and updated
+	// as. Its clinic receives the patients.
+	ServiceAccount string
+	// IdleTimeout closes connections without messages for that long
+	IdleTimeout time.Duration
+}
+
+// ImportConfig holds the limits of bulk patient imports
+type ImportConfig struct {
+	// MaxFileMB is the largest file accepted, in megabytes
+	MaxFileMB int
+	// BatchSize is the number of rows inserted per transaction
+	BatchSize int
+	// Concurrency is the number of imports run at once, the others wait
+	Concurrency int
+}
+
+// ExportConfig holds the limits of bulk patient exports
+type ExportConfig struct {
+	// Concurrency is the number of FHIR bulk exports run at once, the others wait
+	Concurrency int
+	// AuditBatchSize is the number of patients listed by each audit event recording an export
+	AuditBatchSize int
 }
 
 // Load loads the configuration from environment variables
@@ -56,6 +256,10 @@ func Load() (*Config, error) {
 			WriteTimeout: time.Duration(getEnvAsInt("SERVER_WRITE_TIMEOUT", 10)) * time.Second,
 			IdleTimeout:  time.Duration(getEnvAsInt("SERVER_IDLE_TIMEOUT", 120)) * time.Second,
 		},
+		Log: LogConfig{
+			Level:  getEnv("LOG_LEVEL", "info"),
+			Format: getEnv("LOG_FORMAT", "json"),
+		},
 		Database: DatabaseConfig{
 			Host:     getEnv("DB_HOST", "localhost"),
 			Port:     getEnv("DB_PORT", "5432"),
@@ -66,11 +270,173 @@ func Load() (*Config, error) {
 			MaxOpenConns: getEnvAsInt("DB_MAX_OPEN_CONNS", 10),
 			MaxIdleConns: getEnvAsInt("DB_MAX_IDLE_CONNS", 5),
 			MaxIdleTime:  getEnvAsTime("DB_MAX_IDLE_TIME", 5*time.Minute),
+
+			RowLevelSecurity: getEnvAsBool("DB_ROW_LEVEL_SECURITY", false),
 		},
 		JWT: JWTConfig{
 			Secret: getEnv("JWT_SECRET", "your_jwt_secret_key"),
 			Expiry: time.Duration(getEnvAsInt("JWT_EXPIRY_HOURS", 24)) * time.Hour,
+
+			RevocationCacheSize: getEnvAsInt("JWT_REVOCATION_CACHE_SIZE", 10000),
+			RevocationCacheTTL:  getEnvAsTime("JWT_REVOCATION_CACHE_TTL", 30*time.Second),
+		},
+		Password: PasswordConfig{
+			MinLength:     getEnvAsInt("PASSWORD_MIN_LENGTH", 12),
+			MaxBytes:      getEnvAsInt("PASSWORD_MAX_BYTES", 72),
+			RequireUpper:  getEnvAsBool("PASSWORD_REQUIRE_UPPER", true),
+			RequireLower:  getEnvAsBool("PASSWORD_REQUIRE_LOWER", true),
+			RequireDigit:  getEnvAsBool("PASSWORD_REQUIRE_DIGIT", true),
+			RequireSymbol: getEnvAsBool("PASSWORD_REQUIRE_SYMBOL", false),
+			BreachedDir:   getEnv("PASSWORD_BREACHED_DIR", ""),
+
+			HashAlgorithm:     getEnv("PASSWORD_HASH_ALGORITHM", "argon2id"),
+			Argon2Memory:      getEnvAsInt("ARGON2_MEMORY_KB", 64*1024),
+			Argon2Iterations:  getEnvAsInt("ARGON2_ITERATIONS", 3),
+			Argon2Parallelism: getEnvAsInt("ARGON2_PARALLELISM", 2),
+			BcryptCost:        getEnvAsInt("BCRYPT_COST", 12),
+		},
+		Auth: AuthConfig{
+			OpenRegistration: getEnvAsBool("ALLOW_OPEN_REGISTRATION", false),
+			InvitationExpiry: getEnvAsTime("INVITATION_EXPIRY", 72*time.Hour),
+			Backends:         strings.Split(getEnv("AUTH_BACKENDS", "local"), ","),
+			LocalAdminsOnly:  getEnvAsBool("AUTH_LOCAL_ADMINS_ONLY", false),
+			RoleCacheTTL:     getEnvAsTime("ROLE_CACHE_TTL", 30*time.Second),
+		},
+		OIDC: OIDCConfig{
+			Enabled:      getEnvAsBool("OIDC_ENABLED", false),
+			IssuerURL:    getEnv("OIDC_ISSUER_URL", ""),
+			ClientID:     getEnv("OIDC_CLIENT_ID", ""),
+			ClientSecret: getEnv("OIDC_CLIENT_SECRET", ""),
+			RedirectURL:  getEnv("OIDC_REDIRECT_URL", "http://localhost:5000/api/v1/auth/oidc/callback"),
+			Scopes:       strings.Fields(getEnv("OIDC_SCOPES", "openid email profile")),
+			GroupsClaim:  getEnv("OIDC_GROUPS_CLAIM", "groups"),
+		},
+		LDAP: LDAPConfig{
+			URL:            getEnv("LDAP_URL", ""),
+			StartTLS:       getEnvAsBool("LDAP_START_TLS", false),
+			CAFile:         getEnv("LDAP_CA_FILE", ""),
+			Timeout:        getEnvAsTime("LDAP_TIMEOUT", 5*time.Second),
+			BindDN:         getEnv("LDAP_BIND_DN", ""),
+			BindPassword:   getEnv("LDAP_BIND_PASSWORD", ""),
+			BaseDN:         getEnv("LDAP_BASE_DN", ""),
+			UserFilter:     getEnv("LDAP_USER_FILTER", "(uid={username})"),
+			IDAttribute:    getEnv("LDAP_ID_ATTRIBUTE", "entryUUID"),
+			EmailAttribute: getEnv("LDAP_EMAIL_ATTRIBUTE", "mail"),
+			NameAttribute:  getEnv("LDAP_NAME_ATTRIBUTE", "cn"),
+			GroupBaseDN:    getEnv("LDAP_GROUP_BASE_DN", ""),
+			GroupFilter:    getEnv("LDAP_GROUP_FILTER", "(member={dn})"),
+		},
+		SMTP: SMTPConfig{
+			Host:     getEnv("SMTP_HOST", ""),
+			Port:     getEnv("SMTP_PORT", "587"),
+			Username: getEnv("SMTP_USERNAME", ""),
+			Password: getEnv("SMTP_PASSWORD", ""),
+			From:     getEnv("SMTP_FROM", "makerble@localhost"),
 		},
+		EmergencyAccess: EmergencyAccessConfig{
+			Duration:          getEnvAsTime("EMERGENCY_ACCESS_DURATION", time.Hour),
+			ComplianceMailbox: getEnv("COMPLIANCE_MAILBOX", ""),
+		},
+		Encryption: EncryptionConfig{
+			MasterKey:    getEnv("ENCRYPTION_MASTER_KEY", ""),
+			PreviousKeys: strings.FieldsFunc(getEnv("ENCRYPTION_PREVIOUS_KEYS", ""), func(r rune) bool { return r == ',' }),
+			KeyFile:      getEnv("ENCRYPTION_KEY_FILE", ""),
+		},
+		Research: ResearchConfig{
+			PseudonymKey:          getEnv("RESEARCH_PSEUDONYM_KEY", ""),
+			IncludeMedicalHistory: getEnvAsBool("RESEARCH_INCLUDE_MEDICAL_HISTORY", false),
+		},
+		Retention: RetentionConfig{
+			Interval:             getEnvAsTime("RETENTION_INTERVAL", time.Hour),
+			DryRun:               getEnvAsBool("RETENTION_DRY_RUN", false),
+			InvalidTokenDays:     getEnvAsInt("RETENTION_INVALID_TOKEN_DAYS", 0),
+			SessionDays:          getEnvAsInt("RETENTION_SESSION_DAYS", 0),
+			InactivePatientYears: getEnvAsInt("RETENTION_INACTIVE_PATIENT_YEARS", 0),
+			AuditLogYears:        getEnvAsInt("RETENTION_AUDIT_LOG_YEARS", 0),
+			PatientExportHours:   getEnvAsInt("RETENTION_PATIENT_EXPORT_HOURS", 24),
+		},
+		HL7: HL7Config{
+			Addr:           getEnv("HL7_MLLP_ADDR", ""),
+			ServiceAccount: getEnv("HL7_SERVICE_ACCOUNT", ""),
+			IdleTimeout:    getEnvAsTime("HL7_IDLE_TIMEOUT", 10*time.Minute),
+		},
+		Import: ImportConfig{
+			MaxFileMB:   getEnvAsInt("IMPORT_MAX_FILE_MB", 20),
+			BatchSize:   getEnvAsInt("IMPORT_BATCH_SIZE", 500),
+			Concurrency: getEnvAsInt("IMPORT_CONCURRENCY", 2),
+		},
+		Export: ExportConfig{
+			Concurrency:    getEnvAsInt("EXPORT_CONCURRENCY", 2),
+			AuditBatchSize: getEnvAsInt("EXPORT_AUDIT_BATCH_SIZE", 1000),
+		},
+	}
+
+	roleMapping, err := parseMapping(getEnv("OIDC_ROLE_MAPPING", ""))
+	if err != nil {
+		return nil, fmt.Errorf("invalid OIDC_ROLE_MAPPING: %w", err)
+	}
+	config.OIDC.RoleMapping = roleMapping
+
+	ldapRoleMapping, err := parseMapping(getEnv("LDAP_ROLE_MAPPING", ""))
+	if err != nil {
+		return nil, fmt.Errorf("invalid LDAP_ROLE_MAPPING: %w", err)
+	}
+	config.LDAP.RoleMapping = ldapRoleMapping
+
+	if config.OIDC.Enabled && (config.OIDC.IssuerURL == "" || config.OIDC.ClientID == "") {
+		return nil, fmt.Errorf("OIDC_ISSUER_URL and OIDC_CLIENT_ID are required when OIDC is enabled")
+	}
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(config.Log.Level)); err != nil {
+		return nil, fmt.Errorf("invalid LOG_LEVEL %q", config.Log.Level)
+	}
+
+	if config.Log.Format != "json" && config.Log.Format != "text" {
+		return nil, fmt.Errorf("unsupported LOG_FORMAT %q", config.Log.Format)
+	}
+
+	if config.Encryption.MasterKey == "" && config.Encryption.KeyFile == "" {
+		return nil, fmt.Errorf("ENCRYPTION_MASTER_KEY or ENCRYPTION_KEY_FILE is required")
+	}
+
+	for name, value := range map[string]int{
+		"RETENTION_INVALID_TOKEN_DAYS":     config.Retention.InvalidTokenDays,
+		"RETENTION_SESSION_DAYS":           config.Retention.SessionDays,
+		"RETENTION_INACTIVE_PATIENT_YEARS": config.Retention.InactivePatientYears,
+	} {
+		if value < 0 {
+			return nil, fmt.Errorf("%s cannot be negative", name)
+		}
+	}
+
+	if config.Retention.AuditLogYears != 0 && config.Retention.AuditLogYears < MinAuditLogYears {
+		return nil, fmt.Errorf("RETENTION_AUDIT_LOG_YEARS must be at least %d", MinAuditLogYears)
+	}
+
+	if config.Retention.Interval <= 0 {
+		return nil, fmt.Errorf("RETENTION_INTERVAL must be positive")
+	}
+
+	if config.HL7.Addr != "" && config.HL7.ServiceAccount == "" {
+		return nil, fmt.Errorf("HL7_SERVICE_ACCOUNT is required when HL7_MLLP_ADDR is set")
+	}
+
+	for name, value := range map[string]int{
+		"IMPORT_MAX_FILE_MB":             config.Import.MaxFileMB,
+		"IMPORT_BATCH_SIZE":              config.Import.BatchSize,
+		"IMPORT_CONCURRENCY":             config.Import.Concurrency,
+		"EXPORT_CONCURRENCY":             config.Export.Concurrency,
+		"EXPORT_AUDIT_BATCH_SIZE":        config.Export.AuditBatchSize,
+		"RETENTION_PATIENT_EXPORT_HOURS": config.Retention.PatientExportHours,
+	} {
+		if value <= 0 {
+			return nil, fmt.Errorf("%s must be positive", name)
+		}
+	}
+
+	if config.Password.HashAlgorithm != "argon2id" && config.Password.HashAlgorithm != "bcrypt" {
+		return nil, fmt.Errorf("unsupported PASSWORD_HASH_ALGORITHM %q", config.Password.HashAlgorithm)
 	}
 
 	return config, nil
@@ -104,6 +470,34 @@ func getEnvAsInt(key string, defaultValue int) int {
 }
 
 // getEnvAsBool retrieves the value of an environment variable as a bool or returns a default value if not set
+func getEnvAsBool(key string, defaultValue bool) bool {
+	if value, exists := os.LookupEnv(key); exists {
+		if boolValue, err := strconv.ParseBool(value); err == nil {
+			return boolValue
+		}
+	}
+	return defaultValue
+}
+
+// parseMapping parses a comma separated list of key=value pairs
+func parseMapping(value string) (map[string]string, error) {
+	mapping := make(map[string]string)
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		key, val, ok := strings.Cut(pair, "=")
+		if !ok || strings.TrimSpace(key) == "" || strings.TrimSpace(val) == "" {
+			return nil, fmt.Errorf("expected key=value, got %q", pair)
+		}
+		mapping[strings.TrimSpace(key)] = strings.TrimSpace(val)
+	}
+	return mapping, nil
+}
+
+// getEnvAsTime retrieves the value of an environment variable as a duration or returns a default value if not set
 func getEnvAsTime(key string, fallback time.Duration) time.Duration {
 	val, ok := os.LookupEnv(key)
 
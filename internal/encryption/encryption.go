@@ -0,0 +1,275 @@
+// Package encryption protects sensitive values at rest with envelope encryption. Every record is
+// encrypted with its own data key, which is stored wrapped by a master key kept outside of the
+// database. Rotating the master key only requires re-wrapping the data keys.
+package encryption
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/yhwbach/makerble/internal/config"
+)
+
+// KeySize is the size in bytes of master and data keys (AES-256)
+const KeySize = 32
+
+// ErrUnknownKey is returned when a data key was wrapped by a master key the key ring does not hold
+var ErrUnknownKey = errors.New("data key was wrapped by an unknown master key")
+
+// masterKey is a master key with the keys derived from it
+type masterKey struct {
+	id      string
+	wrap    cipher.AEAD
+	indexer []byte
+}
+
+func newMasterKey(secret []byte) (*masterKey, error) {
+	if len(secret) != KeySize {
+		return nil, fmt.Errorf("master key must be %d bytes, got %d", KeySize, len(secret))
+	}
+
+	derive := func(purpose string) ([]byte, error) {
+		return hkdf.Key(sha256.New, secret, nil, "makerble "+purpose, KeySize)
+	}
+
+	wrapKey, err := derive("data key wrapping")
+	if err != nil {
+		return nil, err
+	}
+	wrap, err := newAEAD(wrapKey)
+	if err != nil {
+		return nil, err
+	}
+
+	indexKey, err := derive("blind index")
+	if err != nil {
+		return nil, err
+	}
+
+	// The ID identifies the key the data keys were wrapped with without revealing anything about it
+	id, err := derive("key id")
+	if err != nil {
+		return nil, err
+	}
+
+	return &masterKey{id: hex.EncodeToString(id[:8]), wrap: wrap, indexer: indexKey}, nil
+}
+
+// KeyRing holds the current master key, which wraps new data keys, and previous master keys that
+// still unwrap the data keys they wrapped until these are rotated
+type KeyRing struct {
+	current *masterKey
+	keys    map[string]*masterKey
+}
+
+// NewKeyRing creates a key ring from the current master key and any previous ones
+func NewKeyRing(current []byte, previous ...[]byte) (*KeyRing, error) {
+	k := &KeyRing{keys: make(map[string]*masterKey)}
+	for i, secret := range append([][]byte{current}, previous...) {
+		key, err := newMasterKey(secret)
+		if err != nil {
+			return nil, err
+		}
+		if i == 0 {
+			k.current = key
+		}
+		k.keys[key.id] = key
+	}
+	return k, nil
+}
+
+// Load creates the key ring from the configured master keys. Keys are base64 encoded, either in
+// the configuration or one per line in the key file, the first being the current key.
+func Load(cfg config.EncryptionConfig) (*KeyRing, error) {
+	var encoded []string
+	if cfg.MasterKey != "" {
+		encoded = append(encoded, cfg.MasterKey)
+	}
+
+	if cfg.KeyFile != "" {
+		fromFile, err := readKeyFile(cfg.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		encoded = append(encoded, fromFile...)
+	}
+	encoded = append(encoded, cfg.PreviousKeys...)
+
+	if len(encoded) == 0 {
+		return nil, errors.New("no master key configured")
+	}
+
+	secrets := make([][]byte, len(encoded))
+	for i, value := range encoded {
+		secret, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("invalid master key %d: %w", i+1, err)
+		}
+		secrets[i] = secret
+	}
+
+	return NewKeyRing(secrets[0], secrets[1:]...)
+}
+
+// readKeyFile reads the base64 encoded keys of a key file, skipping blank lines and comments
+func readKeyFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open key file: %w", err)
+	}
+	defer f.Close()
+
+	var keys []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		keys = append(keys, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+	return keys, nil
+}
+
+// CurrentKeyID identifies the master key new data keys are wrapped with
+func (k *KeyRing) CurrentKeyID() string {
+	return k.current.id
+}
+
+// NewDataKey generates a data key wrapped by the current master key
+func (k *KeyRing) NewDataKey() (*DataKey, error) {
+	secret := make([]byte, KeySize)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, fmt.Errorf("failed to generate data key: %w", err)
+	}
+
+	wrapped, err := seal(k.current.wrap, secret, []byte(k.current.id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to wrap data key: %w", err)
+	}
+
+	return newDataKey(k.current.id, wrapped, secret)
+}
+
+// Unwrap recovers a data key wrapped by the master key with the ID
+func (k *KeyRing) Unwrap(keyID string, wrapped []byte) (*DataKey, error) {
+	key, ok := k.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownKey, keyID)
+	}
+
+	secret, err := open(key.wrap, wrapped, []byte(keyID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key: %w", err)
+	}
+
+	return newDataKey(keyID, wrapped, secret)
+}
+
+// Rewrap wraps the data key with the current master key. Values encrypted with the data key are
+// left untouched.
+func (k *KeyRing) Rewrap(key *DataKey) (*DataKey, error) {
+	wrapped, err := seal(k.current.wrap, key.secret, []byte(k.current.id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to wrap data key: %w", err)
+	}
+
+	return newDataKey(k.current.id, wrapped, key.secret)
+}
+
+// BlindIndex returns a keyed hash of the value under the current master key, so that encrypted
+// values can be looked up by equality without being decrypted
+func (k *KeyRing) BlindIndex(value string) []byte {
+	return blindIndex(k.current, value)
+}
+
+// BlindIndexes returns the blind indexes of the value under every master key, to find values
+// whose index was not yet recomputed after a rotation
+func (k *KeyRing) BlindIndexes(value string) [][]byte {
+	indexes := [][]byte{k.BlindIndex(value)}
+	for _, key := range k.keys {
+		if key != k.current {
+			indexes = append(indexes, blindIndex(key, value))
+		}
+	}
+	return indexes
+}
+
+func blindIndex(key *masterKey, value string) []byte {
+	mac := hmac.New(sha256.New, key.indexer)
+	mac.Write([]byte(value))
+	return mac.Sum(nil)
+}
+
+// DataKey encrypts the values of a single record
+type DataKey struct {
+	// KeyID identifies the master key that wrapped the data key
+	KeyID string
+	// Wrapped is the data key encrypted by the master key, which is safe to store
+	Wrapped []byte
+
+	secret []byte
+	aead   cipher.AEAD
+}
+
+func newDataKey(keyID string, wrapped, secret []byte) (*DataKey, error) {
+	aead, err := newAEAD(secret)
+	if err != nil {
+		return nil, err
+	}
+	return &DataKey{KeyID: keyID, Wrapped: wrapped, secret: secret, aead: aead}, nil
+}
+
+// Encrypt encrypts the value. The context, such as the record and column the value is stored in,
+// is authenticated so that ciphertexts cannot be swapped between records.
+func (d *DataKey) Encrypt(value, context string) ([]byte, error) {
+	return seal(d.aead, []byte(value), []byte(context))
+}
+
+// Decrypt decrypts a value encrypted in the same context
+func (d *DataKey) Decrypt(ciphertext []byte, context string) (string, error) {
+	value, err := open(d.aead, ciphertext, []byte(context))
+	if err != nil {
+		return "", err
+	}
+	return string(value), nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts the plaintext with a random nonce, which is prepended to the ciphertext
+func seal(aead cipher.AEAD, plaintext, additionalData []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func open(aead cipher.AEAD, ciphertext, additionalData []byte) ([]byte, error) {
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	nonce, sealed := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+	return aead.Open(nil, nonce, sealed, additionalData)
+}
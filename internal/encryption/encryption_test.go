@@ -0,0 +1,109 @@
+package encryption
+
+import (
+	"bytes"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yhwbach/makerble/internal/config"
+)
+
+var (
+	oldKey = bytes.Repeat([]byte{1}, KeySize)
+	newKey = bytes.Repeat([]byte{2}, KeySize)
+)
+
+func TestEncryptDecrypt(t *testing.T) {
+	keys, err := NewKeyRing(oldKey)
+	require.NoError(t, err)
+
+	dataKey, err := keys.NewDataKey()
+	require.NoError(t, err)
+	assert.Equal(t, keys.CurrentKeyID(), dataKey.KeyID)
+
+	ciphertext, err := dataKey.Encrypt("Asthma", "patients/1/medical_history")
+	require.NoError(t, err)
+	assert.NotContains(t, string(ciphertext), "Asthma")
+
+	unwrapped, err := keys.Unwrap(dataKey.KeyID, dataKey.Wrapped)
+	require.NoError(t, err)
+
+	value, err := unwrapped.Decrypt(ciphertext, "patients/1/medical_history")
+	require.NoError(t, err)
+	assert.Equal(t, "Asthma", value)
+
+	_, err = unwrapped.Decrypt(ciphertext, "patients/2/medical_history")
+	assert.Error(t, err, "ciphertexts are bound to their context")
+}
+
+func TestRotation(t *testing.T) {
+	before, err := NewKeyRing(oldKey)
+	require.NoError(t, err)
+
+	dataKey, err := before.NewDataKey()
+	require.NoError(t, err)
+	ciphertext, err := dataKey.Encrypt("jane@example.com", "email")
+	require.NoError(t, err)
+
+	after, err := NewKeyRing(newKey, oldKey)
+	require.NoError(t, err)
+	assert.NotEqual(t, before.CurrentKeyID(), after.CurrentKeyID())
+
+	unwrapped, err := after.Unwrap(dataKey.KeyID, dataKey.Wrapped)
+	require.NoError(t, err)
+	rewrapped, err := after.Rewrap(unwrapped)
+	require.NoError(t, err)
+	assert.Equal(t, after.CurrentKeyID(), rewrapped.KeyID)
+
+	// Once rotated, the previous key is no longer needed
+	rotated, err := NewKeyRing(newKey)
+	require.NoError(t, err)
+
+	unwrapped, err = rotated.Unwrap(rewrapped.KeyID, rewrapped.Wrapped)
+	require.NoError(t, err)
+	value, err := unwrapped.Decrypt(ciphertext, "email")
+	require.NoError(t, err)
+	assert.Equal(t, "jane@example.com", value)
+
+	_, err = rotated.Unwrap(dataKey.KeyID, dataKey.Wrapped)
+	assert.ErrorIs(t, err, ErrUnknownKey)
+}
+
+func TestBlindIndex(t *testing.T) {
+	before, err := NewKeyRing(oldKey)
+	require.NoError(t, err)
+	after, err := NewKeyRing(newKey, oldKey)
+	require.NoError(t, err)
+
+	index := before.BlindIndex("jane@example.com")
+	assert.Equal(t, index, before.BlindIndex("jane@example.com"))
+	assert.NotEqual(t, index, before.BlindIndex("john@example.com"))
+	assert.NotEqual(t, index, after.BlindIndex("jane@example.com"))
+
+	assert.Contains(t, after.BlindIndexes("jane@example.com"), index)
+}
+
+func TestLoad(t *testing.T) {
+	encode := base64.StdEncoding.EncodeToString
+
+	keyFile := filepath.Join(t.TempDir(), "keys")
+	require.NoError(t, os.WriteFile(keyFile, []byte("# current key first\n"+encode(newKey)+"\n\n"+encode(oldKey)+"\n"), 0o600))
+
+	fromFile, err := Load(config.EncryptionConfig{KeyFile: keyFile})
+	require.NoError(t, err)
+	fromConfig, err := Load(config.EncryptionConfig{MasterKey: encode(newKey), PreviousKeys: []string{encode(oldKey)}})
+	require.NoError(t, err)
+
+	assert.Equal(t, fromConfig.CurrentKeyID(), fromFile.CurrentKeyID())
+	assert.Len(t, fromFile.keys, 2)
+
+	_, err = Load(config.EncryptionConfig{})
+	assert.Error(t, err)
+
+	_, err = Load(config.EncryptionConfig{MasterKey: encode([]byte("too short"))})
+	assert.Error(t, err)
+}
@@ -0,0 +1,104 @@
+package exporter
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/yhwbach/makerble/internal/config"
+	"github.com/yhwbach/makerble/internal/fhir"
+	"github.com/yhwbach/makerble/internal/models"
+	"github.com/yhwbach/makerble/internal/repository"
+	"github.com/yhwbach/makerble/internal/schemas"
+)
+
+// Exporter runs FHIR bulk data exports in the background, a few at a time
+type Exporter struct {
+	Repo repository.RepoStorage
+
+	slots   chan struct{}
+	running sync.WaitGroup
+}
+
+// New creates an exporter with the limits of the configuration
+func New(cfg config.ExportConfig, repo repository.RepoStorage) *Exporter {
+	concurrency := cfg.Concurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	return &Exporter{
+		Repo:  repo,
+		slots: make(chan struct{}, concurrency),
+	}
+}
+
+// Job is a bulk export requested, ready to run
+type Job struct {
+	Export *models.PatientExport
+	// Query restricts the export to the patients the requester has access to
+	Query   schemas.PatientQuery
+	Request *fhir.ExportRequest
+}
+
+// Start records the export and runs it in the background, once fewer exports than the configured
+// concurrency run. The export runs in the clinic of the context, without its cancellation.
+func (e *Exporter) Start(ctx context.Context, job *Job) error {
+	job.Export.Status = models.PatientExportPending
+	if err := e.Repo.PatientExports.Create(ctx, job.Export); err != nil {
+		return err
+	}
+
+	ctx = context.WithoutCancel(ctx)
+	e.running.Add(1)
+	go func() {
+		defer e.running.Done()
+		e.slots <- struct{}{}
+		defer func() { <-e.slots }()
+
+		e.Run(ctx, job)
+	}()
+
+	return nil
+}
+
+// Wait waits for the exports started to finish
+func (e *Exporter) Wait() {
+	e.running.Wait()
+}
+
+// Run selects the patients of an export and records them, completing it
+func (e *Exporter) Run(ctx context.Context, job *Job) {
+	export := job.Export
+	export.Status = models.PatientExportRunning
+	if err := e.Repo.PatientExports.Update(ctx, export); err != nil {
+		slog.ErrorContext(ctx, "failed to record patient export progress", "export_id", export.ID, "error", err)
+	}
+
+	query := job.Query
+	query.UpdatedSince = job.Request.Since
+	patientIDs := []uuid.UUID{}
+	err := e.Repo.Patients.Stream(ctx, query, func(patient schemas.Patients) error {
+		if job.Request.Matches(patient.Patient) {
+			patientIDs = append(patientIDs, patient.ID)
+		}
+		return nil
+	})
+
+	now := time.Now()
+	export.FinishedAt = &now
+	export.Status = models.PatientExportCompleted
+	export.PatientIDs = patientIDs
+	if err != nil {
+		slog.ErrorContext(ctx, "patient export failed", "export_id", export.ID, "error", err)
+		export.Status = models.PatientExportFailed
+		export.PatientIDs = nil
+		export.Error = "The export stopped on an internal error"
+	}
+
+	if err := e.Repo.PatientExports.Update(ctx, export); err != nil {
+		slog.ErrorContext(ctx, "failed to record patient export outcome", "export_id", export.ID, "error", err)
+	}
+}
@@ -0,0 +1,142 @@
+// Package exporter exports patients in bulk. Patient lists are written as CSV or NDJSON while they
+// are read from the database, one patient at a time, and FHIR bulk data exports select their
+// patients in the background for the files to be downloaded later.
+package exporter
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/yhwbach/makerble/internal/fhir"
+	"github.com/yhwbach/makerble/internal/schemas"
+)
+
+// Formats of patient list exports
+const (
+	FormatCSV    = "csv"
+	FormatNDJSON = "ndjson"
+)
+
+// Formats lists the formats patient lists can be exported in
+var Formats = []string{FormatCSV, FormatNDJSON}
+
+// IsValidFormat reports whether the format is one of the known formats
+func IsValidFormat(format string) bool {
+	return slices.Contains(Formats, format)
+}
+
+// ContentType returns the media type of the format
+func ContentType(format string) string {
+	if format == FormatNDJSON {
+		return "application/x-ndjson"
+	}
+	return "text/csv; charset=utf-8"
+}
+
+// Header lists the columns of CSV exports. medical_history is left out when the clinical fields
+// are withheld.
+var Header = []string{
+	"id", "full_name", "date_of_birth", "gender", "address", "phone", "email", "medical_history",
+	"consents", "registered_by", "registered_by_name", "clinic_id", "created_at", "updated_at",
+}
+
+// Writer writes patients one at a time in an export format
+type Writer interface {
+	Write(patient schemas.Patients) error
+	// Flush writes any buffered patients to the underlying writer
+	Flush() error
+}
+
+// NewWriter creates a writer of the format. Unless clinical, the clinical fields are left out,
+// which the patients written must already be redacted of.
+func NewWriter(w io.Writer, format string, clinical bool) (Writer, error) {
+	switch format {
+	case FormatCSV:
+		header := Header
+		if !clinical {
+			header = slices.DeleteFunc(slices.Clone(Header), func(column string) bool { return column == "medical_history" })
+		}
+		out := csv.NewWriter(w)
+		if err := out.Write(header); err != nil {
+			return nil, err
+		}
+		return &csvWriter{out: out, clinical: clinical}, nil
+	case FormatNDJSON:
+		return &ndjsonWriter{out: json.NewEncoder(w)}, nil
+	default:
+		return nil, fmt.Errorf("unsupported format %q", format)
+	}
+}
+
+type csvWriter struct {
+	out      *csv.Writer
+	clinical bool
+}
+
+func (c *csvWriter) Write(patient schemas.Patients) error {
+	record := []string{
+		patient.ID.String(), patient.FullName, patient.DateOfBirth.Format("2006-01-02"), string(patient.Gender),
+		patient.Address, patient.Phone, patient.Email,
+	}
+	if c.clinical {
+		record = append(record, patient.MedicalHistory)
+	}
+	record = append(record,
+		strings.Join(patient.Consents, ";"), patient.RegisteredByUser.ID, patient.RegisteredByUser.FullName,
+		patient.ClinicID.String(), patient.CreatedAt.UTC().Format(time.RFC3339), patient.UpdatedAt.UTC().Format(time.RFC3339),
+	)
+	for i, cell := range record {
+		record[i] = neutralizeFormula(cell)
+	}
+	return c.out.Write(record)
+}
+
+// neutralizeFormula prefixes cells spreadsheets would evaluate as formulas with a quote, so that
+// values such as a name starting with = are shown as text rather than run when the file is opened
+func neutralizeFormula(cell string) string {
+	if cell != "" && strings.ContainsRune("=+-@\t\r", rune(cell[0])) {
+		return "'" + cell
+	}
+	return cell
+}
+
+func (c *csvWriter) Flush() error {
+	c.out.Flush()
+	return c.out.Error()
+}
+
+// ndjsonWriter writes each patient on a line, as the API lists them
+type ndjsonWriter struct {
+	out *json.Encoder
+}
+
+func (n *ndjsonWriter) Write(patient schemas.Patients) error {
+	return n.out.Encode(patient)
+}
+
+func (n *ndjsonWriter) Flush() error {
+	return nil
+}
+
+// NewFHIRWriter creates a writer of the NDJSON files of FHIR bulk data exports, writing each
+// patient as a Patient resource on a line
+func NewFHIRWriter(w io.Writer) Writer {
+	return &fhirWriter{out: json.NewEncoder(w)}
+}
+
+type fhirWriter struct {
+	out *json.Encoder
+}
+
+func (f *fhirWriter) Write(patient schemas.Patients) error {
+	return f.out.Encode(fhir.FromPatient(patient.Patient))
+}
+
+func (f *fhirWriter) Flush() error {
+	return nil
+}
@@ -0,0 +1,168 @@
+package exporter
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yhwbach/makerble/internal/config"
+	"github.com/yhwbach/makerble/internal/fhir"
+	"github.com/yhwbach/makerble/internal/models"
+	"github.com/yhwbach/makerble/internal/repository"
+	"github.com/yhwbach/makerble/internal/repository/mock"
+	"github.com/yhwbach/makerble/internal/schemas"
+)
+
+func testPatient() schemas.Patients {
+	patient := schemas.Patients{Patient: &models.Patient{
+		ID:             uuid.MustParse("6f1c2a44-8f0e-4c55-9b1e-1f2d3c4b5a69"),
+		FullName:       "Doe, Jane",
+		DateOfBirth:    time.Date(1990, 4, 12, 0, 0, 0, 0, time.UTC),
+		Gender:         models.Female,
+		Address:        "12 Main Street\nSpringfield",
+		Email:          "jane@example.com",
+		MedicalHistory: "Asthma",
+		Consents:       []string{"treatment", "research"},
+		ClinicID:       models.DefaultClinicID,
+		CreatedAt:      time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC),
+		UpdatedAt:      time.Date(2025, 6, 2, 12, 0, 0, 0, time.UTC),
+	}}
+	patient.RegisteredByUser.ID = "0b7e5a4c-1d2e-4f3a-8b9c-0d1e2f3a4b5c"
+	patient.RegisteredByUser.FullName = "Dr Smith"
+	return patient
+}
+
+func TestNewWriter(t *testing.T) {
+	patient := testPatient()
+
+	t.Run("csv", func(t *testing.T) {
+		var buf bytes.Buffer
+		out, err := NewWriter(&buf, FormatCSV, true)
+		require.NoError(t, err)
+		require.NoError(t, out.Write(patient))
+		require.NoError(t, out.Flush())
+
+		assert.Equal(t, strings.Join(Header, ",")+"\n"+
+			"6f1c2a44-8f0e-4c55-9b1e-1f2d3c4b5a69,\"Doe, Jane\",1990-04-12,female,\"12 Main Street\nSpringfield\",,jane@example.com,Asthma,"+
+			"treatment;research,0b7e5a4c-1d2e-4f3a-8b9c-0d1e2f3a4b5c,Dr Smith,"+models.DefaultClinicID.String()+
+			",2025-06-01T12:00:00Z,2025-06-02T12:00:00Z\n", buf.String())
+	})
+
+	t.Run("csv without clinical fields", func(t *testing.T) {
+		var buf bytes.Buffer
+		out, err := NewWriter(&buf, FormatCSV, false)
+		require.NoError(t, err)
+		patient.RedactClinical()
+		require.NoError(t, out.Write(patient))
+		require.NoError(t, out.Flush())
+
+		header, _, _ := strings.Cut(buf.String(), "\n")
+		assert.NotContains(t, header, "medical_history")
+		assert.NotContains(t, buf.String(), "Asthma")
+	})
+
+	t.Run("csv formulas", func(t *testing.T) {
+		var buf bytes.Buffer
+		out, err := NewWriter(&buf, FormatCSV, true)
+		require.NoError(t, err)
+		formulas := testPatient()
+		formulas.FullName = "=HYPERLINK(\"http://evil.example\")"
+		formulas.Address = "@SUM(1+1)"
+		formulas.Phone = "+1 555 0100"
+		formulas.MedicalHistory = "-2+3"
+		require.NoError(t, out.Write(formulas))
+		require.NoError(t, out.Flush())
+
+		records, err := csv.NewReader(&buf).ReadAll()
+		require.NoError(t, err)
+		require.Len(t, records, 2)
+		assert.Equal(t, `'=HYPERLINK("http://evil.example")`, records[1][1])
+		assert.Equal(t, "'@SUM(1+1)", records[1][4])
+		assert.Equal(t, "'+1 555 0100", records[1][5])
+		assert.Equal(t, "jane@example.com", records[1][6])
+		assert.Equal(t, "'-2+3", records[1][7])
+	})
+
+	t.Run("ndjson", func(t *testing.T) {
+		var buf bytes.Buffer
+		out, err := NewWriter(&buf, FormatNDJSON, true)
+		require.NoError(t, err)
+		require.NoError(t, out.Write(testPatient()))
+		require.NoError(t, out.Write(testPatient()))
+		require.NoError(t, out.Flush())
+
+		lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+		require.Len(t, lines, 2)
+		var decoded schemas.Patients
+		require.NoError(t, json.Unmarshal([]byte(lines[0]), &decoded))
+		assert.Equal(t, "Doe, Jane", decoded.FullName)
+		assert.Equal(t, "Dr Smith", decoded.RegisteredByUser.FullName)
+	})
+
+	t.Run("fhir", func(t *testing.T) {
+		var buf bytes.Buffer
+		out := NewFHIRWriter(&buf)
+		require.NoError(t, out.Write(testPatient()))
+		require.NoError(t, out.Flush())
+
+		var resource fhir.Patient
+		require.NoError(t, json.Unmarshal(buf.Bytes(), &resource))
+		assert.Equal(t, "Patient", resource.ResourceType)
+		assert.Equal(t, "6f1c2a44-8f0e-4c55-9b1e-1f2d3c4b5a69", resource.ID)
+		assert.NotContains(t, buf.String(), "Asthma")
+	})
+
+	t.Run("unknown format", func(t *testing.T) {
+		_, err := NewWriter(&bytes.Buffer{}, "xml", true)
+		assert.Error(t, err)
+		assert.False(t, IsValidFormat("xml"))
+	})
+}
+
+func TestExporter(t *testing.T) {
+	repo := mock.NewMockRepoStorage()
+	ctx := repository.WithClinic(context.Background(), models.DefaultClinicID)
+	userID := uuid.New()
+
+	created := time.Now().Add(-48 * time.Hour)
+	for _, patient := range []*schemas.PatientCreate{
+		{FullName: "Jane Doe", DateOfBirth: "1990-04-12", Gender: models.Female},
+		{FullName: "Sam Roe", DateOfBirth: "1980-01-01", Gender: models.Male},
+	} {
+		_, err := repo.Patients.Create(ctx, userID, patient, created, nil)
+		require.NoError(t, err)
+	}
+	recentID, err := repo.Patients.Create(ctx, userID, &schemas.PatientCreate{
+		FullName: "Kim Lee", DateOfBirth: "2001-02-03", Gender: models.Female,
+	}, time.Now(), nil)
+	require.NoError(t, err)
+
+	request, issues := fhir.ParseExport(url.Values{
+		"_since":      {time.Now().Add(-time.Hour).Format(time.RFC3339)},
+		"_typeFilter": {"Patient?gender=female"},
+	})
+	require.Empty(t, issues)
+
+	exporter := New(config.ExportConfig{Concurrency: 1}, repo)
+	job := &Job{
+		Export:  &models.PatientExport{CreatedBy: userID, Request: "/fhir/Patient/$export"},
+		Request: request,
+	}
+	require.NoError(t, exporter.Start(ctx, job))
+	exporter.Wait()
+
+	export, err := repo.PatientExports.FindByID(ctx, job.Export.ID)
+	require.NoError(t, err)
+	require.NotNil(t, export)
+	assert.Equal(t, models.PatientExportCompleted, export.Status)
+	assert.NotNil(t, export.FinishedAt)
+	assert.Equal(t, []uuid.UUID{uuid.MustParse(recentID)}, export.PatientIDs)
+}
@@ -0,0 +1,150 @@
+package fhir
+
+import (
+	"fmt"
+	"maps"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/yhwbach/makerble/internal/models"
+)
+
+// The bulk data export of patients follows the FHIR Bulk Data Access IG
+// (https://hl7.org/fhir/uv/bulkdata/), which exports resources asynchronously as NDJSON files.
+
+// NDJSONContentType is the media type of the files of bulk exports, one resource per line
+const NDJSONContentType = "application/fhir+ndjson"
+
+// ExportOperation is the definition of the $export operation of the Patient type
+const ExportOperation = "http://hl7.org/fhir/uv/bulkdata/OperationDefinition/patient-export"
+
+// exportOutputFormats are the values of _outputFormat meaning NDJSON, the only format supported
+var exportOutputFormats = []string{NDJSONContentType, "application/ndjson", "ndjson"}
+
+// ExportRequest is the kick-off request of a bulk export of patients
+type ExportRequest struct {
+	// Since limits the export to the patients created or updated since then
+	Since *time.Time
+	// Filters are the searches of _typeFilter. Patients are exported when they match any of them.
+	Filters []*Search
+}
+
+// ParseExport parses the parameters of a kick-off request. Only Patient resources are exported,
+// so _type may only name Patient, and _typeFilter only holds Patient searches. Parameters
+// narrowing the export that are not supported are rejected rather than ignored, as ignoring them
+// would export more than asked for.
+func ParseExport(query url.Values) (*ExportRequest, []Issue) {
+	request := &ExportRequest{}
+	var issues []Issue
+
+	if format := query.Get("_outputFormat"); format != "" && !slices.Contains(exportOutputFormats, format) {
+		issues = append(issues, ErrorIssue(IssueNotSupported,
+			fmt.Sprintf("Output format %s is not supported, use %s", format, NDJSONContentType), "_outputFormat"))
+	}
+
+	for _, types := range query["_type"] {
+		for _, resourceType := range strings.Split(types, ",") {
+			if strings.TrimSpace(resourceType) != "Patient" {
+				issues = append(issues, ErrorIssue(IssueNotSupported,
+					fmt.Sprintf("Resource type %s is not supported, only Patient is exported", resourceType), "_type"))
+			}
+		}
+	}
+
+	if since := query.Get("_since"); since != "" {
+		parsed, err := time.Parse(time.RFC3339Nano, since)
+		if err != nil {
+			issues = append(issues, ErrorIssue(IssueValue, "_since must be an instant, e.g. 2024-01-31T00:00:00Z", "_since"))
+		} else {
+			request.Since = &parsed
+		}
+	}
+
+	for _, value := range query["_typeFilter"] {
+		for _, filter := range splitTypeFilter(value) {
+			search, filterIssues := parseTypeFilter(filter)
+			issues = append(issues, filterIssues...)
+			if search != nil {
+				request.Filters = append(request.Filters, search)
+			}
+		}
+	}
+
+	for _, param := range []string{"patient", "_elements", "includeAssociatedData"} {
+		if query.Has(param) {
+			issues = append(issues, ErrorIssue(IssueNotSupported, fmt.Sprintf("Parameter %s is not supported", param), param))
+		}
+	}
+
+	return request, issues
+}
+
+// Matches reports whether the patient is part of the export, leaving _since to the query
+func (e *ExportRequest) Matches(patient *models.Patient) bool {
+	if len(e.Filters) == 0 {
+		return true
+	}
+	return slices.ContainsFunc(e.Filters, func(search *Search) bool { return search.Matches(patient) })
+}
+
+// splitTypeFilter splits a _typeFilter value holding several searches separated by commas. Commas
+// within a search, between the values of a parameter, do not start a new one.
+func splitTypeFilter(value string) []string {
+	var filters []string
+	for _, part := range strings.Split(value, ",") {
+		if len(filters) > 0 && !strings.Contains(part, "?") {
+			filters[len(filters)-1] += "," + part
+			continue
+		}
+		filters = append(filters, part)
+	}
+	return filters
+}
+
+// parseTypeFilter parses a search of _typeFilter, such as Patient?gender=female
+func parseTypeFilter(filter string) (*Search, []Issue) {
+	resourceType, rawQuery, _ := strings.Cut(filter, "?")
+	if resourceType != "Patient" {
+		return nil, []Issue{ErrorIssue(IssueNotSupported,
+			fmt.Sprintf("Filters on %s are not supported, only Patient is exported", resourceType), "_typeFilter")}
+	}
+
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return nil, []Issue{ErrorIssue(IssueInvalid, "Invalid _typeFilter search", "_typeFilter")}
+	}
+
+	var issues []Issue
+	for _, key := range slices.Sorted(maps.Keys(query)) {
+		param, _, _ := strings.Cut(key, ":")
+		if !slices.ContainsFunc(SearchParams, func(p SearchParam) bool { return p.Name == param }) {
+			issues = append(issues, ErrorIssue(IssueNotSupported,
+				fmt.Sprintf("Search parameter %s is not supported in _typeFilter", param), "_typeFilter"))
+		}
+	}
+
+	search, searchIssues := ParseSearch(query)
+	issues = append(issues, searchIssues...)
+	if len(issues) > 0 {
+		return nil, issues
+	}
+	return search, nil
+}
+
+// ExportManifest is the response to the status request of a completed bulk export
+type ExportManifest struct {
+	TransactionTime     string         `json:"transactionTime"`
+	Request             string         `json:"request"`
+	RequiresAccessToken bool           `json:"requiresAccessToken"`
+	Output              []ExportOutput `json:"output"`
+	Error               []ExportOutput `json:"error"`
+}
+
+// ExportOutput is a file of a bulk export, holding resources of one type
+type ExportOutput struct {
+	Type  string `json:"type"`
+	URL   string `json:"url"`
+	Count int    `json:"count,omitempty"`
+}
@@ -0,0 +1,97 @@
+package fhir
+
+import "time"
+
+// CapabilityStatement describes what the server supports
+type CapabilityStatement struct {
+	ResourceType   string                   `json:"resourceType"`
+	Status         string                   `json:"status"`
+	Date           string                   `json:"date"`
+	Kind           string                   `json:"kind"`
+	Software       CapabilitySoftware       `json:"software"`
+	Implementation CapabilityImplementation `json:"implementation"`
+	FHIRVersion    string                   `json:"fhirVersion"`
+	Format         []string                 `json:"format"`
+	Rest           []CapabilityRest         `json:"rest"`
+}
+
+// CapabilitySoftware names the software implementing the server
+type CapabilitySoftware struct {
+	Name string `json:"name"`
+}
+
+// CapabilityImplementation describes the instance of the server
+type CapabilityImplementation struct {
+	Description string `json:"description"`
+	URL         string `json:"url"`
+}
+
+// CapabilityRest describes the RESTful interface of the server
+type CapabilityRest struct {
+	Mode     string               `json:"mode"`
+	Security CapabilitySecurity   `json:"security"`
+	Resource []CapabilityResource `json:"resource"`
+}
+
+// CapabilitySecurity describes how clients authenticate
+type CapabilitySecurity struct {
+	Description string `json:"description"`
+}
+
+// CapabilityResource describes the interactions supported on a resource type
+type CapabilityResource struct {
+	Type         string                  `json:"type"`
+	Profile      string                  `json:"profile"`
+	Interaction  []CapabilityInteraction `json:"interaction"`
+	Versioning   string                  `json:"versioning"`
+	ReadHistory  bool                    `json:"readHistory"`
+	UpdateCreate bool                    `json:"updateCreate"`
+	SearchParam  []SearchParam           `json:"searchParam"`
+	Operation    []CapabilityOperation   `json:"operation,omitempty"`
+}
+
+// CapabilityOperation is an operation supported on a resource type
+type CapabilityOperation struct {
+	Name       string `json:"name"`
+	Definition string `json:"definition"`
+}
+
+// CapabilityInteraction is an interaction supported on a resource type
+type CapabilityInteraction struct {
+	Code string `json:"code"`
+}
+
+// Capabilities describes the server available at the base URL
+func Capabilities(baseURL string, date time.Time) *CapabilityStatement {
+	return &CapabilityStatement{
+		ResourceType: "CapabilityStatement",
+		Status:       "active",
+		Date:         date.Format("2006-01-02"),
+		Kind:         "instance",
+		Software:     CapabilitySoftware{Name: "Makerble"},
+		Implementation: CapabilityImplementation{
+			Description: "Makerble patient records",
+			URL:         baseURL,
+		},
+		FHIRVersion: Version,
+		Format:      []string{"json"},
+		Rest: []CapabilityRest{{
+			Mode: "server",
+			Security: CapabilitySecurity{
+				Description: "Bearer access tokens of users, or API keys of service accounts in the Authorization or X-API-Key header",
+			},
+			Resource: []CapabilityResource{{
+				Type:    "Patient",
+				Profile: "http://hl7.org/fhir/StructureDefinition/Patient",
+				Interaction: []CapabilityInteraction{
+					{Code: "read"}, {Code: "search-type"}, {Code: "create"}, {Code: "update"},
+				},
+				Versioning:   "no-version",
+				ReadHistory:  false,
+				UpdateCreate: false,
+				SearchParam:  SearchParams,
+				Operation:    []CapabilityOperation{{Name: "export", Definition: ExportOperation}},
+			}},
+		}},
+	}
+}
@@ -0,0 +1,228 @@
+package fhir
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yhwbach/makerble/internal/models"
+)
+
+func testPatient() *models.Patient {
+	return &models.Patient{
+		ID:             uuid.New(),
+		FullName:       "Jane Mary Doe",
+		DateOfBirth:    time.Date(1990, 4, 12, 0, 0, 0, 0, time.UTC),
+		Gender:         models.Female,
+		Address:        "12 Main Street, Springfield",
+		Phone:          "+1 555 0100",
+		Email:          "jane@example.com",
+		MedicalHistory: "Asthma",
+		UpdatedAt:      time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC),
+	}
+}
+
+func TestFromPatient(t *testing.T) {
+	patient := testPatient()
+	resource := FromPatient(patient)
+
+	assert.Equal(t, "Patient", resource.ResourceType)
+	assert.Equal(t, patient.ID.String(), resource.ID)
+	assert.Equal(t, "2025-06-01T12:00:00Z", resource.Meta.LastUpdated)
+	assert.Equal(t, []Identifier{{Use: "official", System: IdentifierSystem, Value: "urn:uuid:" + patient.ID.String()}}, resource.Identifier)
+	assert.True(t, *resource.Active)
+	assert.Equal(t, []HumanName{{Use: "official", Text: "Jane Mary Doe", Family: "Doe", Given: []string{"Jane", "Mary"}}}, resource.Name)
+	assert.Equal(t, "female", resource.Gender)
+	assert.Equal(t, "1990-04-12", resource.BirthDate)
+	assert.Equal(t, []ContactPoint{{System: "phone", Value: "+1 555 0100"}, {System: "email", Value: "jane@example.com"}}, resource.Telecom)
+	assert.Equal(t, []Address{{Text: "12 Main Street, Springfield"}}, resource.Address)
+
+	archivedAt := time.Now()
+	patient.ArchivedAt = &archivedAt
+	assert.False(t, *FromPatient(patient).Active, "archived patients are inactive")
+}
+
+func TestPatientCreate(t *testing.T) {
+	resource := &Patient{
+		ResourceType: "Patient",
+		Name: []HumanName{
+			{Use: "nickname", Text: "JD"},
+			{Use: "official", Prefix: []string{"Dr."}, Given: []string{"Jane", "Mary"}, Family: "Doe"},
+		},
+		Telecom: []ContactPoint{
+			{System: "fax", Value: "+1 555 0199"},
+			{System: "phone", Value: "+1 555 0100"},
+			{System: "email", Value: "jane@example.com"},
+		},
+		Gender:    "female",
+		BirthDate: "1990-04-12",
+		Address:   []Address{{Line: []string{"12 Main Street"}, City: "Springfield", State: "IL", PostalCode: "62704", Country: "US"}},
+	}
+	require.Empty(t, resource.Validate())
+
+	create := resource.PatientCreate()
+	assert.Equal(t, "Dr. Jane Mary Doe", create.FullName)
+	assert.Equal(t, "1990-04-12", create.DateOfBirth)
+	assert.Equal(t, models.Female, create.Gender)
+	assert.Equal(t, "12 Main Street, Springfield, IL 62704, US", create.Address)
+	assert.Equal(t, "+1 555 0100", create.Phone)
+	assert.Equal(t, "jane@example.com", create.Email)
+	assert.Empty(t, create.MedicalHistory)
+}
+
+func TestValidate(t *testing.T) {
+	expressions := func(issues []Issue) []string {
+		var expressions []string
+		for _, issue := range issues {
+			expressions = append(expressions, issue.Expression...)
+		}
+		return expressions
+	}
+
+	assert.Equal(t, []string{"Patient.resourceType", "Patient.name", "Patient.gender", "Patient.birthDate"},
+		expressions((&Patient{ResourceType: "Practitioner"}).Validate()))
+
+	assert.Equal(t, []string{"Patient.gender", "Patient.birthDate"}, expressions((&Patient{
+		ResourceType: "Patient",
+		Name:         []HumanName{{Family: "Doe"}},
+		Gender:       "unknown",
+		BirthDate:    "1990",
+	}).Validate()), "only male and female and full dates can be stored")
+}
+
+func TestPatientUpdate(t *testing.T) {
+	patient := testPatient()
+	resource := FromPatient(patient)
+
+	update := resource.PatientUpdate(patient)
+	assert.Empty(t, update.Fields(), "nothing changed")
+
+	resource.Telecom = resource.Telecom[:1]
+	resource.Gender = "male"
+	update = resource.PatientUpdate(patient)
+	assert.Equal(t, []string{"email", "gender"}, update.Fields())
+	assert.Equal(t, "", *update.Email, "elements left out of the resource are cleared")
+	assert.Equal(t, models.Male, *update.Gender)
+	assert.Nil(t, update.MedicalHistory, "clinical fields are not part of the resource")
+}
+
+func TestSearch(t *testing.T) {
+	patient := testPatient()
+
+	for _, tt := range []struct {
+		query   string
+		matches bool
+	}{
+		{"", true},
+		{"name=jan", true},
+		{"name=MARY", true},
+		{"name=oe", false},
+		{"name:contains=oe", true},
+		{"name:exact=Jane Mary Doe", true},
+		{"name:exact=jane mary doe", false},
+		{"name=smith,doe", true},
+		{"name=jane&name=smith", false},
+		{"gender=female", true},
+		{"gender=male", false},
+		{"birthdate=1990", true},
+		{"birthdate=1990-04", true},
+		{"birthdate=1990-04-12", true},
+		{"birthdate=1990-04-13", false},
+		{"birthdate=ne1990-04-13", true},
+		{"birthdate=lt1990-04-12", false},
+		{"birthdate=le1990-04-12", true},
+		{"birthdate=gt1990-03", true},
+		{"birthdate=ge1991", false},
+		{"birthdate=ge1990-01-01&birthdate=lt1991-01-01", true},
+		{"identifier=urn:uuid:" + patient.ID.String(), true},
+		{"identifier=" + IdentifierSystem + "|urn:uuid:" + patient.ID.String(), true},
+		{"identifier=http://hospital.example/mrn|urn:uuid:" + patient.ID.String(), false},
+		{"_id=" + patient.ID.String(), true},
+		{"_id=" + uuid.NewString(), false},
+		{"_id=1", false},
+		{"name=jane mary", true},
+		{"name=mary doe", false},
+		{"_count=10&_format=json&unknown=1", true},
+	} {
+		t.Run(tt.query, func(t *testing.T) {
+			query, err := url.ParseQuery(tt.query)
+			require.NoError(t, err)
+
+			search, issues := ParseSearch(query)
+			require.Empty(t, issues)
+			assert.Equal(t, tt.matches, search.Matches(patient))
+		})
+	}
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, query := range []string{"birthdate=12/04/1990", "birthdate=sa1990", "gender:not=male", "_count=0", "_offset=-1"} {
+			values, err := url.ParseQuery(query)
+			require.NoError(t, err)
+
+			_, issues := ParseSearch(values)
+			assert.Len(t, issues, 1, query)
+		}
+	})
+
+	t.Run("paging", func(t *testing.T) {
+		search, issues := ParseSearch(url.Values{})
+		require.Empty(t, issues)
+		assert.Equal(t, DefaultCount, search.Count)
+		assert.Zero(t, search.Offset)
+
+		search, issues = ParseSearch(url.Values{"_count": {"100000"}, "_offset": {"20"}})
+		require.Empty(t, issues)
+		assert.Equal(t, MaxCount, search.Count)
+		assert.Equal(t, 20, search.Offset)
+	})
+}
+
+func TestParseExport(t *testing.T) {
+	patient := testPatient()
+
+	for _, tt := range []struct {
+		query   string
+		matches bool
+	}{
+		{"", true},
+		{"_type=Patient&_outputFormat=application/fhir%2Bndjson", true},
+		{"_typeFilter=Patient?gender=female", true},
+		{"_typeFilter=Patient?gender=male", false},
+		{"_typeFilter=Patient?gender=male,Patient?name=jane", true},
+		{"_typeFilter=Patient?name=smith,doe", true},
+		{"_typeFilter=Patient?gender=male&_typeFilter=Patient?birthdate=1990", true},
+	} {
+		t.Run(tt.query, func(t *testing.T) {
+			query, err := url.ParseQuery(tt.query)
+			require.NoError(t, err)
+
+			request, issues := ParseExport(query)
+			require.Empty(t, issues)
+			assert.Equal(t, tt.matches, request.Matches(patient))
+		})
+	}
+
+	t.Run("since", func(t *testing.T) {
+		request, issues := ParseExport(url.Values{"_since": {"2025-06-01T12:00:00Z"}})
+		require.Empty(t, issues)
+		require.NotNil(t, request.Since)
+		assert.True(t, request.Since.Equal(patient.UpdatedAt))
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, query := range []string{
+			"_type=Observation", "_type=Patient,Observation", "_outputFormat=text/csv", "_since=2025-06-01",
+			"_typeFilter=Observation?code=1", "_typeFilter=Patient?address=Springfield",
+			"_typeFilter=Patient?birthdate=sa1990", "patient=Patient/1", "_elements=name",
+		} {
+			values, err := url.ParseQuery(query)
+			require.NoError(t, err)
+
+			_, issues := ParseExport(values)
+			assert.Len(t, issues, 1, query)
+		}
+	})
+}
@@ -0,0 +1,178 @@
+package fhir
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/yhwbach/makerble/internal/models"
+	"github.com/yhwbach/makerble/internal/schemas"
+)
+
+// IdentifierSystem is the system of the identifier every patient carries, their ID as a URN
+const IdentifierSystem = "urn:ietf:rfc:3986"
+
+// PatientFields are the patient fields a Patient resource discloses. Clinical fields are not part
+// of the resource.
+var PatientFields = []string{"full_name", "date_of_birth", "gender", "address", "phone", "email"}
+
+// FromPatient maps a patient to a Patient resource
+func FromPatient(patient *models.Patient) *Patient {
+	active := patient.ArchivedAt == nil
+	resource := &Patient{
+		ResourceType: "Patient",
+		ID:           patient.ID.String(),
+		Meta:         &Meta{LastUpdated: patient.UpdatedAt.UTC().Format(time.RFC3339)},
+		Identifier: []Identifier{
+			{Use: "official", System: IdentifierSystem, Value: "urn:uuid:" + patient.ID.String()},
+		},
+		Active:    &active,
+		Name:      []HumanName{splitName(patient.FullName)},
+		Gender:    string(patient.Gender),
+		BirthDate: patient.DateOfBirth.Format("2006-01-02"),
+	}
+
+	if patient.Phone != "" {
+		resource.Telecom = append(resource.Telecom, ContactPoint{System: "phone", Value: patient.Phone})
+	}
+	if patient.Email != "" {
+		resource.Telecom = append(resource.Telecom, ContactPoint{System: "email", Value: patient.Email})
+	}
+	if patient.Address != "" {
+		resource.Address = []Address{{Text: patient.Address}}
+	}
+
+	return resource
+}
+
+// splitName takes the last word of a full name as the family name
+func splitName(fullName string) HumanName {
+	name := HumanName{Use: "official", Text: fullName}
+	words := strings.Fields(fullName)
+	if len(words) > 0 {
+		name.Family = words[len(words)-1]
+		name.Given = words[:len(words)-1]
+	}
+	return name
+}
+
+// Validate lists what keeps the resource from being stored as a patient
+func (p *Patient) Validate() []Issue {
+	var issues []Issue
+
+	if p.ResourceType != "Patient" {
+		issues = append(issues, ErrorIssue(IssueInvalid, "Resource type must be Patient", "Patient.resourceType"))
+	}
+
+	if p.FullName() == "" {
+		issues = append(issues, ErrorIssue(IssueRequired, "Patient must have a name", "Patient.name"))
+	}
+
+	switch models.Gender(p.Gender) {
+	case models.Male, models.Female:
+	case "":
+		issues = append(issues, ErrorIssue(IssueRequired, "Patient must have a gender", "Patient.gender"))
+	default:
+		issues = append(issues, ErrorIssue(IssueValue,
+			fmt.Sprintf("Gender %q is not supported, use male or female", p.Gender), "Patient.gender"))
+	}
+
+	if p.BirthDate == "" {
+		issues = append(issues, ErrorIssue(IssueRequired, "Patient must have a birth date", "Patient.birthDate"))
+	} else if _, err := time.Parse("2006-01-02", p.BirthDate); err != nil {
+		issues = append(issues, ErrorIssue(IssueValue, "Birth date must be a full date (YYYY-MM-DD)", "Patient.birthDate"))
+	}
+
+	return issues
+}
+
+// FullName is the name of the patient, taken from their official name if they have several
+func (p *Patient) FullName() string {
+	if len(p.Name) == 0 {
+		return ""
+	}
+
+	name := p.Name[0]
+	if i := slices.IndexFunc(p.Name, func(n HumanName) bool { return n.Use == "official" }); i >= 0 {
+		name = p.Name[i]
+	}
+
+	if text := strings.TrimSpace(name.Text); text != "" {
+		return text
+	}
+
+	var parts []string
+	parts = append(parts, name.Prefix...)
+	parts = append(parts, name.Given...)
+	parts = append(parts, name.Family)
+	parts = append(parts, name.Suffix...)
+	return strings.Join(strings.Fields(strings.Join(parts, " ")), " ")
+}
+
+// contact is the first value of the contact system, e.g. phone or email
+func (p *Patient) contact(system string) string {
+	for _, telecom := range p.Telecom {
+		if telecom.System == system && telecom.Value != "" {
+			return telecom.Value
+		}
+	}
+	return ""
+}
+
+// address is the first address of the patient as a single line
+func (p *Patient) address() string {
+	if len(p.Address) == 0 {
+		return ""
+	}
+
+	address := p.Address[0]
+	if address.Text != "" {
+		return address.Text
+	}
+
+	var parts []string
+	parts = append(parts, address.Line...)
+	for _, part := range []string{address.City, address.District, strings.TrimSpace(address.State + " " + address.PostalCode), address.Country} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// PatientCreate maps a valid resource to a request creating the patient
+func (p *Patient) PatientCreate() *schemas.PatientCreate {
+	return &schemas.PatientCreate{
+		FullName:    p.FullName(),
+		DateOfBirth: p.BirthDate,
+		Gender:      models.Gender(p.Gender),
+		Address:     p.address(),
+		Phone:       p.contact("phone"),
+		Email:       p.contact("email"),
+	}
+}
+
+// PatientUpdate maps a valid resource to an update replacing the patient with it. Only the fields
+// that differ from the existing patient are set. Elements missing from the resource are cleared.
+func (p *Patient) PatientUpdate(existing *models.Patient) *schemas.PatientUpdate {
+	var update schemas.PatientUpdate
+
+	changed := func(value, current string) *string {
+		if value == current {
+			return nil
+		}
+		return &value
+	}
+
+	update.FullName = changed(p.FullName(), existing.FullName)
+	update.DateOfBirth = changed(p.BirthDate, existing.DateOfBirth.Format("2006-01-02"))
+	update.Address = changed(p.address(), existing.Address)
+	update.Phone = changed(p.contact("phone"), existing.Phone)
+	update.Email = changed(p.contact("email"), existing.Email)
+	if gender := models.Gender(p.Gender); gender != existing.Gender {
+		update.Gender = &gender
+	}
+
+	return &update
+}
@@ -0,0 +1,153 @@
+// Package fhir maps patients to and from FHIR R4 resources, for the systems of partner hospitals.
+// Only the JSON format is supported.
+package fhir
+
+import "net/http"
+
+// Version is the FHIR version the server implements
+const Version = "4.0.1"
+
+// ContentType is the media type of FHIR JSON
+const ContentType = "application/fhir+json; charset=utf-8"
+
+// Patient is the FHIR Patient resource, limited to the elements the system stores
+type Patient struct {
+	ResourceType string         `json:"resourceType"`
+	ID           string         `json:"id,omitempty"`
+	Meta         *Meta          `json:"meta,omitempty"`
+	Identifier   []Identifier   `json:"identifier,omitempty"`
+	Active       *bool          `json:"active,omitempty"`
+	Name         []HumanName    `json:"name,omitempty"`
+	Telecom      []ContactPoint `json:"telecom,omitempty"`
+	Gender       string         `json:"gender,omitempty"`
+	BirthDate    string         `json:"birthDate,omitempty"`
+	Address      []Address      `json:"address,omitempty"`
+}
+
+// Meta holds the metadata of a resource
+type Meta struct {
+	LastUpdated string `json:"lastUpdated,omitempty"`
+}
+
+// Identifier identifies a resource in the system given by its URI
+type Identifier struct {
+	Use    string `json:"use,omitempty"`
+	System string `json:"system,omitempty"`
+	Value  string `json:"value,omitempty"`
+}
+
+// HumanName is the name of a person
+type HumanName struct {
+	Use    string   `json:"use,omitempty"`
+	Text   string   `json:"text,omitempty"`
+	Family string   `json:"family,omitempty"`
+	Given  []string `json:"given,omitempty"`
+	Prefix []string `json:"prefix,omitempty"`
+	Suffix []string `json:"suffix,omitempty"`
+}
+
+// ContactPoint is a phone number, email address or other way of contacting a person
+type ContactPoint struct {
+	System string `json:"system,omitempty"`
+	Value  string `json:"value,omitempty"`
+	Use    string `json:"use,omitempty"`
+}
+
+// Address is a postal address
+type Address struct {
+	Use        string   `json:"use,omitempty"`
+	Text       string   `json:"text,omitempty"`
+	Line       []string `json:"line,omitempty"`
+	City       string   `json:"city,omitempty"`
+	District   string   `json:"district,omitempty"`
+	State      string   `json:"state,omitempty"`
+	PostalCode string   `json:"postalCode,omitempty"`
+	Country    string   `json:"country,omitempty"`
+}
+
+// Bundle is a collection of resources, such as the result of a search
+type Bundle struct {
+	ResourceType string        `json:"resourceType"`
+	Type         string        `json:"type"`
+	Total        int           `json:"total"`
+	Link         []BundleLink  `json:"link,omitempty"`
+	Entry        []BundleEntry `json:"entry,omitempty"`
+}
+
+// BundleLink links to the bundle itself or to other pages of results
+type BundleLink struct {
+	Relation string `json:"relation"`
+	URL      string `json:"url"`
+}
+
+// BundleEntry is a resource in a bundle
+type BundleEntry struct {
+	FullURL  string       `json:"fullUrl"`
+	Resource any          `json:"resource"`
+	Search   *EntrySearch `json:"search,omitempty"`
+}
+
+// EntrySearch tells why an entry is part of search results
+type EntrySearch struct {
+	Mode string `json:"mode"`
+}
+
+// OperationOutcome reports the errors of a request
+type OperationOutcome struct {
+	ResourceType string  `json:"resourceType"`
+	Issue        []Issue `json:"issue"`
+}
+
+// Issue is a single error of an operation outcome. Expression points to the elements at fault.
+type Issue struct {
+	Severity    string   `json:"severity"`
+	Code        string   `json:"code"`
+	Diagnostics string   `json:"diagnostics,omitempty"`
+	Expression  []string `json:"expression,omitempty"`
+}
+
+// Issue codes used by the server
+const (
+	IssueInvalid      = "invalid"
+	IssueRequired     = "required"
+	IssueValue        = "value"
+	IssueLogin        = "login"
+	IssueForbidden    = "forbidden"
+	IssueNotFound     = "not-found"
+	IssueNotSupported = "not-supported"
+	IssueConflict     = "conflict"
+	IssueProcessing   = "processing"
+	IssueException    = "exception"
+)
+
+// NewOperationOutcome reports the issues as errors
+func NewOperationOutcome(issues ...Issue) *OperationOutcome {
+	return &OperationOutcome{ResourceType: "OperationOutcome", Issue: issues}
+}
+
+// ErrorIssue is an error of the given code
+func ErrorIssue(code, diagnostics string, expression ...string) Issue {
+	return Issue{Severity: "error", Code: code, Diagnostics: diagnostics, Expression: expression}
+}
+
+// StatusIssueCode is the issue code best describing an HTTP error status
+func StatusIssueCode(status int) string {
+	switch {
+	case status == http.StatusUnauthorized:
+		return IssueLogin
+	case status == http.StatusForbidden:
+		return IssueForbidden
+	case status == http.StatusNotFound, status == http.StatusGone:
+		return IssueNotFound
+	case status == http.StatusMethodNotAllowed, status == http.StatusNotAcceptable:
+		return IssueNotSupported
+	case status == http.StatusConflict:
+		return IssueConflict
+	case status == http.StatusUnprocessableEntity:
+		return IssueProcessing
+	case status >= http.StatusInternalServerError:
+		return IssueException
+	default:
+		return IssueInvalid
+	}
+}
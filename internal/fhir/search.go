@@ -0,0 +1,216 @@
+package fhir
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/yhwbach/makerble/internal/models"
+	"github.com/yhwbach/makerble/internal/schemas"
+)
+
+// SearchParams are the search parameters supported for patients
+var SearchParams = []SearchParam{
+	{Name: "_id", Type: "token", Documentation: "The patient ID"},
+	{Name: "identifier", Type: "token", Documentation: "A patient identifier, e.g. urn:ietf:rfc:3986|urn:uuid:<id>"},
+	{Name: "name", Type: "string", Documentation: "Matches the start of any part of the name. Supports :exact and :contains."},
+	{Name: "birthdate", Type: "date", Documentation: "The date of birth, with the eq, ne, lt, gt, le and ge prefixes"},
+	{Name: "gender", Type: "token", Documentation: "male or female"},
+}
+
+// SearchParam describes a search parameter in the capability statement
+type SearchParam struct {
+	Name          string `json:"name"`
+	Type          string `json:"type"`
+	Documentation string `json:"documentation,omitempty"`
+}
+
+// DefaultCount and MaxCount bound the number of patients a page of search results holds
+const (
+	DefaultCount = 50
+	MaxCount     = 500
+)
+
+// Search is a patient search. Patients match when they match every criterion.
+type Search struct {
+	criteria []schemas.PatientCriterion
+	// Count is the number of patients per page of results, set by _count
+	Count int
+	// Offset is the number of patients skipped before the page, set by _offset
+	Offset int
+}
+
+// ParseSearch parses the search parameters of a query, and the _count and _offset paging
+// parameters. Parameters starting with an underscore other than these and _id, such as
+// _format, are ignored, as are unknown parameters.
+func ParseSearch(query url.Values) (*Search, []Issue) {
+	search := &Search{Count: DefaultCount}
+	var issues []Issue
+
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	for _, key := range keys {
+		switch key {
+		case "_count":
+			count, err := strconv.Atoi(query.Get(key))
+			if err != nil || count < 1 {
+				issues = append(issues, ErrorIssue(IssueValue, "_count must be a positive integer", key))
+				continue
+			}
+			// Servers may return fewer results than asked for
+			search.Count = min(count, MaxCount)
+			continue
+		case "_offset":
+			offset, err := strconv.Atoi(query.Get(key))
+			if err != nil || offset < 0 {
+				issues = append(issues, ErrorIssue(IssueValue, "_offset must be a non-negative integer", key))
+				continue
+			}
+			search.Offset = offset
+			continue
+		}
+
+		param, modifier, _ := strings.Cut(key, ":")
+		if !slices.ContainsFunc(SearchParams, func(p SearchParam) bool { return p.Name == param }) {
+			continue
+		}
+
+		if modifier != "" && !(param == "name" && (modifier == "exact" || modifier == "contains")) {
+			issues = append(issues, ErrorIssue(IssueNotSupported,
+				fmt.Sprintf("Modifier :%s is not supported on %s", modifier, param), key))
+			continue
+		}
+
+		for _, value := range query[key] {
+			criterion, err := parseCriterion(param, modifier, strings.Split(value, ","))
+			if err != nil {
+				issues = append(issues, ErrorIssue(IssueValue, err.Error(), key))
+				continue
+			}
+			search.criteria = append(search.criteria, criterion)
+		}
+	}
+
+	return search, issues
+}
+
+// parseCriterion turns the values of a search parameter into the criterion patients match when
+// they match any of the values. Values no patient can match, such as identifiers of other
+// systems, are left out.
+func parseCriterion(param, modifier string, values []string) (schemas.PatientCriterion, error) {
+	var criterion schemas.PatientCriterion
+	for _, value := range values {
+		switch param {
+		case "_id":
+			if id, err := uuid.Parse(value); err == nil {
+				criterion.IDs = append(criterion.IDs, id)
+			}
+		case "identifier":
+			system, identifier, found := strings.Cut(value, "|")
+			if !found {
+				system, identifier = "", value
+			}
+			if system != "" && system != IdentifierSystem {
+				continue
+			}
+			if id, found := strings.CutPrefix(identifier, "urn:uuid:"); found {
+				if id, err := uuid.Parse(id); err == nil {
+					criterion.IDs = append(criterion.IDs, id)
+				}
+			}
+		case "gender":
+			criterion.Genders = append(criterion.Genders, models.Gender(value))
+		case "name":
+			criterion.Names = append(criterion.Names, schemas.NameMatch{
+				Value:    value,
+				Exact:    modifier == "exact",
+				Contains: modifier == "contains",
+			})
+		case "birthdate":
+			prefix, start, end, err := parseDate(value)
+			if err != nil {
+				return criterion, err
+			}
+			criterion.BirthDates = append(criterion.BirthDates, dateRange(prefix, start, end))
+		}
+	}
+	return criterion, nil
+}
+
+// Criteria returns the criteria of the search, to be matched by patient lists
+func (s *Search) Criteria() []schemas.PatientCriterion {
+	return s.criteria
+}
+
+// Matches reports whether the patient matches the search
+func (s *Search) Matches(patient *models.Patient) bool {
+	for _, c := range s.criteria {
+		if !c.Matches(patient) {
+			return false
+		}
+	}
+	return true
+}
+
+var datePrefixes = []string{"eq", "ne", "lt", "gt", "le", "ge"}
+
+// parseDate parses a date search value into its prefix and the range of days [start, end) it
+// covers, given its precision: a year, a month or a day
+func parseDate(value string) (prefix string, start, end time.Time, err error) {
+	prefix = "eq"
+	if len(value) >= 2 && value[0] >= 'a' && value[0] <= 'z' {
+		prefix, value = value[:2], value[2:]
+		if !slices.Contains(datePrefixes, prefix) {
+			return "", time.Time{}, time.Time{}, fmt.Errorf("date prefix %q is not supported", prefix)
+		}
+	}
+
+	for _, precision := range []struct {
+		layout string
+		years  int
+		months int
+		days   int
+	}{
+		{"2006-01-02", 0, 0, 1},
+		{"2006-01", 0, 1, 0},
+		{"2006", 1, 0, 0},
+	} {
+		if len(value) != len(precision.layout) {
+			continue
+		}
+		start, err := time.Parse(precision.layout, value)
+		if err != nil {
+			break
+		}
+		return prefix, start, start.AddDate(precision.years, precision.months, precision.days), nil
+	}
+
+	return "", time.Time{}, time.Time{}, fmt.Errorf("invalid date %q, use YYYY, YYYY-MM or YYYY-MM-DD", value)
+}
+
+// dateRange is the range of days a date search value matches, given its prefix and the range of
+// days [start, end) it covers
+func dateRange(prefix string, start, end time.Time) schemas.DateRange {
+	switch prefix {
+	case "ne":
+		return schemas.DateRange{From: &start, Until: &end, Outside: true}
+	case "lt":
+		return schemas.DateRange{Until: &start}
+	case "gt":
+		return schemas.DateRange{From: &end}
+	case "le":
+		return schemas.DateRange{Until: &end}
+	case "ge":
+		return schemas.DateRange{From: &start}
+	default:
+		return schemas.DateRange{From: &start, Until: &end}
+	}
+}
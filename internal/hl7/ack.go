@@ -0,0 +1,127 @@
+package hl7
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Acknowledgment codes of MSA-1
+const (
+	// AcceptAccept acknowledges a message that was processed
+	AcceptAccept = "AA"
+	// AcceptError acknowledges a message that could not be processed, which may succeed when sent again
+	AcceptError = "AE"
+	// AcceptReject acknowledges a message that cannot be processed as it is
+	AcceptReject = "AR"
+)
+
+// Error codes of HL7 table 0357, reported in ERR-3
+const (
+	ErrorSegmentSequence       = 100
+	ErrorRequiredFieldMissing  = 101
+	ErrorDataType              = 102
+	ErrorTableValueNotFound    = 103
+	ErrorUnsupportedMessage    = 200
+	ErrorUnsupportedEvent      = 201
+	ErrorUnsupportedProcessing = 202
+	ErrorUnknownKey            = 204
+	ErrorDuplicateKey          = 205
+	ErrorInternal              = 207
+)
+
+var errorCodeNames = map[int]string{
+	ErrorSegmentSequence:       "Segment sequence error",
+	ErrorRequiredFieldMissing:  "Required field missing",
+	ErrorDataType:              "Data type error",
+	ErrorTableValueNotFound:    "Table value not found",
+	ErrorUnsupportedMessage:    "Unsupported message type",
+	ErrorUnsupportedEvent:      "Unsupported event code",
+	ErrorUnsupportedProcessing: "Unsupported processing id",
+	ErrorUnknownKey:            "Unknown key identifier",
+	ErrorDuplicateKey:          "Duplicate key identifier",
+	ErrorInternal:              "Application internal error",
+}
+
+// Error is an error processing a message, reported to the sender in the acknowledgement
+type Error struct {
+	// Code is the error code of HL7 table 0357
+	Code int
+	// Location is the segment and field at fault, e.g. PID-8
+	Location string
+	Message  string
+}
+
+func (e *Error) Error() string {
+	if e.Location == "" {
+		return e.Message
+	}
+	return e.Location + ": " + e.Message
+}
+
+// Ack builds the acknowledgement of a message with the code and the error, if any. The header may
+// be empty for messages too malformed to read it.
+func Ack(header Header, code string, err *Error) string {
+	d := DefaultDelimiters
+	field := string(d.Field)
+	esc := func(value string) string { return Escape(value, d) }
+
+	version := header.Version
+	if version == "" {
+		version = "2.5"
+	}
+	processingID := header.ProcessingID
+	if processingID == "" {
+		processingID = "P"
+	}
+	messageType := "ACK"
+	if header.TriggerEvent != "" {
+		messageType += string(d.Component) + esc(header.TriggerEvent) + string(d.Component) + "ACK"
+	}
+
+	// Sender and receiver swap roles in the acknowledgement
+	msh := []string{
+		"MSH", d.encodingCharacters(),
+		esc(header.ReceivingApplication), esc(header.ReceivingFacility),
+		esc(header.SendingApplication), esc(header.SendingFacility),
+		time.Now().Format("20060102150405-0700"), "",
+		messageType, uuid.NewString(), esc(processingID), esc(version),
+	}
+
+	msa := []string{"MSA", code, esc(header.ControlID)}
+	if err != nil {
+		msa = append(msa, esc(err.Message))
+	}
+
+	segments := []string{strings.Join(msh, field), strings.Join(msa, field)}
+	if err != nil {
+		errorCode := strings.Join([]string{
+			strconv.Itoa(err.Code), esc(errorCodeNames[err.Code]), "HL70357",
+		}, string(d.Component))
+		segments = append(segments, strings.Join([]string{
+			"ERR", "", location(err.Location, d), errorCode, "E", "", "", esc(err.Message),
+		}, field))
+	}
+
+	return strings.Join(segments, "\r") + "\r"
+}
+
+// location formats a location such as PID-8 as the ERL of ERR-2
+func location(value string, d Delimiters) string {
+	segment, field, _ := strings.Cut(value, "-")
+	if segment == "" {
+		return ""
+	}
+	if field == "" {
+		return Escape(segment, d) + string(d.Component) + "1"
+	}
+	return Escape(segment, d) + string(d.Component) + "1" + string(d.Component) + Escape(field, d)
+}
+
+// AckCode reads the acknowledgment code and text of an acknowledgement
+func AckCode(ack *Message) (code, text string) {
+	msa := ack.Segment("MSA")
+	return msa.Value(1).String(), msa.Value(3).String()
+}
@@ -0,0 +1,520 @@
+package hl7
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/yhwbach/makerble/internal/config"
+	"github.com/yhwbach/makerble/internal/models"
+	"github.com/yhwbach/makerble/internal/repository"
+	"github.com/yhwbach/makerble/internal/schemas"
+)
+
+// ADT trigger events applied to patients
+const (
+	EventAdmit          = "A01"
+	EventRegister       = "A04"
+	EventUpdate         = "A08"
+	EventMergePatientID = "A40"
+)
+
+// Processor applies ADT messages to patients. A01, A04 and A08 register the patient identified in
+// PID-3, or update it if one of its identifiers is known. A40 merges the patient identified in
+// MRG-1 into the one identified in PID-3. Every message is stored before it is processed, so that
+// failed messages can be replayed.
+type Processor struct {
+	Repo repository.RepoStorage
+	// Account is the service account patients are registered, updated and audited as. Its clinic
+	// receives the patients.
+	Account *models.User
+}
+
+// NewProcessor creates a processor acting as the service account of the configuration
+func NewProcessor(ctx context.Context, cfg config.HL7Config, repo repository.RepoStorage) (*Processor, error) {
+	account, err := repo.Users.FindByUsername(ctx, cfg.ServiceAccount)
+	if err != nil {
+		return nil, err
+	}
+	if account == nil || account.UserType != models.Service {
+		return nil, fmt.Errorf("HL7 service account %q not found", cfg.ServiceAccount)
+	}
+	if !account.IsActive {
+		return nil, fmt.Errorf("HL7 service account %q is deactivated", cfg.ServiceAccount)
+	}
+
+	return &Processor{Repo: repo, Account: account}, nil
+}
+
+// Handle stores and processes a message and returns its acknowledgement. A message the sender
+// sends again after it was processed, as senders do when an acknowledgement is lost, is
+// acknowledged without processing it twice.
+func (p *Processor) Handle(ctx context.Context, raw string) string {
+	ctx = repository.WithClinic(ctx, p.Account.ClinicID)
+
+	var header Header
+	if message, err := Parse(raw); err == nil {
+		header = message.Header()
+	}
+
+	if header.ControlID != "" {
+		previous, err := p.Repo.HL7Messages.FindByControlID(ctx, header.SendingApplication, header.ControlID)
+		if err != nil {
+			slog.ErrorContext(ctx, "failed to look up HL7 message", "control_id", header.ControlID, "error", err)
+			return Ack(header, AcceptError, &Error{Code: ErrorInternal, Message: "Message could not be processed"})
+		}
+		if previous != nil && previous.Status == models.HL7MessageProcessed {
+			return Ack(header, AcceptAccept, nil)
+		}
+	}
+
+	record := &models.HL7Message{
+		SendingApplication: header.SendingApplication,
+		ControlID:          header.ControlID,
+		MessageType:        header.Type(),
+		Raw:                raw,
+		Status:             models.HL7MessageReceived,
+	}
+	if err := p.Repo.HL7Messages.Create(ctx, record); err != nil {
+		slog.ErrorContext(ctx, "failed to store HL7 message", "control_id", header.ControlID, "error", err)
+		return Ack(header, AcceptError, &Error{Code: ErrorInternal, Message: "Message could not be stored"})
+	}
+
+	code, err := p.run(ctx, record)
+	return Ack(header, code, err)
+}
+
+// Replay processes a stored message again, typically one that failed, and returns it with the
+// new outcome. Messages already processed are not replayed.
+func (p *Processor) Replay(ctx context.Context, id uuid.UUID) (*models.HL7Message, error) {
+	ctx = repository.WithClinic(ctx, p.Account.ClinicID)
+
+	record, err := p.Repo.HL7Messages.FindByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if record == nil {
+		return nil, fmt.Errorf("HL7 message %s not found", id)
+	}
+	if record.Status == models.HL7MessageProcessed {
+		return nil, fmt.Errorf("HL7 message %s was already processed", id)
+	}
+
+	p.run(ctx, record)
+	return record, nil
+}
+
+// run processes a stored message and records the outcome. It returns the acknowledgment code and
+// the error to report to the sender.
+func (p *Processor) run(ctx context.Context, record *models.HL7Message) (string, *Error) {
+	code, patientID, err := p.process(ctx, record)
+
+	var reported *Error
+	if err != nil && !errors.As(err, &reported) {
+		slog.ErrorContext(ctx, "failed to process HL7 message", "message_id", record.ID, "error", err)
+		reported = &Error{Code: ErrorInternal, Message: "Message could not be processed"}
+	}
+
+	record.PatientID = patientID
+	record.Error = ""
+	switch code {
+	case AcceptAccept:
+		record.Status = models.HL7MessageProcessed
+	case AcceptError:
+		record.Status = models.HL7MessageFailed
+		record.Error = err.Error()
+	default:
+		record.Status = models.HL7MessageRejected
+		record.Error = err.Error()
+	}
+
+	if err := p.Repo.HL7Messages.Finish(ctx, record); err != nil {
+		slog.ErrorContext(ctx, "failed to record outcome of HL7 message", "message_id", record.ID, "error", err)
+	}
+
+	return code, reported
+}
+
+// process applies a message. Messages that cannot be processed as they are, such as messages of
+// other types, are rejected; other errors acknowledge the message with an error.
+func (p *Processor) process(ctx context.Context, record *models.HL7Message) (string, *uuid.UUID, error) {
+	message, err := Parse(record.Raw)
+	if err != nil {
+		return AcceptReject, nil, &Error{Code: ErrorSegmentSequence, Location: "MSH", Message: err.Error()}
+	}
+
+	header := message.Header()
+	if header.ControlID == "" {
+		return AcceptReject, nil, &Error{Code: ErrorRequiredFieldMissing, Location: "MSH-10", Message: "Message control ID is required"}
+	}
+	if header.MessageType != "ADT" {
+		return AcceptReject, nil, &Error{Code: ErrorUnsupportedMessage, Location: "MSH-9", Message: "Only ADT messages are supported"}
+	}
+
+	var patientID uuid.UUID
+	switch header.TriggerEvent {
+	case EventAdmit, EventRegister, EventUpdate:
+		patientID, err = p.register(ctx, record, message, header)
+	case EventMergePatientID:
+		patientID, err = p.merge(ctx, record, message, header)
+	default:
+		return AcceptReject, nil, &Error{Code: ErrorUnsupportedEvent, Location: "MSH-9", Message: fmt.Sprintf("Unsupported event %s", header.TriggerEvent)}
+	}
+	if err != nil {
+		return AcceptError, nil, err
+	}
+
+	return AcceptAccept, &patientID, nil
+}
+
+// register registers the patient of the PID segment, or updates it if it is known
+func (p *Processor) register(ctx context.Context, record *models.HL7Message, message *Message, header Header) (uuid.UUID, error) {
+	pid := message.Segment("PID")
+	if pid == nil {
+		return uuid.Nil, &Error{Code: ErrorRequiredFieldMissing, Location: "PID", Message: "PID segment is required"}
+	}
+
+	identifiers, err := readIdentifiers(pid, 3, header)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	fields, err := readPatient(pid)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	patient, err := p.findPatient(ctx, identifiers, "PID-3")
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if patient == nil {
+		return p.create(ctx, record, identifiers, fields)
+	}
+
+	return patient.ID, p.update(ctx, record, patient, identifiers, fields)
+}
+
+// merge merges the patient of the MRG segment into the patient of the PID segment. If only the
+// patient of the MRG segment is known, it takes the identifiers of the PID segment instead.
+func (p *Processor) merge(ctx context.Context, record *models.HL7Message, message *Message, header Header) (uuid.UUID, error) {
+	pid := message.Segment("PID")
+	if pid == nil {
+		return uuid.Nil, &Error{Code: ErrorRequiredFieldMissing, Location: "PID", Message: "PID segment is required"}
+	}
+	mrg := message.Segment("MRG")
+	if mrg == nil {
+		return uuid.Nil, &Error{Code: ErrorRequiredFieldMissing, Location: "MRG", Message: "MRG segment is required"}
+	}
+
+	identifiers, err := readIdentifiers(pid, 3, header)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	priorIdentifiers, err := readIdentifiers(mrg, 1, header)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	fields, err := readPatient(pid)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	survivor, err := p.findPatient(ctx, identifiers, "PID-3")
+	if err != nil {
+		return uuid.Nil, err
+	}
+	prior, err := p.findPatient(ctx, priorIdentifiers, "MRG-1")
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	switch {
+	case survivor == nil && prior == nil:
+		return uuid.Nil, &Error{Code: ErrorUnknownKey, Location: "MRG-1", Message: "Neither patient is known"}
+	case survivor == nil:
+		survivor = prior
+	case prior != nil && prior.ID != survivor.ID:
+		merged, err := p.Repo.Patients.Merge(ctx, &models.PatientMerge{
+			SurvivorID: survivor.ID,
+			MergedID:   prior.ID,
+			MergedBy:   p.Account.ID,
+			Source:     "hl7:" + record.ID.String(),
+		})
+		if err != nil {
+			return uuid.Nil, err
+		}
+		if !merged {
+			return uuid.Nil, fmt.Errorf("patients %s and %s could not be merged", survivor.ID, prior.ID)
+		}
+
+		// The survivor took over fields of the merged patient
+		survivor, err = p.Repo.Patients.FindByID(ctx, survivor.ID, schemas.PatientQuery{})
+		if err != nil {
+			return uuid.Nil, err
+		}
+		if survivor == nil {
+			return uuid.Nil, fmt.Errorf("merged patient not found")
+		}
+	}
+
+	return survivor.ID, p.update(ctx, record, survivor, identifiers, fields)
+}
+
+// create registers a new patient with the identifiers, in one transaction
+func (p *Processor) create(ctx context.Context, record *models.HL7Message, identifiers []models.PatientIdentifier, fields *schemas.PatientUpdate) (uuid.UUID, error) {
+	for _, required := range []struct {
+		location string
+		missing  bool
+	}{
+		{"PID-5", fields.FullName == nil},
+		{"PID-7", fields.DateOfBirth == nil},
+		{"PID-8", fields.Gender == nil},
+	} {
+		if required.missing {
+			return uuid.Nil, &Error{Code: ErrorRequiredFieldMissing, Location: required.location, Message: "Required to register a patient"}
+		}
+	}
+
+	create := &schemas.PatientCreate{
+		FullName:    *fields.FullName,
+		DateOfBirth: *fields.DateOfBirth,
+		Gender:      *fields.Gender,
+	}
+	for _, field := range []struct{ to, from *string }{
+		{&create.Address, fields.Address},
+		{&create.Phone, fields.Phone},
+		{&create.Email, fields.Email},
+	} {
+		if field.from != nil {
+			*field.to = *field.from
+		}
+	}
+	dateOfBirth, _ := time.Parse("2006-01-02", create.DateOfBirth)
+
+	// Another message may register the patient concurrently; only one of them gets the identifiers
+	createdID, err := p.Repo.Patients.CreateWithIdentifiers(ctx, p.Account.ID, create, dateOfBirth, identifiers,
+		p.auditEvent(record, models.AuditActionPatientCreate, nil, create.Fields()))
+	if err != nil {
+		if errors.Is(err, repository.ErrIdentifierTaken) {
+			return uuid.Nil, &Error{Code: ErrorDuplicateKey, Location: "PID-3", Message: "Identifier belongs to another patient"}
+		}
+		return uuid.Nil, err
+	}
+
+	return uuid.MustParse(createdID), nil
+}
+
+// update applies the fields that changed to the patient and adds the identifiers it lacks
+func (p *Processor) update(ctx context.Context, record *models.HL7Message, patient *models.Patient, identifiers []models.PatientIdentifier, fields *schemas.PatientUpdate) error {
+	if err := p.Repo.Patients.AddIdentifiers(ctx, patient.ID, identifiers); err != nil {
+		if errors.Is(err, repository.ErrIdentifierTaken) {
+			return &Error{Code: ErrorDuplicateKey, Location: "PID-3", Message: "Identifier belongs to another patient"}
+		}
+		return err
+	}
+
+	update := changes(patient, fields)
+	changed := update.Fields()
+	if len(changed) == 0 {
+		return nil
+	}
+
+	updated, err := p.Repo.Patients.UpdateByID(ctx, patient.ID, update,
+		p.auditEvent(record, models.AuditActionPatientUpdate, &patient.ID, changed))
+	if err != nil {
+		return err
+	}
+	if updated == nil {
+		return fmt.Errorf("patient %s not found", patient.ID)
+	}
+
+	return nil
+}
+
+// findPatient finds the patient known by any of the identifiers, returning nil if none is
+func (p *Processor) findPatient(ctx context.Context, identifiers []models.PatientIdentifier, location string) (*models.Patient, error) {
+	var found *models.Patient
+	for _, identifier := range identifiers {
+		patient, err := p.Repo.Patients.FindByIdentifier(ctx, identifier)
+		if err != nil {
+			return nil, err
+		}
+		if patient == nil {
+			continue
+		}
+		if found != nil && found.ID != patient.ID {
+			return nil, &Error{Code: ErrorDuplicateKey, Location: location, Message: "Identifiers belong to different patients"}
+		}
+		found = patient
+	}
+	return found, nil
+}
+
+// auditEvent describes a change of the service account, with the message that caused it, for the
+// change to record in its transaction
+func (p *Processor) auditEvent(record *models.HL7Message, action string, patientID *uuid.UUID, fields []string) *models.AuditEvent {
+	return &models.AuditEvent{
+		Severity:  models.AuditSeverityInfo,
+		Action:    action,
+		ActorID:   &p.Account.ID,
+		PatientID: patientID,
+		Fields:    fields,
+		Details: map[string]interface{}{
+			"hl7_message_id": record.ID,
+			"control_id":     record.ControlID,
+		},
+	}
+}
+
+// readIdentifiers reads the identifiers of a CX field. The assigning authority is the system of an
+// identifier, or the sending application when there is none.
+func readIdentifiers(segment *Segment, field int, header Header) ([]models.PatientIdentifier, error) {
+	var identifiers []models.PatientIdentifier
+	for _, value := range segment.Repetitions(field) {
+		identifier := models.PatientIdentifier{Value: value.Component(1), System: value.Subcomponent(4, 1)}
+		if identifier.System == "" {
+			identifier.System = value.Subcomponent(4, 2)
+		}
+		if identifier.System == "" {
+			identifier.System = header.SendingApplication
+		}
+		if identifier.Value != "" && identifier.System != "" {
+			identifiers = append(identifiers, identifier)
+		}
+	}
+
+	if len(identifiers) == 0 {
+		return nil, &Error{
+			Code:     ErrorRequiredFieldMissing,
+			Location: fmt.Sprintf("%s-%d", segment.Name, field),
+			Message:  "Patient identifier is required",
+		}
+	}
+	return identifiers, nil
+}
+
+// readPatient reads the patient fields of a PID segment. Empty fields are left nil, leaving the
+// patient unchanged; fields sent as "" clear the address, phone and email.
+func readPatient(pid *Segment) (*schemas.PatientUpdate, error) {
+	fields := &schemas.PatientUpdate{}
+	clear := func(value Value) *string {
+		if value.IsNull() {
+			empty := ""
+			return &empty
+		}
+		return nil
+	}
+
+	if name := pid.Value(5); !name.IsEmpty() && !name.IsNull() {
+		fullName := joinNonEmpty(" ",
+			name.Component(5), name.Component(2), name.Component(3), name.Component(1), name.Component(4))
+		if fullName == "" {
+			return nil, &Error{Code: ErrorDataType, Location: "PID-5", Message: "Patient name is empty"}
+		}
+		fields.FullName = &fullName
+	}
+
+	if birth := pid.Value(7); !birth.IsEmpty() && !birth.IsNull() {
+		value := birth.String()
+		if len(value) < 8 {
+			return nil, &Error{Code: ErrorDataType, Location: "PID-7", Message: "Date of birth must include the day"}
+		}
+		dateOfBirth, err := time.Parse("20060102", value[:8])
+		if err != nil {
+			return nil, &Error{Code: ErrorDataType, Location: "PID-7", Message: "Invalid date of birth"}
+		}
+		formatted := dateOfBirth.Format("2006-01-02")
+		fields.DateOfBirth = &formatted
+	}
+
+	if sex := pid.Value(8); !sex.IsEmpty() && !sex.IsNull() {
+		var gender models.Gender
+		switch strings.ToUpper(sex.String()) {
+		case "M":
+			gender = models.Male
+		case "F":
+			gender = models.Female
+		default:
+			return nil, &Error{Code: ErrorTableValueNotFound, Location: "PID-8", Message: "Only sex M and F can be stored"}
+		}
+		fields.Gender = &gender
+	}
+
+	if address := pid.Value(11); address.IsNull() {
+		fields.Address = clear(address)
+	} else if !address.IsEmpty() {
+		region := joinNonEmpty(" ", address.Component(4), address.Component(5))
+		formatted := joinNonEmpty(", ",
+			address.Subcomponent(1, 1), address.Component(2), address.Component(3), region, address.Component(6))
+		fields.Address = &formatted
+	}
+
+	if telecoms := pid.Repetitions(13); len(telecoms) == 1 && telecoms[0].IsNull() {
+		fields.Phone = clear(telecoms[0])
+		fields.Email = clear(telecoms[0])
+	} else {
+		for _, telecom := range telecoms {
+			if telecom.Component(2) == "NET" || strings.EqualFold(telecom.Component(3), "Internet") {
+				if email := telecom.Component(4); email != "" && fields.Email == nil {
+					fields.Email = &email
+				}
+				continue
+			}
+
+			phone := telecom.Component(1)
+			if phone == "" {
+				phone = joinNonEmpty(" ", prefixed("+", telecom.Component(5)), telecom.Component(6), telecom.Component(7))
+			}
+			if phone != "" && fields.Phone == nil {
+				fields.Phone = &phone
+			}
+		}
+	}
+
+	return fields, nil
+}
+
+// changes keeps the fields that differ from the patient
+func changes(patient *models.Patient, fields *schemas.PatientUpdate) *schemas.PatientUpdate {
+	update := &schemas.PatientUpdate{}
+	for _, field := range []struct {
+		to           **string
+		from         *string
+		currentValue string
+	}{
+		{&update.FullName, fields.FullName, patient.FullName},
+		{&update.DateOfBirth, fields.DateOfBirth, patient.DateOfBirth.Format("2006-01-02")},
+		{&update.Address, fields.Address, patient.Address},
+		{&update.Phone, fields.Phone, patient.Phone},
+		{&update.Email, fields.Email, patient.Email},
+	} {
+		if field.from != nil && *field.from != field.currentValue {
+			*field.to = field.from
+		}
+	}
+	if fields.Gender != nil && *fields.Gender != patient.Gender {
+		update.Gender = fields.Gender
+	}
+	return update
+}
+
+func joinNonEmpty(sep string, values ...string) string {
+	var nonEmpty []string
+	for _, value := range values {
+		if value = strings.TrimSpace(value); value != "" {
+			nonEmpty = append(nonEmpty, value)
+		}
+	}
+	return strings.Join(nonEmpty, sep)
+}
+
+func prefixed(prefix, value string) string {
+	if value == "" {
+		return ""
+	}
+	return prefix + value
+}
@@ -0,0 +1,315 @@
+package hl7
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yhwbach/makerble/internal/models"
+	"github.com/yhwbach/makerble/internal/repository/mock"
+	"github.com/yhwbach/makerble/internal/schemas"
+)
+
+// adt builds an ADT message with the trigger event and control ID from segments after MSH
+func adt(event, controlID string, segments ...string) string {
+	msh := "MSH|^~\\&|REGISTRATION|HOSPITAL|MAKERBLE|CLINIC|20250601120000||ADT^" + event + "^ADT_A01|" + controlID + "|P|2.5"
+	return strings.Join(append([]string{msh}, segments...), "\r") + "\r"
+}
+
+func TestParse(t *testing.T) {
+	message, err := Parse(adt(EventAdmit, "MSG1",
+		`PID|1||12345^^^HOSP^MR~99^^^&2.16.840.1.113883&ISO||Doe^Jane^Mary^^Dr.||19900412|F|||12 Main\T\Co Street^^Springfield^IL^62704^US||^PRN^PH^^1^555^0100~^NET^Internet^jane@example.com`,
+	))
+	require.NoError(t, err)
+
+	assert.Equal(t, Header{
+		SendingApplication:   "REGISTRATION",
+		SendingFacility:      "HOSPITAL",
+		ReceivingApplication: "MAKERBLE",
+		ReceivingFacility:    "CLINIC",
+		MessageType:          "ADT",
+		TriggerEvent:         "A01",
+		ControlID:            "MSG1",
+		ProcessingID:         "P",
+		Version:              "2.5",
+	}, message.Header())
+	assert.Equal(t, "ADT^A01", message.Header().Type())
+
+	msh := message.Segment("MSH")
+	assert.Equal(t, "|", msh.Field(1), "MSH-1 is the field separator")
+	assert.Equal(t, `^~\&`, msh.Field(2))
+
+	pid := message.Segment("PID")
+	identifiers := pid.Repetitions(3)
+	require.Len(t, identifiers, 2)
+	assert.Equal(t, "12345", identifiers[0].Component(1))
+	assert.Equal(t, "HOSP", identifiers[0].Component(4))
+	assert.Equal(t, "2.16.840.1.113883", identifiers[1].Subcomponent(4, 2))
+	assert.Equal(t, "Jane", pid.Value(5).Component(2))
+	assert.Equal(t, "12 Main&Co Street", pid.Value(11).Component(1), "escape sequences are replaced")
+	assert.Equal(t, "", pid.Value(30).String())
+	assert.Nil(t, message.Segment("MRG"))
+	assert.Equal(t, "", message.Segment("MRG").Field(1), "missing segments have no fields")
+
+	t.Run("line feeds", func(t *testing.T) {
+		message, err := Parse(strings.ReplaceAll(adt(EventAdmit, "MSG1", "PID|1||12345"), "\r", "\r\n"))
+		require.NoError(t, err)
+		assert.Len(t, message.Segments, 2)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, raw := range []string{"", "PID|1||12345", "MSH|^^\\&", "MSH|^~\\&|A\rP D|1"} {
+			_, err := Parse(raw)
+			assert.ErrorIs(t, err, ErrInvalidMessage, raw)
+		}
+	})
+}
+
+func TestEscape(t *testing.T) {
+	value := "a|b^c~d\\e&f"
+	escaped := Escape(value, DefaultDelimiters)
+	assert.Equal(t, `a\F\b\S\c\R\d\E\e\T\f`, escaped)
+	assert.Equal(t, value, Unescape(escaped, DefaultDelimiters))
+	assert.Equal(t, "Ab\nc", Unescape(`\X41\b\.br\c\H\`, DefaultDelimiters))
+	assert.Equal(t, `\Z1\`, Unescape(`\Z1\`, DefaultDelimiters), "unknown sequences are kept")
+}
+
+func TestAck(t *testing.T) {
+	message, err := Parse(adt(EventRegister, "MSG1"))
+	require.NoError(t, err)
+
+	ack, err := Parse(Ack(message.Header(), AcceptAccept, nil))
+	require.NoError(t, err)
+	header := ack.Header()
+	assert.Equal(t, "MAKERBLE", header.SendingApplication, "sender and receiver swap")
+	assert.Equal(t, "REGISTRATION", header.ReceivingApplication)
+	assert.Equal(t, "ACK^A04^ACK", ack.Segment("MSH").Field(9))
+	assert.NotEmpty(t, header.ControlID)
+	code, _ := AckCode(ack)
+	assert.Equal(t, AcceptAccept, code)
+	assert.Equal(t, "MSG1", ack.Segment("MSA").Field(2))
+	assert.Nil(t, ack.Segment("ERR"))
+
+	ack, err = Parse(Ack(message.Header(), AcceptError, &Error{Code: ErrorTableValueNotFound, Location: "PID-8", Message: "Sex U|X"}))
+	require.NoError(t, err)
+	code, text := AckCode(ack)
+	assert.Equal(t, AcceptError, code)
+	assert.Equal(t, "Sex U|X", text)
+	errSegment := ack.Segment("ERR")
+	assert.Equal(t, "PID^1^8", errSegment.Field(2))
+	assert.Equal(t, "103", errSegment.Value(3).Component(1))
+	assert.Equal(t, "HL70357", errSegment.Value(3).Component(3))
+
+	// Messages too malformed to read are still acknowledged
+	ack, err = Parse(Ack(Header{}, AcceptReject, &Error{Code: ErrorSegmentSequence, Message: "invalid"}))
+	require.NoError(t, err)
+	assert.Equal(t, "ACK", ack.Segment("MSH").Field(9))
+}
+
+func TestMLLP(t *testing.T) {
+	t.Run("frames", func(t *testing.T) {
+		var buf bytes.Buffer
+		require.NoError(t, WriteFrame(&buf, []byte("first")))
+		require.NoError(t, WriteFrame(&buf, []byte("second")))
+
+		reader := bufio.NewReader(bytes.NewReader(append([]byte("noise"), buf.Bytes()...)))
+		message, err := ReadFrame(reader)
+		require.NoError(t, err)
+		assert.Equal(t, "first", string(message), "data before the start block is skipped")
+		message, err = ReadFrame(reader)
+		require.NoError(t, err)
+		assert.Equal(t, "second", string(message))
+
+		_, err = ReadFrame(bufio.NewReader(bytes.NewReader([]byte{startBlock, 'a', endBlock, 'x'})))
+		assert.Error(t, err)
+	})
+
+	t.Run("server", func(t *testing.T) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		server := &Server{Handler: HandlerFunc(func(ctx context.Context, raw string) string {
+			return "ACK " + raw
+		})}
+		done := make(chan error)
+		go func() { done <- server.Serve(ctx, listener) }()
+
+		client, err := Dial(listener.Addr().String())
+		require.NoError(t, err)
+		defer client.Close()
+
+		for _, message := range []string{"one", "two"} {
+			ack, err := client.Send(message)
+			require.NoError(t, err)
+			assert.Equal(t, "ACK "+message, ack)
+		}
+
+		cancel()
+		assert.NoError(t, <-done, "the server stops with the context, closing its connections")
+	})
+}
+
+func TestProcessor(t *testing.T) {
+	ctx := context.Background()
+	repo := mock.NewMockRepoStorage()
+	processor := &Processor{Repo: repo, Account: &models.User{
+		ID:       uuid.New(),
+		UserType: models.Service,
+		ClinicID: models.DefaultClinicID,
+		IsActive: true,
+	}}
+
+	send := func(t *testing.T, raw string) (string, *Message) {
+		ack, err := Parse(processor.Handle(ctx, raw))
+		require.NoError(t, err)
+		code, _ := AckCode(ack)
+		return code, ack
+	}
+	patientWith := func(t *testing.T, system, value string) *models.Patient {
+		patient, err := repo.Patients.FindByIdentifier(ctx, models.PatientIdentifier{System: system, Value: value})
+		require.NoError(t, err)
+		return patient
+	}
+	messages := func(t *testing.T, status string) []models.HL7Message {
+		messages, err := repo.HL7Messages.List(ctx, status, 100)
+		require.NoError(t, err)
+		return messages
+	}
+
+	pid := `PID|1||12345^^^HOSP^MR||Doe^Jane^Mary||19900412|F|||12 Main Street^^Springfield^IL^62704^US||^PRN^PH^^1^555^0100~^NET^Internet^jane@example.com`
+
+	var patientID uuid.UUID
+	t.Run("register", func(t *testing.T) {
+		code, _ := send(t, adt(EventRegister, "MSG1", pid))
+		require.Equal(t, AcceptAccept, code)
+
+		patient := patientWith(t, "HOSP", "12345")
+		require.NotNil(t, patient)
+		patientID = patient.ID
+		assert.Equal(t, "Jane Mary Doe", patient.FullName)
+		assert.Equal(t, "1990-04-12", patient.DateOfBirth.Format("2006-01-02"))
+		assert.Equal(t, models.Female, patient.Gender)
+		assert.Equal(t, "12 Main Street, Springfield, IL 62704, US", patient.Address)
+		assert.Equal(t, "+1 555 0100", patient.Phone)
+		assert.Equal(t, "jane@example.com", patient.Email)
+		assert.Equal(t, processor.Account.ID, patient.RegisteredBy)
+
+		stored := messages(t, models.HL7MessageProcessed)
+		require.Len(t, stored, 1)
+		assert.Equal(t, "ADT^A04", stored[0].MessageType)
+		assert.Equal(t, adt(EventRegister, "MSG1", pid), stored[0].Raw)
+		assert.Equal(t, &patientID, stored[0].PatientID)
+
+		events, err := repo.Audit.ListByPatient(ctx, patientID)
+		require.NoError(t, err)
+		require.Len(t, events, 1)
+		assert.Equal(t, models.AuditActionPatientCreate, events[0].Action)
+		assert.Equal(t, processor.Account.ID, *events[0].ActorID)
+	})
+
+	t.Run("sent again", func(t *testing.T) {
+		code, _ := send(t, adt(EventRegister, "MSG1", pid))
+		assert.Equal(t, AcceptAccept, code)
+		assert.Len(t, messages(t, ""), 1, "the message is neither stored nor processed twice")
+	})
+
+	t.Run("update", func(t *testing.T) {
+		code, _ := send(t, adt(EventUpdate, "MSG2", `PID|1||12345^^^HOSP^MR~A-77^^^CLINIC2||||||||""`))
+		require.Equal(t, AcceptAccept, code)
+
+		patient := patientWith(t, "CLINIC2", "A-77")
+		require.NotNil(t, patient, "new identifiers are added")
+		assert.Equal(t, patientID, patient.ID)
+		assert.Equal(t, "Jane Mary Doe", patient.FullName, "empty fields are left unchanged")
+		assert.Equal(t, "", patient.Address, `"" clears the field`)
+		assert.Equal(t, "+1 555 0100", patient.Phone)
+
+		events, err := repo.Audit.ListByPatient(ctx, patientID)
+		require.NoError(t, err)
+		require.Len(t, events, 2)
+		assert.Equal(t, []string{"address"}, events[1].Fields)
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		code, ack := send(t, adt(EventRegister, "MSG3", `PID|1||555^^^HOSP^MR||Roe^Sam||19800101|U`))
+		assert.Equal(t, AcceptError, code)
+		assert.Equal(t, "PID^1^8", ack.Segment("ERR").Field(2))
+		assert.Nil(t, patientWith(t, "HOSP", "555"))
+
+		code, ack = send(t, adt(EventRegister, "MSG4", `PID|1||556^^^HOSP^MR||Roe^Sam`))
+		assert.Equal(t, AcceptError, code)
+		assert.Equal(t, "101", ack.Segment("ERR").Value(3).Component(1))
+
+		code, _ = send(t, strings.Replace(adt(EventAdmit, "MSG5", pid), "ADT^A01", "ORU^R01", 1))
+		assert.Equal(t, AcceptReject, code)
+		code, _ = send(t, adt("A03", "MSG6", pid))
+		assert.Equal(t, AcceptReject, code)
+		code, _ = send(t, "not a message")
+		assert.Equal(t, AcceptReject, code)
+
+		assert.Len(t, messages(t, models.HL7MessageFailed), 2)
+		assert.Len(t, messages(t, models.HL7MessageRejected), 3, "rejected messages are kept too")
+	})
+
+	t.Run("replay", func(t *testing.T) {
+		failed := messages(t, models.HL7MessageFailed)[1]
+
+		replayed, err := processor.Replay(ctx, failed.ID)
+		require.NoError(t, err)
+		assert.Equal(t, models.HL7MessageFailed, replayed.Status, "the message is still incomplete")
+		assert.Contains(t, replayed.Error, "PID-7")
+
+		processed := messages(t, models.HL7MessageProcessed)[0]
+		_, err = processor.Replay(ctx, processed.ID)
+		assert.Error(t, err, "processed messages are not replayed")
+	})
+
+	t.Run("merge", func(t *testing.T) {
+		code, _ := send(t, adt(EventRegister, "MSG7", `PID|1||999^^^HOSP^MR||Doe^Jane||19900412|F|||||^PRN^PH^^^^555-0199`))
+		require.Equal(t, AcceptAccept, code)
+		duplicate := patientWith(t, "HOSP", "999")
+		require.NotNil(t, duplicate)
+		require.NoError(t, repo.Consents.Create(ctx, &models.Consent{PatientID: duplicate.ID, Type: models.ConsentResearch}))
+
+		code, _ = send(t, adt(EventMergePatientID, "MSG8", `PID|1||12345^^^HOSP^MR`, `MRG|999^^^HOSP^MR`))
+		require.Equal(t, AcceptAccept, code)
+
+		survivor := patientWith(t, "HOSP", "999")
+		require.NotNil(t, survivor, "the identifiers of the merged patient lead to the survivor")
+		assert.Equal(t, patientID, survivor.ID)
+		assert.Contains(t, survivor.Consents, models.ConsentResearch)
+
+		merged, err := repo.Patients.FindByID(ctx, duplicate.ID, schemas.PatientQuery{})
+		require.NoError(t, err)
+		assert.Nil(t, merged)
+
+		code, ack := send(t, adt(EventMergePatientID, "MSG9", `PID|1||404^^^HOSP^MR`, `MRG|405^^^HOSP^MR`))
+		assert.Equal(t, AcceptError, code)
+		assert.Equal(t, "204", ack.Segment("ERR").Value(3).Component(1))
+	})
+
+	t.Run("registered concurrently", func(t *testing.T) {
+		before, err := repo.Patients.Count(ctx, schemas.PatientQuery{})
+		require.NoError(t, err)
+
+		// Another message registered the patient after this one looked it up
+		name, dateOfBirth, gender := "Jane Doe", "1990-04-12", models.Female
+		_, err = processor.create(ctx, &models.HL7Message{ID: uuid.New()},
+			[]models.PatientIdentifier{{System: "HOSP", Value: "12345"}},
+			&schemas.PatientUpdate{FullName: &name, DateOfBirth: &dateOfBirth, Gender: &gender})
+		var hl7Err *Error
+		require.ErrorAs(t, err, &hl7Err)
+		assert.Equal(t, ErrorDuplicateKey, hl7Err.Code)
+
+		after, err := repo.Patients.Count(ctx, schemas.PatientQuery{})
+		require.NoError(t, err)
+		assert.Equal(t, before, after, "no patient is left without its identifiers")
+	})
+}
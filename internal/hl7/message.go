@@ -0,0 +1,300 @@
+// Package hl7 receives HL7 v2 messages from other systems, such as the ADT feed of a hospital's
+// registration system, over MLLP. It parses the messages, applies ADT events to patients and
+// acknowledges each message.
+package hl7
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidMessage is returned for data that is not an HL7 v2 message
+var ErrInvalidMessage = errors.New("invalid HL7 message")
+
+// Null is the value HL7 sends to clear a field, as opposed to an empty field leaving it unchanged
+const Null = `""`
+
+// Delimiters are the separators and escape character of a message, declared in its MSH segment
+type Delimiters struct {
+	Field        byte
+	Component    byte
+	Repetition   byte
+	Escape       byte
+	Subcomponent byte
+}
+
+// DefaultDelimiters are the delimiters recommended by the standard, used for acknowledgements
+var DefaultDelimiters = Delimiters{Field: '|', Component: '^', Repetition: '~', Escape: '\\', Subcomponent: '&'}
+
+// encodingCharacters is the value of MSH-2 for the delimiters
+func (d Delimiters) encodingCharacters() string {
+	return string([]byte{d.Component, d.Repetition, d.Escape, d.Subcomponent})
+}
+
+// Message is a parsed HL7 v2 message
+type Message struct {
+	Delimiters Delimiters
+	Segments   []*Segment
+}
+
+// Segment is a segment of a message. Fields are numbered from 1 as in the standard, so that for
+// MSH, field 1 is the field separator and field 2 the encoding characters.
+type Segment struct {
+	Name       string
+	fields     []string
+	delimiters *Delimiters
+}
+
+// Parse parses a message. Segments may be separated by carriage returns, as the standard requires,
+// or by line feeds.
+func Parse(raw string) (*Message, error) {
+	raw = strings.ReplaceAll(raw, "\r\n", "\r")
+	raw = strings.ReplaceAll(raw, "\n", "\r")
+	raw = strings.Trim(raw, "\r")
+
+	if !strings.HasPrefix(raw, "MSH") || len(raw) < 8 {
+		return nil, fmt.Errorf("%w: it must start with an MSH segment", ErrInvalidMessage)
+	}
+
+	d := Delimiters{Field: raw[3], Component: raw[4], Repetition: raw[5], Escape: raw[6], Subcomponent: raw[7]}
+	if d.Subcomponent == d.Field {
+		// Messages without subcomponents may declare only three encoding characters
+		d.Subcomponent = DefaultDelimiters.Subcomponent
+	}
+	seen := map[byte]bool{}
+	for _, c := range []byte{d.Field, d.Component, d.Repetition, d.Escape, d.Subcomponent} {
+		if seen[c] || c == '\r' || isAlphanumeric(c) {
+			return nil, fmt.Errorf("%w: invalid delimiters %q", ErrInvalidMessage, raw[3:8])
+		}
+		seen[c] = true
+	}
+
+	message := &Message{Delimiters: d}
+	for _, line := range strings.Split(raw, "\r") {
+		if line == "" {
+			continue
+		}
+
+		parts := strings.Split(line, string(d.Field))
+		name := parts[0]
+		if len(name) != 3 || !isAlphanumeric(name[0]) || !isAlphanumeric(name[1]) || !isAlphanumeric(name[2]) {
+			return nil, fmt.Errorf("%w: invalid segment %q", ErrInvalidMessage, name)
+		}
+
+		fields := parts
+		if name == "MSH" {
+			fields = append([]string{name, string(d.Field)}, parts[1:]...)
+		}
+		message.Segments = append(message.Segments, &Segment{Name: name, fields: fields, delimiters: &message.Delimiters})
+	}
+
+	return message, nil
+}
+
+// Segment returns the first segment with the name, or nil if there is none
+func (m *Message) Segment(name string) *Segment {
+	for _, segment := range m.Segments {
+		if segment.Name == name {
+			return segment
+		}
+	}
+	return nil
+}
+
+// Field returns the raw value of a field, with its repetitions, components and escapes, or an
+// empty string if the segment is nil or has no such field
+func (s *Segment) Field(n int) string {
+	if s == nil || n < 1 || n >= len(s.fields) {
+		return ""
+	}
+	return s.fields[n]
+}
+
+// Repetitions returns the repetitions of a field
+func (s *Segment) Repetitions(n int) []Value {
+	field := s.Field(n)
+	if field == "" {
+		return nil
+	}
+	if s.Name == "MSH" && n <= 2 {
+		return []Value{{raw: field, delimiters: s.delimiters}}
+	}
+
+	var values []Value
+	for _, repetition := range strings.Split(field, string(s.delimiters.Repetition)) {
+		values = append(values, Value{raw: repetition, delimiters: s.delimiters})
+	}
+	return values
+}
+
+// Value returns the first repetition of a field
+func (s *Segment) Value(n int) Value {
+	if repetitions := s.Repetitions(n); len(repetitions) > 0 {
+		return repetitions[0]
+	}
+	return Value{}
+}
+
+// Value is a repetition of a field
+type Value struct {
+	raw        string
+	delimiters *Delimiters
+}
+
+// IsNull reports whether the value clears the field
+func (v Value) IsNull() bool {
+	return v.raw == Null
+}
+
+// IsEmpty reports whether the value is absent, leaving the field unchanged
+func (v Value) IsEmpty() bool {
+	return v.raw == ""
+}
+
+// String returns the first component, unescaped
+func (v Value) String() string {
+	return v.Component(1)
+}
+
+// Component returns the first subcomponent of a component, numbered from 1, unescaped
+func (v Value) Component(n int) string {
+	return v.Subcomponent(n, 1)
+}
+
+// Subcomponent returns a subcomponent of a component, both numbered from 1, unescaped
+func (v Value) Subcomponent(component, subcomponent int) string {
+	if v.raw == "" || component < 1 || subcomponent < 1 {
+		return ""
+	}
+
+	components := strings.Split(v.raw, string(v.delimiters.Component))
+	if component > len(components) {
+		return ""
+	}
+	subcomponents := strings.Split(components[component-1], string(v.delimiters.Subcomponent))
+	if subcomponent > len(subcomponents) {
+		return ""
+	}
+	return Unescape(subcomponents[subcomponent-1], *v.delimiters)
+}
+
+// Unescape replaces the escape sequences of a value with the characters they stand for. Formatting
+// sequences are dropped and unknown ones kept as they are.
+func Unescape(value string, d Delimiters) string {
+	if strings.IndexByte(value, d.Escape) < 0 {
+		return value
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != d.Escape {
+			b.WriteByte(value[i])
+			continue
+		}
+
+		end := strings.IndexByte(value[i+1:], d.Escape)
+		if end < 0 {
+			b.WriteString(value[i:])
+			break
+		}
+		sequence := value[i+1 : i+1+end]
+		i += end + 1
+
+		switch {
+		case sequence == "F":
+			b.WriteByte(d.Field)
+		case sequence == "S":
+			b.WriteByte(d.Component)
+		case sequence == "R":
+			b.WriteByte(d.Repetition)
+		case sequence == "E":
+			b.WriteByte(d.Escape)
+		case sequence == "T":
+			b.WriteByte(d.Subcomponent)
+		case sequence == ".br":
+			b.WriteByte('\n')
+		case strings.HasPrefix(sequence, "X") && len(sequence)%2 == 1:
+			for j := 1; j < len(sequence); j += 2 {
+				c, err := strconv.ParseUint(sequence[j:j+2], 16, 8)
+				if err != nil {
+					break
+				}
+				b.WriteByte(byte(c))
+			}
+		case strings.HasPrefix(sequence, ".") || sequence == "H" || sequence == "N":
+			// Formatting has no place in the plain text fields of patients
+		default:
+			b.WriteByte(d.Escape)
+			b.WriteString(sequence)
+			b.WriteByte(d.Escape)
+		}
+	}
+	return b.String()
+}
+
+// Escape replaces the delimiters in a value with escape sequences
+func Escape(value string, d Delimiters) string {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		switch value[i] {
+		case d.Field:
+			b.WriteString(string(d.Escape) + "F" + string(d.Escape))
+		case d.Component:
+			b.WriteString(string(d.Escape) + "S" + string(d.Escape))
+		case d.Repetition:
+			b.WriteString(string(d.Escape) + "R" + string(d.Escape))
+		case d.Escape:
+			b.WriteString(string(d.Escape) + "E" + string(d.Escape))
+		case d.Subcomponent:
+			b.WriteString(string(d.Escape) + "T" + string(d.Escape))
+		case '\r', '\n':
+			b.WriteString(string(d.Escape) + ".br" + string(d.Escape))
+		default:
+			b.WriteByte(value[i])
+		}
+	}
+	return b.String()
+}
+
+// Header is the information of the MSH segment needed to process and acknowledge a message
+type Header struct {
+	SendingApplication   string
+	SendingFacility      string
+	ReceivingApplication string
+	ReceivingFacility    string
+	MessageType          string // e.g. ADT
+	TriggerEvent         string // e.g. A01
+	ControlID            string
+	ProcessingID         string
+	Version              string
+}
+
+// Header reads the MSH segment of the message
+func (m *Message) Header() Header {
+	msh := m.Segment("MSH")
+	return Header{
+		SendingApplication:   msh.Value(3).String(),
+		SendingFacility:      msh.Value(4).String(),
+		ReceivingApplication: msh.Value(5).String(),
+		ReceivingFacility:    msh.Value(6).String(),
+		MessageType:          msh.Value(9).Component(1),
+		TriggerEvent:         msh.Value(9).Component(2),
+		ControlID:            msh.Value(10).String(),
+		ProcessingID:         msh.Value(11).String(),
+		Version:              msh.Value(12).String(),
+	}
+}
+
+// Type is the message type and trigger event, e.g. ADT^A01
+func (h Header) Type() string {
+	if h.TriggerEvent == "" {
+		return h.MessageType
+	}
+	return h.MessageType + "^" + h.TriggerEvent
+}
+
+func isAlphanumeric(c byte) bool {
+	return c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9'
+}
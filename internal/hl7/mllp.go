@@ -0,0 +1,218 @@
+package hl7
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+)
+
+// MLLP frames each message between a start block and an end block followed by a carriage return
+const (
+	startBlock     = 0x0b
+	endBlock       = 0x1c
+	carriageReturn = 0x0d
+)
+
+// MaxMessageSize is the size of the largest message read, so that a misbehaving peer cannot
+// exhaust memory
+const MaxMessageSize = 4 << 20
+
+// ErrMessageTooLarge is returned for frames larger than MaxMessageSize
+var ErrMessageTooLarge = errors.New("HL7 message too large")
+
+// ReadFrame reads the next MLLP frame and returns the message it holds. Data before the start
+// block is skipped. It returns io.EOF when the peer closed the connection between messages.
+func ReadFrame(r *bufio.Reader) ([]byte, error) {
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if b == startBlock {
+			break
+		}
+	}
+
+	var message bytes.Buffer
+	for {
+		chunk, err := r.ReadSlice(endBlock)
+		if err != nil && err != bufio.ErrBufferFull {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		message.Write(chunk)
+		if message.Len() > MaxMessageSize {
+			return nil, ErrMessageTooLarge
+		}
+		if err == nil {
+			break
+		}
+	}
+
+	b, err := r.ReadByte()
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	if b != carriageReturn {
+		return nil, fmt.Errorf("invalid MLLP frame: end block followed by %#x", b)
+	}
+
+	return bytes.TrimSuffix(message.Bytes(), []byte{endBlock}), nil
+}
+
+// WriteFrame writes a message in an MLLP frame
+func WriteFrame(w io.Writer, message []byte) error {
+	frame := make([]byte, 0, len(message)+3)
+	frame = append(frame, startBlock)
+	frame = append(frame, message...)
+	frame = append(frame, endBlock, carriageReturn)
+	_, err := w.Write(frame)
+	return err
+}
+
+// Handler processes a message and returns its acknowledgement
+type Handler interface {
+	Handle(ctx context.Context, raw string) string
+}
+
+// HandlerFunc adapts a function to a Handler
+type HandlerFunc func(ctx context.Context, raw string) string
+
+func (f HandlerFunc) Handle(ctx context.Context, raw string) string {
+	return f(ctx, raw)
+}
+
+// Server accepts MLLP connections and answers each message with the acknowledgement of the
+// handler. Messages of a connection are processed in order, one at a time, as senders expect.
+type Server struct {
+	Addr    string
+	Handler Handler
+	// IdleTimeout closes connections without messages for that long, zero keeps them open
+	IdleTimeout time.Duration
+}
+
+// ListenAndServe listens on the address of the server and serves connections until the context
+// is done
+func (s *Server) ListenAndServe(ctx context.Context) error {
+	listener, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen for HL7 messages: %w", err)
+	}
+	return s.Serve(ctx, listener)
+}
+
+// Serve serves the connections of the listener until the context is done, then closes the
+// listener and the connections
+func (s *Server) Serve(ctx context.Context, listener net.Listener) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			return fmt.Errorf("failed to accept HL7 connection: %w", err)
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.serveConn(ctx, conn)
+		}()
+	}
+}
+
+func (s *Server) serveConn(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+
+	// Closing the connection interrupts a pending read when the server stops
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	logger := slog.With("remote_addr", conn.RemoteAddr().String())
+	reader := bufio.NewReader(conn)
+	for {
+		if s.IdleTimeout > 0 {
+			conn.SetReadDeadline(time.Now().Add(s.IdleTimeout))
+		}
+
+		message, err := ReadFrame(reader)
+		if err != nil {
+			if err != io.EOF && ctx.Err() == nil {
+				logger.WarnContext(ctx, "closing HL7 connection", "error", err)
+			}
+			return
+		}
+
+		ack := s.Handler.Handle(ctx, string(message))
+		if err := WriteFrame(conn, []byte(ack)); err != nil {
+			logger.WarnContext(ctx, "failed to send HL7 acknowledgement", "error", err)
+			return
+		}
+	}
+}
+
+// Client sends messages to an MLLP server and waits for their acknowledgements
+type Client struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	// Timeout bounds the wait for an acknowledgement, zero waits indefinitely
+	Timeout time.Duration
+}
+
+// Dial connects to an MLLP server
+func Dial(addr string) (*Client, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{conn: conn, reader: bufio.NewReader(conn), Timeout: 30 * time.Second}, nil
+}
+
+// Send sends a message and returns its acknowledgement
+func (c *Client) Send(message string) (string, error) {
+	if c.Timeout > 0 {
+		c.conn.SetDeadline(time.Now().Add(c.Timeout))
+	}
+	if err := WriteFrame(c.conn, []byte(message)); err != nil {
+		return "", err
+	}
+
+	ack, err := ReadFrame(c.reader)
+	if err != nil {
+		return "", err
+	}
+	return string(ack), nil
+}
+
+// Close closes the connection
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
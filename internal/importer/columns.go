@@ -0,0 +1,206 @@
+package importer
+
+import (
+	"fmt"
+	"net/mail"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/yhwbach/makerble/internal/models"
+	"github.com/yhwbach/makerble/internal/schemas"
+)
+
+// Fields are the patient fields columns map to, named as in schemas.PatientCreate
+var Fields = []string{"full_name", "date_of_birth", "gender", "address", "phone", "email", "medical_history"}
+
+// RequiredFields are the fields every file must have a column for
+var RequiredFields = []string{"full_name", "date_of_birth", "gender"}
+
+// headerAliases are the other column names recognised for the fields
+var headerAliases = map[string]string{
+	"name":          "full_name",
+	"patient_name":  "full_name",
+	"dob":           "date_of_birth",
+	"birth_date":    "date_of_birth",
+	"birthdate":     "date_of_birth",
+	"sex":           "gender",
+	"phone_number":  "phone",
+	"telephone":     "phone",
+	"mobile":        "phone",
+	"email_address": "email",
+	"e_mail":        "email",
+	"history":       "medical_history",
+}
+
+// normalizeHeader is the form column names are compared in, e.g. date_of_birth for "Date of Birth"
+func normalizeHeader(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r == ' ' || r == '-' || r == '.' {
+			return '_'
+		}
+		return r
+	}, strings.ToLower(strings.TrimSpace(name)))
+}
+
+// Columns maps the columns of a file to patient fields: Columns[i] is the field of column i,
+// empty for columns left out
+type Columns []string
+
+// MapColumns maps the columns of the header to patient fields by their names. The mapping given
+// maps column names to fields, taking precedence over the names recognised; mapping a column to an
+// empty field leaves it out. Columns of unknown names are left out. It lists the problems keeping
+// the file from being imported, such as a required field without a column.
+func MapColumns(header []string, mapping map[string]string) (Columns, []string) {
+	var problems []string
+
+	explicit := make(map[string]string, len(mapping))
+	for name, field := range mapping {
+		if field != "" && !slices.Contains(Fields, field) {
+			problems = append(problems, fmt.Sprintf("unknown field %q for column %q", field, name))
+			continue
+		}
+		explicit[normalizeHeader(name)] = field
+	}
+
+	columns := make(Columns, len(header))
+	mapped := map[string]string{}
+	for i, name := range header {
+		normalized := normalizeHeader(name)
+		field, found := explicit[normalized]
+		if !found {
+			field = headerAliases[normalized]
+			if slices.Contains(Fields, normalized) {
+				field = normalized
+			}
+		}
+		if field == "" {
+			continue
+		}
+
+		if other, taken := mapped[field]; taken {
+			problems = append(problems, fmt.Sprintf("columns %q and %q both map to %s", other, name, field))
+			continue
+		}
+		mapped[field] = name
+		columns[i] = field
+	}
+
+	for _, field := range RequiredFields {
+		if _, found := mapped[field]; !found {
+			problems = append(problems, fmt.Sprintf("no column for %s", field))
+		}
+	}
+
+	slices.Sort(problems)
+	return columns, problems
+}
+
+// Fields returns the patient fields the columns set
+func (c Columns) Fields() []string {
+	var fields []string
+	for _, field := range c {
+		if field != "" {
+			fields = append(fields, field)
+		}
+	}
+	slices.Sort(fields)
+	return fields
+}
+
+// ClinicalFields returns the clinical fields the columns set
+func (c Columns) ClinicalFields() []string {
+	if slices.Contains(c, "medical_history") {
+		return []string{"medical_history"}
+	}
+	return nil
+}
+
+// Parse validates a row, returning the patient it describes or the problems keeping it from
+// being imported. The problems never quote the values of the row.
+func (c Columns) Parse(row Row) (*models.Patient, []models.PatientImportError) {
+	var patient schemas.PatientCreate
+	for i, field := range c {
+		if field == "" || i >= len(row.Cells) {
+			continue
+		}
+		value := strings.TrimSpace(row.Cells[i])
+		switch field {
+		case "full_name":
+			patient.FullName = value
+		case "date_of_birth":
+			patient.DateOfBirth = value
+		case "gender":
+			patient.Gender = models.Gender(value)
+		case "address":
+			patient.Address = value
+		case "phone":
+			patient.Phone = value
+		case "email":
+			patient.Email = value
+		case "medical_history":
+			patient.MedicalHistory = value
+		}
+	}
+
+	var problems []models.PatientImportError
+	problem := func(field, message string) {
+		problems = append(problems, models.PatientImportError{Row: row.Number, Field: field, Message: message})
+	}
+
+	if patient.FullName == "" {
+		problem("full_name", "Full name is required")
+	} else if utf8.RuneCountInString(patient.FullName) > 255 {
+		problem("full_name", "Full name must be at most 255 characters")
+	}
+
+	var dateOfBirth time.Time
+	if patient.DateOfBirth == "" {
+		problem("date_of_birth", "Date of birth is required")
+	} else if parsed, err := time.Parse("2006-01-02", patient.DateOfBirth); err != nil {
+		problem("date_of_birth", "Date of birth must be a date in the format YYYY-MM-DD")
+	} else if parsed.After(time.Now()) {
+		problem("date_of_birth", "Date of birth must not be in the future")
+	} else {
+		dateOfBirth = parsed
+	}
+
+	gender, valid := parseGender(string(patient.Gender))
+	if patient.Gender == "" {
+		problem("gender", "Gender is required")
+	} else if !valid {
+		problem("gender", "Gender must be male or female")
+	}
+
+	if patient.Email != "" {
+		if address, err := mail.ParseAddress(patient.Email); err != nil || address.Address != patient.Email {
+			problem("email", "Email must be a valid email address")
+		}
+	}
+
+	if len(problems) > 0 {
+		return nil, problems
+	}
+
+	return &models.Patient{
+		FullName:       patient.FullName,
+		DateOfBirth:    dateOfBirth,
+		Gender:         gender,
+		Address:        patient.Address,
+		Phone:          patient.Phone,
+		Email:          patient.Email,
+		MedicalHistory: patient.MedicalHistory,
+	}, nil
+}
+
+// parseGender reads a gender ignoring case, accepting M and F
+func parseGender(value string) (models.Gender, bool) {
+	switch strings.ToLower(value) {
+	case "male", "m":
+		return models.Male, true
+	case "female", "f":
+		return models.Female, true
+	}
+	return "", false
+}
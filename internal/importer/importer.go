@@ -0,0 +1,306 @@
+// Package importer imports patients in bulk from CSV and XLSX files, as when onboarding a clinic.
+// Files are read and their columns mapped to patient fields when uploaded; the rows are then
+// validated, checked for duplicates and inserted in batches in the background, and the problems
+// found reported per row.
+package importer
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yhwbach/makerble/internal/config"
+	"github.com/yhwbach/makerble/internal/models"
+	"github.com/yhwbach/makerble/internal/repository"
+)
+
+// Formats of import files
+const (
+	FormatCSV  = "csv"
+	FormatXLSX = "xlsx"
+)
+
+// Formats lists the formats files can be imported from
+var Formats = []string{FormatCSV, FormatXLSX}
+
+// IsValidFormat reports whether files can be imported from the format
+func IsValidFormat(format string) bool {
+	return slices.Contains(Formats, format)
+}
+
+// FormatOf returns the format of a file from the extension of its name, empty when unknown
+func FormatOf(filename string) string {
+	format := strings.ToLower(strings.TrimPrefix(filepath.Ext(filename), "."))
+	if !IsValidFormat(format) {
+		return ""
+	}
+	return format
+}
+
+// ErrInvalidFile is returned for files that cannot be read in their format
+var ErrInvalidFile = errors.New("invalid import file")
+
+// Row is a row of an import file. Number is its number in the file, the first row being 1.
+type Row struct {
+	Number int
+	Cells  []string
+}
+
+// blank reports whether every cell of the row is empty
+func (r Row) blank() bool {
+	for _, cell := range r.Cells {
+		if strings.TrimSpace(cell) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// Read reads the rows of a file in the format, leaving out blank rows. The header is the first
+// row returned. Dates of XLSX files are read as YYYY-MM-DD.
+func Read(format string, data []byte) ([]Row, error) {
+	var rows []Row
+	var err error
+	switch format {
+	case FormatCSV:
+		rows, err = readCSV(data)
+	case FormatXLSX:
+		rows, err = readXLSX(data)
+	default:
+		return nil, fmt.Errorf("%w: unsupported format %q", ErrInvalidFile, format)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return slices.DeleteFunc(rows, Row.blank), nil
+}
+
+// readCSV reads a CSV file. Spreadsheets exported with a locale using the comma as decimal
+// separator use semicolons, which is recognised from the header.
+func readCSV(data []byte) ([]Row, error) {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = -1
+	header, _, _ := bytes.Cut(data, []byte("\n"))
+	if bytes.Count(header, []byte(";")) > bytes.Count(header, []byte(",")) {
+		reader.Comma = ';'
+	}
+
+	var rows []Row
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidFile, err)
+		}
+		line, _ := reader.FieldPos(0)
+		rows = append(rows, Row{Number: line, Cells: record})
+	}
+
+	return rows, nil
+}
+
+// Importer runs imports in the background, a few at a time
+type Importer struct {
+	Repo repository.RepoStorage
+	// BatchSize is the number of rows inserted per transaction
+	BatchSize int
+
+	slots   chan struct{}
+	running sync.WaitGroup
+}
+
+// New creates an importer with the limits of the configuration
+func New(cfg config.ImportConfig, repo repository.RepoStorage) *Importer {
+	batchSize, concurrency := cfg.BatchSize, cfg.Concurrency
+	if batchSize <= 0 {
+		batchSize = 500
+	}
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	return &Importer{
+		Repo:      repo,
+		BatchSize: batchSize,
+		slots:     make(chan struct{}, concurrency),
+	}
+}
+
+// Job is an import file read and mapped, ready to run
+type Job struct {
+	Import  *models.PatientImport
+	Columns Columns
+	// Rows are the rows of the file after the header
+	Rows []Row
+}
+
+// Start records the import and runs it in the background, once fewer imports than the configured
+// concurrency run. The import runs in the clinic of the context, without its cancellation.
+func (i *Importer) Start(ctx context.Context, job *Job) error {
+	job.Import.Status = models.PatientImportPending
+	job.Import.TotalRows = len(job.Rows)
+	if err := i.Repo.PatientImports.Create(ctx, job.Import); err != nil {
+		return err
+	}
+
+	ctx = context.WithoutCancel(ctx)
+	i.running.Add(1)
+	go func() {
+		defer i.running.Done()
+		i.slots <- struct{}{}
+		defer func() { <-i.slots }()
+
+		i.Run(ctx, job)
+	}()
+
+	return nil
+}
+
+// Wait waits for the imports started to finish
+func (i *Importer) Wait() {
+	i.running.Wait()
+}
+
+// Run runs an import to completion, recording its progress after each batch. Valid rows are
+// imported even if others are not; an error stops the import, keeping the batches inserted.
+func (i *Importer) Run(ctx context.Context, job *Job) {
+	patientImport := job.Import
+	patientImport.Status = models.PatientImportRunning
+	if err := i.Repo.PatientImports.Update(ctx, patientImport); err != nil {
+		slog.ErrorContext(ctx, "failed to record patient import progress", "import_id", patientImport.ID, "error", err)
+	}
+
+	err := i.run(ctx, job)
+
+	now := time.Now()
+	patientImport.FinishedAt = &now
+	patientImport.Status = models.PatientImportCompleted
+	if err != nil {
+		slog.ErrorContext(ctx, "patient import failed", "import_id", patientImport.ID, "error", err)
+		patientImport.Status = models.PatientImportFailed
+		patientImport.Error = "The import stopped on an internal error, the rows imported until then are kept"
+	}
+
+	if err := i.Repo.PatientImports.Update(ctx, patientImport); err != nil {
+		slog.ErrorContext(ctx, "failed to record patient import outcome", "import_id", patientImport.ID, "error", err)
+	}
+}
+
+func (i *Importer) run(ctx context.Context, job *Job) error {
+	patientImport := job.Import
+
+	// Rows are validated and checked against each other first, so that the first of two rows
+	// describing the same patient is the one imported
+	var rowErrors []models.PatientImportError
+	var patients []*models.Patient
+	var rowNumbers []int
+	seen := map[string]int{}
+	for _, row := range job.Rows {
+		patient, problems := job.Columns.Parse(row)
+		if len(problems) > 0 {
+			rowErrors = append(rowErrors, problems...)
+			patientImport.InvalidRows++
+			continue
+		}
+
+		if problem := firstOccurrence(seen, row.Number, patient); problem != nil {
+			rowErrors = append(rowErrors, *problem)
+			patientImport.DuplicateRows++
+			continue
+		}
+
+		patients = append(patients, patient)
+		rowNumbers = append(rowNumbers, row.Number)
+	}
+
+	if err := i.Repo.PatientImports.AddErrors(ctx, patientImport.ID, rowErrors); err != nil {
+		return err
+	}
+	if err := i.Repo.PatientImports.Update(ctx, patientImport); err != nil {
+		return err
+	}
+
+	fields := job.Columns.Fields()
+	for start := 0; start < len(patients); start += i.BatchSize {
+		end := min(start+i.BatchSize, len(patients))
+
+		duplicates, err := i.Repo.Patients.Import(ctx, &models.PatientImportBatch{
+			ImportID:   patientImport.ID,
+			ImportedBy: patientImport.CreatedBy,
+			Fields:     fields,
+			Patients:   patients[start:end],
+		})
+		if err != nil {
+			return err
+		}
+
+		var batchErrors []models.PatientImportError
+		for _, duplicate := range duplicates {
+			batchErrors = append(batchErrors, duplicateError(rowNumbers[start+duplicate.Index], duplicate))
+		}
+		if err := i.Repo.PatientImports.AddErrors(ctx, patientImport.ID, batchErrors); err != nil {
+			return err
+		}
+
+		patientImport.DuplicateRows += len(duplicates)
+		patientImport.ImportedRows += end - start - len(duplicates)
+		if err := i.Repo.PatientImports.Update(ctx, patientImport); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// firstOccurrence reports a patient described by an earlier row of the file, with the same email
+// or the same name and date of birth, and otherwise remembers the row
+func firstOccurrence(seen map[string]int, rowNumber int, patient *models.Patient) *models.PatientImportError {
+	keys := []struct {
+		field, key, message string
+	}{
+		{"email", "email:" + strings.ToLower(patient.Email), "Same email as row %d"},
+		{"full_name", "name:" + strings.ToLower(patient.FullName) + "\x00" + patient.DateOfBirth.Format("2006-01-02"),
+			"Same name and date of birth as row %d"},
+	}
+	if patient.Email == "" {
+		keys = keys[1:]
+	}
+
+	for _, key := range keys {
+		if first, found := seen[key.key]; found {
+			return &models.PatientImportError{Row: rowNumber, Field: key.field, Message: fmt.Sprintf(key.message, first)}
+		}
+	}
+	for _, key := range keys {
+		seen[key.key] = rowNumber
+	}
+	return nil
+}
+
+// duplicateError reports a row matching an existing patient
+func duplicateError(rowNumber int, duplicate models.PatientDuplicate) models.PatientImportError {
+	importError := models.PatientImportError{Row: rowNumber, Field: duplicate.Match}
+	switch {
+	case duplicate.Match == "email" && duplicate.PatientID != nil:
+		importError.Message = fmt.Sprintf("Email matches existing patient %s", duplicate.PatientID)
+	case duplicate.Match == "email":
+		importError.Message = "Email is already registered"
+	default:
+		importError.Message = fmt.Sprintf("Name and date of birth match existing patient %s", duplicate.PatientID)
+	}
+	return importError
+}
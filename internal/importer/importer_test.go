@@ -0,0 +1,216 @@
+package importer
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yhwbach/makerble/internal/config"
+	"github.com/yhwbach/makerble/internal/models"
+	"github.com/yhwbach/makerble/internal/repository"
+	"github.com/yhwbach/makerble/internal/repository/mock"
+	"github.com/yhwbach/makerble/internal/schemas"
+)
+
+func TestReadCSV(t *testing.T) {
+	data := "\xef\xbb\xbfName;Date of Birth;Sex\r\nJane Doe;1990-04-12;F\r\n;;\r\n\"Roe; Sam\";1980-01-01;m\r\n"
+
+	rows, err := Read(FormatCSV, []byte(data))
+	require.NoError(t, err)
+	require.Len(t, rows, 3, "blank rows are left out")
+	assert.Equal(t, Row{Number: 1, Cells: []string{"Name", "Date of Birth", "Sex"}}, rows[0])
+	assert.Equal(t, Row{Number: 4, Cells: []string{"Roe; Sam", "1980-01-01", "m"}}, rows[2])
+
+	_, err = Read(FormatCSV, []byte("name,dob\n\"Jane,1990-04-12\n"))
+	assert.ErrorIs(t, err, ErrInvalidFile)
+
+	_, err = Read("ods", []byte(data))
+	assert.ErrorIs(t, err, ErrInvalidFile)
+}
+
+// xlsxFile builds a workbook whose first sheet holds the given sheet data
+func xlsxFile(t *testing.T, sheetData string) []byte {
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	for name, content := range map[string]string{
+		"xl/workbook.xml": `<?xml version="1.0" encoding="UTF-8"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+<sheets><sheet name="Patients" sheetId="1" r:id="rId1"/><sheet name="Other" sheetId="2" r:id="rId2"/></sheets></workbook>`,
+		"xl/_rels/workbook.xml.rels": `<?xml version="1.0" encoding="UTF-8"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet2.xml"/>
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>
+</Relationships>`,
+		"xl/sharedStrings.xml": `<?xml version="1.0" encoding="UTF-8"?>
+<sst xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">
+<si><t>Full Name</t></si><si><t>DOB</t></si><si><t>Gender</t></si><si><r><t>Jane </t></r><r><t>Doe</t></r></si>
+</sst>`,
+		"xl/styles.xml": `<?xml version="1.0" encoding="UTF-8"?>
+<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">
+<numFmts><numFmt numFmtId="164" formatCode="dd/mm/yyyy"/><numFmt numFmtId="165" formatCode="&quot;Day&quot; 0"/></numFmts>
+<cellXfs><xf numFmtId="0"/><xf numFmtId="164"/><xf numFmtId="14"/><xf numFmtId="165"/></cellXfs></styleSheet>`,
+		"xl/worksheets/sheet1.xml": `<?xml version="1.0" encoding="UTF-8"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>` + sheetData + `</sheetData></worksheet>`,
+		"xl/worksheets/sheet2.xml": `<?xml version="1.0" encoding="UTF-8"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData/></worksheet>`,
+	} {
+		w, err := archive.Create(name)
+		require.NoError(t, err)
+		_, err = w.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, archive.Close())
+	return buf.Bytes()
+}
+
+func TestReadXLSX(t *testing.T) {
+	data := xlsxFile(t, `
+<row r="1"><c r="A1" t="s"><v>0</v></c><c r="B1" t="s"><v>1</v></c><c r="C1" t="s"><v>2</v></c></row>
+<row r="2"><c r="A2" t="s"><v>3</v></c><c r="B2" s="1"><v>32975</v></c><c r="C2" t="inlineStr"><is><t>female</t></is></c></row>
+<row r="3"><c r="A3" t="inlineStr"><is><t></t></is></c></row>
+<row r="5"><c r="A5" t="str"><v>Sam Roe</v></c><c r="C5"><v>1</v></c><c r="E5" s="3"><v>12</v></c></row>
+<row r="6"><c r="B6" s="2"><v>29221.75</v></c><c r="C6" t="d"><v>1980-01-01T00:00:00</v></c></row>`)
+
+	rows, err := Read(FormatXLSX, data)
+	require.NoError(t, err)
+	require.Len(t, rows, 4)
+	assert.Equal(t, Row{Number: 1, Cells: []string{"Full Name", "DOB", "Gender"}}, rows[0])
+	assert.Equal(t, Row{Number: 2, Cells: []string{"Jane Doe", "1990-04-12", "female"}}, rows[1])
+	assert.Equal(t, Row{Number: 5, Cells: []string{"Sam Roe", "", "1", "", "12"}}, rows[2],
+		"missing cells are empty and numbers without a date format are kept as is")
+	assert.Equal(t, Row{Number: 6, Cells: []string{"", "1980-01-01", "1980-01-01"}}, rows[3])
+
+	_, err = Read(FormatXLSX, []byte("name,dob\n"))
+	assert.ErrorIs(t, err, ErrInvalidFile)
+
+	_, err = Read(FormatXLSX, xlsxFile(t, `<row r="1"><c r="A1" t="s"><v>9</v></c></row>`))
+	assert.ErrorIs(t, err, ErrInvalidFile)
+}
+
+func TestSerialDate(t *testing.T) {
+	assert.Equal(t, "1900-03-01", serialDate(61, false).Format("2006-01-02"))
+	assert.Equal(t, "2025-06-01", serialDate(45809, false).Format("2006-01-02"))
+	assert.Equal(t, "2025-06-01", serialDate(44347, true).Format("2006-01-02"))
+}
+
+func TestMapColumns(t *testing.T) {
+	columns, problems := MapColumns([]string{"Patient Name", "Date of Birth", "SEX", "Notes", "E-mail"}, nil)
+	assert.Empty(t, problems)
+	assert.Equal(t, Columns{"full_name", "date_of_birth", "gender", "", "email"}, columns)
+	assert.Equal(t, []string{"date_of_birth", "email", "full_name", "gender"}, columns.Fields())
+	assert.Empty(t, columns.ClinicalFields())
+
+	columns, problems = MapColumns([]string{"Name", "Born", "Sex", "Notes", "Email"}, map[string]string{
+		"born":  "date_of_birth",
+		"Notes": "medical_history",
+		"Email": "",
+	})
+	assert.Empty(t, problems)
+	assert.Equal(t, Columns{"full_name", "date_of_birth", "gender", "medical_history", ""}, columns)
+	assert.Equal(t, []string{"medical_history"}, columns.ClinicalFields())
+
+	_, problems = MapColumns([]string{"Name", "Full Name", "Phone"}, map[string]string{"Phone": "mobile"})
+	assert.Equal(t, []string{
+		`columns "Name" and "Full Name" both map to full_name`,
+		"no column for date_of_birth",
+		"no column for gender",
+		`unknown field "mobile" for column "Phone"`,
+	}, problems)
+}
+
+func TestParse(t *testing.T) {
+	columns := Columns{"full_name", "date_of_birth", "gender", "email", "", "medical_history"}
+
+	patient, problems := columns.Parse(Row{Number: 2, Cells: []string{" Jane Doe ", "1990-04-12", "F", "jane@example.com", "ignored", "Asthma"}})
+	require.Empty(t, problems)
+	assert.Equal(t, &models.Patient{
+		FullName:       "Jane Doe",
+		DateOfBirth:    time.Date(1990, 4, 12, 0, 0, 0, 0, time.UTC),
+		Gender:         models.Female,
+		Email:          "jane@example.com",
+		MedicalHistory: "Asthma",
+	}, patient)
+
+	_, problems = columns.Parse(Row{Number: 3, Cells: []string{"", "12/04/1990", "x", "Jane <jane@example.com>"}})
+	assert.Equal(t, []models.PatientImportError{
+		{Row: 3, Field: "full_name", Message: "Full name is required"},
+		{Row: 3, Field: "date_of_birth", Message: "Date of birth must be a date in the format YYYY-MM-DD"},
+		{Row: 3, Field: "gender", Message: "Gender must be male or female"},
+		{Row: 3, Field: "email", Message: "Email must be a valid email address"},
+	}, problems)
+
+	future := time.Now().AddDate(0, 0, 2).Format("2006-01-02")
+	_, problems = columns.Parse(Row{Number: 4, Cells: []string{"Sam Roe", future}})
+	assert.Equal(t, []models.PatientImportError{
+		{Row: 4, Field: "date_of_birth", Message: "Date of birth must not be in the future"},
+		{Row: 4, Field: "gender", Message: "Gender is required"},
+	}, problems, "missing cells are empty")
+}
+
+func TestImporter(t *testing.T) {
+	repo := mock.NewMockRepoStorage()
+	ctx := repository.WithClinic(context.Background(), models.DefaultClinicID)
+	userID := uuid.New()
+
+	existingID, err := repo.Patients.Create(ctx, userID, &schemas.PatientCreate{
+		FullName: "Existing Patient", DateOfBirth: "1970-01-01", Gender: models.Male, Email: "existing@example.com",
+	}, time.Now(), nil)
+	require.NoError(t, err)
+
+	data := "name,dob,gender,email\n" +
+		"Jane Doe,1990-04-12,female,jane@example.com\n" +
+		"Sam Roe,1980-01-01,male,\n" +
+		"Invalid,1990-13-01,male,\n" +
+		"JANE DOE,1990-04-12,f,\n" +
+		"Other Jane,1991-01-01,f,JANE@example.com\n" +
+		"Someone,1975-05-05,m,existing@example.com\n" +
+		"existing patient,1970-01-01,m,\n" +
+		"Kim Lee,2001-02-03,f,kim@example.com\n"
+	rows, err := Read(FormatCSV, []byte(data))
+	require.NoError(t, err)
+	columns, problems := MapColumns(rows[0].Cells, nil)
+	require.Empty(t, problems)
+
+	importer := New(config.ImportConfig{BatchSize: 2, Concurrency: 1}, repo)
+	job := &Job{
+		Import:  &models.PatientImport{CreatedBy: userID, Filename: "patients.csv", Format: FormatCSV},
+		Columns: columns,
+		Rows:    rows[1:],
+	}
+	require.NoError(t, importer.Start(ctx, job))
+	importer.Wait()
+
+	patientImport, err := repo.PatientImports.FindByID(ctx, job.Import.ID)
+	require.NoError(t, err)
+	require.NotNil(t, patientImport)
+	assert.Equal(t, models.PatientImportCompleted, patientImport.Status)
+	assert.NotNil(t, patientImport.FinishedAt)
+	assert.Equal(t, 8, patientImport.TotalRows)
+	assert.Equal(t, 3, patientImport.ImportedRows)
+	assert.Equal(t, 1, patientImport.InvalidRows)
+	assert.Equal(t, 4, patientImport.DuplicateRows)
+
+	report, err := repo.PatientImports.ListErrors(ctx, patientImport.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []models.PatientImportError{
+		{Row: 4, Field: "date_of_birth", Message: "Date of birth must be a date in the format YYYY-MM-DD"},
+		{Row: 5, Field: "full_name", Message: "Same name and date of birth as row 2"},
+		{Row: 6, Field: "email", Message: "Same email as row 2"},
+		{Row: 7, Field: "email", Message: "Email matches existing patient " + existingID},
+		{Row: 8, Field: "full_name", Message: "Name and date of birth match existing patient " + existingID},
+	}, report)
+
+	patients, err := repo.Patients.FindAll(ctx, schemas.PatientQuery{})
+	require.NoError(t, err)
+	assert.Len(t, patients, 4)
+
+	events, err := repo.Audit.List(ctx, schemas.AuditQuery{Action: models.AuditActionPatientImport})
+	require.NoError(t, err)
+	require.Len(t, events, 2, "one event per batch")
+	assert.Equal(t, []string{"date_of_birth", "email", "full_name", "gender"}, events[0].Fields)
+}
@@ -0,0 +1,247 @@
+package importer
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxXLSXPartSize bounds the size of the parts of an XLSX file once decompressed
+const maxXLSXPartSize = 256 << 20
+
+// Parts of an XLSX file read, see ECMA-376 part 1. Elements are matched by local name.
+type xlsxWorkbook struct {
+	Properties struct {
+		Date1904 bool `xml:"date1904,attr"`
+	} `xml:"workbookPr"`
+	Sheets []struct {
+		RelationshipID string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+	} `xml:"sheets>sheet"`
+}
+
+type xlsxRelationships struct {
+	Relationships []struct {
+		ID     string `xml:"Id,attr"`
+		Target string `xml:"Target,attr"`
+	} `xml:"Relationship"`
+}
+
+// xlsxText is rich or plain text, as in shared strings and inline strings
+type xlsxText struct {
+	Text string `xml:"t"`
+	Runs []struct {
+		Text string `xml:"t"`
+	} `xml:"r"`
+}
+
+func (t xlsxText) String() string {
+	var text strings.Builder
+	text.WriteString(t.Text)
+	for _, run := range t.Runs {
+		text.WriteString(run.Text)
+	}
+	return text.String()
+}
+
+type xlsxSharedStrings struct {
+	Items []xlsxText `xml:"si"`
+}
+
+type xlsxStyles struct {
+	NumberFormats []struct {
+		ID   int    `xml:"numFmtId,attr"`
+		Code string `xml:"formatCode,attr"`
+	} `xml:"numFmts>numFmt"`
+	CellFormats []struct {
+		NumberFormatID int `xml:"numFmtId,attr"`
+	} `xml:"cellXfs>xf"`
+}
+
+type xlsxSheet struct {
+	Rows []struct {
+		Number int `xml:"r,attr"`
+		Cells  []struct {
+			Reference string   `xml:"r,attr"`
+			Type      string   `xml:"t,attr"`
+			Style     int      `xml:"s,attr"`
+			Value     string   `xml:"v"`
+			Inline    xlsxText `xml:"is"`
+		} `xml:"c"`
+	} `xml:"sheetData>row"`
+}
+
+// readXLSX reads the first sheet of an XLSX workbook. Cells with a date format are read as
+// YYYY-MM-DD, other cells as displayed without formatting.
+func readXLSX(data []byte) ([]Row, error) {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidFile, err)
+	}
+
+	var workbook xlsxWorkbook
+	if err := readXLSXPart(archive, "xl/workbook.xml", &workbook, true); err != nil {
+		return nil, err
+	}
+	if len(workbook.Sheets) == 0 {
+		return nil, fmt.Errorf("%w: the workbook has no sheet", ErrInvalidFile)
+	}
+
+	var relationships xlsxRelationships
+	if err := readXLSXPart(archive, "xl/_rels/workbook.xml.rels", &relationships, true); err != nil {
+		return nil, err
+	}
+	sheetPath := ""
+	for _, relationship := range relationships.Relationships {
+		if relationship.ID == workbook.Sheets[0].RelationshipID {
+			if strings.HasPrefix(relationship.Target, "/") {
+				sheetPath = strings.TrimPrefix(relationship.Target, "/")
+			} else {
+				sheetPath = path.Join("xl", relationship.Target)
+			}
+		}
+	}
+	if sheetPath == "" {
+		return nil, fmt.Errorf("%w: the first sheet is missing", ErrInvalidFile)
+	}
+
+	var sharedStrings xlsxSharedStrings
+	if err := readXLSXPart(archive, "xl/sharedStrings.xml", &sharedStrings, false); err != nil {
+		return nil, err
+	}
+	var styles xlsxStyles
+	if err := readXLSXPart(archive, "xl/styles.xml", &styles, false); err != nil {
+		return nil, err
+	}
+	dateStyles := make([]bool, len(styles.CellFormats))
+	for i, cellFormat := range styles.CellFormats {
+		dateStyles[i] = isDateFormat(cellFormat.NumberFormatID, "")
+		for _, numberFormat := range styles.NumberFormats {
+			if numberFormat.ID == cellFormat.NumberFormatID {
+				dateStyles[i] = isDateFormat(numberFormat.ID, numberFormat.Code)
+			}
+		}
+	}
+
+	var sheet xlsxSheet
+	if err := readXLSXPart(archive, sheetPath, &sheet, true); err != nil {
+		return nil, err
+	}
+
+	rows := make([]Row, 0, len(sheet.Rows))
+	for i, sheetRow := range sheet.Rows {
+		row := Row{Number: sheetRow.Number}
+		if row.Number == 0 {
+			row.Number = i + 1
+		}
+
+		for _, cell := range sheetRow.Cells {
+			column := len(row.Cells)
+			if cell.Reference != "" {
+				if column, err = columnIndex(cell.Reference); err != nil {
+					return nil, err
+				}
+			}
+
+			var value string
+			switch cell.Type {
+			case "s":
+				index, err := strconv.Atoi(cell.Value)
+				if err != nil || index < 0 || index >= len(sharedStrings.Items) {
+					return nil, fmt.Errorf("%w: cell %s refers to a missing string", ErrInvalidFile, cell.Reference)
+				}
+				value = sharedStrings.Items[index].String()
+			case "inlineStr":
+				value = cell.Inline.String()
+			case "d":
+				// ISO 8601 dates, written by some applications instead of serial numbers
+				value, _, _ = strings.Cut(cell.Value, "T")
+			case "", "n":
+				value = cell.Value
+				if cell.Style >= 0 && cell.Style < len(dateStyles) && dateStyles[cell.Style] && value != "" {
+					serial, err := strconv.ParseFloat(value, 64)
+					if err != nil {
+						return nil, fmt.Errorf("%w: cell %s is not a number", ErrInvalidFile, cell.Reference)
+					}
+					value = serialDate(serial, workbook.Properties.Date1904).Format("2006-01-02")
+				}
+			default:
+				// Booleans, errors and the cached results of string formulas
+				value = cell.Value
+			}
+
+			for len(row.Cells) <= column {
+				row.Cells = append(row.Cells, "")
+			}
+			row.Cells[column] = value
+		}
+
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
+
+// readXLSXPart decodes a part of the archive, which may be missing unless required
+func readXLSXPart(archive *zip.Reader, name string, v interface{}, required bool) error {
+	file, err := archive.Open(name)
+	if err != nil {
+		if !required {
+			return nil
+		}
+		return fmt.Errorf("%w: %s is missing", ErrInvalidFile, name)
+	}
+	defer file.Close()
+
+	if err := xml.NewDecoder(io.LimitReader(file, maxXLSXPartSize)).Decode(v); err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrInvalidFile, name, err)
+	}
+	return nil
+}
+
+// columnIndex returns the index of the column of a cell reference, 0 for A1
+func columnIndex(reference string) (int, error) {
+	column := 0
+	letters := 0
+	for _, r := range reference {
+		if r < 'A' || r > 'Z' {
+			break
+		}
+		column = column*26 + int(r-'A'+1)
+		letters++
+	}
+	if letters == 0 || letters > 3 {
+		return 0, fmt.Errorf("%w: invalid cell reference %q", ErrInvalidFile, reference)
+	}
+	return column - 1, nil
+}
+
+// quotedFormat matches the literal text and the colours and conditions of number formats
+var quotedFormat = regexp.MustCompile(`"[^"]*"|\[[^\]]*\]|\\.`)
+
+// isDateFormat reports whether a number format shows dates. Formats 14 to 22 are the built-in
+// date formats, 27 to 36 and 50 to 58 their East Asian variants.
+func isDateFormat(id int, code string) bool {
+	if code == "" {
+		return (id >= 14 && id <= 22) || (id >= 27 && id <= 36) || (id >= 45 && id <= 47) || (id >= 50 && id <= 58)
+	}
+	code = strings.ToLower(quotedFormat.ReplaceAllString(code, ""))
+	return strings.ContainsAny(code, "dy")
+}
+
+// serialDate converts a spreadsheet serial date to a date. Serial dates count days from the end of
+// 1899, taking 1900 as a leap year, or from 1904 in workbooks using the 1904 date system.
+func serialDate(serial float64, date1904 bool) time.Time {
+	epoch := time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+	if date1904 {
+		epoch = time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	return epoch.AddDate(0, 0, int(math.Floor(serial)))
+}
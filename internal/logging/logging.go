@@ -0,0 +1,84 @@
+// Package logging sets up structured logging that keeps personal data and secrets out of the logs.
+// Log through log/slog with a context, so records carry the attributes of the request the context
+// belongs to, such as its ID and the caller.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+
+	"github.com/yhwbach/makerble/internal/config"
+)
+
+// New creates a logger writing records in the configured format and at or above the configured
+// level. Every attribute goes through Redact.
+func New(cfg config.LogConfig, w io.Writer) (*slog.Logger, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(cfg.Level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q", cfg.Level)
+	}
+
+	opts := &slog.HandlerOptions{Level: level, ReplaceAttr: Redact}
+
+	var handler slog.Handler
+	switch cfg.Format {
+	case "json":
+		handler = slog.NewJSONHandler(w, opts)
+	case "text":
+		handler = slog.NewTextHandler(w, opts)
+	default:
+		return nil, fmt.Errorf("unsupported log format %q", cfg.Format)
+	}
+
+	return slog.New(contextHandler{handler}), nil
+}
+
+type attrsContextKey struct{}
+
+// contextAttrs are the attributes added to every record logged with a context. They are shared
+// by the contexts derived from it, so that attributes added further down the middleware chain,
+// such as the caller, also appear in the records logged further up once the request completes.
+type contextAttrs struct {
+	mu    sync.Mutex
+	attrs []slog.Attr
+}
+
+// NewContext returns a context whose records carry the attributes, and those added to it later
+func NewContext(ctx context.Context, attrs ...slog.Attr) context.Context {
+	return context.WithValue(ctx, attrsContextKey{}, &contextAttrs{attrs: attrs})
+}
+
+// AddAttrs adds attributes to the records logged with the context and every context sharing its
+// attributes. It does nothing for contexts not created by NewContext.
+func AddAttrs(ctx context.Context, attrs ...slog.Attr) {
+	if c, ok := ctx.Value(attrsContextKey{}).(*contextAttrs); ok {
+		c.mu.Lock()
+		c.attrs = append(c.attrs, attrs...)
+		c.mu.Unlock()
+	}
+}
+
+// contextHandler adds the attributes of the context to the records
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if c, ok := ctx.Value(attrsContextKey{}).(*contextAttrs); ok {
+		c.mu.Lock()
+		record.AddAttrs(c.attrs...)
+		c.mu.Unlock()
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
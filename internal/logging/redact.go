@@ -0,0 +1,71 @@
+package logging
+
+import (
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+)
+
+// Redacted replaces the values withheld from the logs
+const Redacted = "[REDACTED]"
+
+// sensitiveKeys are attribute keys whose values are never logged, whatever they hold
+var sensitiveKeys = map[string]bool{
+	"body":            true,
+	"request_body":    true,
+	"response_body":   true,
+	"password":        true,
+	"token":           true,
+	"access_token":    true,
+	"refresh_token":   true,
+	"id_token":        true,
+	"authorization":   true,
+	"cookie":          true,
+	"api_key":         true,
+	"secret":          true,
+	"client_secret":   true,
+	"email":           true,
+	"phone":           true,
+	"address":         true,
+	"medical_history": true,
+}
+
+// sensitivePatterns find personal data and credentials within logged text, such as error messages
+var sensitivePatterns = []struct {
+	pattern     *regexp.Regexp
+	replacement string
+}{
+	{regexp.MustCompile(`(?i)\bbearer\s+\S+`), "Bearer " + Redacted},
+	{regexp.MustCompile(`\beyJ[\w-]*\.[\w-]+\.[\w-]*`), Redacted},
+	{regexp.MustCompile(`\bmk_\w+`), Redacted},
+	{regexp.MustCompile(`[\w.%+-]+@[\w-]+(\.[\w-]+)*\.[A-Za-z]{2,}`), Redacted},
+	{regexp.MustCompile(`\+\d[\d\s().-]{6,}\d`), Redacted},
+	{regexp.MustCompile(`\(?\b\d{3}\)?[\s.-]?\d{3}[\s.-]\d{4}\b`), Redacted},
+}
+
+// Redact withholds the values of sensitive attributes, and personal data and credentials found in
+// text values. It is meant as the ReplaceAttr function of slog handlers, which also pass it the
+// message. Values of other kinds than strings, durations, times, numbers and booleans are logged
+// as redacted text, as their structure may hold anything.
+func Redact(groups []string, a slog.Attr) slog.Attr {
+	if sensitiveKeys[strings.ToLower(a.Key)] {
+		return slog.String(a.Key, Redacted)
+	}
+
+	switch a.Value.Kind() {
+	case slog.KindString:
+		a.Value = slog.StringValue(RedactString(a.Value.String()))
+	case slog.KindAny:
+		a.Value = slog.StringValue(RedactString(fmt.Sprint(a.Value.Any())))
+	}
+	return a
+}
+
+// RedactString withholds personal data and credentials found in the text
+func RedactString(s string) string {
+	for _, p := range sensitivePatterns {
+		s = p.pattern.ReplaceAllString(s, p.replacement)
+	}
+	return s
+}
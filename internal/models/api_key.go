@@ -0,0 +1,74 @@
+package models
+
+import (
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// API key scopes. They are deliberately narrower than the user roles.
+const (
+	ScopePatientsRead          = "patients:read"
+	ScopePatientsReadClinical  = "patients:read:clinical"
+	ScopePatientsWrite         = "patients:write"
+	ScopePatientsWriteClinical = "patients:write:clinical"
+	ScopePatientsDelete        = "patients:delete"
+	ScopePatientsExport        = "patients:export"
+)
+
+// Scopes lists every scope an API key can be granted
+var Scopes = []string{
+	ScopePatientsRead, ScopePatientsReadClinical, ScopePatientsWrite, ScopePatientsWriteClinical, ScopePatientsDelete,
+	ScopePatientsExport,
+}
+
+// scopePermissions lists the permissions each scope grants
+var scopePermissions = map[string][]string{
+	ScopePatientsRead:          {PermissionPatientRead},
+	ScopePatientsReadClinical:  {PermissionPatientReadClinical},
+	ScopePatientsWrite:         {PermissionPatientCreate, PermissionPatientUpdateDemographics},
+	ScopePatientsWriteClinical: {PermissionPatientUpdateClinical},
+	ScopePatientsDelete:        {PermissionPatientDelete},
+	ScopePatientsExport:        {PermissionPatientBulkExport},
+}
+
+// IsValidScope reports whether the scope is one of the known scopes
+func IsValidScope(scope string) bool {
+	return slices.Contains(Scopes, scope)
+}
+
+// APIKey lets a service account call the API without a login
+type APIKey struct {
+	ID         uuid.UUID  `json:"id"`
+	UserID     uuid.UUID  `json:"user_id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	LastUsedIP string     `json:"last_used_ip,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	CreatedBy  uuid.UUID  `json:"created_by"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// IsActive reports whether the key can still be used to authenticate
+func (k *APIKey) IsActive() bool {
+	return k.RevokedAt == nil && (k.ExpiresAt == nil || time.Now().Before(*k.ExpiresAt))
+}
+
+// HasScope reports whether the key was granted the scope
+func (k *APIKey) HasScope(scope string) bool {
+	return slices.Contains(k.Scopes, scope)
+}
+
+// HasPermission reports whether one of the key's scopes grants the permission
+func (k *APIKey) HasPermission(permission string) bool {
+	for _, scope := range k.Scopes {
+		if slices.Contains(scopePermissions[scope], permission) {
+			return true
+		}
+	}
+	return false
+}
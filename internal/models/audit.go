@@ -0,0 +1,67 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// AuditSeverity ranks audit events so that reviewers can find the ones that need attention
+type AuditSeverity string
+
+const (
+	AuditSeverityInfo AuditSeverity = "info"
+	AuditSeverityHigh AuditSeverity = "high"
+)
+
+// Actions recorded in the audit log
+const (
+	AuditActionPatientRead       = "patient.read"
+	AuditActionPatientList       = "patient.list"
+	AuditActionPatientCreate     = "patient.create"
+	AuditActionPatientUpdate     = "patient.update"
+	AuditActionPatientDelete     = "patient.delete"
+	AuditActionEmergencyAccess   = "patient.emergency_access"
+	AuditActionPatientTransfer   = "patient.transfer"
+	AuditActionPatientExport     = "patient.export"
+	AuditActionResearchExport    = "patient.research_export"
+	AuditActionPatientArchive    = "patient.archive"
+	AuditActionPatientMerge      = "patient.merge"
+	AuditActionPatientImport     = "patient.import"
+	AuditActionPatientBulkExport = "patient.bulk_export"
+	AuditActionAuditPurge        = "audit.purge"
+)
+
+// AuditEvent records an action taken by a user. Events are append-only and chained: each hash
+// covers the event and the hash of the event before it.
+type AuditEvent struct {
+	ID         int64                  `json:"id"`
+	OccurredAt time.Time              `json:"occurred_at"`
+	Severity   AuditSeverity          `json:"severity"`
+	Action     string                 `json:"action"`
+	ActorID    *uuid.UUID             `json:"actor_id,omitempty"`
+	PatientID  *uuid.UUID             `json:"patient_id,omitempty"`
+	ClinicID   *uuid.UUID             `json:"clinic_id,omitempty"`
+	RequestID  string                 `json:"request_id,omitempty"`
+	IPAddress  string                 `json:"ip_address,omitempty"`
+	Fields     []string               `json:"fields,omitempty"` // Patient fields read or written
+	Details    map[string]interface{} `json:"details,omitempty"`
+	PrevHash   string                 `json:"prev_hash"`
+	Hash       string                 `json:"hash"`
+}
+
+// AuditVerification is the outcome of checking the audit log chain
+type AuditVerification struct {
+	Events   int64  `json:"events"`
+	HeadHash string `json:"head_hash"` // Hash of the latest event, to compare with a copy kept elsewhere
+	// PurgedThrough is the last event removed by the retention policy, which the log now starts after
+	PurgedThrough *int64 `json:"purged_through,omitempty"`
+	// BrokenAt is the first event that does not match its hash or the event before it
+	BrokenAt *int64 `json:"broken_at,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// Valid reports whether the chain is intact
+func (v *AuditVerification) Valid() bool {
+	return v.BrokenAt == nil
+}
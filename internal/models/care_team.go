@@ -0,0 +1,41 @@
+package models
+
+import (
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Roles a user can have in the care team of a patient
+const (
+	CareTeamAttending  = "attending"
+	CareTeamConsulting = "consulting"
+	CareTeamCovering   = "covering"
+)
+
+// CareTeamRoles lists every role a care team member can have
+var CareTeamRoles = []string{CareTeamAttending, CareTeamConsulting, CareTeamCovering}
+
+// IsValidCareTeamRole reports whether the role is one of the known care team roles
+func IsValidCareTeamRole(role string) bool {
+	return slices.Contains(CareTeamRoles, role)
+}
+
+// CareTeamAssignment gives a user access to a patient for a period of time
+type CareTeamAssignment struct {
+	ID         uuid.UUID  `json:"id"`
+	PatientID  uuid.UUID  `json:"patient_id"`
+	UserID     uuid.UUID  `json:"user_id"`
+	Role       string     `json:"role"`
+	ValidFrom  time.Time  `json:"valid_from"`
+	ValidUntil *time.Time `json:"valid_until,omitempty"`
+	AssignedBy uuid.UUID  `json:"assigned_by"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// IsActive reports whether the assignment currently grants access
+func (a *CareTeamAssignment) IsActive() bool {
+	now := time.Now()
+	return !a.ValidFrom.After(now) && (a.ValidUntil == nil || now.Before(*a.ValidUntil))
+}
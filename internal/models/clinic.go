@@ -0,0 +1,29 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// DefaultClinicID identifies the clinic that existing users and patients were assigned to when
+// clinics were introduced. Accounts provisioned without a clinic join it.
+var DefaultClinicID = uuid.MustParse("00000000-0000-0000-0000-000000000001")
+
+// Clinic is a tenant of the system. Users and patients belong to exactly one clinic.
+type Clinic struct {
+	ID        uuid.UUID `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// PatientTransfer records a patient moving from one clinic to another
+type PatientTransfer struct {
+	ID            uuid.UUID `json:"id"`
+	PatientID     uuid.UUID `json:"patient_id"`
+	FromClinicID  uuid.UUID `json:"from_clinic_id"`
+	ToClinicID    uuid.UUID `json:"to_clinic_id"`
+	Reason        string    `json:"reason"`
+	TransferredBy uuid.UUID `json:"transferred_by"`
+	TransferredAt time.Time `json:"transferred_at"`
+}
@@ -0,0 +1,44 @@
+package models
+
+import (
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Kinds of consent a patient can give
+const (
+	ConsentTreatment   = "treatment"
+	ConsentDataSharing = "data_sharing"
+	ConsentResearch    = "research"
+	ConsentMarketing   = "marketing"
+)
+
+// ConsentTypes lists every kind of consent a patient can give
+var ConsentTypes = []string{ConsentTreatment, ConsentDataSharing, ConsentResearch, ConsentMarketing}
+
+// IsValidConsentType reports whether the consent type is one of the known types
+func IsValidConsentType(consentType string) bool {
+	return slices.Contains(ConsentTypes, consentType)
+}
+
+// Consent records a patient's consent. Revoked consents are kept as history.
+type Consent struct {
+	ID        uuid.UUID `json:"id"`
+	PatientID uuid.UUID `json:"patient_id"`
+	Type      string    `json:"type"`
+	// Scope narrows the consent down, e.g. to a study or a partner organisation
+	Scope     string     `json:"scope,omitempty"`
+	GrantedAt time.Time  `json:"granted_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+	// DocumentRef points to the signed consent form
+	DocumentRef string    `json:"document_ref,omitempty"`
+	RecordedBy  uuid.UUID `json:"recorded_by"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// IsActive reports whether the consent is currently given
+func (c *Consent) IsActive() bool {
+	return !c.GrantedAt.After(time.Now()) && c.RevokedAt == nil
+}
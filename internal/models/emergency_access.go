@@ -0,0 +1,18 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// EmergencyAccessGrant gives a user temporary access to a patient outside of their care team
+type EmergencyAccessGrant struct {
+	ID            uuid.UUID `json:"id"`
+	PatientID     uuid.UUID `json:"patient_id"`
+	UserID        uuid.UUID `json:"user_id"`
+	Username      string    `json:"username,omitempty"`
+	Justification string    `json:"justification"`
+	GrantedAt     time.Time `json:"granted_at"`
+	ExpiresAt     time.Time `json:"expires_at"`
+}
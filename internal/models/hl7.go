@@ -0,0 +1,46 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Statuses of received HL7 messages, after the acknowledgement sent for them
+const (
+	HL7MessageReceived  = "received"  // Not processed yet
+	HL7MessageProcessed = "processed" // Acknowledged with AA
+	HL7MessageFailed    = "failed"    // Acknowledged with AE, the message can be replayed
+	HL7MessageRejected  = "rejected"  // Acknowledged with AR, the message cannot be processed as is
+)
+
+// HL7Message is an HL7 v2 message received from another system, kept as received for replay
+type HL7Message struct {
+	ID                 uuid.UUID  `json:"id"`
+	ClinicID           uuid.UUID  `json:"clinic_id"`
+	SendingApplication string     `json:"sending_application"`
+	ControlID          string     `json:"control_id"`
+	MessageType        string     `json:"message_type"` // e.g. ADT^A01
+	Raw                string     `json:"-"`
+	Status             string     `json:"status"`
+	Error              string     `json:"error,omitempty"`
+	PatientID          *uuid.UUID `json:"patient_id,omitempty"`
+	ReceivedAt         time.Time  `json:"received_at"`
+	ProcessedAt        *time.Time `json:"processed_at,omitempty"`
+}
+
+// PatientIdentifier is an identifier another system knows a patient by, such as a medical record
+// number. System names the assigning authority.
+type PatientIdentifier struct {
+	System string `json:"system"`
+	Value  string `json:"value"`
+}
+
+// PatientMerge merges a duplicate patient record into the surviving one. Source names what
+// requested the merge, e.g. the HL7 message.
+type PatientMerge struct {
+	SurvivorID uuid.UUID `json:"survivor_id"`
+	MergedID   uuid.UUID `json:"merged_id"`
+	MergedBy   uuid.UUID `json:"merged_by"`
+	Source     string    `json:"source,omitempty"`
+}
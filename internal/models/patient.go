@@ -1,6 +1,7 @@
 package models
 
 import (
+	"slices"
 	"time"
 
 	"github.com/google/uuid"
@@ -22,8 +23,48 @@ type Patient struct {
 	Address        string    `json:"address"`
 	Phone          string    `json:"phone"`
 	Email          string    `json:"email"`
-	MedicalHistory string    `json:"medical_history"`
+	MedicalHistory string    `json:"medical_history,omitempty"`
 	RegisteredBy   uuid.UUID `json:"registered_by"`
+	ClinicID       uuid.UUID `json:"clinic_id"`
 	CreatedAt      time.Time `json:"created_at"`
 	UpdatedAt      time.Time `json:"updated_at"`
+
+	// ArchivedAt is set when the retention policy archived the patient for inactivity. Archived
+	// patients are left out of lists until they are updated again.
+	ArchivedAt *time.Time `json:"archived_at,omitempty"`
+
+	// Consents lists the types of consent the patient currently gives
+	Consents []string `json:"consents,omitempty"`
+
+	// RedactedFields lists the fields withheld from the caller
+	RedactedFields []string `json:"redacted_fields,omitempty"`
+}
+
+// PatientFields are the fields holding information about the patient
+var PatientFields = []string{"full_name", "date_of_birth", "gender", "address", "phone", "email", "medical_history"}
+
+// PatientClinicalFields are the patient fields holding clinical information. Reading them
+// requires patient.read.clinical and writing them patient.update.clinical.
+var PatientClinicalFields = []string{"medical_history"}
+
+// RedactClinical withholds the clinical fields of the patient
+func (p *Patient) RedactClinical() {
+	p.MedicalHistory = ""
+	p.RedactedFields = PatientClinicalFields
+}
+
+// VisibleFields lists the fields holding information about the patient that were not redacted
+func (p *Patient) VisibleFields() []string {
+	var fields []string
+	for _, field := range PatientFields {
+		if !slices.Contains(p.RedactedFields, field) {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+// HasConsent reports whether the patient currently gives the type of consent
+func (p *Patient) HasConsent(consentType string) bool {
+	return slices.Contains(p.Consents, consentType)
 }
@@ -0,0 +1,39 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Statuses of bulk patient exports
+const (
+	PatientExportPending   = "pending"   // Waiting for other exports to finish
+	PatientExportRunning   = "running"   // Selecting the patients to export
+	PatientExportCompleted = "completed" // The files can be downloaded
+	PatientExportFailed    = "failed"    // Stopped by an error, nothing to download
+)
+
+// PatientExport is a FHIR bulk data export of patients. Exports record which patients they
+// selected rather than the files themselves, which are read from the patients when downloaded,
+// so that no decrypted copy of the patients is kept.
+type PatientExport struct {
+	ID        uuid.UUID `json:"id"`
+	ClinicID  uuid.UUID `json:"clinic_id"`
+	CreatedBy uuid.UUID `json:"created_by"`
+	// Request is the URL of the kick-off request
+	Request string `json:"request"`
+	Status  string `json:"status"`
+	// PatientIDs are the patients selected, once completed
+	PatientIDs []uuid.UUID `json:"-"`
+	Error      string      `json:"error,omitempty"`
+	// CreatedAt is the transaction time of the export: the patients selected are those matching
+	// the request then
+	CreatedAt  time.Time  `json:"created_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// Finished reports whether the export is over
+func (e *PatientExport) Finished() bool {
+	return e.Status == PatientExportCompleted || e.Status == PatientExportFailed
+}
@@ -0,0 +1,64 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Statuses of patient imports
+const (
+	PatientImportPending   = "pending"   // Waiting for other imports to finish
+	PatientImportRunning   = "running"   // Validating and inserting rows
+	PatientImportCompleted = "completed" // Every row was either imported or reported
+	PatientImportFailed    = "failed"    // Stopped by an error, the rows imported until then are kept
+)
+
+// PatientImport is a bulk import of patients from a CSV or XLSX file
+type PatientImport struct {
+	ID        uuid.UUID `json:"id"`
+	ClinicID  uuid.UUID `json:"clinic_id"`
+	CreatedBy uuid.UUID `json:"created_by"`
+	Filename  string    `json:"filename"`
+	Format    string    `json:"format"`
+	Status    string    `json:"status"`
+	// TotalRows counts the rows of the file, header and blank rows aside
+	TotalRows     int        `json:"total_rows"`
+	ImportedRows  int        `json:"imported_rows"`
+	InvalidRows   int        `json:"invalid_rows"`
+	DuplicateRows int        `json:"duplicate_rows"`
+	Error         string     `json:"error,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	FinishedAt    *time.Time `json:"finished_at,omitempty"`
+}
+
+// Finished reports whether the import is over
+func (i *PatientImport) Finished() bool {
+	return i.Status == PatientImportCompleted || i.Status == PatientImportFailed
+}
+
+// PatientImportError is a problem with a row of an import, which kept it from being imported.
+// Row is the number of the row in the file, the header being row 1.
+type PatientImportError struct {
+	Row     int    `json:"row"`
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+// PatientImportBatch is a batch of valid rows of an import, inserted together
+type PatientImportBatch struct {
+	ImportID   uuid.UUID
+	ImportedBy uuid.UUID
+	// Fields are the patient fields the file sets
+	Fields   []string
+	Patients []*Patient
+}
+
+// PatientDuplicate is a patient of an import batch left out as it matches an existing patient.
+// PatientID is nil when the match is in another clinic.
+type PatientDuplicate struct {
+	Index     int
+	PatientID *uuid.UUID
+	// Match is the field matched, email or full_name for the same name and date of birth
+	Match string
+}
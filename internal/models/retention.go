@@ -0,0 +1,52 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Retention policies
+const (
+	RetentionInvalidTokens    = "invalid_tokens"
+	RetentionExpiredSessions  = "expired_sessions"
+	RetentionInactivePatients = "inactive_patients"
+	RetentionAuditLog         = "audit_log"
+	RetentionPatientExports   = "patient_exports"
+)
+
+// What retention policies do with the rows they apply to
+const (
+	RetentionDeleted  = "deleted"
+	RetentionArchived = "archived"
+)
+
+// RetentionRun reports what a run of the retention policies archived or deleted. Dry runs report
+// what would have been.
+type RetentionRun struct {
+	ID         uuid.UUID         `json:"id"`
+	StartedAt  time.Time         `json:"started_at"`
+	FinishedAt time.Time         `json:"finished_at"`
+	DryRun     bool              `json:"dry_run"`
+	Results    []RetentionResult `json:"results"`
+}
+
+// RetentionResult is the outcome of applying a retention policy
+type RetentionResult struct {
+	Policy string `json:"policy"`
+	Action string `json:"action"`
+	// Before is the cutoff: the policy applies to rows older than it
+	Before time.Time `json:"before"`
+	Count  int64     `json:"count"`
+	Error  string    `json:"error,omitempty"`
+}
+
+// Failed reports whether any policy of the run failed
+func (r *RetentionRun) Failed() bool {
+	for _, result := range r.Results {
+		if result.Error != "" {
+			return true
+		}
+	}
+	return false
+}
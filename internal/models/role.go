@@ -0,0 +1,93 @@
+package models
+
+import (
+	"slices"
+	"time"
+)
+
+// Permissions that roles and API key scopes grant
+const (
+	PermissionPatientRead               = "patient.read"
+	PermissionPatientReadClinical       = "patient.read.clinical"
+	PermissionPatientReadAll            = "patient.read.all"
+	PermissionPatientCreate             = "patient.create"
+	PermissionPatientUpdateDemographics = "patient.update.demographics"
+	PermissionPatientUpdateClinical     = "patient.update.clinical"
+	PermissionPatientDelete             = "patient.delete"
+	PermissionPatientTransfer           = "patient.transfer"
+	PermissionPatientEmergencyAccess    = "patient.emergency_access"
+	PermissionPatientExport             = "patient.export"
+	PermissionPatientImport             = "patient.import"
+	PermissionPatientBulkExport         = "patient.bulk_export"
+	PermissionCareTeamManage            = "care_team.manage"
+	PermissionConsentManage             = "consent.manage"
+	PermissionEmergencyAccessReview     = "emergency_access.review"
+	PermissionAuditRead                 = "audit.read"
+	PermissionResearchExport            = "research.export"
+	PermissionUserManage                = "user.manage"
+	PermissionInvitationManage          = "invitation.manage"
+	PermissionServiceAccountManage      = "service_account.manage"
+	PermissionRoleManage                = "role.manage"
+	PermissionClinicManage              = "clinic.manage"
+)
+
+// Permissions lists every permission a role can be granted
+var Permissions = []string{
+	PermissionPatientRead,
+	PermissionPatientReadClinical,
+	PermissionPatientReadAll,
+	PermissionPatientCreate,
+	PermissionPatientUpdateDemographics,
+	PermissionPatientUpdateClinical,
+	PermissionPatientDelete,
+	PermissionPatientTransfer,
+	PermissionPatientEmergencyAccess,
+	PermissionPatientExport,
+	PermissionPatientImport,
+	PermissionPatientBulkExport,
+	PermissionCareTeamManage,
+	PermissionConsentManage,
+	PermissionEmergencyAccessReview,
+	PermissionAuditRead,
+	PermissionResearchExport,
+	PermissionUserManage,
+	PermissionInvitationManage,
+	PermissionServiceAccountManage,
+	PermissionRoleManage,
+	PermissionClinicManage,
+}
+
+// DeploymentPermissions reach beyond the clinic of the user holding them, so only users holding
+// clinic.manage manage the accounts and roles granting them
+var DeploymentPermissions = []string{
+	PermissionPatientTransfer,
+	PermissionRoleManage,
+	PermissionClinicManage,
+}
+
+// IsValidPermission reports whether the permission is one of the known permissions
+func IsValidPermission(permission string) bool {
+	return slices.Contains(Permissions, permission)
+}
+
+// Role is a named set of permissions. Its name is the user type of the users holding it.
+type Role struct {
+	Name        UserType  `json:"name"`
+	Description string    `json:"description"`
+	Permissions []string  `json:"permissions"`
+	BuiltIn     bool      `json:"built_in"` // Built-in roles cannot be deleted
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// HasPermission reports whether the role grants the permission
+func (r *Role) HasPermission(permission string) bool {
+	return slices.Contains(r.Permissions, permission)
+}
+
+// IsDeploymentLevel reports whether the role grants any of the deployment permissions
+func (r *Role) IsDeploymentLevel() bool {
+	return slices.ContainsFunc(r.Permissions, func(permission string) bool {
+		return slices.Contains(DeploymentPermissions, permission)
+	})
+}
@@ -6,9 +6,10 @@ import (
 	"github.com/google/uuid"
 )
 
+// InvalidToken is a revoked token, identified by the SHA-256 hash of its jti claim
 type InvalidToken struct {
 	ID            uuid.UUID `json:"id"`
-	Token         string    `json:"token"`
+	JTIHash       string    `json:"jti_hash"`
 	InvalidatedAt time.Time `json:"invalidated_at"`
 	ExpiresAt     time.Time `json:"expires_at"`
 }
@@ -12,16 +12,52 @@ type UserType string
 const (
 	Doctor       UserType = "doctor"
 	Receptionist UserType = "receptionist"
+	Admin        UserType = "admin"
+
+	// Operators run the deployment. They manage clinics and roles, which span every clinic, and are
+	// deliberately not one of the valid roles so SSO and LDAP never provision them.
+	Operator UserType = "operator"
+
+	// Service accounts are used by other systems through API keys. They cannot log in and are
+	// not a role that can be assigned to people.
+	Service UserType = "service"
+)
+
+// IsValid reports whether the user type is one of the known roles
+func (t UserType) IsValid() bool {
+	switch t {
+	case Doctor, Receptionist, Admin:
+		return true
+	default:
+		return false
+	}
+}
+
+// Authentication providers a user account can originate from
+const (
+	LocalProvider = "local"
+	OIDCProvider  = "oidc"
+	LDAPProvider  = "ldap"
+	// APIKeyProvider marks service accounts, which only authenticate with API keys
+	APIKeyProvider = "api_key"
 )
 
-// User represents a user in the system (doctor or receptionist)
+// UnusablePassword is stored for accounts that never authenticate with a local password
+const UnusablePassword = "!"
+
+// User represents a user in the system (doctor, receptionist, admin or service account)
 type User struct {
-	ID        uuid.UUID `json:"id"`
-	Username  string    `json:"username"`
-	Password  string    `json:"-"` // Never expose the password
-	Email     string    `json:"email"`
-	FullName  string    `json:"full_name"`
-	UserType  UserType  `json:"user_type"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID            uuid.UUID  `json:"id"`
+	Username      string     `json:"username"`
+	Password      string     `json:"-"` // Never expose the password
+	Email         string     `json:"email"`
+	FullName      string     `json:"full_name"`
+	UserType      UserType   `json:"user_type"`
+	ClinicID      uuid.UUID  `json:"clinic_id"`
+	AuthProvider  string     `json:"auth_provider"`
+	ExternalID    string     `json:"-"`
+	IsActive      bool       `json:"is_active"`
+	DeactivatedAt *time.Time `json:"deactivated_at,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
 }
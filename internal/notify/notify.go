@@ -0,0 +1,135 @@
+// Package notify delivers notifications to people outside of the API, such as compliance officers.
+package notify
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"github.com/yhwbach/makerble/internal/config"
+)
+
+// Message is a plain text notification
+type Message struct {
+	To      []string
+	Subject string
+	Body    string
+}
+
+// Notifier delivers notifications
+type Notifier interface {
+	Notify(ctx context.Context, msg Message) error
+}
+
+// New returns a notifier sending mail through the configured SMTP server, or one that only logs
+// notifications when no server is configured
+func New(cfg config.SMTPConfig) Notifier {
+	if cfg.Host == "" {
+		return LogNotifier{}
+	}
+	return &SMTPNotifier{cfg: cfg}
+}
+
+// LogNotifier logs notifications instead of delivering them. The recipients and body are left out
+// as they may contain sensitive details.
+type LogNotifier struct{}
+
+// Notify logs the number of recipients and subject of the message
+func (LogNotifier) Notify(ctx context.Context, msg Message) error {
+	slog.InfoContext(ctx, "notification not delivered, no SMTP server configured", "recipients", len(msg.To), "subject", msg.Subject)
+	return nil
+}
+
+// SMTPNotifier sends notifications as mail
+type SMTPNotifier struct {
+	cfg config.SMTPConfig
+}
+
+// Notify sends the message, upgrading the connection with STARTTLS when the server supports it
+func (n *SMTPNotifier) Notify(ctx context.Context, msg Message) error {
+	data, err := n.message(msg)
+	if err != nil {
+		return err
+	}
+
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(n.cfg.Host, n.cfg.Port))
+	if err != nil {
+		return fmt.Errorf("failed to connect to mail server: %w", err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	client, err := smtp.NewClient(conn, n.cfg.Host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to connect to mail server: %w", err)
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: n.cfg.Host, MinVersion: tls.VersionTLS12}); err != nil {
+			return fmt.Errorf("failed to start TLS: %w", err)
+		}
+	}
+
+	if n.cfg.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", n.cfg.Username, n.cfg.Password, n.cfg.Host)); err != nil {
+			return fmt.Errorf("failed to authenticate with mail server: %w", err)
+		}
+	}
+
+	if err := client.Mail(n.cfg.From); err != nil {
+		return fmt.Errorf("failed to send notification: %w", err)
+	}
+	for _, to := range msg.To {
+		if err := client.Rcpt(to); err != nil {
+			return fmt.Errorf("failed to send notification to %s: %w", to, err)
+		}
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("failed to send notification: %w", err)
+	}
+	if _, err := w.Write(data); err != nil {
+		return fmt.Errorf("failed to send notification: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("failed to send notification: %w", err)
+	}
+
+	return client.Quit()
+}
+
+// message renders the mail, refusing header values that would inject further headers
+func (n *SMTPNotifier) message(msg Message) ([]byte, error) {
+	if len(msg.To) == 0 {
+		return nil, errors.New("notification has no recipients")
+	}
+
+	headers := append([]string{n.cfg.From, msg.Subject}, msg.To...)
+	for _, value := range headers {
+		if strings.ContainsAny(value, "\r\n") {
+			return nil, errors.New("notification header contains a line break")
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", n.cfg.From)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(msg.To, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", msg.Subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(strings.ReplaceAll(msg.Body, "\r\n", "\n"), "\n", "\r\n"))
+
+	return []byte(b.String()), nil
+}
@@ -0,0 +1,40 @@
+package notify
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yhwbach/makerble/internal/config"
+)
+
+func TestNewWithoutServerLogs(t *testing.T) {
+	assert.IsType(t, LogNotifier{}, New(config.SMTPConfig{}))
+	assert.IsType(t, &SMTPNotifier{}, New(config.SMTPConfig{Host: "mail.example.com", Port: "587"}))
+}
+
+func TestSMTPMessage(t *testing.T) {
+	n := &SMTPNotifier{cfg: config.SMTPConfig{From: "makerble@example.com"}}
+
+	data, err := n.message(Message{
+		To:      []string{"compliance@example.com"},
+		Subject: "Emergency access",
+		Body:    "line one\nline two",
+	})
+	require.NoError(t, err)
+
+	msg := string(data)
+	assert.Contains(t, msg, "To: compliance@example.com\r\n")
+	assert.Contains(t, msg, "Subject: Emergency access\r\n")
+	assert.True(t, strings.HasSuffix(msg, "\r\n\r\nline one\r\nline two"))
+
+	_, err = n.message(Message{
+		To:      []string{"compliance@example.com"},
+		Subject: "Emergency access\r\nBcc: attacker@example.com",
+	})
+	assert.Error(t, err)
+
+	_, err = n.message(Message{Subject: "Emergency access"})
+	assert.Error(t, err)
+}
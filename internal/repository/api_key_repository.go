@@ -0,0 +1,165 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/yhwbach/makerble/internal/models"
+	"github.com/yhwbach/makerble/internal/utils"
+)
+
+type APIKeyRepoStorage struct {
+	db *sql.DB
+}
+
+const apiKeyColumns = `id, user_id, name, prefix, scopes, expires_at, last_used_at, COALESCE(last_used_ip, ''),
+	revoked_at, created_by, created_at`
+
+// Create stores a new API key. Only the hash of the key is persisted.
+func (r *APIKeyRepoStorage) Create(ctx context.Context, apiKey *models.APIKey, key string) error {
+	query := `
+		INSERT INTO api_keys (user_id, name, prefix, key_hash, scopes, expires_at, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, created_at
+	`
+
+	err := r.db.QueryRowContext(ctx, query,
+		apiKey.UserID, apiKey.Name, apiKey.Prefix, utils.HashToken(key), pq.Array(apiKey.Scopes), apiKey.ExpiresAt, apiKey.CreatedBy,
+	).Scan(&apiKey.ID, &apiKey.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create API key: %w", err)
+	}
+
+	return nil
+}
+
+// FindByKey retrieves the API key matching the prefix and secret, returning nil if there is none
+func (r *APIKeyRepoStorage) FindByKey(ctx context.Context, prefix, key string) (*models.APIKey, error) {
+	query := `SELECT ` + apiKeyColumns + ` FROM api_keys WHERE prefix = $1 AND key_hash = $2`
+
+	apiKey, err := scanAPIKey(r.db.QueryRowContext(ctx, query, prefix, utils.HashToken(key)))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get API key: %w", err)
+	}
+
+	return apiKey, nil
+}
+
+// FindByID retrieves an API key by ID, returning nil if it does not exist
+func (r *APIKeyRepoStorage) FindByID(ctx context.Context, id uuid.UUID) (*models.APIKey, error) {
+	query := `SELECT ` + apiKeyColumns + ` FROM api_keys WHERE id = $1`
+
+	apiKey, err := scanAPIKey(r.db.QueryRowContext(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get API key: %w", err)
+	}
+
+	return apiKey, nil
+}
+
+// ListByUser retrieves every API key of a service account, newest first
+func (r *APIKeyRepoStorage) ListByUser(ctx context.Context, userID uuid.UUID) ([]models.APIKey, error) {
+	query := `SELECT ` + apiKeyColumns + ` FROM api_keys WHERE user_id = $1 ORDER BY created_at DESC`
+
+	rows, err := r.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list API keys: %w", err)
+	}
+	defer rows.Close()
+
+	apiKeys := []models.APIKey{}
+	for rows.Next() {
+		apiKey, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list API keys: %w", err)
+		}
+		apiKeys = append(apiKeys, *apiKey)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list API keys: %w", err)
+	}
+
+	return apiKeys, nil
+}
+
+// Touch records the use of an API key. Writes are throttled to once a minute per key.
+func (r *APIKeyRepoStorage) Touch(ctx context.Context, id uuid.UUID, ipAddress string) error {
+	query := `
+		UPDATE api_keys
+		SET last_used_at = NOW(), last_used_ip = $2
+		WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < NOW() - INTERVAL '1 minute')
+	`
+
+	if _, err := r.db.ExecContext(ctx, query, id, ipAddress); err != nil {
+		return fmt.Errorf("failed to touch API key: %w", err)
+	}
+
+	return nil
+}
+
+// ExpireAt shortens the lifetime of an API key so it stops working at the given time
+func (r *APIKeyRepoStorage) ExpireAt(ctx context.Context, id uuid.UUID, expiresAt time.Time) error {
+	query := `
+		UPDATE api_keys SET expires_at = LEAST(COALESCE(expires_at, $2), $2)
+		WHERE id = $1 AND revoked_at IS NULL
+	`
+
+	if _, err := r.db.ExecContext(ctx, query, id, expiresAt); err != nil {
+		return fmt.Errorf("failed to expire API key: %w", err)
+	}
+
+	return nil
+}
+
+// Revoke disables an API key immediately. It returns false if no unrevoked key matched.
+func (r *APIKeyRepoStorage) Revoke(ctx context.Context, id uuid.UUID) (bool, error) {
+	query := `UPDATE api_keys SET revoked_at = NOW() WHERE id = $1 AND revoked_at IS NULL`
+
+	result, err := r.db.ExecContext(ctx, query, id)
+	if err != nil {
+		return false, fmt.Errorf("failed to revoke API key: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to revoke API key: %w", err)
+	}
+
+	return affected > 0, nil
+}
+
+func scanAPIKey(row rowScanner) (*models.APIKey, error) {
+	var apiKey models.APIKey
+	var expiresAt, lastUsedAt, revokedAt sql.NullTime
+
+	if err := row.Scan(
+		&apiKey.ID, &apiKey.UserID, &apiKey.Name, &apiKey.Prefix, pq.Array(&apiKey.Scopes), &expiresAt, &lastUsedAt,
+		&apiKey.LastUsedIP, &revokedAt, &apiKey.CreatedBy, &apiKey.CreatedAt,
+	); err != nil {
+		return nil, err
+	}
+
+	if expiresAt.Valid {
+		apiKey.ExpiresAt = &expiresAt.Time
+	}
+	if lastUsedAt.Valid {
+		apiKey.LastUsedAt = &lastUsedAt.Time
+	}
+	if revokedAt.Valid {
+		apiKey.RevokedAt = &revokedAt.Time
+	}
+
+	return &apiKey, nil
+}
@@ -0,0 +1,364 @@
+package repository
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/yhwbach/makerble/internal/models"
+	"github.com/yhwbach/makerble/internal/schemas"
+)
+
+type AuditRepoStorage struct {
+	db *sql.DB
+}
+
+// defaultAuditLimit and maxAuditLimit bound the number of events a query returns
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+const auditColumns = `id, occurred_at, severity, action, actor_id, patient_id, clinic_id, request_id, ip_address,
+	fields, details::text, prev_hash, hash`
+
+// Record appends an event to the audit log
+func (r *AuditRepoStorage) Record(ctx context.Context, event *models.AuditEvent) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to record audit event: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := insertAuditEvent(ctx, tx, event); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to record audit event: %w", err)
+	}
+
+	return nil
+}
+
+// List retrieves the audit events of the clinic of the context matching the query, most recent first
+func (r *AuditRepoStorage) List(ctx context.Context, filter schemas.AuditQuery) ([]models.AuditEvent, error) {
+	query := `SELECT ` + auditColumns + ` FROM audit_events
+		WHERE ($1::uuid IS NULL OR patient_id = $1 OR details -> 'patient_ids' ? $1::text)
+			AND ($2::uuid IS NULL OR actor_id = $2)
+			AND ($3 = '' OR action = $3)
+			AND ($4::timestamptz IS NULL OR occurred_at >= $4)
+			AND ($5::timestamptz IS NULL OR occurred_at < $5)
+			AND ($6::uuid IS NULL OR clinic_id = $6)
+		ORDER BY id DESC
+		LIMIT $7`
+
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = defaultAuditLimit
+	}
+	limit = min(limit, maxAuditLimit)
+
+	return r.queryEvents(ctx, query,
+		filter.PatientID, filter.ActorID, filter.Action, filter.Since, filter.Until, clinicArg(ctx), limit,
+	)
+}
+
+// ListByPatient retrieves every audit event of the clinic of the context about the patient, including
+// the lists that returned it, oldest first
+func (r *AuditRepoStorage) ListByPatient(ctx context.Context, patientID uuid.UUID) ([]models.AuditEvent, error) {
+	query := `SELECT ` + auditColumns + ` FROM audit_events
+		WHERE (patient_id = $1 OR details -> 'patient_ids' ? $1::text)
+			AND ($2::uuid IS NULL OR clinic_id = $2)
+		ORDER BY id`
+
+	return r.queryEvents(ctx, query, patientID, clinicArg(ctx))
+}
+
+func (r *AuditRepoStorage) queryEvents(ctx context.Context, query string, args ...interface{}) ([]models.AuditEvent, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list audit events: %w", err)
+	}
+	defer rows.Close()
+
+	events := []models.AuditEvent{}
+	for rows.Next() {
+		row, err := scanAuditRow(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list audit events: %w", err)
+		}
+
+		event, err := row.event()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list audit events: %w", err)
+		}
+		events = append(events, *event)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list audit events: %w", err)
+	}
+
+	return events, nil
+}
+
+// Verify walks the whole audit log in order and recomputes the hash chain. The hashes are computed
+// by the database when events are recorded, and recomputed here independently of it. A log that
+// was purged starts from the hash of the last event purged.
+func (r *AuditRepoStorage) Verify(ctx context.Context) (*models.AuditVerification, error) {
+	verification := &models.AuditVerification{}
+
+	var purgedThrough int64
+	err := r.db.QueryRowContext(ctx, `SELECT purged_through, head_hash FROM audit_checkpoints ORDER BY id DESC LIMIT 1`).
+		Scan(&purgedThrough, &verification.HeadHash)
+	switch {
+	case err == nil:
+		verification.PurgedThrough = &purgedThrough
+	case err != sql.ErrNoRows:
+		return nil, fmt.Errorf("failed to verify audit log: %w", err)
+	}
+
+	rows, err := r.db.QueryContext(ctx, `SELECT `+auditColumns+` FROM audit_events ORDER BY id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify audit log: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		row, err := scanAuditRow(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to verify audit log: %w", err)
+		}
+
+		switch {
+		case row.prevHash != verification.HeadHash:
+			verification.BrokenAt = &row.id
+			verification.Reason = "previous hash does not match the preceding event"
+		case row.hash != row.computeHash():
+			verification.BrokenAt = &row.id
+			verification.Reason = "hash does not match the event"
+		}
+		if verification.BrokenAt != nil {
+			return verification, nil
+		}
+
+		verification.Events++
+		verification.HeadHash = row.hash
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to verify audit log: %w", err)
+	}
+
+	return verification, nil
+}
+
+// Purge deletes the events recorded before the given time, the oldest part of the chain, and
+// keeps the hash of the last one deleted as a checkpoint for verification. The purge itself is
+// recorded in the log. With dryRun it only counts the events.
+func (r *AuditRepoStorage) Purge(ctx context.Context, before time.Time, dryRun bool) (int64, error) {
+	if dryRun {
+		var count int64
+		err := r.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM audit_events WHERE occurred_at < $1`, before).Scan(&count)
+		if err != nil {
+			return 0, fmt.Errorf("failed to count audit events: %w", err)
+		}
+		return count, nil
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge audit events: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Events are chained under this lock, so none is recorded while the chain is cut
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext('audit_events'))`); err != nil {
+		return 0, fmt.Errorf("failed to purge audit events: %w", err)
+	}
+
+	// Ids follow the order events were recorded in, so everything up to the last event before the
+	// cutoff is the start of the chain
+	var through int64
+	var headHash string
+	err = tx.QueryRowContext(ctx,
+		`SELECT id, hash FROM audit_events WHERE occurred_at < $1 ORDER BY id DESC LIMIT 1`, before,
+	).Scan(&through, &headHash)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge audit events: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, `SELECT set_config('app.audit_purge', 'on', true)`); err != nil {
+		return 0, fmt.Errorf("failed to purge audit events: %w", err)
+	}
+	result, err := tx.ExecContext(ctx, `DELETE FROM audit_events WHERE id <= $1`, through)
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge audit events: %w", err)
+	}
+	count, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge audit events: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `SELECT set_config('app.audit_purge', '', true)`); err != nil {
+		return 0, fmt.Errorf("failed to purge audit events: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO audit_checkpoints (purged_through, head_hash, events_purged) VALUES ($1, $2, $3)`,
+		through, headHash, count,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to record audit checkpoint: %w", err)
+	}
+
+	err = insertAuditEvent(ctx, tx, &models.AuditEvent{
+		Severity: models.AuditSeverityHigh,
+		Action:   models.AuditActionAuditPurge,
+		Details: map[string]interface{}{
+			"purged_through": through,
+			"head_hash":      headHash,
+			"count":          count,
+			"before":         before,
+		},
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to purge audit events: %w", err)
+	}
+
+	return count, nil
+}
+
+// insertAuditEvent records an audit event within a transaction, so that the event is only
+// recorded when the audited change is committed. The database chains the event to the log.
+func insertAuditEvent(ctx context.Context, tx *sql.Tx, event *models.AuditEvent) error {
+	query := `
+		INSERT INTO audit_events (severity, action, actor_id, patient_id, clinic_id, request_id, ip_address, fields, details)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		RETURNING id, occurred_at, prev_hash, hash
+	`
+
+	var details []byte
+	if len(event.Details) > 0 {
+		var err error
+		if details, err = json.Marshal(event.Details); err != nil {
+			return fmt.Errorf("failed to encode audit event details: %w", err)
+		}
+	}
+
+	if event.ClinicID == nil {
+		event.ClinicID = clinicArg(ctx)
+	}
+	if event.Fields == nil {
+		event.Fields = []string{}
+	}
+
+	err := tx.QueryRowContext(ctx, query,
+		event.Severity, event.Action, event.ActorID, event.PatientID, event.ClinicID,
+		event.RequestID, event.IPAddress, pq.Array(event.Fields), details,
+	).Scan(&event.ID, &event.OccurredAt, &event.PrevHash, &event.Hash)
+	if err != nil {
+		return fmt.Errorf("failed to record audit event: %w", err)
+	}
+
+	return nil
+}
+
+// auditRow is an audit event as stored, with the details kept in the text form they are hashed in
+type auditRow struct {
+	id         int64
+	occurredAt time.Time
+	severity   string
+	action     string
+	actorID    uuid.NullUUID
+	patientID  uuid.NullUUID
+	clinicID   uuid.NullUUID
+	requestID  string
+	ipAddress  string
+	fields     []string
+	details    sql.NullString
+	prevHash   string
+	hash       string
+}
+
+func scanAuditRow(row rowScanner) (*auditRow, error) {
+	var a auditRow
+	if err := row.Scan(
+		&a.id, &a.occurredAt, &a.severity, &a.action, &a.actorID, &a.patientID, &a.clinicID,
+		&a.requestID, &a.ipAddress, pq.Array(&a.fields), &a.details, &a.prevHash, &a.hash,
+	); err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// computeHash hashes the event like the audit_event_hash database function
+func (a *auditRow) computeHash() string {
+	nullable := func(id uuid.NullUUID) string {
+		if !id.Valid {
+			return ""
+		}
+		return id.UUID.String()
+	}
+
+	canonical := strings.Join([]string{
+		a.prevHash,
+		strconv.FormatInt(a.id, 10),
+		a.occurredAt.UTC().Format("2006-01-02T15:04:05.000000Z"),
+		a.severity,
+		a.action,
+		nullable(a.actorID),
+		nullable(a.patientID),
+		nullable(a.clinicID),
+		a.requestID,
+		a.ipAddress,
+		strings.Join(a.fields, ","),
+		a.details.String,
+	}, "\n")
+
+	sum := sha256.Sum256([]byte(canonical))
+	return hex.EncodeToString(sum[:])
+}
+
+func (a *auditRow) event() (*models.AuditEvent, error) {
+	event := &models.AuditEvent{
+		ID:         a.id,
+		OccurredAt: a.occurredAt,
+		Severity:   models.AuditSeverity(a.severity),
+		Action:     a.action,
+		RequestID:  a.requestID,
+		IPAddress:  a.ipAddress,
+		Fields:     a.fields,
+		PrevHash:   a.prevHash,
+		Hash:       a.hash,
+	}
+	if a.actorID.Valid {
+		event.ActorID = &a.actorID.UUID
+	}
+	if a.patientID.Valid {
+		event.PatientID = &a.patientID.UUID
+	}
+	if a.clinicID.Valid {
+		event.ClinicID = &a.clinicID.UUID
+	}
+	if a.details.Valid {
+		if err := json.Unmarshal([]byte(a.details.String), &event.Details); err != nil {
+			return nil, fmt.Errorf("failed to decode audit event details: %w", err)
+		}
+	}
+	return event, nil
+}
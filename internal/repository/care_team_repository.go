@@ -0,0 +1,121 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/yhwbach/makerble/internal/models"
+)
+
+type CareTeamRepoStorage struct {
+	db *sql.DB
+}
+
+const careTeamColumns = `id, patient_id, user_id, role, valid_from, valid_until, assigned_by, created_at`
+
+// Create adds a user to the care team of a patient. A zero ValidFrom starts the assignment now.
+func (r *CareTeamRepoStorage) Create(ctx context.Context, assignment *models.CareTeamAssignment) error {
+	query := `
+		INSERT INTO care_team_assignments (patient_id, user_id, role, valid_from, valid_until, assigned_by)
+		VALUES ($1, $2, $3, COALESCE($4, NOW()), $5, $6)
+		RETURNING id, valid_from, created_at
+	`
+
+	var validFrom *time.Time
+	if !assignment.ValidFrom.IsZero() {
+		validFrom = &assignment.ValidFrom
+	}
+
+	err := r.db.QueryRowContext(ctx, query,
+		assignment.PatientID, assignment.UserID, assignment.Role, validFrom, assignment.ValidUntil, assignment.AssignedBy,
+	).Scan(&assignment.ID, &assignment.ValidFrom, &assignment.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create care team assignment: %w", err)
+	}
+
+	return nil
+}
+
+// FindByID retrieves a care team assignment by ID, returning nil if it does not exist
+func (r *CareTeamRepoStorage) FindByID(ctx context.Context, id uuid.UUID) (*models.CareTeamAssignment, error) {
+	query := `SELECT ` + careTeamColumns + ` FROM care_team_assignments WHERE id = $1`
+
+	assignment, err := scanCareTeamAssignment(r.db.QueryRowContext(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get care team assignment: %w", err)
+	}
+
+	return assignment, nil
+}
+
+// ListByPatient retrieves every care team assignment of a patient, including ended ones
+func (r *CareTeamRepoStorage) ListByPatient(ctx context.Context, patientID uuid.UUID) ([]models.CareTeamAssignment, error) {
+	query := `SELECT ` + careTeamColumns + ` FROM care_team_assignments WHERE patient_id = $1 ORDER BY valid_from DESC`
+
+	rows, err := r.db.QueryContext(ctx, query, patientID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list care team: %w", err)
+	}
+	defer rows.Close()
+
+	assignments := []models.CareTeamAssignment{}
+	for rows.Next() {
+		assignment, err := scanCareTeamAssignment(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list care team: %w", err)
+		}
+		assignments = append(assignments, *assignment)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list care team: %w", err)
+	}
+
+	return assignments, nil
+}
+
+// End ends an assignment that has not ended yet, keeping it as history. It returns false if
+// no such assignment matched.
+func (r *CareTeamRepoStorage) End(ctx context.Context, id uuid.UUID) (bool, error) {
+	query := `
+		UPDATE care_team_assignments SET valid_until = NOW()
+		WHERE id = $1 AND (valid_until IS NULL OR valid_until > NOW())
+	`
+
+	result, err := r.db.ExecContext(ctx, query, id)
+	if err != nil {
+		return false, fmt.Errorf("failed to end care team assignment: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to end care team assignment: %w", err)
+	}
+
+	return affected > 0, nil
+}
+
+func scanCareTeamAssignment(row rowScanner) (*models.CareTeamAssignment, error) {
+	var assignment models.CareTeamAssignment
+	var validUntil sql.NullTime
+
+	if err := row.Scan(
+		&assignment.ID, &assignment.PatientID, &assignment.UserID, &assignment.Role, &assignment.ValidFrom,
+		&validUntil, &assignment.AssignedBy, &assignment.CreatedAt,
+	); err != nil {
+		return nil, err
+	}
+
+	if validUntil.Valid {
+		assignment.ValidUntil = &validUntil.Time
+	}
+
+	return &assignment, nil
+}
@@ -0,0 +1,88 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/yhwbach/makerble/internal/models"
+)
+
+type ClinicRepoStorage struct {
+	db *sql.DB
+}
+
+// Create inserts a new clinic
+func (r *ClinicRepoStorage) Create(ctx context.Context, clinic *models.Clinic) error {
+	query := `INSERT INTO clinics (name) VALUES ($1) RETURNING id, created_at`
+
+	if err := r.db.QueryRowContext(ctx, query, clinic.Name).Scan(&clinic.ID, &clinic.CreatedAt); err != nil {
+		return fmt.Errorf("failed to create clinic: %w", err)
+	}
+
+	return nil
+}
+
+// FindAll retrieves every clinic, sorted by name
+func (r *ClinicRepoStorage) FindAll(ctx context.Context) ([]models.Clinic, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT id, name, created_at FROM clinics ORDER BY name`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list clinics: %w", err)
+	}
+	defer rows.Close()
+
+	clinics := []models.Clinic{}
+	for rows.Next() {
+		clinic, err := scanClinic(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list clinics: %w", err)
+		}
+		clinics = append(clinics, *clinic)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list clinics: %w", err)
+	}
+
+	return clinics, nil
+}
+
+// FindByID retrieves a clinic by ID, returning nil if it does not exist
+func (r *ClinicRepoStorage) FindByID(ctx context.Context, id uuid.UUID) (*models.Clinic, error) {
+	query := `SELECT id, name, created_at FROM clinics WHERE id = $1`
+
+	clinic, err := scanClinic(r.db.QueryRowContext(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get clinic: %w", err)
+	}
+
+	return clinic, nil
+}
+
+// FindByName retrieves a clinic by name, returning nil if it does not exist
+func (r *ClinicRepoStorage) FindByName(ctx context.Context, name string) (*models.Clinic, error) {
+	query := `SELECT id, name, created_at FROM clinics WHERE name = $1`
+
+	clinic, err := scanClinic(r.db.QueryRowContext(ctx, query, name))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get clinic: %w", err)
+	}
+
+	return clinic, nil
+}
+
+func scanClinic(row rowScanner) (*models.Clinic, error) {
+	var clinic models.Clinic
+	if err := row.Scan(&clinic.ID, &clinic.Name, &clinic.CreatedAt); err != nil {
+		return nil, err
+	}
+	return &clinic, nil
+}
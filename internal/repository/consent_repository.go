@@ -0,0 +1,117 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/yhwbach/makerble/internal/models"
+)
+
+type ConsentRepoStorage struct {
+	db *sql.DB
+}
+
+const consentColumns = `id, patient_id, consent_type, scope, granted_at, revoked_at, document_ref, recorded_by, created_at`
+
+// Create records a patient's consent. A zero GrantedAt records the consent as given now.
+func (r *ConsentRepoStorage) Create(ctx context.Context, consent *models.Consent) error {
+	query := `
+		INSERT INTO patient_consents (patient_id, consent_type, scope, granted_at, document_ref, recorded_by)
+		VALUES ($1, $2, $3, COALESCE($4, NOW()), $5, $6)
+		RETURNING id, granted_at, created_at
+	`
+
+	var grantedAt *time.Time
+	if !consent.GrantedAt.IsZero() {
+		grantedAt = &consent.GrantedAt
+	}
+
+	err := r.db.QueryRowContext(ctx, query,
+		consent.PatientID, consent.Type, consent.Scope, grantedAt, consent.DocumentRef, consent.RecordedBy,
+	).Scan(&consent.ID, &consent.GrantedAt, &consent.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to record consent: %w", err)
+	}
+
+	return nil
+}
+
+// FindByID retrieves a consent by ID, returning nil if it does not exist
+func (r *ConsentRepoStorage) FindByID(ctx context.Context, id uuid.UUID) (*models.Consent, error) {
+	query := `SELECT ` + consentColumns + ` FROM patient_consents WHERE id = $1`
+
+	consent, err := scanConsent(r.db.QueryRowContext(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get consent: %w", err)
+	}
+
+	return consent, nil
+}
+
+// ListByPatient retrieves every consent of a patient, including revoked ones
+func (r *ConsentRepoStorage) ListByPatient(ctx context.Context, patientID uuid.UUID) ([]models.Consent, error) {
+	query := `SELECT ` + consentColumns + ` FROM patient_consents WHERE patient_id = $1 ORDER BY granted_at DESC`
+
+	rows, err := r.db.QueryContext(ctx, query, patientID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list consents: %w", err)
+	}
+	defer rows.Close()
+
+	consents := []models.Consent{}
+	for rows.Next() {
+		consent, err := scanConsent(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list consents: %w", err)
+		}
+		consents = append(consents, *consent)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list consents: %w", err)
+	}
+
+	return consents, nil
+}
+
+// Revoke revokes a consent that has not been revoked yet. It returns false if no such consent matched.
+func (r *ConsentRepoStorage) Revoke(ctx context.Context, id uuid.UUID) (bool, error) {
+	query := `UPDATE patient_consents SET revoked_at = NOW() WHERE id = $1 AND revoked_at IS NULL`
+
+	result, err := r.db.ExecContext(ctx, query, id)
+	if err != nil {
+		return false, fmt.Errorf("failed to revoke consent: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to revoke consent: %w", err)
+	}
+
+	return affected > 0, nil
+}
+
+func scanConsent(row rowScanner) (*models.Consent, error) {
+	var consent models.Consent
+	var revokedAt sql.NullTime
+
+	if err := row.Scan(
+		&consent.ID, &consent.PatientID, &consent.Type, &consent.Scope, &consent.GrantedAt, &revokedAt,
+		&consent.DocumentRef, &consent.RecordedBy, &consent.CreatedAt,
+	); err != nil {
+		return nil, err
+	}
+
+	if revokedAt.Valid {
+		consent.RevokedAt = &revokedAt.Time
+	}
+
+	return &consent, nil
+}
@@ -0,0 +1,96 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/yhwbach/makerble/internal/models"
+	"github.com/yhwbach/makerble/internal/schemas"
+)
+
+type EmergencyAccessRepoStorage struct {
+	db *sql.DB
+}
+
+// Create grants emergency access and records a high-severity audit event with it. Either both
+// are stored or neither is.
+func (r *EmergencyAccessRepoStorage) Create(ctx context.Context, grant *models.EmergencyAccessGrant) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to grant emergency access: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO emergency_access_grants (patient_id, user_id, justification, expires_at)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, granted_at
+	`
+	err = tx.QueryRowContext(ctx, query,
+		grant.PatientID, grant.UserID, grant.Justification, grant.ExpiresAt,
+	).Scan(&grant.ID, &grant.GrantedAt)
+	if err != nil {
+		return fmt.Errorf("failed to grant emergency access: %w", err)
+	}
+
+	err = insertAuditEvent(ctx, tx, &models.AuditEvent{
+		Severity:  models.AuditSeverityHigh,
+		Action:    models.AuditActionEmergencyAccess,
+		ActorID:   &grant.UserID,
+		PatientID: &grant.PatientID,
+		Details: map[string]interface{}{
+			"grant_id":      grant.ID,
+			"justification": grant.Justification,
+			"expires_at":    grant.ExpiresAt,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to grant emergency access: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to grant emergency access: %w", err)
+	}
+
+	return nil
+}
+
+// List retrieves the emergency access grants matching the query, most recent first
+func (r *EmergencyAccessRepoStorage) List(ctx context.Context, filter schemas.EmergencyAccessQuery) ([]models.EmergencyAccessGrant, error) {
+	query := `
+		SELECT g.id, g.patient_id, g.user_id, u.username, g.justification, g.granted_at, g.expires_at
+		FROM emergency_access_grants g
+		JOIN users u ON u.id = g.user_id
+		WHERE ($1::timestamptz IS NULL OR g.granted_at >= $1)
+			AND ($2::timestamptz IS NULL OR g.granted_at < $2)
+			AND ($3::uuid IS NULL OR g.patient_id = $3)
+			AND ($4::uuid IS NULL OR g.user_id = $4)
+			AND ($5::uuid IS NULL OR u.clinic_id = $5)
+		ORDER BY g.granted_at DESC
+	`
+
+	rows, err := r.db.QueryContext(ctx, query, filter.Since, filter.Until, filter.PatientID, filter.UserID, clinicArg(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list emergency access grants: %w", err)
+	}
+	defer rows.Close()
+
+	grants := []models.EmergencyAccessGrant{}
+	for rows.Next() {
+		var grant models.EmergencyAccessGrant
+		if err := rows.Scan(
+			&grant.ID, &grant.PatientID, &grant.UserID, &grant.Username, &grant.Justification,
+			&grant.GrantedAt, &grant.ExpiresAt,
+		); err != nil {
+			return nil, fmt.Errorf("failed to list emergency access grants: %w", err)
+		}
+		grants = append(grants, grant)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list emergency access grants: %w", err)
+	}
+
+	return grants, nil
+}
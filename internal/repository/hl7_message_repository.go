@@ -0,0 +1,225 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/yhwbach/makerble/internal/encryption"
+	"github.com/yhwbach/makerble/internal/models"
+)
+
+// HL7MessageRepoStorage keeps the HL7 messages received, encrypting the raw messages with a data
+// key per message
+type HL7MessageRepoStorage struct {
+	db   *sql.DB
+	keys *encryption.KeyRing
+}
+
+const hl7MessageColumns = `id, clinic_id, sending_application, control_id, message_type, data_key_id, data_key,
+	encrypted_raw, status, error, patient_id, received_at, processed_at`
+
+// Create stores a message as received, before it is processed
+func (h *HL7MessageRepoStorage) Create(ctx context.Context, message *models.HL7Message) error {
+	message.ID = uuid.New()
+	message.ClinicID = clinicForInsert(ctx, message.ClinicID)
+
+	dataKey, err := h.keys.NewDataKey()
+	if err != nil {
+		return err
+	}
+	encryptedRaw, err := dataKey.Encrypt(message.Raw, hl7EncryptionContext(message.ID))
+	if err != nil {
+		return fmt.Errorf("failed to encrypt HL7 message: %w", err)
+	}
+
+	query := `
+		INSERT INTO hl7_messages (id, clinic_id, sending_application, control_id, message_type,
+			data_key_id, data_key, encrypted_raw, status, error, patient_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		RETURNING received_at
+	`
+	err = h.db.QueryRowContext(ctx, query,
+		message.ID, message.ClinicID, message.SendingApplication, message.ControlID, message.MessageType,
+		dataKey.KeyID, dataKey.Wrapped, encryptedRaw, message.Status, message.Error, message.PatientID,
+	).Scan(&message.ReceivedAt)
+	if err != nil {
+		return fmt.Errorf("failed to store HL7 message: %w", err)
+	}
+
+	return nil
+}
+
+// Finish records the outcome of processing a message
+func (h *HL7MessageRepoStorage) Finish(ctx context.Context, message *models.HL7Message) error {
+	query := `
+		UPDATE hl7_messages SET status = $1, error = $2, patient_id = $3, processed_at = NOW()
+		WHERE id = $4
+		RETURNING processed_at
+	`
+	err := h.db.QueryRowContext(ctx, query, message.Status, message.Error, message.PatientID, message.ID).
+		Scan(&message.ProcessedAt)
+	if err != nil {
+		return fmt.Errorf("failed to update HL7 message: %w", err)
+	}
+
+	return nil
+}
+
+// FindByID retrieves a message with its raw content, returning nil if it does not exist in the
+// clinic of the context
+func (h *HL7MessageRepoStorage) FindByID(ctx context.Context, id uuid.UUID) (*models.HL7Message, error) {
+	query := `SELECT ` + hl7MessageColumns + ` FROM hl7_messages
+		WHERE id = $1 AND ($2::uuid IS NULL OR clinic_id = $2)`
+
+	message, err := h.scanMessage(h.db.QueryRowContext(ctx, query, id, clinicArg(ctx)))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find HL7 message: %w", err)
+	}
+
+	return message, nil
+}
+
+// FindByControlID retrieves the latest message the application sent with the control ID, to
+// recognize messages sent again. It returns nil if there is none.
+func (h *HL7MessageRepoStorage) FindByControlID(ctx context.Context, sendingApplication, controlID string) (*models.HL7Message, error) {
+	query := `SELECT ` + hl7MessageColumns + ` FROM hl7_messages
+		WHERE sending_application = $1 AND control_id = $2 AND ($3::uuid IS NULL OR clinic_id = $3)
+		ORDER BY received_at DESC LIMIT 1`
+
+	message, err := h.scanMessage(h.db.QueryRowContext(ctx, query, sendingApplication, controlID, clinicArg(ctx)))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find HL7 message: %w", err)
+	}
+
+	return message, nil
+}
+
+// List retrieves the messages with the status, or all messages if it is empty, oldest first
+func (h *HL7MessageRepoStorage) List(ctx context.Context, status string, limit int) ([]models.HL7Message, error) {
+	query := `SELECT ` + hl7MessageColumns + ` FROM hl7_messages
+		WHERE ($1 = '' OR status = $1) AND ($2::uuid IS NULL OR clinic_id = $2)
+		ORDER BY received_at LIMIT $3`
+
+	rows, err := h.db.QueryContext(ctx, query, status, clinicArg(ctx), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list HL7 messages: %w", err)
+	}
+	defer rows.Close()
+
+	messages := []models.HL7Message{}
+	for rows.Next() {
+		message, err := h.scanMessage(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list HL7 messages: %w", err)
+		}
+		messages = append(messages, *message)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list HL7 messages: %w", err)
+	}
+
+	return messages, nil
+}
+
+// RotateKeys re-wraps the data keys of up to batchSize messages that were wrapped by a previous
+// master key with the current one. It returns how many messages it rotated.
+func (h *HL7MessageRepoStorage) RotateKeys(ctx context.Context, batchSize int) (int, error) {
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to rotate HL7 message keys: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `SELECT id, data_key_id, data_key FROM hl7_messages
+		WHERE data_key_id <> $1
+		ORDER BY id LIMIT $2 FOR UPDATE SKIP LOCKED`
+
+	rows, err := tx.QueryContext(ctx, query, h.keys.CurrentKeyID(), batchSize)
+	if err != nil {
+		return 0, fmt.Errorf("failed to rotate HL7 message keys: %w", err)
+	}
+
+	type stored struct {
+		id        uuid.UUID
+		dataKeyID string
+		dataKey   []byte
+	}
+	var messages []stored
+	for rows.Next() {
+		var s stored
+		if err := rows.Scan(&s.id, &s.dataKeyID, &s.dataKey); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to rotate HL7 message keys: %w", err)
+		}
+		messages = append(messages, s)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to rotate HL7 message keys: %w", err)
+	}
+
+	for _, s := range messages {
+		dataKey, err := h.keys.Unwrap(s.dataKeyID, s.dataKey)
+		if err != nil {
+			return 0, fmt.Errorf("failed to rotate key of HL7 message %s: %w", s.id, err)
+		}
+
+		rewrapped, err := h.keys.Rewrap(dataKey)
+		if err != nil {
+			return 0, err
+		}
+
+		if _, err := tx.ExecContext(ctx,
+			`UPDATE hl7_messages SET data_key_id = $1, data_key = $2 WHERE id = $3`,
+			rewrapped.KeyID, rewrapped.Wrapped, s.id,
+		); err != nil {
+			return 0, fmt.Errorf("failed to rotate key of HL7 message %s: %w", s.id, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to rotate HL7 message keys: %w", err)
+	}
+
+	return len(messages), nil
+}
+
+// scanMessage scans the message columns and decrypts the raw message. Scan errors are returned as is.
+func (h *HL7MessageRepoStorage) scanMessage(row rowScanner) (*models.HL7Message, error) {
+	var message models.HL7Message
+	var dataKeyID string
+	var wrapped, encryptedRaw []byte
+	err := row.Scan(
+		&message.ID, &message.ClinicID, &message.SendingApplication, &message.ControlID, &message.MessageType,
+		&dataKeyID, &wrapped, &encryptedRaw, &message.Status, &message.Error, &message.PatientID,
+		&message.ReceivedAt, &message.ProcessedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	dataKey, err := h.keys.Unwrap(dataKeyID, wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt HL7 message %s: %w", message.ID, err)
+	}
+	message.Raw, err = dataKey.Decrypt(encryptedRaw, hl7EncryptionContext(message.ID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt HL7 message %s: %w", message.ID, err)
+	}
+
+	return &message, nil
+}
+
+// hl7EncryptionContext binds an encrypted message to its row
+func hl7EncryptionContext(id uuid.UUID) string {
+	return "hl7_messages/" + id.String() + "/raw"
+}
@@ -0,0 +1,149 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/yhwbach/makerble/internal/models"
+	"github.com/yhwbach/makerble/internal/utils"
+)
+
+type InvitationRepoStorage struct {
+	db tenantDB
+}
+
+const invitationColumns = `id, email, user_type, clinic_id, invited_by, expires_at, accepted_at, accepted_by, revoked_at, created_at`
+
+// Create stores a new invitation. Only the hash of the token is persisted.
+func (r *InvitationRepoStorage) Create(ctx context.Context, invitation *models.Invitation, token string) error {
+	query := `
+		INSERT INTO invitations (token_hash, email, user_type, clinic_id, invited_by, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, clinic_id, created_at
+	`
+
+	err := r.db.QueryRowContext(ctx, query,
+		utils.HashToken(token), invitation.Email, invitation.UserType, clinicForInsert(ctx, invitation.ClinicID),
+		invitation.InvitedBy, invitation.ExpiresAt,
+	).Scan(&invitation.ID, &invitation.ClinicID, &invitation.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create invitation: %w", err)
+	}
+
+	return nil
+}
+
+// FindAll retrieves all invitations of the clinic of the context, newest first
+func (r *InvitationRepoStorage) FindAll(ctx context.Context) ([]models.Invitation, error) {
+	query := `SELECT ` + invitationColumns + ` FROM invitations
+		WHERE ($1::uuid IS NULL OR clinic_id = $1) ORDER BY created_at DESC`
+
+	rows, err := r.db.QueryContext(ctx, query, clinicArg(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list invitations: %w", err)
+	}
+	defer rows.Close()
+
+	invitations := []models.Invitation{}
+	for rows.Next() {
+		invitation, err := scanInvitation(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list invitations: %w", err)
+		}
+		invitations = append(invitations, *invitation)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list invitations: %w", err)
+	}
+
+	return invitations, nil
+}
+
+// Claim atomically marks a pending invitation as accepted so it cannot be used twice.
+// It returns nil if the token does not match a pending, unexpired invitation.
+func (r *InvitationRepoStorage) Claim(ctx context.Context, token string) (*models.Invitation, error) {
+	query := `
+		UPDATE invitations SET accepted_at = NOW()
+		WHERE token_hash = $1 AND accepted_at IS NULL AND revoked_at IS NULL AND expires_at > NOW()
+		RETURNING ` + invitationColumns
+
+	invitation, err := scanInvitation(r.db.QueryRowContext(ctx, query, utils.HashToken(token)))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to claim invitation: %w", err)
+	}
+
+	return invitation, nil
+}
+
+// Release returns a claimed invitation to pending when registration could not be completed
+func (r *InvitationRepoStorage) Release(ctx context.Context, id uuid.UUID) error {
+	query := `UPDATE invitations SET accepted_at = NULL WHERE id = $1 AND accepted_by IS NULL`
+
+	if _, err := r.db.ExecContext(ctx, query, id); err != nil {
+		return fmt.Errorf("failed to release invitation: %w", err)
+	}
+
+	return nil
+}
+
+// MarkAccepted records the user that registered with a claimed invitation
+func (r *InvitationRepoStorage) MarkAccepted(ctx context.Context, id uuid.UUID, userID uuid.UUID) error {
+	query := `UPDATE invitations SET accepted_by = $2 WHERE id = $1`
+
+	if _, err := r.db.ExecContext(ctx, query, id, userID); err != nil {
+		return fmt.Errorf("failed to accept invitation: %w", err)
+	}
+
+	return nil
+}
+
+// Revoke cancels a pending invitation of the clinic of the context. It returns false if no
+// pending invitation matched.
+func (r *InvitationRepoStorage) Revoke(ctx context.Context, id uuid.UUID) (bool, error) {
+	query := `UPDATE invitations SET revoked_at = NOW()
+		WHERE id = $1 AND accepted_at IS NULL AND revoked_at IS NULL AND ($2::uuid IS NULL OR clinic_id = $2)`
+
+	result, err := r.db.ExecContext(ctx, query, id, clinicArg(ctx))
+	if err != nil {
+		return false, fmt.Errorf("failed to revoke invitation: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to revoke invitation: %w", err)
+	}
+
+	return affected > 0, nil
+}
+
+func scanInvitation(row rowScanner) (*models.Invitation, error) {
+	var invitation models.Invitation
+	var acceptedAt, revokedAt sql.NullTime
+	var acceptedBy uuid.NullUUID
+
+	if err := row.Scan(
+		&invitation.ID, &invitation.Email, &invitation.UserType, &invitation.ClinicID, &invitation.InvitedBy, &invitation.ExpiresAt,
+		&acceptedAt, &acceptedBy, &revokedAt, &invitation.CreatedAt,
+	); err != nil {
+		return nil, err
+	}
+
+	if acceptedAt.Valid {
+		invitation.AcceptedAt = &acceptedAt.Time
+	}
+	if acceptedBy.Valid {
+		invitation.AcceptedBy = &acceptedBy.UUID
+	}
+	if revokedAt.Valid {
+		invitation.RevokedAt = &revokedAt.Time
+	}
+
+	return &invitation, nil
+}
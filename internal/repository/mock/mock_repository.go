@@ -3,6 +3,8 @@ package mock
 import (
 	"context"
 	"fmt"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 
@@ -14,8 +16,12 @@ import (
 
 
 type MockPatientRepo struct {
-	patients map[uuid.UUID]*models.Patient
-	mu       sync.RWMutex
+	patients    map[uuid.UUID]*models.Patient
+	identifiers map[clinicIdentifier]uuid.UUID
+	careTeams   *MockCareTeamRepo
+	consents  *MockConsentRepo
+	audit     *MockAuditRepo
+	mu        sync.RWMutex
 }
 
 type MockUserRepo struct {
@@ -28,20 +34,173 @@ type MockTokenRepo struct {
 	mu     sync.RWMutex
 }
 
+type MockSessionRepo struct {
+	sessions map[uuid.UUID]*models.Session
+	mu       sync.RWMutex
+}
+
+type MockInvitationRepo struct {
+	invitations map[string]*models.Invitation
+	mu          sync.RWMutex
+}
+
+type MockAPIKeyRepo struct {
+	apiKeys map[string]*models.APIKey
+	mu      sync.RWMutex
+}
+
+type MockRoleRepo struct {
+	roles map[models.UserType]*models.Role
+	users *MockUserRepo
+	mu    sync.RWMutex
+}
+
+// newMockRoleRepo returns a role repository holding the built-in roles seeded by the migrations
+func newMockRoleRepo(users *MockUserRepo) *MockRoleRepo {
+	builtIn := []models.Role{
+		{Name: models.Doctor, Permissions: []string{
+			models.PermissionPatientRead, models.PermissionPatientReadClinical, models.PermissionPatientCreate,
+			models.PermissionPatientUpdateClinical, models.PermissionPatientEmergencyAccess, models.PermissionConsentManage,
+		}},
+		{Name: models.Receptionist, Permissions: []string{
+			models.PermissionPatientRead, models.PermissionPatientReadAll, models.PermissionPatientUpdateDemographics,
+			models.PermissionPatientDelete, models.PermissionCareTeamManage, models.PermissionConsentManage,
+		}},
+		{Name: models.Admin, Permissions: []string{
+			models.PermissionPatientRead, models.PermissionPatientReadAll, models.PermissionUserManage, models.PermissionInvitationManage,
+			models.PermissionServiceAccountManage, models.PermissionEmergencyAccessReview, models.PermissionAuditRead,
+			models.PermissionPatientExport, models.PermissionResearchExport, models.PermissionPatientImport,
+			models.PermissionPatientBulkExport,
+		}},
+		{Name: models.Operator, Permissions: []string{
+			models.PermissionPatientRead, models.PermissionPatientReadAll, models.PermissionPatientTransfer,
+			models.PermissionInvitationManage, models.PermissionRoleManage, models.PermissionClinicManage,
+		}},
+	}
+
+	repo := &MockRoleRepo{roles: make(map[models.UserType]*models.Role), users: users}
+	for _, role := range builtIn {
+		role.BuiltIn = true
+		role.CreatedAt = time.Now()
+		role.UpdatedAt = role.CreatedAt
+		repo.roles[role.Name] = &role
+	}
+	return repo
+}
+
+type MockCareTeamRepo struct {
+	assignments map[uuid.UUID]*models.CareTeamAssignment
+	emergency   *MockEmergencyAccessRepo
+	mu          sync.RWMutex
+}
+
+type MockConsentRepo struct {
+	consents map[uuid.UUID]*models.Consent
+	mu       sync.RWMutex
+}
+
+type MockEmergencyAccessRepo struct {
+	grants []models.EmergencyAccessGrant
+	users  *MockUserRepo
+	mu     sync.RWMutex
+}
+
+type MockAuditRepo struct {
+	events []models.AuditEvent
+	mu     sync.RWMutex
+}
+
+type MockRetentionRepo struct {
+	runs []models.RetentionRun
+	mu   sync.RWMutex
+}
+
+type MockHL7MessageRepo struct {
+	messages []*models.HL7Message
+	mu       sync.RWMutex
+}
+
+type MockPatientImportRepo struct {
+	imports []*models.PatientImport
+	errors  map[uuid.UUID][]models.PatientImportError
+	mu      sync.RWMutex
+}
+
+type MockPatientExportRepo struct {
+	exports map[uuid.UUID]*models.PatientExport
+	mu      sync.RWMutex
+}
+
+// clinicIdentifier is a patient identifier, which is unique within a clinic
+type clinicIdentifier struct {
+	clinicID uuid.UUID
+	models.PatientIdentifier
+}
+
+type MockClinicRepo struct {
+	clinics map[uuid.UUID]*models.Clinic
+	mu      sync.RWMutex
+}
+
+// inClinic reports whether a row of the clinic is visible to the context, like the clinic
+// filters of the repositories
+func inClinic(ctx context.Context, clinicID uuid.UUID) bool {
+	scope, ok := repository.ClinicFromContext(ctx)
+	return !ok || scope == clinicID
+}
+
+// clinicForInsert picks the clinic of a new row like the repositories do
+func clinicForInsert(ctx context.Context, clinicID uuid.UUID) uuid.UUID {
+	if clinicID != uuid.Nil {
+		return clinicID
+	}
+	if scope, ok := repository.ClinicFromContext(ctx); ok {
+		return scope
+	}
+	return models.DefaultClinicID
+}
+
 func NewMockRepoStorage() repository.RepoStorage {
+	users := &MockUserRepo{users: make(map[uuid.UUID]*models.User)}
+	emergency := &MockEmergencyAccessRepo{users: users}
+	careTeams := &MockCareTeamRepo{assignments: make(map[uuid.UUID]*models.CareTeamAssignment), emergency: emergency}
+	consents := &MockConsentRepo{consents: make(map[uuid.UUID]*models.Consent)}
+	audit := &MockAuditRepo{}
+	clinics := &MockClinicRepo{clinics: map[uuid.UUID]*models.Clinic{
+		models.DefaultClinicID: {ID: models.DefaultClinicID, Name: "Main clinic", CreatedAt: time.Now()},
+	}}
 	return repository.RepoStorage{
-		Patients: &MockPatientRepo{patients: make(map[uuid.UUID]*models.Patient)},
-		Users:    &MockUserRepo{users: make(map[uuid.UUID]*models.User)},
+		Patients: &MockPatientRepo{
+			patients:    make(map[uuid.UUID]*models.Patient),
+			identifiers: make(map[clinicIdentifier]uuid.UUID),
+			careTeams:   careTeams,
+			consents:    consents,
+			audit:       audit,
+		},
+		Users:    users,
 		Tokens:   &MockTokenRepo{tokens: make(map[string]time.Time)},
+		Sessions:    &MockSessionRepo{sessions: make(map[uuid.UUID]*models.Session)},
+		Invitations: &MockInvitationRepo{invitations: make(map[string]*models.Invitation)},
+		APIKeys:     &MockAPIKeyRepo{apiKeys: make(map[string]*models.APIKey)},
+		Roles:       newMockRoleRepo(users),
+		CareTeams:   careTeams,
+		EmergencyAccess: emergency,
+		Consents:        consents,
+		Clinics:         clinics,
+		Audit:           audit,
+		Retention:       &MockRetentionRepo{},
+		HL7Messages:     &MockHL7MessageRepo{},
+		PatientImports:  &MockPatientImportRepo{errors: make(map[uuid.UUID][]models.PatientImportError)},
+		PatientExports:  &MockPatientExportRepo{exports: make(map[uuid.UUID]*models.PatientExport)},
 	}
 }
 
-func (m *MockPatientRepo) Create(ctx context.Context, userID uuid.UUID, patient *schemas.PatientCreate, createdAt time.Time) (string, error) {
+func (m *MockPatientRepo) Create(ctx context.Context, userID uuid.UUID, patient *schemas.PatientCreate, createdAt time.Time, audit *models.AuditEvent) (string, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
 	id := uuid.New()
-	dob, _ := time.Parse(patient.DateOfBirth, "2006-01-02")
+	dob, _ := time.Parse("2006-01-02", patient.DateOfBirth)
 	m.patients[id] = &models.Patient{
 		ID:             id,
 		FullName:       patient.FullName,
@@ -52,20 +211,54 @@ func (m *MockPatientRepo) Create(ctx context.Context, userID uuid.UUID, patient
 		Email:          patient.Email,
 		MedicalHistory: patient.MedicalHistory,
 		RegisteredBy:   userID,
+		ClinicID:       clinicForInsert(ctx, uuid.Nil),
 		CreatedAt:      createdAt,
 		UpdatedAt:      createdAt,
 	}
+	if audit != nil {
+		audit.PatientID = &id
+		m.audit.Record(ctx, audit)
+	}
 	return id.String(), nil
 }
 
-func (m *MockPatientRepo) FindAll(ctx context.Context) ([]schemas.Patients, error) {
+func (m *MockPatientRepo) CreateWithIdentifiers(ctx context.Context, userID uuid.UUID, patient *schemas.PatientCreate, createdAt time.Time, identifiers []models.PatientIdentifier, audit *models.AuditEvent) (string, error) {
+	m.mu.RLock()
+	clinicID := clinicForInsert(ctx, uuid.Nil)
+	for _, identifier := range identifiers {
+		if _, exists := m.identifiers[clinicIdentifier{clinicID: clinicID, PatientIdentifier: identifier}]; exists {
+			m.mu.RUnlock()
+			return "", fmt.Errorf("%w: %s %s", repository.ErrIdentifierTaken, identifier.System, identifier.Value)
+		}
+	}
+	m.mu.RUnlock()
+
+	id, err := m.Create(ctx, userID, patient, createdAt, audit)
+	if err != nil {
+		return "", err
+	}
+	return id, m.AddIdentifiers(ctx, uuid.MustParse(id), identifiers)
+}
+
+func (m *MockPatientRepo) FindAll(ctx context.Context, filter schemas.PatientQuery) ([]schemas.Patients, error) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
 	var patients []schemas.Patients
 	for _, p := range m.patients {
+		if !inClinic(ctx, p.ClinicID) || !m.careTeams.grantsAccess(p.ID, filter) || !m.consents.matches(p.ID, filter) || p.ArchivedAt != nil {
+			continue
+		}
+		if (filter.IDs != nil && !slices.Contains(filter.IDs, p.ID)) || (filter.UpdatedSince != nil && p.UpdatedAt.Before(*filter.UpdatedSince)) {
+			continue
+		}
+		if slices.ContainsFunc(filter.Search, func(c schemas.PatientCriterion) bool { return !c.Matches(p) }) {
+			continue
+		}
+		found := *p
+		found.Consents = m.consents.activeTypes(p.ID)
 		patients = append(patients, schemas.Patients{
-			Patient: p,
+			Patient: &found,
 			RegisteredByUser: struct {
 				ID       string `json:"id"`
 				FullName string `json:"full_name"`
@@ -75,15 +268,52 @@ func (m *MockPatientRepo) FindAll(ctx context.Context) ([]schemas.Patients, erro
 			},
 		})
 	}
+
+	slices.SortFunc(patients, func(a, b schemas.Patients) int {
+		if c := b.CreatedAt.Compare(a.CreatedAt); c != 0 {
+			return c
+		}
+		return strings.Compare(a.ID.String(), b.ID.String())
+	})
+	if filter.Limit > 0 {
+		patients = patients[min(filter.Offset, len(patients)):min(filter.Offset+filter.Limit, len(patients))]
+	}
 	return patients, nil
 }
 
-func (m *MockPatientRepo) FindByID(ctx context.Context, id uuid.UUID) (*models.Patient, error) {
+func (m *MockPatientRepo) Stream(ctx context.Context, filter schemas.PatientQuery, fn func(schemas.Patients) error) error {
+	patients, _ := m.FindAll(ctx, filter)
+	for _, patient := range patients {
+		if err := fn(patient); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *MockPatientRepo) FindIDs(ctx context.Context, filter schemas.PatientQuery) ([]uuid.UUID, error) {
+	patients, _ := m.FindAll(ctx, filter)
+	ids := []uuid.UUID{}
+	for _, patient := range patients {
+		ids = append(ids, patient.ID)
+	}
+	return ids, nil
+}
+
+func (m *MockPatientRepo) Count(ctx context.Context, filter schemas.PatientQuery) (int, error) {
+	filter.Limit = 0
+	patients, _ := m.FindAll(ctx, filter)
+	return len(patients), nil
+}
+
+func (m *MockPatientRepo) FindByID(ctx context.Context, id uuid.UUID, filter schemas.PatientQuery) (*models.Patient, error) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
-	if patient, exists := m.patients[id]; exists {
-		return patient, nil
+	if patient, exists := m.patients[id]; exists && inClinic(ctx, patient.ClinicID) && m.careTeams.grantsAccess(id, filter) && m.consents.matches(id, filter) {
+		found := *patient
+		found.Consents = m.consents.activeTypes(id)
+		return &found, nil
 	}
 	return nil, nil
 }
@@ -93,21 +323,24 @@ func (m *MockPatientRepo) FindByEmail(ctx context.Context, email string) (*model
 	defer m.mu.RUnlock()
 
 	for _, p := range m.patients {
-		if p.Email == email {
+		if strings.EqualFold(p.Email, strings.TrimSpace(email)) && inClinic(ctx, p.ClinicID) {
 			return p, nil
 		}
 	}
 	return nil, nil
 }
 
-func (m *MockPatientRepo) UpdateByID(ctx context.Context, id uuid.UUID, update *schemas.PatientUpdate) (*models.Patient, error) {
+func (m *MockPatientRepo) UpdateByID(ctx context.Context, id uuid.UUID, update *schemas.PatientUpdate, audit *models.AuditEvent) (*models.Patient, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	if patient, exists := m.patients[id]; exists {
+	if patient, exists := m.patients[id]; exists && inClinic(ctx, patient.ClinicID) {
 		if update.FullName != nil {
 			patient.FullName = *update.FullName
 		}
+		if update.DateOfBirth != nil {
+			patient.DateOfBirth, _ = time.Parse("2006-01-02", *update.DateOfBirth)
+		}
 		if update.Gender != nil {
 			patient.Gender = *update.Gender
 		}
@@ -124,16 +357,241 @@ func (m *MockPatientRepo) UpdateByID(ctx context.Context, id uuid.UUID, update *
 			patient.MedicalHistory = *update.MedicalHistory
 		}
 		patient.UpdatedAt = time.Now()
-		return patient, nil
+		patient.ArchivedAt = nil
+		if audit != nil {
+			m.audit.Record(ctx, audit)
+		}
+		updated := *patient
+		return &updated, nil
+	}
+	return nil, nil
+}
+
+func (m *MockPatientRepo) DeleteByID(ctx context.Context, id uuid.UUID, audit *models.AuditEvent) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if patient, exists := m.patients[id]; exists && inClinic(ctx, patient.ClinicID) {
+		delete(m.patients, id)
+		m.removeIdentifiers(id)
+		if audit != nil {
+			m.audit.Record(ctx, audit)
+		}
+	}
+	return nil
+}
+
+func (m *MockPatientRepo) Transfer(ctx context.Context, transfer *models.PatientTransfer) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	patient, exists := m.patients[transfer.PatientID]
+	if !exists || !inClinic(ctx, patient.ClinicID) {
+		return false, nil
+	}
+
+	transfer.ID = uuid.New()
+	transfer.FromClinicID = patient.ClinicID
+	transfer.TransferredAt = time.Now()
+	patient.ClinicID = transfer.ToClinicID
+	patient.UpdatedAt = transfer.TransferredAt
+	m.careTeams.endForPatient(patient.ID, transfer.TransferredAt)
+	m.careTeams.emergency.endForPatient(patient.ID, transfer.TransferredAt)
+	m.removeIdentifiers(patient.ID)
+	return true, nil
+}
+
+func (m *MockPatientRepo) FindByIdentifier(ctx context.Context, identifier models.PatientIdentifier) (*models.Patient, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for key, patientID := range m.identifiers {
+		if key.PatientIdentifier != identifier || !inClinic(ctx, key.clinicID) {
+			continue
+		}
+		found := *m.patients[patientID]
+		found.Consents = m.consents.activeTypes(patientID)
+		return &found, nil
 	}
 	return nil, nil
 }
 
-func (m *MockPatientRepo) DeleteByID(ctx context.Context, id uuid.UUID) error {
+func (m *MockPatientRepo) AddIdentifiers(ctx context.Context, patientID uuid.UUID, identifiers []models.PatientIdentifier) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	patient, exists := m.patients[patientID]
+	if !exists || !inClinic(ctx, patient.ClinicID) {
+		return fmt.Errorf("failed to add patient identifiers: patient %s not found", patientID)
+	}
+
+	for _, identifier := range identifiers {
+		key := clinicIdentifier{clinicID: patient.ClinicID, PatientIdentifier: identifier}
+		if owner, exists := m.identifiers[key]; exists && owner != patientID {
+			return fmt.Errorf("%w: %s %s", repository.ErrIdentifierTaken, identifier.System, identifier.Value)
+		}
+		m.identifiers[key] = patientID
+	}
+	return nil
+}
+
+func (m *MockPatientRepo) Merge(ctx context.Context, merge *models.PatientMerge) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	survivor, exists := m.patients[merge.SurvivorID]
+	if !exists || !inClinic(ctx, survivor.ClinicID) {
+		return false, nil
+	}
+	merged, exists := m.patients[merge.MergedID]
+	if !exists || !inClinic(ctx, merged.ClinicID) || survivor.ID == merged.ID {
+		return false, nil
+	}
+
+	for key, patientID := range m.identifiers {
+		if patientID == merged.ID {
+			m.identifiers[key] = survivor.ID
+		}
+	}
+	m.careTeams.mu.Lock()
+	for _, assignment := range m.careTeams.assignments {
+		if assignment.PatientID == merged.ID {
+			assignment.PatientID = survivor.ID
+		}
+	}
+	m.careTeams.mu.Unlock()
+	m.consents.mu.Lock()
+	for _, consent := range m.consents.consents {
+		if consent.PatientID == merged.ID {
+			consent.PatientID = survivor.ID
+		}
+	}
+	m.consents.mu.Unlock()
+
+	for _, fields := range [][2]*string{
+		{&survivor.Address, &merged.Address},
+		{&survivor.Phone, &merged.Phone},
+		{&survivor.Email, &merged.Email},
+	} {
+		if *fields[0] == "" {
+			*fields[0] = *fields[1]
+		}
+	}
+	if merged.MedicalHistory != "" && merged.MedicalHistory != survivor.MedicalHistory {
+		if survivor.MedicalHistory != "" {
+			survivor.MedicalHistory += "\n\n"
+		}
+		survivor.MedicalHistory += merged.MedicalHistory
+	}
+	survivor.UpdatedAt = time.Now()
+	survivor.ArchivedAt = nil
+	delete(m.patients, merged.ID)
+
+	m.audit.Record(ctx, &models.AuditEvent{
+		Severity:  models.AuditSeverityInfo,
+		Action:    models.AuditActionPatientMerge,
+		ActorID:   &merge.MergedBy,
+		PatientID: &survivor.ID,
+		Details:   map[string]interface{}{"merged_patient_id": merged.ID, "source": merge.Source},
+	})
+	return true, nil
+}
+
+// removeIdentifiers forgets the identifiers of a patient, like the cascade of the database
+func (m *MockPatientRepo) removeIdentifiers(patientID uuid.UUID) {
+	for key, owner := range m.identifiers {
+		if owner == patientID {
+			delete(m.identifiers, key)
+		}
+	}
+}
+
+// The mock keeps patients in memory only, so there is nothing to encrypt or rotate
+func (m *MockPatientRepo) EncryptPlaintext(ctx context.Context, batchSize int) (int, error) {
+	return 0, nil
+}
+
+func (m *MockPatientRepo) RotateKeys(ctx context.Context, batchSize int) (int, error) {
+	return 0, nil
+}
+
+func (m *MockPatientRepo) ArchiveInactive(ctx context.Context, before time.Time, dryRun bool) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	var count int64
+	for _, patient := range m.patients {
+		if patient.ArchivedAt != nil || !patient.UpdatedAt.Before(before) || m.audit.accessedSince(patient.ID, before) {
+			continue
+		}
+		count++
+		if !dryRun {
+			patient.ArchivedAt = &now
+		}
+	}
+	return count, nil
+}
+
+// Import matches emails across clinics and names with dates of birth within the clinic, like the
+// unique index and the duplicate search of the database
+func (m *MockPatientRepo) Import(ctx context.Context, batch *models.PatientImportBatch) ([]models.PatientDuplicate, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	delete(m.patients, id)
+	var duplicates []models.PatientDuplicate
+	var patientIDs []string
+	now := time.Now()
+	for i, patient := range batch.Patients {
+		if duplicate := m.findDuplicate(ctx, patient); duplicate != nil {
+			duplicate.Index = i
+			duplicates = append(duplicates, *duplicate)
+			continue
+		}
+
+		stored := *patient
+		stored.ID = uuid.New()
+		stored.RegisteredBy = batch.ImportedBy
+		stored.ClinicID = clinicForInsert(ctx, uuid.Nil)
+		stored.CreatedAt = now
+		stored.UpdatedAt = now
+		patient.ID = stored.ID
+		m.patients[stored.ID] = &stored
+		patientIDs = append(patientIDs, stored.ID.String())
+	}
+
+	if len(patientIDs) > 0 {
+		m.audit.Record(ctx, &models.AuditEvent{
+			Severity: models.AuditSeverityInfo,
+			Action:   models.AuditActionPatientImport,
+			ActorID:  &batch.ImportedBy,
+			Fields:   batch.Fields,
+			Details: map[string]interface{}{
+				"patient_ids": patientIDs,
+				"count":       len(patientIDs),
+				"import_id":   batch.ImportID,
+			},
+		})
+	}
+	return duplicates, nil
+}
+
+func (m *MockPatientRepo) findDuplicate(ctx context.Context, patient *models.Patient) *models.PatientDuplicate {
+	for _, existing := range m.patients {
+		if patient.Email != "" && strings.EqualFold(strings.TrimSpace(existing.Email), strings.TrimSpace(patient.Email)) {
+			duplicate := models.PatientDuplicate{Match: "email"}
+			if inClinic(ctx, existing.ClinicID) {
+				duplicate.PatientID = &existing.ID
+			}
+			return &duplicate
+		}
+	}
+	for _, existing := range m.patients {
+		if inClinic(ctx, existing.ClinicID) && strings.EqualFold(existing.FullName, patient.FullName) &&
+			existing.DateOfBirth.Equal(patient.DateOfBirth) {
+			return &models.PatientDuplicate{PatientID: &existing.ID, Match: "full_name"}
+		}
+	}
 	return nil
 }
 
@@ -149,21 +607,51 @@ func (m *MockUserRepo) Create(ctx context.Context, user *schemas.UserRegister, h
 		Password:  hashedPassword,
 		Email:     user.Email,
 		FullName:  user.FullName,
-		UserType:  user.UserType,
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
+		UserType:     user.UserType,
+		ClinicID:     clinicForInsert(ctx, user.ClinicID),
+		AuthProvider: models.LocalProvider,
+		IsActive:     true,
+		CreatedAt:    time.Now(),
+		UpdatedAt:    time.Now(),
 	}
 	return id.String(), nil
 }
 
+func (m *MockUserRepo) CreateExternal(ctx context.Context, user *models.User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user.ID = uuid.New()
+	user.ClinicID = clinicForInsert(ctx, user.ClinicID)
+	user.Password = models.UnusablePassword
+	user.IsActive = true
+	user.CreatedAt = time.Now()
+	user.UpdatedAt = user.CreatedAt
+	stored := *user
+	m.users[user.ID] = &stored
+	return nil
+}
+
+func (m *MockUserRepo) FindByExternalID(ctx context.Context, provider, externalID string) (*models.User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, u := range m.users {
+		if u.AuthProvider == provider && u.ExternalID == externalID && inClinic(ctx, u.ClinicID) {
+			return u, nil
+		}
+	}
+	return nil, repository.ErrUserNotFound
+}
+
 func (m *MockUserRepo) FindByID(ctx context.Context, id uuid.UUID) (*models.User, error) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
-	if user, exists := m.users[id]; exists {
+	if user, exists := m.users[id]; exists && inClinic(ctx, user.ClinicID) {
 		return user, nil
 	}
-	return nil, fmt.Errorf("user not found")
+	return nil, repository.ErrUserNotFound
 }
 
 func (m *MockUserRepo) FindByUsername(ctx context.Context, username string) (*models.User, error) {
@@ -171,11 +659,11 @@ func (m *MockUserRepo) FindByUsername(ctx context.Context, username string) (*mo
 	defer m.mu.RUnlock()
 
 	for _, u := range m.users {
-		if u.Username == username {
+		if u.Username == username && inClinic(ctx, u.ClinicID) {
 			return u, nil
 		}
 	}
-	return nil, fmt.Errorf("user not found")
+	return nil, repository.ErrUserNotFound
 }
 
 func (m *MockUserRepo) FindByEmail(ctx context.Context, email string) (*models.User, error) {
@@ -183,18 +671,18 @@ func (m *MockUserRepo) FindByEmail(ctx context.Context, email string) (*models.U
 	defer m.mu.RUnlock()
 
 	for _, u := range m.users {
-		if u.Email == email {
+		if u.Email == email && inClinic(ctx, u.ClinicID) {
 			return u, nil
 		}
 	}
-	return nil, fmt.Errorf("user not found")
+	return nil, repository.ErrUserNotFound
 }
 
 func (m *MockUserRepo) UpdateByID(ctx context.Context, id uuid.UUID, update *schemas.UserUpdate) (*models.User, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	if user, exists := m.users[id]; exists {
+	if user, exists := m.users[id]; exists && inClinic(ctx, user.ClinicID) {
 		if update.Username != nil {
 			user.Username = *update.Username
 		}
@@ -210,7 +698,49 @@ func (m *MockUserRepo) UpdateByID(ctx context.Context, id uuid.UUID, update *sch
 		user.UpdatedAt = time.Now()
 		return user, nil
 	}
-	return nil, fmt.Errorf("user not found")
+	return nil, repository.ErrUserNotFound
+}
+
+func (m *MockUserRepo) UpdatePassword(ctx context.Context, id uuid.UUID, hashedPassword string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if user, exists := m.users[id]; exists && inClinic(ctx, user.ClinicID) {
+		user.Password = hashedPassword
+		user.UpdatedAt = time.Now()
+		return nil
+	}
+	return repository.ErrUserNotFound
+}
+
+func (m *MockUserRepo) FindAll(ctx context.Context) ([]models.User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	users := []models.User{}
+	for _, u := range m.users {
+		if inClinic(ctx, u.ClinicID) {
+			users = append(users, *u)
+		}
+	}
+	return users, nil
+}
+
+func (m *MockUserRepo) SetActive(ctx context.Context, id uuid.UUID, active bool) (*models.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if user, exists := m.users[id]; exists && inClinic(ctx, user.ClinicID) {
+		user.IsActive = active
+		user.DeactivatedAt = nil
+		if !active {
+			now := time.Now()
+			user.DeactivatedAt = &now
+		}
+		user.UpdatedAt = time.Now()
+		return user, nil
+	}
+	return nil, repository.ErrUserNotFound
 }
 
 func (m *MockUserRepo) EmailExists(ctx context.Context, email string) (bool, error) {
@@ -238,33 +768,1004 @@ func (m *MockUserRepo) UsernameExists(ctx context.Context, username string) (boo
 }
 
 // MockTokenRepo implementations
-func (m *MockTokenRepo) InvalidateToken(ctx context.Context, token string, expiresAt time.Time) error {
+func (m *MockTokenRepo) InvalidateToken(ctx context.Context, jti string, expiresAt time.Time) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	m.tokens[token] = expiresAt
+	m.tokens[jti] = expiresAt
 	return nil
 }
 
-func (m *MockTokenRepo) IsTokenInvalid(ctx context.Context, token string) (bool, error) {
+func (m *MockTokenRepo) IsTokenInvalid(ctx context.Context, jti string) (bool, error) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
-	if expiry, exists := m.tokens[token]; exists {
+	if expiry, exists := m.tokens[jti]; exists {
 		return time.Now().Before(expiry), nil
 	}
 	return false, nil
 }
 
-func (m *MockTokenRepo) CleanupExpiredTokens(ctx context.Context) error {
+func (m *MockTokenRepo) PurgeInvalidTokens(ctx context.Context, before time.Time, dryRun bool) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var count int64
+	for jti, expiry := range m.tokens {
+		if !expiry.After(before) {
+			count++
+			if !dryRun {
+				delete(m.tokens, jti)
+			}
+		}
+	}
+	return count, nil
+}
+
+// MockSessionRepo implementations
+func (m *MockSessionRepo) Create(ctx context.Context, session *models.Session) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	session.ID = uuid.New()
+	session.CreatedAt = time.Now()
+	session.LastSeenAt = session.CreatedAt
+	stored := *session
+	m.sessions[session.ID] = &stored
+	return nil
+}
+
+func (m *MockSessionRepo) FindByID(ctx context.Context, id uuid.UUID) (*models.Session, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if session, exists := m.sessions[id]; exists {
+		found := *session
+		return &found, nil
+	}
+	return nil, nil
+}
+
+func (m *MockSessionRepo) ListActiveByUser(ctx context.Context, userID uuid.UUID) ([]models.Session, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	sessions := []models.Session{}
+	for _, s := range m.sessions {
+		if s.UserID == userID && s.IsActive() {
+			sessions = append(sessions, *s)
+		}
+	}
+	return sessions, nil
+}
+
+func (m *MockSessionRepo) Touch(ctx context.Context, id uuid.UUID, ipAddress string) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
+	if session, exists := m.sessions[id]; exists {
+		session.LastSeenAt = time.Now()
+		session.IPAddress = ipAddress
+	}
+	return nil
+}
+
+func (m *MockSessionRepo) Revoke(ctx context.Context, id uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if session, exists := m.sessions[id]; exists && session.RevokedAt == nil {
+		now := time.Now()
+		session.RevokedAt = &now
+	}
+	return nil
+}
+
+func (m *MockSessionRepo) RevokeAllForUser(ctx context.Context, userID uuid.UUID, except *uuid.UUID) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var revoked int64
 	now := time.Now()
-	for token, expiry := range m.tokens {
-		if now.After(expiry) {
-			delete(m.tokens, token)
+	for id, session := range m.sessions {
+		if session.UserID != userID || session.RevokedAt != nil || (except != nil && id == *except) {
+			continue
+		}
+		session.RevokedAt = &now
+		revoked++
+	}
+	return revoked, nil
+}
+
+func (m *MockSessionRepo) PurgeExpiredSessions(ctx context.Context, before time.Time, dryRun bool) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var count int64
+	for id, session := range m.sessions {
+		if !session.ExpiresAt.After(before) {
+			count++
+			if !dryRun {
+				delete(m.sessions, id)
+			}
 		}
 	}
+	return count, nil
+}
+
+// MockInvitationRepo implementations
+func (m *MockInvitationRepo) Create(ctx context.Context, invitation *models.Invitation, token string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	invitation.ID = uuid.New()
+	invitation.ClinicID = clinicForInsert(ctx, invitation.ClinicID)
+	invitation.CreatedAt = time.Now()
+	stored := *invitation
+	m.invitations[token] = &stored
 	return nil
 }
+
+func (m *MockInvitationRepo) FindAll(ctx context.Context) ([]models.Invitation, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	invitations := []models.Invitation{}
+	for _, i := range m.invitations {
+		if inClinic(ctx, i.ClinicID) {
+			invitations = append(invitations, *i)
+		}
+	}
+	return invitations, nil
+}
+
+func (m *MockInvitationRepo) Claim(ctx context.Context, token string) (*models.Invitation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	invitation, exists := m.invitations[token]
+	if !exists || invitation.AcceptedAt != nil || invitation.RevokedAt != nil || time.Now().After(invitation.ExpiresAt) {
+		return nil, nil
+	}
+
+	now := time.Now()
+	invitation.AcceptedAt = &now
+	claimed := *invitation
+	return &claimed, nil
+}
+
+func (m *MockInvitationRepo) Release(ctx context.Context, id uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, i := range m.invitations {
+		if i.ID == id && i.AcceptedBy == nil {
+			i.AcceptedAt = nil
+		}
+	}
+	return nil
+}
+
+func (m *MockInvitationRepo) MarkAccepted(ctx context.Context, id uuid.UUID, userID uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, i := range m.invitations {
+		if i.ID == id {
+			i.AcceptedBy = &userID
+		}
+	}
+	return nil
+}
+
+func (m *MockInvitationRepo) Revoke(ctx context.Context, id uuid.UUID) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, i := range m.invitations {
+		if i.ID == id && i.AcceptedAt == nil && i.RevokedAt == nil && inClinic(ctx, i.ClinicID) {
+			now := time.Now()
+			i.RevokedAt = &now
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// MockAPIKeyRepo implementations
+func (m *MockAPIKeyRepo) Create(ctx context.Context, apiKey *models.APIKey, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	apiKey.ID = uuid.New()
+	apiKey.CreatedAt = time.Now()
+	stored := *apiKey
+	m.apiKeys[key] = &stored
+	return nil
+}
+
+func (m *MockAPIKeyRepo) FindByKey(ctx context.Context, prefix, key string) (*models.APIKey, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if apiKey, exists := m.apiKeys[key]; exists && apiKey.Prefix == prefix {
+		found := *apiKey
+		return &found, nil
+	}
+	return nil, nil
+}
+
+func (m *MockAPIKeyRepo) FindByID(ctx context.Context, id uuid.UUID) (*models.APIKey, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, k := range m.apiKeys {
+		if k.ID == id {
+			found := *k
+			return &found, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *MockAPIKeyRepo) ListByUser(ctx context.Context, userID uuid.UUID) ([]models.APIKey, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	apiKeys := []models.APIKey{}
+	for _, k := range m.apiKeys {
+		if k.UserID == userID {
+			apiKeys = append(apiKeys, *k)
+		}
+	}
+	return apiKeys, nil
+}
+
+func (m *MockAPIKeyRepo) Touch(ctx context.Context, id uuid.UUID, ipAddress string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, k := range m.apiKeys {
+		if k.ID == id {
+			now := time.Now()
+			k.LastUsedAt = &now
+			k.LastUsedIP = ipAddress
+		}
+	}
+	return nil
+}
+
+func (m *MockAPIKeyRepo) ExpireAt(ctx context.Context, id uuid.UUID, expiresAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, k := range m.apiKeys {
+		if k.ID == id && k.RevokedAt == nil && (k.ExpiresAt == nil || expiresAt.Before(*k.ExpiresAt)) {
+			k.ExpiresAt = &expiresAt
+		}
+	}
+	return nil
+}
+
+func (m *MockAPIKeyRepo) Revoke(ctx context.Context, id uuid.UUID) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, k := range m.apiKeys {
+		if k.ID == id && k.RevokedAt == nil {
+			now := time.Now()
+			k.RevokedAt = &now
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// MockRoleRepo implementations
+func (m *MockRoleRepo) FindAll(ctx context.Context) ([]models.Role, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	roles := []models.Role{}
+	for _, r := range m.roles {
+		roles = append(roles, *r)
+	}
+	return roles, nil
+}
+
+func (m *MockRoleRepo) FindByName(ctx context.Context, name models.UserType) (*models.Role, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if role, exists := m.roles[name]; exists {
+		found := *role
+		found.Permissions = append([]string(nil), role.Permissions...)
+		return &found, nil
+	}
+	return nil, nil
+}
+
+func (m *MockRoleRepo) Create(ctx context.Context, role *models.Role) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.roles[role.Name]; exists {
+		return fmt.Errorf("role %s already exists", role.Name)
+	}
+	role.BuiltIn = false
+	role.CreatedAt = time.Now()
+	role.UpdatedAt = role.CreatedAt
+	stored := *role
+	m.roles[role.Name] = &stored
+	return nil
+}
+
+func (m *MockRoleRepo) Update(ctx context.Context, role *models.Role) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, exists := m.roles[role.Name]
+	if !exists {
+		return fmt.Errorf("role %s not found", role.Name)
+	}
+	stored.Description = role.Description
+	stored.Permissions = append([]string(nil), role.Permissions...)
+	stored.UpdatedAt = time.Now()
+	*role = *stored
+	return nil
+}
+
+func (m *MockRoleRepo) Delete(ctx context.Context, name models.UserType) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if role, exists := m.roles[name]; exists && !role.BuiltIn {
+		delete(m.roles, name)
+		return true, nil
+	}
+	return false, nil
+}
+
+func (m *MockRoleRepo) IsAssigned(ctx context.Context, name models.UserType) (bool, error) {
+	m.users.mu.RLock()
+	defer m.users.mu.RUnlock()
+
+	for _, u := range m.users.users {
+		if u.UserType == name {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// MockCareTeamRepo implementations
+func (m *MockCareTeamRepo) Create(ctx context.Context, assignment *models.CareTeamAssignment) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	assignment.ID = uuid.New()
+	assignment.CreatedAt = time.Now()
+	if assignment.ValidFrom.IsZero() {
+		assignment.ValidFrom = assignment.CreatedAt
+	}
+	stored := *assignment
+	m.assignments[assignment.ID] = &stored
+	return nil
+}
+
+func (m *MockCareTeamRepo) FindByID(ctx context.Context, id uuid.UUID) (*models.CareTeamAssignment, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if assignment, exists := m.assignments[id]; exists {
+		found := *assignment
+		return &found, nil
+	}
+	return nil, nil
+}
+
+func (m *MockCareTeamRepo) ListByPatient(ctx context.Context, patientID uuid.UUID) ([]models.CareTeamAssignment, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	assignments := []models.CareTeamAssignment{}
+	for _, a := range m.assignments {
+		if a.PatientID == patientID {
+			assignments = append(assignments, *a)
+		}
+	}
+	return assignments, nil
+}
+
+func (m *MockCareTeamRepo) End(ctx context.Context, id uuid.UUID) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	if a, exists := m.assignments[id]; exists && (a.ValidUntil == nil || now.Before(*a.ValidUntil)) {
+		a.ValidUntil = &now
+		return true, nil
+	}
+	return false, nil
+}
+
+// endForPatient ends the active assignments of a patient
+func (m *MockCareTeamRepo) endForPatient(patientID uuid.UUID, at time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, a := range m.assignments {
+		if a.PatientID == patientID && (a.ValidUntil == nil || at.Before(*a.ValidUntil)) {
+			a.ValidUntil = &at
+		}
+	}
+}
+
+// grantsAccess reports whether the query may see the patient
+func (m *MockCareTeamRepo) grantsAccess(patientID uuid.UUID, filter schemas.PatientQuery) bool {
+	if filter.CareTeamMember == nil {
+		return true
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, a := range m.assignments {
+		if a.PatientID == patientID && a.UserID == *filter.CareTeamMember && a.IsActive() {
+			return true
+		}
+	}
+	return m.emergency.grantsAccess(patientID, *filter.CareTeamMember)
+}
+
+// MockConsentRepo implementations
+func (m *MockConsentRepo) Create(ctx context.Context, consent *models.Consent) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	consent.ID = uuid.New()
+	consent.CreatedAt = time.Now()
+	if consent.GrantedAt.IsZero() {
+		consent.GrantedAt = consent.CreatedAt
+	}
+	stored := *consent
+	m.consents[consent.ID] = &stored
+	return nil
+}
+
+func (m *MockConsentRepo) FindByID(ctx context.Context, id uuid.UUID) (*models.Consent, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if consent, exists := m.consents[id]; exists {
+		found := *consent
+		return &found, nil
+	}
+	return nil, nil
+}
+
+func (m *MockConsentRepo) ListByPatient(ctx context.Context, patientID uuid.UUID) ([]models.Consent, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	consents := []models.Consent{}
+	for _, consent := range m.consents {
+		if consent.PatientID == patientID {
+			consents = append(consents, *consent)
+		}
+	}
+	return consents, nil
+}
+
+func (m *MockConsentRepo) Revoke(ctx context.Context, id uuid.UUID) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if consent, exists := m.consents[id]; exists && consent.RevokedAt == nil {
+		now := time.Now()
+		consent.RevokedAt = &now
+		return true, nil
+	}
+	return false, nil
+}
+
+// activeTypes returns the types of consent the patient currently gives
+func (m *MockConsentRepo) activeTypes(patientID uuid.UUID) []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var types []string
+	for _, consent := range m.consents {
+		if consent.PatientID == patientID && consent.IsActive() && !slices.Contains(types, consent.Type) {
+			types = append(types, consent.Type)
+		}
+	}
+	return types
+}
+
+// matches reports whether the patient gives the consent the query asks for
+func (m *MockConsentRepo) matches(patientID uuid.UUID, filter schemas.PatientQuery) bool {
+	return filter.Consent == "" || slices.Contains(m.activeTypes(patientID), filter.Consent)
+}
+
+// MockEmergencyAccessRepo implementations
+func (m *MockEmergencyAccessRepo) Create(ctx context.Context, grant *models.EmergencyAccessGrant) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	grant.ID = uuid.New()
+	grant.GrantedAt = time.Now()
+	m.grants = append(m.grants, *grant)
+	return nil
+}
+
+func (m *MockEmergencyAccessRepo) List(ctx context.Context, filter schemas.EmergencyAccessQuery) ([]models.EmergencyAccessGrant, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	grants := []models.EmergencyAccessGrant{}
+	for i := len(m.grants) - 1; i >= 0; i-- {
+		grant := m.grants[i]
+		if (filter.Since != nil && grant.GrantedAt.Before(*filter.Since)) ||
+			(filter.Until != nil && !grant.GrantedAt.Before(*filter.Until)) ||
+			(filter.PatientID != nil && grant.PatientID != *filter.PatientID) ||
+			(filter.UserID != nil && grant.UserID != *filter.UserID) {
+			continue
+		}
+		// Like the join in the query, grants of users outside the clinic are left out
+		user, err := m.users.FindByID(ctx, grant.UserID)
+		if err != nil {
+			continue
+		}
+		grant.Username = user.Username
+		grants = append(grants, grant)
+	}
+	return grants, nil
+}
+
+// endForPatient expires the emergency access to a patient
+func (m *MockEmergencyAccessRepo) endForPatient(patientID uuid.UUID, at time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.grants {
+		if m.grants[i].PatientID == patientID && at.Before(m.grants[i].ExpiresAt) {
+			m.grants[i].ExpiresAt = at
+		}
+	}
+}
+
+// grantsAccess reports whether the user has unexpired emergency access to the patient
+func (m *MockEmergencyAccessRepo) grantsAccess(patientID, userID uuid.UUID) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, grant := range m.grants {
+		if grant.PatientID == patientID && grant.UserID == userID && time.Now().Before(grant.ExpiresAt) {
+			return true
+		}
+	}
+	return false
+}
+
+// MockClinicRepo implementations
+func (m *MockClinicRepo) Create(ctx context.Context, clinic *models.Clinic) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, c := range m.clinics {
+		if c.Name == clinic.Name {
+			return fmt.Errorf("clinic %s already exists", clinic.Name)
+		}
+	}
+
+	clinic.ID = uuid.New()
+	clinic.CreatedAt = time.Now()
+	stored := *clinic
+	m.clinics[clinic.ID] = &stored
+	return nil
+}
+
+func (m *MockClinicRepo) FindAll(ctx context.Context) ([]models.Clinic, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	clinics := []models.Clinic{}
+	for _, c := range m.clinics {
+		clinics = append(clinics, *c)
+	}
+	slices.SortFunc(clinics, func(a, b models.Clinic) int { return strings.Compare(a.Name, b.Name) })
+	return clinics, nil
+}
+
+func (m *MockClinicRepo) FindByID(ctx context.Context, id uuid.UUID) (*models.Clinic, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if clinic, exists := m.clinics[id]; exists {
+		found := *clinic
+		return &found, nil
+	}
+	return nil, nil
+}
+
+func (m *MockClinicRepo) FindByName(ctx context.Context, name string) (*models.Clinic, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, c := range m.clinics {
+		if c.Name == name {
+			found := *c
+			return &found, nil
+		}
+	}
+	return nil, nil
+}
+
+// MockAuditRepo implementations
+func (m *MockAuditRepo) Record(ctx context.Context, event *models.AuditEvent) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	event.ID = int64(len(m.events) + 1)
+	event.OccurredAt = time.Now()
+	if event.ClinicID == nil {
+		if clinicID, ok := repository.ClinicFromContext(ctx); ok {
+			event.ClinicID = &clinicID
+		}
+	}
+	m.events = append(m.events, *event)
+	return nil
+}
+
+func (m *MockAuditRepo) List(ctx context.Context, filter schemas.AuditQuery) ([]models.AuditEvent, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = 100
+	}
+
+	events := []models.AuditEvent{}
+	for i := len(m.events) - 1; i >= 0 && len(events) < limit; i-- {
+		event := m.events[i]
+		if (filter.PatientID != nil && !auditEventConcerns(event, *filter.PatientID)) ||
+			(filter.ActorID != nil && (event.ActorID == nil || *event.ActorID != *filter.ActorID)) ||
+			(filter.Action != "" && event.Action != filter.Action) ||
+			(filter.Since != nil && event.OccurredAt.Before(*filter.Since)) ||
+			(filter.Until != nil && !event.OccurredAt.Before(*filter.Until)) ||
+			(event.ClinicID != nil && !inClinic(ctx, *event.ClinicID)) {
+			continue
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+func (m *MockAuditRepo) ListByPatient(ctx context.Context, patientID uuid.UUID) ([]models.AuditEvent, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	events := []models.AuditEvent{}
+	for _, event := range m.events {
+		if auditEventConcerns(event, patientID) && (event.ClinicID == nil || inClinic(ctx, *event.ClinicID)) {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+func (m *MockAuditRepo) Verify(ctx context.Context) (*models.AuditVerification, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return &models.AuditVerification{Events: int64(len(m.events))}, nil
+}
+
+func (m *MockAuditRepo) Purge(ctx context.Context, before time.Time, dryRun bool) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var count int64
+	for count < int64(len(m.events)) && m.events[count].OccurredAt.Before(before) {
+		count++
+	}
+	if !dryRun {
+		m.events = m.events[count:]
+	}
+	return count, nil
+}
+
+// accessedSince reports whether an event about the patient was recorded at or after the time
+func (m *MockAuditRepo) accessedSince(patientID uuid.UUID, since time.Time) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, event := range m.events {
+		if event.PatientID != nil && *event.PatientID == patientID && !event.OccurredAt.Before(since) {
+			return true
+		}
+	}
+	return false
+}
+
+// MockRetentionRepo implementations
+func (m *MockRetentionRepo) Record(ctx context.Context, run *models.RetentionRun) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	run.ID = uuid.New()
+	m.runs = append(m.runs, *run)
+	return nil
+}
+
+func (m *MockRetentionRepo) List(ctx context.Context, limit int) ([]models.RetentionRun, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	runs := []models.RetentionRun{}
+	for i := len(m.runs) - 1; i >= 0 && len(runs) < limit; i-- {
+		runs = append(runs, m.runs[i])
+	}
+	return runs, nil
+}
+
+// MockHL7MessageRepo implementations
+func (m *MockHL7MessageRepo) Create(ctx context.Context, message *models.HL7Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	message.ID = uuid.New()
+	message.ClinicID = clinicForInsert(ctx, message.ClinicID)
+	message.ReceivedAt = time.Now()
+	stored := *message
+	m.messages = append(m.messages, &stored)
+	return nil
+}
+
+func (m *MockHL7MessageRepo) Finish(ctx context.Context, message *models.HL7Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, stored := range m.messages {
+		if stored.ID == message.ID {
+			now := time.Now()
+			message.ProcessedAt = &now
+			stored.Status = message.Status
+			stored.Error = message.Error
+			stored.PatientID = message.PatientID
+			stored.ProcessedAt = message.ProcessedAt
+			return nil
+		}
+	}
+	return fmt.Errorf("failed to update HL7 message: %s not found", message.ID)
+}
+
+func (m *MockHL7MessageRepo) FindByID(ctx context.Context, id uuid.UUID) (*models.HL7Message, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, stored := range m.messages {
+		if stored.ID == id && inClinic(ctx, stored.ClinicID) {
+			found := *stored
+			return &found, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *MockHL7MessageRepo) FindByControlID(ctx context.Context, sendingApplication, controlID string) (*models.HL7Message, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for i := len(m.messages) - 1; i >= 0; i-- {
+		stored := m.messages[i]
+		if stored.SendingApplication == sendingApplication && stored.ControlID == controlID && inClinic(ctx, stored.ClinicID) {
+			found := *stored
+			return &found, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *MockHL7MessageRepo) List(ctx context.Context, status string, limit int) ([]models.HL7Message, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	messages := []models.HL7Message{}
+	for _, stored := range m.messages {
+		if len(messages) == limit {
+			break
+		}
+		if (status == "" || stored.Status == status) && inClinic(ctx, stored.ClinicID) {
+			messages = append(messages, *stored)
+		}
+	}
+	return messages, nil
+}
+
+// The mock keeps messages in memory only, so there is nothing to rotate
+func (m *MockHL7MessageRepo) RotateKeys(ctx context.Context, batchSize int) (int, error) {
+	return 0, nil
+}
+
+// auditEventConcerns reports whether the event is about the patient, like the patient filter of the query
+func auditEventConcerns(event models.AuditEvent, patientID uuid.UUID) bool {
+	if event.PatientID != nil && *event.PatientID == patientID {
+		return true
+	}
+	ids, _ := event.Details["patient_ids"].([]string)
+	return slices.Contains(ids, patientID.String())
+}
+
+// MockPatientImportRepo implementations
+func (m *MockPatientImportRepo) Create(ctx context.Context, patientImport *models.PatientImport) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	patientImport.ID = uuid.New()
+	patientImport.ClinicID = clinicForInsert(ctx, patientImport.ClinicID)
+	patientImport.CreatedAt = time.Now()
+	stored := *patientImport
+	m.imports = append(m.imports, &stored)
+	return nil
+}
+
+func (m *MockPatientImportRepo) Update(ctx context.Context, patientImport *models.PatientImport) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, stored := range m.imports {
+		if stored.ID == patientImport.ID {
+			updated := *patientImport
+			updated.ClinicID = stored.ClinicID
+			updated.CreatedAt = stored.CreatedAt
+			m.imports[i] = &updated
+			return nil
+		}
+	}
+	return fmt.Errorf("patient import %s not found", patientImport.ID)
+}
+
+func (m *MockPatientImportRepo) FindByID(ctx context.Context, id uuid.UUID) (*models.PatientImport, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, stored := range m.imports {
+		if stored.ID == id && inClinic(ctx, stored.ClinicID) {
+			found := *stored
+			return &found, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *MockPatientImportRepo) List(ctx context.Context, limit int) ([]models.PatientImport, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	imports := []models.PatientImport{}
+	for i := len(m.imports) - 1; i >= 0 && len(imports) < limit; i-- {
+		if inClinic(ctx, m.imports[i].ClinicID) {
+			imports = append(imports, *m.imports[i])
+		}
+	}
+	return imports, nil
+}
+
+func (m *MockPatientImportRepo) AddErrors(ctx context.Context, importID uuid.UUID, importErrors []models.PatientImportError) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.errors[importID] = append(m.errors[importID], importErrors...)
+	return nil
+}
+
+func (m *MockPatientImportRepo) ListErrors(ctx context.Context, importID uuid.UUID) ([]models.PatientImportError, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	importErrors := append([]models.PatientImportError{}, m.errors[importID]...)
+	slices.SortStableFunc(importErrors, func(a, b models.PatientImportError) int { return a.Row - b.Row })
+	return importErrors, nil
+}
+
+func (m *MockPatientImportRepo) FailUnfinished(ctx context.Context, reason string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var count int64
+	now := time.Now()
+	for _, stored := range m.imports {
+		if !stored.Finished() {
+			stored.Status = models.PatientImportFailed
+			stored.Error = reason
+			stored.FinishedAt = &now
+			count++
+		}
+	}
+	return count, nil
+}
+
+// MockPatientExportRepo implementations
+func (m *MockPatientExportRepo) Create(ctx context.Context, export *models.PatientExport) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	export.ID = uuid.New()
+	export.ClinicID = clinicForInsert(ctx, export.ClinicID)
+	export.CreatedAt = time.Now()
+	stored := *export
+	m.exports[export.ID] = &stored
+	return nil
+}
+
+func (m *MockPatientExportRepo) Update(ctx context.Context, export *models.PatientExport) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if stored, exists := m.exports[export.ID]; exists {
+		updated := *export
+		updated.ClinicID = stored.ClinicID
+		updated.CreatedAt = stored.CreatedAt
+		m.exports[export.ID] = &updated
+	}
+	return nil
+}
+
+func (m *MockPatientExportRepo) FindByID(ctx context.Context, id uuid.UUID) (*models.PatientExport, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if stored, exists := m.exports[id]; exists && inClinic(ctx, stored.ClinicID) {
+		found := *stored
+		found.PatientIDs = slices.Clone(stored.PatientIDs)
+		return &found, nil
+	}
+	return nil, nil
+}
+
+func (m *MockPatientExportRepo) Delete(ctx context.Context, id uuid.UUID) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if stored, exists := m.exports[id]; exists && inClinic(ctx, stored.ClinicID) {
+		delete(m.exports, id)
+		return true, nil
+	}
+	return false, nil
+}
+
+func (m *MockPatientExportRepo) FailUnfinished(ctx context.Context, reason string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var count int64
+	now := time.Now()
+	for _, stored := range m.exports {
+		if !stored.Finished() {
+			stored.Status = models.PatientExportFailed
+			stored.Error = reason
+			stored.FinishedAt = &now
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (m *MockPatientExportRepo) Purge(ctx context.Context, before time.Time, dryRun bool) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var count int64
+	for id, stored := range m.exports {
+		if stored.CreatedAt.Before(before) {
+			count++
+			if !dryRun {
+				delete(m.exports, id)
+			}
+		}
+	}
+	return count, nil
+}
@@ -0,0 +1,414 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/yhwbach/makerble/internal/models"
+	"github.com/yhwbach/makerble/internal/schemas"
+)
+
+// patientListFilter matches the patients lists return for the query passed by patientListArgs,
+// leaving out archived patients
+const patientListFilter = careTeamFilter + ` AND ` + consentFilter + `
+	AND ($3::uuid IS NULL OR p.clinic_id = $3) AND p.archived_at IS NULL
+	AND ($4::uuid[] IS NULL OR p.id = ANY($4)) AND ($5::timestamptz IS NULL OR p.updated_at >= $5)`
+
+func patientListArgs(ctx context.Context, filter schemas.PatientQuery) []interface{} {
+	return []interface{}{filter.CareTeamMember, filter.Consent, clinicArg(ctx), pq.Array(filter.IDs), filter.UpdatedSince}
+}
+
+// patientListWhere returns the conditions of the patients lists return for the query, with the
+// criteria of its search, and their arguments
+func patientListWhere(ctx context.Context, filter schemas.PatientQuery) (string, []interface{}) {
+	where := patientListFilter
+	args := patientListArgs(ctx, filter)
+	arg := func(value interface{}) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	for _, criterion := range filter.Search {
+		var conditions []string
+		if len(criterion.IDs) > 0 {
+			conditions = append(conditions, "p.id = ANY("+arg(pq.Array(criterion.IDs))+"::uuid[])")
+		}
+		if len(criterion.Genders) > 0 {
+			genders := make([]string, 0, len(criterion.Genders))
+			for _, gender := range criterion.Genders {
+				genders = append(genders, string(gender))
+			}
+			conditions = append(conditions, "p.gender::text = ANY("+arg(pq.Array(genders))+"::text[])")
+		}
+		for _, name := range criterion.Names {
+			conditions = append(conditions, nameCondition(name, arg))
+		}
+		for _, dates := range criterion.BirthDates {
+			conditions = append(conditions, dateCondition("p.date_of_birth", dates, arg))
+		}
+
+		if len(conditions) == 0 {
+			where += " AND FALSE"
+		} else {
+			where += " AND (" + strings.Join(conditions, " OR ") + ")"
+		}
+	}
+
+	return where, args
+}
+
+// nameCondition matches full names as the name match does. Names match by default when they
+// start with the value, or when one of their words does, which only values without spaces can.
+func nameCondition(name schemas.NameMatch, arg func(interface{}) string) string {
+	value := likeEscaper.Replace(name.Value)
+	switch {
+	case name.Exact:
+		return "p.full_name = " + arg(name.Value)
+	case name.Contains:
+		return "lower(p.full_name) LIKE lower(" + arg("%"+value+"%") + ")"
+	case strings.ContainsFunc(name.Value, unicode.IsSpace):
+		return "lower(p.full_name) LIKE lower(" + arg(value+"%") + ")"
+	default:
+		return `' ' || lower(regexp_replace(p.full_name, '\s+', ' ', 'g')) LIKE lower(` + arg("% "+value+"%") + ")"
+	}
+}
+
+// likeEscaper escapes the wildcards of LIKE patterns
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// dateCondition matches the dates of the column in the range
+func dateCondition(column string, dates schemas.DateRange, arg func(interface{}) string) string {
+	conditions := []string{"TRUE"}
+	if dates.From != nil {
+		conditions = append(conditions, column+" >= "+arg(dates.From.Format("2006-01-02"))+"::date")
+	}
+	if dates.Until != nil {
+		conditions = append(conditions, column+" < "+arg(dates.Until.Format("2006-01-02"))+"::date")
+	}
+
+	condition := "(" + strings.Join(conditions, " AND ") + ")"
+	if dates.Outside {
+		return "NOT " + condition
+	}
+	return condition
+}
+
+// patientPage returns the LIMIT and OFFSET clauses paging the list, appending their arguments
+func patientPage(filter schemas.PatientQuery, args []interface{}) (string, []interface{}) {
+	if filter.Limit <= 0 {
+		return "", args
+	}
+	args = append(args, filter.Limit, filter.Offset)
+	return fmt.Sprintf(" LIMIT $%d OFFSET $%d", len(args)-1, len(args)), args
+}
+
+// Stream calls fn with each patient matching the query, in the order of FindAll, decrypting them
+// one at a time as they are read rather than loading them all. It stops at the first error fn
+// returns, returning it as is.
+func (p *PatientRepoStorage) Stream(ctx context.Context, filter schemas.PatientQuery, fn func(schemas.Patients) error) error {
+	where, args := patientListWhere(ctx, filter)
+	page, args := patientPage(filter, args)
+
+	// Patients transferred in were registered by users of another clinic, who are hidden by
+	// row-level security, hence the outer join
+	query := `
+		SELECT ` + patientColumns + `, ` + activeConsents + `,
+			COALESCE(u.id::text, '') as user_id, COALESCE(u.full_name, '') as user_full_name
+		FROM patients p
+		LEFT JOIN users u ON p.registered_by = u.id
+		WHERE ` + where + `
+		ORDER BY p.created_at DESC, p.id` + page
+
+	rows, err := p.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var patient models.Patient
+		var user struct {
+			ID       string
+			FullName string
+		}
+
+		if err := p.scanPatient(rows, &patient, pq.Array(&patient.Consents), &user.ID, &user.FullName); err != nil {
+			return err
+		}
+
+		patientWithUser := schemas.Patients{
+			Patient: &patient,
+			RegisteredByUser: struct {
+				ID       string `json:"id"`
+				FullName string `json:"full_name"`
+			}{
+				ID:       user.ID,
+				FullName: user.FullName,
+			},
+		}
+
+		if err := fn(patientWithUser); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// FindIDs retrieves the IDs of the patients matching the query, in the order of FindAll
+func (p *PatientRepoStorage) FindIDs(ctx context.Context, filter schemas.PatientQuery) ([]uuid.UUID, error) {
+	where, args := patientListWhere(ctx, filter)
+	page, args := patientPage(filter, args)
+	query := `SELECT p.id FROM patients p WHERE ` + where + ` ORDER BY p.created_at DESC, p.id` + page
+
+	rows, err := p.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find patients: %w", err)
+	}
+	defer rows.Close()
+
+	ids := []uuid.UUID{}
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to find patients: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to find patients: %w", err)
+	}
+
+	return ids, nil
+}
+
+// Count counts the patients matching the query, regardless of its paging
+func (p *PatientRepoStorage) Count(ctx context.Context, filter schemas.PatientQuery) (int, error) {
+	where, args := patientListWhere(ctx, filter)
+
+	var count int
+	if err := p.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM patients p WHERE `+where, args...).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count patients: %w", err)
+	}
+
+	return count, nil
+}
+
+// importColumns are the columns of the patients of an import batch, copied into a staging table
+// before they are inserted
+var importColumns = []string{
+	"id", "full_name", "date_of_birth", "gender", "data_key_id", "data_key",
+	"encrypted_address", "encrypted_phone", "encrypted_email", "encrypted_medical_history", "email_index",
+}
+
+// Import inserts a batch of patients read from an import file, leaving out and returning those
+// matching an existing patient by email, or by name and date of birth. The patients are encrypted
+// and copied into a staging table with COPY, as row-level security does not allow COPY into the
+// patients table, then inserted from there along with the audit event of the batch.
+func (p *PatientRepoStorage) Import(ctx context.Context, batch *models.PatientImportBatch) ([]models.PatientDuplicate, error) {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to import patients: %w", err)
+	}
+	defer tx.Rollback()
+
+	duplicates, err := p.findDuplicates(ctx, tx, batch.Patients)
+	if err != nil {
+		return nil, err
+	}
+	duplicated := make(map[int]bool, len(duplicates))
+	for _, duplicate := range duplicates {
+		duplicated[duplicate.Index] = true
+	}
+
+	if _, err := tx.ExecContext(ctx, `CREATE TEMPORARY TABLE patient_import_rows ON COMMIT DROP AS
+		SELECT `+strings.Join(importColumns, ", ")+` FROM patients WITH NO DATA`); err != nil {
+		return nil, fmt.Errorf("failed to create import staging table: %w", err)
+	}
+
+	stmt, err := tx.PrepareContext(ctx, pq.CopyIn("patient_import_rows", importColumns...))
+	if err != nil {
+		return nil, fmt.Errorf("failed to import patients: %w", err)
+	}
+	defer stmt.Close()
+
+	indexes := make(map[uuid.UUID]int, len(batch.Patients))
+	for i, patient := range batch.Patients {
+		if duplicated[i] {
+			continue
+		}
+
+		// The ID is generated up front as the encrypted fields are bound to it
+		patient.ID = uuid.New()
+		patient.RegisteredBy = batch.ImportedBy
+		indexes[patient.ID] = i
+
+		sealed, err := p.seal(patient)
+		if err != nil {
+			return nil, err
+		}
+		// COPY writes nil byte slices as empty values rather than NULL
+		var emailIndex interface{}
+		if sealed.emailIndex != nil {
+			emailIndex = sealed.emailIndex
+		}
+		_, err = stmt.ExecContext(ctx,
+			patient.ID, patient.FullName, patient.DateOfBirth.Format("2006-01-02"), patient.Gender,
+			sealed.dataKeyID, sealed.dataKey,
+			sealed.ciphertext[0], sealed.ciphertext[1], sealed.ciphertext[2], sealed.ciphertext[3], emailIndex,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to copy patients: %w", err)
+		}
+	}
+	if _, err := stmt.ExecContext(ctx); err != nil {
+		return nil, fmt.Errorf("failed to copy patients: %w", err)
+	}
+	if err := stmt.Close(); err != nil {
+		return nil, fmt.Errorf("failed to copy patients: %w", err)
+	}
+
+	// Emails are unique across clinics, so a patient can still clash with one that row-level
+	// security hides from the search for duplicates
+	query := `
+		INSERT INTO patients (registered_by, clinic_id, ` + strings.Join(importColumns, ", ") + `)
+		SELECT $1::uuid, $2::uuid, ` + strings.Join(importColumns, ", ") + ` FROM patient_import_rows
+		ON CONFLICT DO NOTHING
+		RETURNING id
+	`
+	rows, err := tx.QueryContext(ctx, query, batch.ImportedBy, clinicForInsert(ctx, uuid.Nil))
+	if err != nil {
+		return nil, fmt.Errorf("failed to import patients: %w", err)
+	}
+	var patientIDs []string
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to import patients: %w", err)
+		}
+		patientIDs = append(patientIDs, id.String())
+		delete(indexes, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to import patients: %w", err)
+	}
+
+	for _, i := range indexes {
+		duplicates = append(duplicates, models.PatientDuplicate{Index: i, Match: "email"})
+	}
+
+	if len(patientIDs) > 0 {
+		err = insertAuditEvent(ctx, tx, &models.AuditEvent{
+			Severity: models.AuditSeverityInfo,
+			Action:   models.AuditActionPatientImport,
+			ActorID:  &batch.ImportedBy,
+			Fields:   batch.Fields,
+			Details: map[string]interface{}{
+				"patient_ids": patientIDs,
+				"count":       len(patientIDs),
+				"import_id":   batch.ImportID,
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to import patients: %w", err)
+	}
+
+	return duplicates, nil
+}
+
+// findDuplicates finds the patients matching an existing patient of the clinic of the context by
+// email, or by name and date of birth. Names are compared ignoring case.
+func (p *PatientRepoStorage) findDuplicates(ctx context.Context, tx *sql.Tx, patients []*models.Patient) ([]models.PatientDuplicate, error) {
+	var blindIndexes [][]byte
+	var emails, names, datesOfBirth []string
+	for _, patient := range patients {
+		if patient.Email != "" {
+			email := normalizeEmail(patient.Email)
+			blindIndexes = append(blindIndexes, p.keys.BlindIndexes(email)...)
+			emails = append(emails, email)
+		}
+		names = append(names, strings.ToLower(patient.FullName))
+		datesOfBirth = append(datesOfBirth, patient.DateOfBirth.Format("2006-01-02"))
+	}
+
+	query := `
+		SELECT p.id, p.email_index, COALESCE(lower(p.email), ''), lower(p.full_name), p.date_of_birth
+		FROM patients p
+		WHERE (p.email_index = ANY($1) OR (p.data_key IS NULL AND lower(p.email) = ANY($2))
+				OR (lower(p.full_name), p.date_of_birth) IN (SELECT * FROM unnest($3::text[], $4::date[])))
+			AND ($5::uuid IS NULL OR p.clinic_id = $5)
+	`
+	rows, err := tx.QueryContext(ctx, query,
+		pq.ByteaArray(blindIndexes), pq.Array(emails), pq.Array(names), pq.Array(datesOfBirth), clinicArg(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("failed to find duplicate patients: %w", err)
+	}
+	defer rows.Close()
+
+	byEmail := map[string]uuid.UUID{}
+	byName := map[string]uuid.UUID{}
+	for rows.Next() {
+		var existing models.Patient
+		var emailIndex []byte
+		var email string
+		if err := rows.Scan(&existing.ID, &emailIndex, &email, &existing.FullName, &existing.DateOfBirth); err != nil {
+			return nil, fmt.Errorf("failed to find duplicate patients: %w", err)
+		}
+		if emailIndex != nil {
+			byEmail[string(emailIndex)] = existing.ID
+		}
+		if email != "" {
+			byEmail[email] = existing.ID
+		}
+		byName[nameAndDateOfBirth(&existing)] = existing.ID
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to find duplicate patients: %w", err)
+	}
+
+	var duplicates []models.PatientDuplicate
+	for i, patient := range patients {
+		if patient.Email != "" {
+			email := normalizeEmail(patient.Email)
+			keys := []string{email}
+			for _, blindIndex := range p.keys.BlindIndexes(email) {
+				keys = append(keys, string(blindIndex))
+			}
+			if id, found := firstMatch(byEmail, keys); found {
+				duplicates = append(duplicates, models.PatientDuplicate{Index: i, PatientID: &id, Match: "email"})
+				continue
+			}
+		}
+		if id, found := byName[nameAndDateOfBirth(patient)]; found {
+			duplicates = append(duplicates, models.PatientDuplicate{Index: i, PatientID: &id, Match: "full_name"})
+		}
+	}
+
+	return duplicates, nil
+}
+
+// nameAndDateOfBirth is the key patients with the same name and date of birth share
+func nameAndDateOfBirth(patient *models.Patient) string {
+	return strings.ToLower(patient.FullName) + "\x00" + patient.DateOfBirth.Format("2006-01-02")
+}
+
+func firstMatch(matches map[string]uuid.UUID, keys []string) (uuid.UUID, bool) {
+	for _, key := range keys {
+		if id, found := matches[key]; found {
+			return id, true
+		}
+	}
+	return uuid.Nil, false
+}
@@ -0,0 +1,261 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/yhwbach/makerble/internal/models"
+)
+
+// patientColumns are the patient columns scanPatient reads, from a query aliasing patients as p
+const patientColumns = `p.id, p.full_name, p.date_of_birth, p.gender, p.registered_by, p.clinic_id, p.created_at, p.updated_at, p.archived_at,
+	p.address, p.phone, p.email, p.medical_history, p.data_key_id, p.data_key,
+	p.encrypted_address, p.encrypted_phone, p.encrypted_email, p.encrypted_medical_history`
+
+// sensitivePatientColumns are the columns of the patient fields encrypted at rest
+var sensitivePatientColumns = [4]string{"address", "phone", "email", "medical_history"}
+
+func sensitivePatientFields(patient *models.Patient) [4]*string {
+	return [4]*string{&patient.Address, &patient.Phone, &patient.Email, &patient.MedicalHistory}
+}
+
+// sealedPatient holds the sensitive fields of a patient as stored: encrypted with the data key,
+// or in the plaintext columns for patients stored before encryption
+type sealedPatient struct {
+	plaintext  [4]sql.NullString
+	dataKeyID  sql.NullString
+	dataKey    []byte
+	ciphertext [4][]byte
+	emailIndex []byte
+}
+
+// scanPatient scans the patient columns, followed by any extra destinations, and decrypts the
+// sensitive fields. Scan errors are returned as is.
+func (p *PatientRepoStorage) scanPatient(row rowScanner, patient *models.Patient, extra ...interface{}) error {
+	var sealed sealedPatient
+	dest := []interface{}{
+		&patient.ID, &patient.FullName, &patient.DateOfBirth, &patient.Gender,
+		&patient.RegisteredBy, &patient.ClinicID, &patient.CreatedAt, &patient.UpdatedAt, &patient.ArchivedAt,
+	}
+	for i := range sealed.plaintext {
+		dest = append(dest, &sealed.plaintext[i])
+	}
+	dest = append(dest, &sealed.dataKeyID, &sealed.dataKey)
+	for i := range sealed.ciphertext {
+		dest = append(dest, &sealed.ciphertext[i])
+	}
+
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return err
+	}
+
+	return p.open(patient, &sealed)
+}
+
+// open sets the sensitive fields of the patient from their stored form
+func (p *PatientRepoStorage) open(patient *models.Patient, sealed *sealedPatient) error {
+	fields := sensitivePatientFields(patient)
+
+	if sealed.dataKey == nil {
+		for i, field := range fields {
+			*field = sealed.plaintext[i].String
+		}
+		return nil
+	}
+
+	dataKey, err := p.keys.Unwrap(sealed.dataKeyID.String, sealed.dataKey)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt patient %s: %w", patient.ID, err)
+	}
+
+	for i, field := range fields {
+		value, err := dataKey.Decrypt(sealed.ciphertext[i], encryptionContext(patient.ID, sensitivePatientColumns[i]))
+		if err != nil {
+			return fmt.Errorf("failed to decrypt %s of patient %s: %w", sensitivePatientColumns[i], patient.ID, err)
+		}
+		*field = value
+	}
+	return nil
+}
+
+// seal encrypts the sensitive fields of the patient with a new data key
+func (p *PatientRepoStorage) seal(patient *models.Patient) (*sealedPatient, error) {
+	dataKey, err := p.keys.NewDataKey()
+	if err != nil {
+		return nil, err
+	}
+
+	sealed := &sealedPatient{
+		dataKeyID: sql.NullString{String: dataKey.KeyID, Valid: true},
+		dataKey:   dataKey.Wrapped,
+	}
+	for i, field := range sensitivePatientFields(patient) {
+		sealed.ciphertext[i], err = dataKey.Encrypt(*field, encryptionContext(patient.ID, sensitivePatientColumns[i]))
+		if err != nil {
+			return nil, fmt.Errorf("failed to encrypt %s: %w", sensitivePatientColumns[i], err)
+		}
+	}
+
+	if patient.Email != "" {
+		sealed.emailIndex = p.keys.BlindIndex(normalizeEmail(patient.Email))
+	}
+
+	return sealed, nil
+}
+
+// writeSealed stores the patient with its sensitive fields encrypted, clearing the plaintext columns
+func writeSealed(ctx context.Context, tx *sql.Tx, patient *models.Patient, sealed *sealedPatient) error {
+	query := `
+		UPDATE patients
+		SET full_name = $1, date_of_birth = $2, gender = $3,
+			address = NULL, phone = NULL, email = NULL, medical_history = NULL,
+			data_key_id = $4, data_key = $5,
+			encrypted_address = $6, encrypted_phone = $7, encrypted_email = $8, encrypted_medical_history = $9,
+			email_index = $10, updated_at = $11, archived_at = $12
+		WHERE id = $13
+	`
+
+	_, err := tx.ExecContext(ctx, query,
+		patient.FullName, patient.DateOfBirth, patient.Gender,
+		sealed.dataKeyID, sealed.dataKey,
+		sealed.ciphertext[0], sealed.ciphertext[1], sealed.ciphertext[2], sealed.ciphertext[3],
+		sealed.emailIndex, patient.UpdatedAt, patient.ArchivedAt, patient.ID,
+	)
+	return err
+}
+
+// encryptionContext binds an encrypted value to the patient and column it is stored in
+func encryptionContext(patientID uuid.UUID, column string) string {
+	return "patients/" + patientID.String() + "/" + column
+}
+
+// normalizeEmail is the form of an email address its blind index is computed from, so that
+// lookups do not depend on case
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// EncryptPlaintext encrypts the sensitive fields of up to batchSize patients stored before field
+// encryption and returns how many it encrypted
+func (p *PatientRepoStorage) EncryptPlaintext(ctx context.Context, batchSize int) (int, error) {
+	tx, err := p.db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to encrypt patients: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `SELECT ` + patientColumns + ` FROM patients p WHERE p.data_key IS NULL
+		ORDER BY p.id LIMIT $1 FOR UPDATE SKIP LOCKED`
+
+	rows, err := tx.QueryContext(ctx, query, batchSize)
+	if err != nil {
+		return 0, fmt.Errorf("failed to encrypt patients: %w", err)
+	}
+
+	var patients []*models.Patient
+	for rows.Next() {
+		var patient models.Patient
+		if err := p.scanPatient(rows, &patient); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to encrypt patients: %w", err)
+		}
+		patients = append(patients, &patient)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to encrypt patients: %w", err)
+	}
+
+	for _, patient := range patients {
+		sealed, err := p.seal(patient)
+		if err != nil {
+			return 0, err
+		}
+		if err := writeSealed(ctx, tx, patient, sealed); err != nil {
+			return 0, fmt.Errorf("failed to encrypt patient %s: %w", patient.ID, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to encrypt patients: %w", err)
+	}
+
+	return len(patients), nil
+}
+
+// RotateKeys re-wraps the data keys of up to batchSize patients that were wrapped by a previous
+// master key with the current one, and recomputes their email blind index. The encrypted fields
+// are left untouched. It returns how many patients it rotated.
+func (p *PatientRepoStorage) RotateKeys(ctx context.Context, batchSize int) (int, error) {
+	tx, err := p.db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to rotate patient keys: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `SELECT id, data_key_id, data_key, encrypted_email FROM patients
+		WHERE data_key IS NOT NULL AND data_key_id <> $1
+		ORDER BY id LIMIT $2 FOR UPDATE SKIP LOCKED`
+
+	rows, err := tx.QueryContext(ctx, query, p.keys.CurrentKeyID(), batchSize)
+	if err != nil {
+		return 0, fmt.Errorf("failed to rotate patient keys: %w", err)
+	}
+
+	type stored struct {
+		id             uuid.UUID
+		dataKeyID      string
+		dataKey        []byte
+		encryptedEmail []byte
+	}
+	var patients []stored
+	for rows.Next() {
+		var s stored
+		if err := rows.Scan(&s.id, &s.dataKeyID, &s.dataKey, &s.encryptedEmail); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to rotate patient keys: %w", err)
+		}
+		patients = append(patients, s)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to rotate patient keys: %w", err)
+	}
+
+	for _, s := range patients {
+		dataKey, err := p.keys.Unwrap(s.dataKeyID, s.dataKey)
+		if err != nil {
+			return 0, fmt.Errorf("failed to rotate key of patient %s: %w", s.id, err)
+		}
+
+		rewrapped, err := p.keys.Rewrap(dataKey)
+		if err != nil {
+			return 0, err
+		}
+
+		email, err := dataKey.Decrypt(s.encryptedEmail, encryptionContext(s.id, "email"))
+		if err != nil {
+			return 0, fmt.Errorf("failed to decrypt email of patient %s: %w", s.id, err)
+		}
+		var emailIndex []byte
+		if email != "" {
+			emailIndex = p.keys.BlindIndex(normalizeEmail(email))
+		}
+
+		if _, err := tx.ExecContext(ctx,
+			`UPDATE patients SET data_key_id = $1, data_key = $2, email_index = $3 WHERE id = $4`,
+			rewrapped.KeyID, rewrapped.Wrapped, emailIndex, s.id,
+		); err != nil {
+			return 0, fmt.Errorf("failed to rotate key of patient %s: %w", s.id, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to rotate patient keys: %w", err)
+	}
+
+	return len(patients), nil
+}
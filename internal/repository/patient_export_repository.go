@@ -0,0 +1,118 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/yhwbach/makerble/internal/models"
+)
+
+type PatientExportRepoStorage struct {
+	db *sql.DB
+}
+
+const patientExportColumns = `id, clinic_id, created_by, request, status, patient_ids, error, created_at, finished_at`
+
+// Create stores a new export in the clinic of the context
+func (p *PatientExportRepoStorage) Create(ctx context.Context, export *models.PatientExport) error {
+	export.ClinicID = clinicForInsert(ctx, export.ClinicID)
+
+	query := `
+		INSERT INTO patient_exports (clinic_id, created_by, request, status)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at
+	`
+	err := p.db.QueryRowContext(ctx, query,
+		export.ClinicID, export.CreatedBy, export.Request, export.Status,
+	).Scan(&export.ID, &export.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create patient export: %w", err)
+	}
+
+	return nil
+}
+
+// Update records the status of an export and the patients it selected. Exports deleted in the
+// meantime are left deleted.
+func (p *PatientExportRepoStorage) Update(ctx context.Context, export *models.PatientExport) error {
+	patientIDs := export.PatientIDs
+	if patientIDs == nil {
+		patientIDs = []uuid.UUID{}
+	}
+
+	query := `
+		UPDATE patient_exports SET status = $1, patient_ids = $2, error = $3, finished_at = $4
+		WHERE id = $5
+	`
+	_, err := p.db.ExecContext(ctx, query,
+		export.Status, pq.Array(patientIDs), export.Error, export.FinishedAt, export.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update patient export: %w", err)
+	}
+
+	return nil
+}
+
+// FindByID retrieves an export, returning nil if it does not exist in the clinic of the context
+func (p *PatientExportRepoStorage) FindByID(ctx context.Context, id uuid.UUID) (*models.PatientExport, error) {
+	query := `SELECT ` + patientExportColumns + ` FROM patient_exports
+		WHERE id = $1 AND ($2::uuid IS NULL OR clinic_id = $2)`
+
+	var export models.PatientExport
+	err := p.db.QueryRowContext(ctx, query, id, clinicArg(ctx)).Scan(
+		&export.ID, &export.ClinicID, &export.CreatedBy, &export.Request, &export.Status,
+		pq.Array(&export.PatientIDs), &export.Error, &export.CreatedAt, &export.FinishedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find patient export: %w", err)
+	}
+
+	return &export, nil
+}
+
+// Delete deletes an export of the clinic of the context, reporting whether it existed
+func (p *PatientExportRepoStorage) Delete(ctx context.Context, id uuid.UUID) (bool, error) {
+	result, err := p.db.ExecContext(ctx,
+		`DELETE FROM patient_exports WHERE id = $1 AND ($2::uuid IS NULL OR clinic_id = $2)`, id, clinicArg(ctx))
+	if err != nil {
+		return false, fmt.Errorf("failed to delete patient export: %w", err)
+	}
+
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to delete patient export: %w", err)
+	}
+	return deleted > 0, nil
+}
+
+// FailUnfinished marks the exports left unfinished, by a restart of the server, as failed with
+// the reason given and returns how many there were
+func (p *PatientExportRepoStorage) FailUnfinished(ctx context.Context, reason string) (int64, error) {
+	query := `
+		UPDATE patient_exports SET status = $1, error = $2, finished_at = NOW()
+		WHERE status IN ($3, $4)
+	`
+	result, err := p.db.ExecContext(ctx, query,
+		models.PatientExportFailed, reason, models.PatientExportPending, models.PatientExportRunning)
+	if err != nil {
+		return 0, fmt.Errorf("failed to fail unfinished patient exports: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+// Purge deletes the exports started before the cutoff, or only counts them with dryRun
+func (p *PatientExportRepoStorage) Purge(ctx context.Context, before time.Time, dryRun bool) (int64, error) {
+	count, err := purgeRows(ctx, p.db, `patient_exports WHERE created_at < $1`, dryRun, before)
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge patient exports: %w", err)
+	}
+
+	return count, nil
+}
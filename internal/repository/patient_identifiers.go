@@ -0,0 +1,198 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/yhwbach/makerble/internal/models"
+)
+
+// ErrIdentifierTaken is returned when an identifier already belongs to another patient
+var ErrIdentifierTaken = errors.New("identifier belongs to another patient")
+
+// FindByIdentifier retrieves the patient another system knows by the identifier, returning nil
+// if there is none in the clinic of the context
+func (p *PatientRepoStorage) FindByIdentifier(ctx context.Context, identifier models.PatientIdentifier) (*models.Patient, error) {
+	query := `SELECT ` + patientColumns + `, ` + activeConsents + `
+		FROM patients p
+		JOIN patient_identifiers i ON i.patient_id = p.id AND i.clinic_id = p.clinic_id
+		WHERE i.system = $1 AND i.value = $2 AND ($3::uuid IS NULL OR p.clinic_id = $3)`
+
+	row := p.db.QueryRowContext(ctx, query, identifier.System, identifier.Value, clinicArg(ctx))
+
+	var patient models.Patient
+	if err := p.scanPatient(row, &patient, pq.Array(&patient.Consents)); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to find patient by identifier: %w", err)
+	}
+
+	return &patient, nil
+}
+
+// AddIdentifiers records identifiers of the patient. Identifiers the patient already has are
+// skipped; ErrIdentifierTaken is returned for an identifier of another patient.
+func (p *PatientRepoStorage) AddIdentifiers(ctx context.Context, patientID uuid.UUID, identifiers []models.PatientIdentifier) error {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to add patient identifiers: %w", err)
+	}
+	defer tx.Rollback()
+
+	var clinicID uuid.UUID
+	query := `SELECT clinic_id FROM patients WHERE id = $1 AND ($2::uuid IS NULL OR clinic_id = $2)`
+	if err := tx.QueryRowContext(ctx, query, patientID, clinicArg(ctx)).Scan(&clinicID); err != nil {
+		return fmt.Errorf("failed to add patient identifiers: %w", err)
+	}
+
+	if err := addIdentifiers(ctx, tx, patientID, clinicID, identifiers); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to add patient identifiers: %w", err)
+	}
+
+	return nil
+}
+
+// addIdentifiers records identifiers of the patient of the clinic in the transaction. An
+// identifier another transaction is adding waits for it to complete, and is then taken or added.
+func addIdentifiers(ctx context.Context, tx *sql.Tx, patientID, clinicID uuid.UUID, identifiers []models.PatientIdentifier) error {
+	for _, identifier := range identifiers {
+		var owner uuid.UUID
+		err := tx.QueryRowContext(ctx, `
+			INSERT INTO patient_identifiers (patient_id, clinic_id, system, value)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT (clinic_id, system, value) DO UPDATE SET system = EXCLUDED.system
+			RETURNING patient_id
+		`, patientID, clinicID, identifier.System, identifier.Value).Scan(&owner)
+		if err != nil {
+			return fmt.Errorf("failed to add patient identifier: %w", err)
+		}
+		if owner != patientID {
+			return fmt.Errorf("%w: %s %s", ErrIdentifierTaken, identifier.System, identifier.Value)
+		}
+	}
+	return nil
+}
+
+// patientReferences are the tables referring to patients, which a merge moves to the surviving patient
+var patientReferences = []string{
+	"patient_identifiers", "care_team_assignments", "emergency_access_grants", "patient_consents", "patient_transfers",
+}
+
+// Merge merges a duplicate record into the surviving patient, as when two records turn out to be
+// the same person. Identifiers, care team, consents, emergency access and transfers move to the
+// survivor, which also takes the fields it lacks from the duplicate; medical histories are joined.
+// The duplicate is deleted and the merge recorded in the audit log. It returns false when either
+// patient does not exist in the clinic of the context.
+func (p *PatientRepoStorage) Merge(ctx context.Context, merge *models.PatientMerge) (bool, error) {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to merge patients: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `SELECT ` + patientColumns + ` FROM patients p
+		WHERE id = ANY($1) AND ($2::uuid IS NULL OR clinic_id = $2)
+		ORDER BY id FOR UPDATE`
+
+	rows, err := tx.QueryContext(ctx, query, pq.Array([]uuid.UUID{merge.SurvivorID, merge.MergedID}), clinicArg(ctx))
+	if err != nil {
+		return false, fmt.Errorf("failed to merge patients: %w", err)
+	}
+	var survivor, merged *models.Patient
+	for rows.Next() {
+		var patient models.Patient
+		if err := p.scanPatient(rows, &patient); err != nil {
+			rows.Close()
+			return false, fmt.Errorf("failed to merge patients: %w", err)
+		}
+		switch patient.ID {
+		case merge.SurvivorID:
+			survivor = &patient
+		case merge.MergedID:
+			merged = &patient
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return false, fmt.Errorf("failed to merge patients: %w", err)
+	}
+	if survivor == nil || merged == nil || survivor.ID == merged.ID {
+		return false, nil
+	}
+
+	for _, table := range patientReferences {
+		if _, err := tx.ExecContext(ctx,
+			`UPDATE `+table+` SET patient_id = $1 WHERE patient_id = $2`, survivor.ID, merged.ID,
+		); err != nil {
+			return false, fmt.Errorf("failed to move %s: %w", table, err)
+		}
+	}
+
+	var fields []string
+	for _, field := range []struct {
+		name     string
+		to, from *string
+	}{
+		{"address", &survivor.Address, &merged.Address},
+		{"phone", &survivor.Phone, &merged.Phone},
+		{"email", &survivor.Email, &merged.Email},
+	} {
+		if *field.to == "" && *field.from != "" {
+			*field.to = *field.from
+			fields = append(fields, field.name)
+		}
+	}
+	if merged.MedicalHistory != "" && merged.MedicalHistory != survivor.MedicalHistory {
+		if survivor.MedicalHistory != "" {
+			survivor.MedicalHistory += "\n\n"
+		}
+		survivor.MedicalHistory += merged.MedicalHistory
+		fields = append(fields, "medical_history")
+	}
+
+	// The duplicate goes first, so that the survivor can take over its email
+	if _, err := tx.ExecContext(ctx, `DELETE FROM patients WHERE id = $1`, merged.ID); err != nil {
+		return false, fmt.Errorf("failed to delete merged patient: %w", err)
+	}
+
+	survivor.UpdatedAt = time.Now()
+	survivor.ArchivedAt = nil
+	sealed, err := p.seal(survivor)
+	if err != nil {
+		return false, err
+	}
+	if err := writeSealed(ctx, tx, survivor, sealed); err != nil {
+		return false, fmt.Errorf("failed to merge patients: %w", err)
+	}
+
+	err = insertAuditEvent(ctx, tx, &models.AuditEvent{
+		Severity:  models.AuditSeverityInfo,
+		Action:    models.AuditActionPatientMerge,
+		ActorID:   &merge.MergedBy,
+		PatientID: &survivor.ID,
+		Fields:    fields,
+		Details: map[string]interface{}{
+			"merged_patient_id": merged.ID,
+			"source":            merge.Source,
+		},
+	})
+	if err != nil {
+		return false, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to merge patients: %w", err)
+	}
+
+	return true, nil
+}
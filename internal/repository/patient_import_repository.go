@@ -0,0 +1,196 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/yhwbach/makerble/internal/models"
+)
+
+type PatientImportRepoStorage struct {
+	db *sql.DB
+}
+
+const patientImportColumns = `id, clinic_id, created_by, filename, format, status,
+	total_rows, imported_rows, invalid_rows, duplicate_rows, error, created_at, finished_at`
+
+// Create stores a new import in the clinic of the context
+func (p *PatientImportRepoStorage) Create(ctx context.Context, patientImport *models.PatientImport) error {
+	patientImport.ClinicID = clinicForInsert(ctx, patientImport.ClinicID)
+
+	query := `
+		INSERT INTO patient_imports (clinic_id, created_by, filename, format, status, total_rows)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at
+	`
+	err := p.db.QueryRowContext(ctx, query,
+		patientImport.ClinicID, patientImport.CreatedBy, patientImport.Filename, patientImport.Format,
+		patientImport.Status, patientImport.TotalRows,
+	).Scan(&patientImport.ID, &patientImport.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create patient import: %w", err)
+	}
+
+	return nil
+}
+
+// Update records the status and progress of an import
+func (p *PatientImportRepoStorage) Update(ctx context.Context, patientImport *models.PatientImport) error {
+	query := `
+		UPDATE patient_imports
+		SET status = $1, total_rows = $2, imported_rows = $3, invalid_rows = $4, duplicate_rows = $5,
+			error = $6, finished_at = $7
+		WHERE id = $8
+	`
+	_, err := p.db.ExecContext(ctx, query,
+		patientImport.Status, patientImport.TotalRows, patientImport.ImportedRows, patientImport.InvalidRows,
+		patientImport.DuplicateRows, patientImport.Error, patientImport.FinishedAt, patientImport.ID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update patient import: %w", err)
+	}
+
+	return nil
+}
+
+// FindByID retrieves an import, returning nil if it does not exist in the clinic of the context
+func (p *PatientImportRepoStorage) FindByID(ctx context.Context, id uuid.UUID) (*models.PatientImport, error) {
+	query := `SELECT ` + patientImportColumns + ` FROM patient_imports
+		WHERE id = $1 AND ($2::uuid IS NULL OR clinic_id = $2)`
+
+	patientImport, err := scanPatientImport(p.db.QueryRowContext(ctx, query, id, clinicArg(ctx)))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find patient import: %w", err)
+	}
+
+	return patientImport, nil
+}
+
+// List retrieves the latest imports of the clinic of the context, most recent first
+func (p *PatientImportRepoStorage) List(ctx context.Context, limit int) ([]models.PatientImport, error) {
+	query := `SELECT ` + patientImportColumns + ` FROM patient_imports
+		WHERE ($1::uuid IS NULL OR clinic_id = $1)
+		ORDER BY created_at DESC
+		LIMIT $2`
+
+	rows, err := p.db.QueryContext(ctx, query, clinicArg(ctx), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list patient imports: %w", err)
+	}
+	defer rows.Close()
+
+	imports := []models.PatientImport{}
+	for rows.Next() {
+		patientImport, err := scanPatientImport(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list patient imports: %w", err)
+		}
+		imports = append(imports, *patientImport)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list patient imports: %w", err)
+	}
+
+	return imports, nil
+}
+
+// AddErrors records problems found in the rows of an import
+func (p *PatientImportRepoStorage) AddErrors(ctx context.Context, importID uuid.UUID, importErrors []models.PatientImportError) error {
+	if len(importErrors) == 0 {
+		return nil
+	}
+
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to record patient import errors: %w", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, pq.CopyIn("patient_import_errors", "import_id", "row_number", "field", "message"))
+	if err != nil {
+		return fmt.Errorf("failed to record patient import errors: %w", err)
+	}
+	defer stmt.Close()
+
+	for _, importError := range importErrors {
+		if _, err := stmt.ExecContext(ctx, importID, importError.Row, importError.Field, importError.Message); err != nil {
+			return fmt.Errorf("failed to record patient import errors: %w", err)
+		}
+	}
+	if _, err := stmt.ExecContext(ctx); err != nil {
+		return fmt.Errorf("failed to record patient import errors: %w", err)
+	}
+	if err := stmt.Close(); err != nil {
+		return fmt.Errorf("failed to record patient import errors: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to record patient import errors: %w", err)
+	}
+
+	return nil
+}
+
+// ListErrors retrieves the problems found in the rows of an import, by row
+func (p *PatientImportRepoStorage) ListErrors(ctx context.Context, importID uuid.UUID) ([]models.PatientImportError, error) {
+	query := `SELECT row_number, field, message FROM patient_import_errors
+		WHERE import_id = $1
+		ORDER BY row_number, id`
+
+	rows, err := p.db.QueryContext(ctx, query, importID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list patient import errors: %w", err)
+	}
+	defer rows.Close()
+
+	importErrors := []models.PatientImportError{}
+	for rows.Next() {
+		var importError models.PatientImportError
+		if err := rows.Scan(&importError.Row, &importError.Field, &importError.Message); err != nil {
+			return nil, fmt.Errorf("failed to list patient import errors: %w", err)
+		}
+		importErrors = append(importErrors, importError)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list patient import errors: %w", err)
+	}
+
+	return importErrors, nil
+}
+
+// FailUnfinished marks the imports left unfinished, by a restart of the server, as failed with
+// the reason given and returns how many there were
+func (p *PatientImportRepoStorage) FailUnfinished(ctx context.Context, reason string) (int64, error) {
+	query := `
+		UPDATE patient_imports SET status = $1, error = $2, finished_at = NOW()
+		WHERE status IN ($3, $4)
+	`
+	result, err := p.db.ExecContext(ctx, query,
+		models.PatientImportFailed, reason, models.PatientImportPending, models.PatientImportRunning)
+	if err != nil {
+		return 0, fmt.Errorf("failed to fail unfinished patient imports: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+func scanPatientImport(row rowScanner) (*models.PatientImport, error) {
+	var patientImport models.PatientImport
+	err := row.Scan(
+		&patientImport.ID, &patientImport.ClinicID, &patientImport.CreatedBy, &patientImport.Filename,
+		&patientImport.Format, &patientImport.Status, &patientImport.TotalRows, &patientImport.ImportedRows,
+		&patientImport.InvalidRows, &patientImport.DuplicateRows, &patientImport.Error,
+		&patientImport.CreatedAt, &patientImport.FinishedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &patientImport, nil
+}
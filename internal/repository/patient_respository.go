@@ -3,20 +3,33 @@ package repository
 import (
 	"context"
 	"database/sql"
+	"fmt"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/yhwbach/makerble/internal/encryption"
 	"github.com/yhwbach/makerble/internal/models"
 	"github.com/yhwbach/makerble/internal/schemas"
 )
 
 type PatientRepoStorage struct {
-	db *sql.DB
+	db   tenantDB
+	keys *encryption.KeyRing
 }
 
-// Create inserts a new patient into the database.
-func (p *PatientRepoStorage) Create(ctx context.Context, userID uuid.UUID, patient *schemas.PatientCreate, dateOfBirth time.Time) (string, error) {
+// Create inserts a new patient into the database, encrypting its sensitive fields. The audit
+// event, if any, is recorded for the new patient in the same transaction.
+func (p *PatientRepoStorage) Create(ctx context.Context, userID uuid.UUID, patient *schemas.PatientCreate, dateOfBirth time.Time, audit *models.AuditEvent) (string, error) {
+	return p.CreateWithIdentifiers(ctx, userID, patient, dateOfBirth, nil, audit)
+}
+
+// CreateWithIdentifiers inserts a new patient like Create, along with the identifiers other systems
+// know it by. ErrIdentifierTaken is returned, and no patient created, when an identifier belongs
+// to another patient, including one created concurrently.
+func (p *PatientRepoStorage) CreateWithIdentifiers(ctx context.Context, userID uuid.UUID, patient *schemas.PatientCreate, dateOfBirth time.Time, identifiers []models.PatientIdentifier, audit *models.AuditEvent) (string, error) {
 	patientModel := &models.Patient{
+		ID:             uuid.New(),
 		FullName:       patient.FullName,
 		DateOfBirth:    dateOfBirth,
 		Gender:         patient.Gender,
@@ -27,114 +40,112 @@ func (p *PatientRepoStorage) Create(ctx context.Context, userID uuid.UUID, patie
 		RegisteredBy:   userID,
 	}
 
-	query := `INSERT INTO patients (full_name, date_of_birth, gender, address, phone, email, medical_history, registered_by)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id`
+	// The ID is generated up front as the encrypted fields are bound to it
+	sealed, err := p.seal(patientModel)
+	if err != nil {
+		return "", err
+	}
+
+	query := `INSERT INTO patients (id, full_name, date_of_birth, gender, registered_by, clinic_id,
+			data_key_id, data_key, encrypted_address, encrypted_phone, encrypted_email, encrypted_medical_history, email_index)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+		RETURNING clinic_id`
 
-	err := p.db.QueryRowContext(ctx, query,
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create patient: %w", err)
+	}
+	defer tx.Rollback()
+
+	var clinicID uuid.UUID
+	err = tx.QueryRowContext(ctx, query,
+		patientModel.ID,
 		patientModel.FullName,
 		patientModel.DateOfBirth,
 		patientModel.Gender,
-		patientModel.Address,
-		patientModel.Phone,
-		patientModel.Email,
-		patientModel.MedicalHistory,
 		patientModel.RegisteredBy,
-	).Scan(&patientModel.ID)
+		clinicForInsert(ctx, uuid.Nil),
+		sealed.dataKeyID,
+		sealed.dataKey,
+		sealed.ciphertext[0],
+		sealed.ciphertext[1],
+		sealed.ciphertext[2],
+		sealed.ciphertext[3],
+		sealed.emailIndex,
+	).Scan(&clinicID)
 
 	if err != nil {
 		return "", err
 	}
 
-	return patientModel.ID.String(), nil
-}
+	if err := addIdentifiers(ctx, tx, patientModel.ID, clinicID, identifiers); err != nil {
+		return "", err
+	}
 
-// FindAll retrieves all patients with their registered user details from the database.
-func (p *PatientRepoStorage) FindAll(ctx context.Context) ([]schemas.Patients, error) {
-	query := `
-		SELECT 
-			p.id, p.full_name, p.date_of_birth, p.gender, p.address, 
-			p.phone, p.email, p.medical_history, p.registered_by, 
-			p.created_at, p.updated_at,
-			u.id as user_id, u.full_name as user_full_name
-		FROM patients p
-		JOIN users u ON p.registered_by = u.id
-		ORDER BY p.created_at DESC
-	`
+	if audit != nil {
+		audit.PatientID = &patientModel.ID
+		if err := insertAuditEvent(ctx, tx, audit); err != nil {
+			return "", err
+		}
+	}
 
-	rows, err := p.db.QueryContext(ctx, query)
-	if err != nil {
-		return nil, err
+	if err := tx.Commit(); err != nil {
+		return "", fmt.Errorf("failed to create patient: %w", err)
 	}
-	defer rows.Close()
 
-	var patients []schemas.Patients
-	for rows.Next() {
-		var patient models.Patient
-		var user struct {
-			ID       string
-			FullName string
-		}
+	return patientModel.ID.String(), nil
+}
 
-		if err := rows.Scan(
-			&patient.ID,
-			&patient.FullName,
-			&patient.DateOfBirth,
-			&patient.Gender,
-			&patient.Address,
-			&patient.Phone,
-			&patient.Email,
-			&patient.MedicalHistory,
-			&patient.RegisteredBy,
-			&patient.CreatedAt,
-			&patient.UpdatedAt,
-			&user.ID,
-			&user.FullName,
-		); err != nil {
-			return nil, err
-		}
+// careTeamFilter matches the patients the user given as $1 currently has access to, through the
+// care team or emergency access, or every patient when $1 is NULL
+const careTeamFilter = `($1::uuid IS NULL OR EXISTS (
+		SELECT 1 FROM care_team_assignments c
+		WHERE c.patient_id = p.id AND c.user_id = $1
+			AND c.valid_from <= NOW() AND (c.valid_until IS NULL OR c.valid_until > NOW())
+	) OR EXISTS (
+		SELECT 1 FROM emergency_access_grants g
+		WHERE g.patient_id = p.id AND g.user_id = $1 AND g.expires_at > NOW()
+	))`
 
-		patientWithUser := schemas.Patients{
-			Patient: &patient,
-			RegisteredByUser: struct {
-				ID       string `json:"id"`
-				FullName string `json:"full_name"`
-			}{
-				ID:       user.ID,
-				FullName: user.FullName,
-			},
-		}
+// consentFilter matches the patients currently giving the type of consent given as $2, or every
+// patient when $2 is empty
+const consentFilter = `($2 = '' OR EXISTS (
+		SELECT 1 FROM patient_consents pc
+		WHERE pc.patient_id = p.id AND pc.consent_type = $2
+			AND pc.granted_at <= NOW() AND pc.revoked_at IS NULL
+	))`
 
-		patients = append(patients, patientWithUser)
-	}
+// activeConsents selects the types of consent the patient currently gives
+const activeConsents = `ARRAY(
+		SELECT DISTINCT pc.consent_type FROM patient_consents pc
+		WHERE pc.patient_id = p.id AND pc.granted_at <= NOW() AND pc.revoked_at IS NULL
+	)`
 
-	if err := rows.Err(); err != nil {
+// FindAll retrieves the patients matching the query with their registered user details from the
+// database. Archived patients are left out.
+func (p *PatientRepoStorage) FindAll(ctx context.Context, filter schemas.PatientQuery) ([]schemas.Patients, error) {
+	var patients []schemas.Patients
+	err := p.Stream(ctx, filter, func(patient schemas.Patients) error {
+		patients = append(patients, patient)
+		return nil
+	})
+	if err != nil {
 		return nil, err
 	}
 
 	return patients, nil
 }
 
-// FindByID retrieves a patient by ID from the database.
-func (p *PatientRepoStorage) FindByID(ctx context.Context, id uuid.UUID) (*models.Patient, error) {
-	query := `SELECT id, full_name, date_of_birth, gender, address, phone, email, medical_history, registered_by, created_at, updated_at
-		FROM patients WHERE id = $1`
+// FindByID retrieves a patient by ID from the database, returning nil if it does not match the query.
+func (p *PatientRepoStorage) FindByID(ctx context.Context, id uuid.UUID, filter schemas.PatientQuery) (*models.Patient, error) {
+	query := `SELECT ` + patientColumns + `, ` + activeConsents + `
+		FROM patients p WHERE id = $3 AND ` + careTeamFilter + ` AND ` + consentFilter + `
+			AND ($4::uuid IS NULL OR clinic_id = $4)`
 
-	row := p.db.QueryRowContext(ctx, query, id)
+	row := p.db.QueryRowContext(ctx, query, filter.CareTeamMember, filter.Consent, id, clinicArg(ctx))
 
 	var patient models.Patient
-	if err := row.Scan(
-		&patient.ID,
-		&patient.FullName,
-		&patient.DateOfBirth,
-		&patient.Gender,
-		&patient.Address,
-		&patient.Phone,
-		&patient.Email,
-		&patient.MedicalHistory,
-		&patient.RegisteredBy,
-		&patient.CreatedAt,
-		&patient.UpdatedAt,
-	); err != nil {
+	if err := p.scanPatient(row, &patient, pq.Array(&patient.Consents)); err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
 		}
@@ -144,27 +155,19 @@ func (p *PatientRepoStorage) FindByID(ctx context.Context, id uuid.UUID) (*model
 	return &patient, nil
 }
 
-// FindByEmail retrieves a patient by email from the database.
+// FindByEmail retrieves a patient by email from the database, ignoring case. Encrypted emails are
+// matched through their blind index under any of the master keys, as indexes are only recomputed
+// under the current one when keys are rotated.
 func (p *PatientRepoStorage) FindByEmail(ctx context.Context, email string) (*models.Patient, error) {
-	query := `SELECT id, full_name, date_of_birth, gender, address, phone, email, medical_history, registered_by, created_at, updated_at
-		FROM patients WHERE email = $1`
+	query := `SELECT ` + patientColumns + `
+		FROM patients p WHERE (email_index = ANY($1) OR (data_key IS NULL AND lower(email) = $2))
+			AND ($3::uuid IS NULL OR clinic_id = $3)`
 
-	row := p.db.QueryRowContext(ctx, query, email)
+	email = normalizeEmail(email)
+	row := p.db.QueryRowContext(ctx, query, pq.ByteaArray(p.keys.BlindIndexes(email)), email, clinicArg(ctx))
 
 	var patient models.Patient
-	if err := row.Scan(
-		&patient.ID,
-		&patient.FullName,
-		&patient.DateOfBirth,
-		&patient.Gender,
-		&patient.Address,
-		&patient.Phone,
-		&patient.Email,
-		&patient.MedicalHistory,
-		&patient.RegisteredBy,
-		&patient.CreatedAt,
-		&patient.UpdatedAt,
-	); err != nil {
+	if err := p.scanPatient(row, &patient); err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
 		}
@@ -174,64 +177,252 @@ func (p *PatientRepoStorage) FindByEmail(ctx context.Context, email string) (*mo
 	return &patient, nil
 }
 
-// UpdateByID updates a patient's information in the database.
-func (p *PatientRepoStorage) UpdateByID(ctx context.Context, id uuid.UUID, patient *schemas.PatientUpdate) (*models.Patient, error) {
-	query := `
-		UPDATE patients 
-		SET full_name = COALESCE($1, full_name), 
-		date_of_birth = COALESCE($2, date_of_birth),
-		gender = COALESCE($3, gender), 
-		address = COALESCE($4, address), 
-		phone = COALESCE($5, phone),
-		email = COALESCE($6, email), 
-		medical_history = COALESCE($7, medical_history),
-		updated_at = NOW()
-		WHERE id = $8 
-		RETURNING id, full_name, date_of_birth, gender, address, phone, email, medical_history, registered_by, created_at, updated_at
-		`
+// UpdateByID updates a patient's information in the database, re-encrypting its sensitive fields.
+// The audit event, if any, is recorded in the same transaction.
+func (p *PatientRepoStorage) UpdateByID(ctx context.Context, id uuid.UUID, patient *schemas.PatientUpdate, audit *models.AuditEvent) (*models.Patient, error) {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update patient: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `SELECT ` + patientColumns + ` FROM patients p
+		WHERE id = $1 AND ($2::uuid IS NULL OR clinic_id = $2) FOR UPDATE`
 
 	var patientModel models.Patient
-	err := p.db.QueryRowContext(ctx, query,
-		patient.FullName,
-		patient.DateOfBirth,
-		patient.Gender,
-		patient.Address,
-		patient.Phone,
-		patient.Email,
-		patient.MedicalHistory,
-		id,
-	).Scan(
-		&patientModel.ID,
-		&patientModel.FullName,
-		&patientModel.DateOfBirth,
-		&patientModel.Gender,
-		&patientModel.Address,
-		&patientModel.Phone,
-		&patientModel.Email,
-		&patientModel.MedicalHistory,
-		&patientModel.RegisteredBy,
-		&patientModel.CreatedAt,
-		&patientModel.UpdatedAt,
-	)
-
-	if err != nil {
+	if err := p.scanPatient(tx.QueryRowContext(ctx, query, id, clinicArg(ctx)), &patientModel); err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
 		}
 		return nil, err
 	}
 
+	// The fields are encrypted, so the update is applied here rather than in the query
+	if patient.DateOfBirth != nil {
+		dateOfBirth, err := time.Parse("2006-01-02", *patient.DateOfBirth)
+		if err != nil {
+			return nil, fmt.Errorf("invalid date of birth: %w", err)
+		}
+		patientModel.DateOfBirth = dateOfBirth
+	}
+	if patient.Gender != nil {
+		patientModel.Gender = *patient.Gender
+	}
+	for dest, value := range map[*string]*string{
+		&patientModel.FullName:       patient.FullName,
+		&patientModel.Address:        patient.Address,
+		&patientModel.Phone:          patient.Phone,
+		&patientModel.Email:          patient.Email,
+		&patientModel.MedicalHistory: patient.MedicalHistory,
+	} {
+		if value != nil {
+			*dest = *value
+		}
+	}
+	patientModel.UpdatedAt = time.Now()
+	// Updating an archived patient makes it active again
+	patientModel.ArchivedAt = nil
+
+	sealed, err := p.seal(&patientModel)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeSealed(ctx, tx, &patientModel, sealed); err != nil {
+		return nil, err
+	}
+
+	if audit != nil {
+		if err := insertAuditEvent(ctx, tx, audit); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to update patient: %w", err)
+	}
+
 	return &patientModel, nil
 }
 
-// DeleteByID deletes a patient by ID from the database.
-func (p *PatientRepoStorage) DeleteByID(ctx context.Context, id uuid.UUID) error {
-	query := `DELETE FROM patients WHERE id = $1`
+// DeleteByID deletes a patient by ID from the database. The audit event, if any, is recorded in
+// the same transaction when the patient was deleted.
+func (p *PatientRepoStorage) DeleteByID(ctx context.Context, id uuid.UUID, audit *models.AuditEvent) error {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to delete patient: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `DELETE FROM patients WHERE id = $1 AND ($2::uuid IS NULL OR clinic_id = $2)`
 
-	_, err := p.db.ExecContext(ctx, query, id)
+	result, err := tx.ExecContext(ctx, query, id, clinicArg(ctx))
+	if err != nil {
+		return err
+	}
 
+	deleted, err := result.RowsAffected()
 	if err != nil {
 		return err
 	}
-	return nil
+	if audit != nil && deleted > 0 {
+		if err := insertAuditEvent(ctx, tx, audit); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Transfer moves a patient to another clinic and records the transfer. The care team, emergency
+// access and identifiers of the clinic the patient leaves end with the transfer. It returns false
+// when the patient does not exist in the clinic of the context.
+func (p *PatientRepoStorage) Transfer(ctx context.Context, transfer *models.PatientTransfer) (bool, error) {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to transfer patient: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `SELECT clinic_id FROM patients WHERE id = $1 AND ($2::uuid IS NULL OR clinic_id = $2) FOR UPDATE`
+	if err := tx.QueryRowContext(ctx, query, transfer.PatientID, clinicArg(ctx)).Scan(&transfer.FromClinicID); err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to transfer patient: %w", err)
+	}
+
+	// The patient belongs to the clinic of the context, which the moved row no longer does, so the
+	// move itself runs outside of the row-level security scope
+	if _, err := tx.ExecContext(ctx, `SELECT set_config('app.clinic_id', '', true)`); err != nil {
+		return false, fmt.Errorf("failed to transfer patient: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE patients SET clinic_id = $1, updated_at = NOW() WHERE id = $2`,
+		transfer.ToClinicID, transfer.PatientID,
+	); err != nil {
+		return false, fmt.Errorf("failed to transfer patient: %w", err)
+	}
+
+	query = `
+		INSERT INTO patient_transfers (patient_id, from_clinic_id, to_clinic_id, reason, transferred_by)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, transferred_at
+	`
+	err = tx.QueryRowContext(ctx, query,
+		transfer.PatientID, transfer.FromClinicID, transfer.ToClinicID, transfer.Reason, transfer.TransferredBy,
+	).Scan(&transfer.ID, &transfer.TransferredAt)
+	if err != nil {
+		return false, fmt.Errorf("failed to record patient transfer: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE care_team_assignments SET valid_until = NOW()
+		WHERE patient_id = $1 AND (valid_until IS NULL OR valid_until > NOW())
+	`, transfer.PatientID); err != nil {
+		return false, fmt.Errorf("failed to end care team assignments: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE emergency_access_grants SET expires_at = NOW()
+		WHERE patient_id = $1 AND expires_at > NOW()
+	`, transfer.PatientID); err != nil {
+		return false, fmt.Errorf("failed to end emergency access: %w", err)
+	}
+
+	// Identifiers are assigned by the systems of the clinic the patient leaves
+	if _, err := tx.ExecContext(ctx, `DELETE FROM patient_identifiers WHERE patient_id = $1`, transfer.PatientID); err != nil {
+		return false, fmt.Errorf("failed to remove patient identifiers: %w", err)
+	}
+
+	err = insertAuditEvent(ctx, tx, &models.AuditEvent{
+		Severity:  models.AuditSeverityInfo,
+		Action:    models.AuditActionPatientTransfer,
+		ActorID:   &transfer.TransferredBy,
+		PatientID: &transfer.PatientID,
+		Details: map[string]interface{}{
+			"transfer_id":    transfer.ID,
+			"from_clinic_id": transfer.FromClinicID,
+			"to_clinic_id":   transfer.ToClinicID,
+			"reason":         transfer.Reason,
+		},
+	})
+	if err != nil {
+		return false, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to transfer patient: %w", err)
+	}
+
+	return true, nil
+}
+
+// inactivePatients matches the patients not yet archived that were neither updated nor accessed
+// since the time given as $1
+const inactivePatients = `patients p
+	WHERE p.archived_at IS NULL AND p.updated_at < $1
+		AND NOT EXISTS (SELECT 1 FROM audit_events e WHERE e.patient_id = p.id AND e.occurred_at >= $1)`
+
+// ArchiveInactive archives the patients that were neither updated nor accessed since the given
+// time, recording the archive in the audit log. With dryRun it only counts them.
+func (p *PatientRepoStorage) ArchiveInactive(ctx context.Context, before time.Time, dryRun bool) (int64, error) {
+	if dryRun {
+		var count int64
+		if err := p.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM `+inactivePatients, before).Scan(&count); err != nil {
+			return 0, fmt.Errorf("failed to count inactive patients: %w", err)
+		}
+		return count, nil
+	}
+
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to archive inactive patients: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `UPDATE patients SET archived_at = NOW()
+		WHERE id IN (SELECT p.id FROM ` + inactivePatients + `)
+		RETURNING id`
+
+	rows, err := tx.QueryContext(ctx, query, before)
+	if err != nil {
+		return 0, fmt.Errorf("failed to archive inactive patients: %w", err)
+	}
+
+	var patientIDs []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to archive inactive patients: %w", err)
+		}
+		patientIDs = append(patientIDs, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to archive inactive patients: %w", err)
+	}
+
+	if len(patientIDs) == 0 {
+		return 0, nil
+	}
+
+	err = insertAuditEvent(ctx, tx, &models.AuditEvent{
+		Severity: models.AuditSeverityInfo,
+		Action:   models.AuditActionPatientArchive,
+		Details: map[string]interface{}{
+			"patient_ids": patientIDs,
+			"count":       len(patientIDs),
+			"before":      before,
+		},
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to archive inactive patients: %w", err)
+	}
+
+	return int64(len(patientIDs)), nil
 }
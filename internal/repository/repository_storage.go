@@ -6,25 +6,51 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/yhwbach/makerble/internal/encryption"
 	"github.com/yhwbach/makerble/internal/models"
 	"github.com/yhwbach/makerble/internal/schemas"
 )
 
 // RepoStorage is a struct that holds references to different repository interfaces.
 type RepoStorage struct {
-	Patients PatientRepository
-	Users    UserRepository
-	Tokens   TokenRepository
+	Patients        PatientRepository
+	Users           UserRepository
+	Tokens          TokenRepository
+	Sessions        SessionRepository
+	Invitations     InvitationRepository
+	APIKeys         APIKeyRepository
+	Roles           RoleRepository
+	CareTeams       CareTeamRepository
+	EmergencyAccess EmergencyAccessRepository
+	Consents        ConsentRepository
+	Clinics         ClinicRepository
+	Audit           AuditRepository
+	Retention       RetentionRepository
+	HL7Messages     HL7MessageRepository
+	PatientImports  PatientImportRepository
+	PatientExports  PatientExportRepository
 }
 
 // PatientRepoStorage is a struct that implements the PatientRepository interface.
 type PatientRepository interface {
-	Create(context.Context, uuid.UUID, *schemas.PatientCreate, time.Time) (string, error)
-	FindAll(context.Context) ([]schemas.Patients, error) // Changed return type
-	FindByID(context.Context, uuid.UUID) (*models.Patient, error)
+	Create(context.Context, uuid.UUID, *schemas.PatientCreate, time.Time, *models.AuditEvent) (string, error)
+	CreateWithIdentifiers(context.Context, uuid.UUID, *schemas.PatientCreate, time.Time, []models.PatientIdentifier, *models.AuditEvent) (string, error)
+	FindAll(context.Context, schemas.PatientQuery) ([]schemas.Patients, error) // Changed return type
+	Stream(context.Context, schemas.PatientQuery, func(schemas.Patients) error) error
+	FindIDs(context.Context, schemas.PatientQuery) ([]uuid.UUID, error)
+	Count(context.Context, schemas.PatientQuery) (int, error)
+	FindByID(context.Context, uuid.UUID, schemas.PatientQuery) (*models.Patient, error)
 	FindByEmail(context.Context, string) (*models.Patient, error)
-	UpdateByID(context.Context, uuid.UUID, *schemas.PatientUpdate) (*models.Patient, error)
-	DeleteByID(context.Context, uuid.UUID) error
+	UpdateByID(context.Context, uuid.UUID, *schemas.PatientUpdate, *models.AuditEvent) (*models.Patient, error)
+	DeleteByID(context.Context, uuid.UUID, *models.AuditEvent) error
+	Transfer(context.Context, *models.PatientTransfer) (bool, error)
+	FindByIdentifier(context.Context, models.PatientIdentifier) (*models.Patient, error)
+	AddIdentifiers(context.Context, uuid.UUID, []models.PatientIdentifier) error
+	Merge(context.Context, *models.PatientMerge) (bool, error)
+	Import(context.Context, *models.PatientImportBatch) ([]models.PatientDuplicate, error)
+	EncryptPlaintext(context.Context, int) (int, error)
+	RotateKeys(context.Context, int) (int, error)
+	ArchiveInactive(context.Context, time.Time, bool) (int64, error)
 }
 
 // UserRepoStorage is a struct that implements the UserRepository interface.
@@ -33,7 +59,12 @@ type UserRepository interface {
 	FindByID(context.Context, uuid.UUID) (*models.User, error)
 	FindByUsername(context.Context, string) (*models.User, error)
 	FindByEmail(context.Context, string) (*models.User, error)
+	FindByExternalID(context.Context, string, string) (*models.User, error)
+	CreateExternal(context.Context, *models.User) error
 	UpdateByID(context.Context, uuid.UUID, *schemas.UserUpdate) (*models.User, error)
+	UpdatePassword(context.Context, uuid.UUID, string) error
+	FindAll(context.Context) ([]models.User, error)
+	SetActive(context.Context, uuid.UUID, bool) (*models.User, error)
 	EmailExists(context.Context, string) (bool, error)
 	UsernameExists(context.Context, string) (bool, error)
 }
@@ -41,13 +72,148 @@ type UserRepository interface {
 type TokenRepository interface {
 	InvalidateToken(context.Context, string, time.Time) error
 	IsTokenInvalid(context.Context, string) (bool, error)
-	CleanupExpiredTokens(context.Context) error
+	PurgeInvalidTokens(context.Context, time.Time, bool) (int64, error)
 }
 
-func NewRepoStorage(db *sql.DB) RepoStorage {
+// SessionRepository manages the login sessions of users.
+type SessionRepository interface {
+	Create(context.Context, *models.Session) error
+	FindByID(context.Context, uuid.UUID) (*models.Session, error)
+	ListActiveByUser(context.Context, uuid.UUID) ([]models.Session, error)
+	Touch(context.Context, uuid.UUID, string) error
+	Revoke(context.Context, uuid.UUID) error
+	RevokeAllForUser(context.Context, uuid.UUID, *uuid.UUID) (int64, error)
+	PurgeExpiredSessions(context.Context, time.Time, bool) (int64, error)
+}
+
+// InvitationRepository manages admin issued registration invitations.
+type InvitationRepository interface {
+	Create(context.Context, *models.Invitation, string) error
+	FindAll(context.Context) ([]models.Invitation, error)
+	Claim(context.Context, string) (*models.Invitation, error)
+	Release(context.Context, uuid.UUID) error
+	MarkAccepted(context.Context, uuid.UUID, uuid.UUID) error
+	Revoke(context.Context, uuid.UUID) (bool, error)
+}
+
+// APIKeyRepository manages the API keys of service accounts.
+type APIKeyRepository interface {
+	Create(context.Context, *models.APIKey, string) error
+	FindByKey(context.Context, string, string) (*models.APIKey, error)
+	FindByID(context.Context, uuid.UUID) (*models.APIKey, error)
+	ListByUser(context.Context, uuid.UUID) ([]models.APIKey, error)
+	Touch(context.Context, uuid.UUID, string) error
+	ExpireAt(context.Context, uuid.UUID, time.Time) error
+	Revoke(context.Context, uuid.UUID) (bool, error)
+}
+
+// RoleRepository manages roles and the permissions they grant.
+type RoleRepository interface {
+	FindAll(context.Context) ([]models.Role, error)
+	FindByName(context.Context, models.UserType) (*models.Role, error)
+	Create(context.Context, *models.Role) error
+	Update(context.Context, *models.Role) error
+	Delete(context.Context, models.UserType) (bool, error)
+	IsAssigned(context.Context, models.UserType) (bool, error)
+}
+
+// CareTeamRepository manages the care team assignments that give users access to patients.
+type CareTeamRepository interface {
+	Create(context.Context, *models.CareTeamAssignment) error
+	FindByID(context.Context, uuid.UUID) (*models.CareTeamAssignment, error)
+	ListByPatient(context.Context, uuid.UUID) ([]models.CareTeamAssignment, error)
+	End(context.Context, uuid.UUID) (bool, error)
+}
+
+// EmergencyAccessRepository manages break-the-glass access to patients outside of the care team.
+type EmergencyAccessRepository interface {
+	Create(context.Context, *models.EmergencyAccessGrant) error
+	List(context.Context, schemas.EmergencyAccessQuery) ([]models.EmergencyAccessGrant, error)
+}
+
+// ConsentRepository manages the consents patients give.
+type ConsentRepository interface {
+	Create(context.Context, *models.Consent) error
+	FindByID(context.Context, uuid.UUID) (*models.Consent, error)
+	ListByPatient(context.Context, uuid.UUID) ([]models.Consent, error)
+	Revoke(context.Context, uuid.UUID) (bool, error)
+}
+
+// AuditRepository manages the append-only, hash chained audit log.
+type AuditRepository interface {
+	Record(context.Context, *models.AuditEvent) error
+	List(context.Context, schemas.AuditQuery) ([]models.AuditEvent, error)
+	ListByPatient(context.Context, uuid.UUID) ([]models.AuditEvent, error)
+	Verify(context.Context) (*models.AuditVerification, error)
+	Purge(context.Context, time.Time, bool) (int64, error)
+}
+
+// RetentionRepository keeps the reports of retention runs.
+type RetentionRepository interface {
+	Record(context.Context, *models.RetentionRun) error
+	List(context.Context, int) ([]models.RetentionRun, error)
+}
+
+// HL7MessageRepository keeps the HL7 messages received from other systems for replay.
+type HL7MessageRepository interface {
+	Create(context.Context, *models.HL7Message) error
+	Finish(context.Context, *models.HL7Message) error
+	FindByID(context.Context, uuid.UUID) (*models.HL7Message, error)
+	FindByControlID(context.Context, string, string) (*models.HL7Message, error)
+	List(context.Context, string, int) ([]models.HL7Message, error)
+	RotateKeys(context.Context, int) (int, error)
+}
+
+// PatientImportRepository keeps track of bulk patient imports and the problems found in their rows.
+type PatientImportRepository interface {
+	Create(context.Context, *models.PatientImport) error
+	Update(context.Context, *models.PatientImport) error
+	FindByID(context.Context, uuid.UUID) (*models.PatientImport, error)
+	List(context.Context, int) ([]models.PatientImport, error)
+	AddErrors(context.Context, uuid.UUID, []models.PatientImportError) error
+	ListErrors(context.Context, uuid.UUID) ([]models.PatientImportError, error)
+	FailUnfinished(context.Context, string) (int64, error)
+}
+
+// PatientExportRepository keeps track of FHIR bulk data exports of patients.
+type PatientExportRepository interface {
+	Create(context.Context, *models.PatientExport) error
+	Update(context.Context, *models.PatientExport) error
+	FindByID(context.Context, uuid.UUID) (*models.PatientExport, error)
+	Delete(context.Context, uuid.UUID) (bool, error)
+	FailUnfinished(context.Context, string) (int64, error)
+	Purge(context.Context, time.Time, bool) (int64, error)
+}
+
+// ClinicRepository manages the clinics users and patients belong to.
+type ClinicRepository interface {
+	Create(context.Context, *models.Clinic) error
+	FindAll(context.Context) ([]models.Clinic, error)
+	FindByID(context.Context, uuid.UUID) (*models.Clinic, error)
+	FindByName(context.Context, string) (*models.Clinic, error)
+}
+
+// NewRepoStorage creates the repositories. With rowLevelSecurity the patient and user
+// repositories also set the clinic of the request on the database session. The key ring encrypts
+// the sensitive fields of patients and the HL7 messages received.
+func NewRepoStorage(db *sql.DB, rowLevelSecurity bool, keys *encryption.KeyRing) RepoStorage {
+	tenant := tenantDB{DB: db, rowLevelSecurity: rowLevelSecurity}
 	return RepoStorage{
-		Patients: &PatientRepoStorage{db: db},
-		Users:    &UserRepoStorage{db: db},
-		Tokens:   &TokenRepoStorage{db: db},
+		Patients:        &PatientRepoStorage{db: tenant, keys: keys},
+		Users:           &UserRepoStorage{db: tenant},
+		Tokens:          &TokenRepoStorage{db: db},
+		Sessions:        &SessionRepoStorage{db: db},
+		Invitations:     &InvitationRepoStorage{db: tenant},
+		APIKeys:         &APIKeyRepoStorage{db: db},
+		Roles:           &RoleRepoStorage{db: db},
+		CareTeams:       &CareTeamRepoStorage{db: db},
+		EmergencyAccess: &EmergencyAccessRepoStorage{db: db},
+		Consents:        &ConsentRepoStorage{db: db},
+		Clinics:         &ClinicRepoStorage{db: db},
+		Audit:           &AuditRepoStorage{db: db},
+		Retention:       &RetentionRepoStorage{db: db},
+		HL7Messages:     &HL7MessageRepoStorage{db: db, keys: keys},
+		PatientImports:  &PatientImportRepoStorage{db: db},
+		PatientExports:  &PatientExportRepoStorage{db: db},
 	}
 }
@@ -0,0 +1,85 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	"github.com/yhwbach/makerble/internal/models"
+)
+
+type RetentionRepoStorage struct {
+	db *sql.DB
+}
+
+// Record stores the report of a retention run
+func (r *RetentionRepoStorage) Record(ctx context.Context, run *models.RetentionRun) error {
+	results, err := json.Marshal(run.Results)
+	if err != nil {
+		return fmt.Errorf("failed to encode retention results: %w", err)
+	}
+
+	query := `
+		INSERT INTO retention_runs (started_at, finished_at, dry_run, results)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id
+	`
+	err = r.db.QueryRowContext(ctx, query, run.StartedAt, run.FinishedAt, run.DryRun, results).Scan(&run.ID)
+	if err != nil {
+		return fmt.Errorf("failed to record retention run: %w", err)
+	}
+
+	return nil
+}
+
+// List retrieves the reports of the latest retention runs, most recent first
+func (r *RetentionRepoStorage) List(ctx context.Context, limit int) ([]models.RetentionRun, error) {
+	query := `
+		SELECT id, started_at, finished_at, dry_run, results
+		FROM retention_runs
+		ORDER BY started_at DESC
+		LIMIT $1
+	`
+
+	rows, err := r.db.QueryContext(ctx, query, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list retention runs: %w", err)
+	}
+	defer rows.Close()
+
+	runs := []models.RetentionRun{}
+	for rows.Next() {
+		var run models.RetentionRun
+		var results []byte
+		if err := rows.Scan(&run.ID, &run.StartedAt, &run.FinishedAt, &run.DryRun, &results); err != nil {
+			return nil, fmt.Errorf("failed to list retention runs: %w", err)
+		}
+		if err := json.Unmarshal(results, &run.Results); err != nil {
+			return nil, fmt.Errorf("failed to decode retention results: %w", err)
+		}
+		runs = append(runs, run)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list retention runs: %w", err)
+	}
+
+	return runs, nil
+}
+
+// purgeRows deletes the rows selected by from, a table followed by a WHERE clause, and returns how
+// many were deleted. With dryRun it only counts them.
+func purgeRows(ctx context.Context, db *sql.DB, from string, dryRun bool, args ...interface{}) (int64, error) {
+	if dryRun {
+		var count int64
+		err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM `+from, args...).Scan(&count)
+		return count, err
+	}
+
+	result, err := db.ExecContext(ctx, `DELETE FROM `+from, args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
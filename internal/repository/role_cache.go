@@ -0,0 +1,63 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/yhwbach/makerble/internal/models"
+	"github.com/yhwbach/makerble/internal/utils"
+)
+
+// CachedRoleRepository keeps an in-process cache of role lookups in front of a RoleRepository,
+// since every authorized request needs the permissions of the caller's role. Edits made through
+// the cache take effect immediately; edits made by another instance once the TTL ran out.
+type CachedRoleRepository struct {
+	RoleRepository
+	cache *utils.LRUCache[models.UserType, *models.Role]
+	ttl   time.Duration
+}
+
+// NewCachedRoleRepository wraps repo with a cache that trusts a lookup for ttl
+func NewCachedRoleRepository(repo RoleRepository, ttl time.Duration) *CachedRoleRepository {
+	return &CachedRoleRepository{
+		RoleRepository: repo,
+		cache:          utils.NewLRUCache[models.UserType, *models.Role](100),
+		ttl:            ttl,
+	}
+}
+
+// FindByName answers from the cache when possible and falls back to the wrapped repository.
+// Roles that do not exist are cached as well.
+func (c *CachedRoleRepository) FindByName(ctx context.Context, name models.UserType) (*models.Role, error) {
+	if role, ok := c.cache.Get(name); ok {
+		return role, nil
+	}
+
+	role, err := c.RoleRepository.FindByName(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.ttl > 0 {
+		c.cache.Set(name, role, time.Now().Add(c.ttl))
+	}
+	return role, nil
+}
+
+// Create inserts the role and forgets a cached lookup of its name
+func (c *CachedRoleRepository) Create(ctx context.Context, role *models.Role) error {
+	defer c.cache.Delete(role.Name)
+	return c.RoleRepository.Create(ctx, role)
+}
+
+// Update changes the role and forgets its cached permissions
+func (c *CachedRoleRepository) Update(ctx context.Context, role *models.Role) error {
+	defer c.cache.Delete(role.Name)
+	return c.RoleRepository.Update(ctx, role)
+}
+
+// Delete removes the role and forgets its cached permissions
+func (c *CachedRoleRepository) Delete(ctx context.Context, name models.UserType) (bool, error) {
+	defer c.cache.Delete(name)
+	return c.RoleRepository.Delete(ctx, name)
+}
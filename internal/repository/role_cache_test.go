@@ -0,0 +1,46 @@
+package repository_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yhwbach/makerble/internal/models"
+	"github.com/yhwbach/makerble/internal/repository"
+	"github.com/yhwbach/makerble/internal/repository/mock"
+)
+
+func TestCachedRoleRepository(t *testing.T) {
+	ctx := context.Background()
+	backing := mock.NewMockRepoStorage().Roles
+	cached := repository.NewCachedRoleRepository(backing, time.Minute)
+
+	nurse := &models.Role{Name: "nurse", Permissions: []string{models.PermissionPatientRead}}
+
+	// A missing role is cached too, until it is created through the cache
+	role, err := cached.FindByName(ctx, "nurse")
+	require.NoError(t, err)
+	assert.Nil(t, role)
+
+	require.NoError(t, cached.Create(ctx, nurse))
+	role, err = cached.FindByName(ctx, "nurse")
+	require.NoError(t, err)
+	require.NotNil(t, role)
+	assert.True(t, role.HasPermission(models.PermissionPatientRead))
+
+	// Edited behind the cache's back, e.g. by another instance: the cached permissions stay
+	// until the TTL runs out
+	require.NoError(t, backing.Update(ctx, &models.Role{Name: "nurse"}))
+	role, err = cached.FindByName(ctx, "nurse")
+	require.NoError(t, err)
+	assert.True(t, role.HasPermission(models.PermissionPatientRead))
+
+	// Edits through the cache take effect immediately
+	require.NoError(t, cached.Update(ctx, &models.Role{Name: "nurse", Permissions: []string{models.PermissionPatientCreate}}))
+	role, err = cached.FindByName(ctx, "nurse")
+	require.NoError(t, err)
+	assert.False(t, role.HasPermission(models.PermissionPatientRead))
+	assert.True(t, role.HasPermission(models.PermissionPatientCreate))
+}
@@ -0,0 +1,173 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/lib/pq"
+	"github.com/yhwbach/makerble/internal/models"
+)
+
+type RoleRepoStorage struct {
+	db *sql.DB
+}
+
+const roleQuery = `
+	SELECT r.name, r.description, r.built_in, r.created_at, r.updated_at,
+		COALESCE(array_agg(p.permission ORDER BY p.permission) FILTER (WHERE p.permission IS NOT NULL), '{}')
+	FROM roles r
+	LEFT JOIN role_permissions p ON p.role = r.name`
+
+// FindAll retrieves all roles with their permissions
+func (r *RoleRepoStorage) FindAll(ctx context.Context) ([]models.Role, error) {
+	query := roleQuery + ` GROUP BY r.name ORDER BY r.name`
+
+	rows, err := r.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list roles: %w", err)
+	}
+	defer rows.Close()
+
+	roles := []models.Role{}
+	for rows.Next() {
+		role, err := scanRole(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list roles: %w", err)
+		}
+		roles = append(roles, *role)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list roles: %w", err)
+	}
+
+	return roles, nil
+}
+
+// FindByName retrieves a role with its permissions, returning nil if it does not exist
+func (r *RoleRepoStorage) FindByName(ctx context.Context, name models.UserType) (*models.Role, error) {
+	query := roleQuery + ` WHERE r.name = $1 GROUP BY r.name`
+
+	role, err := scanRole(r.db.QueryRowContext(ctx, query, name))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get role: %w", err)
+	}
+
+	return role, nil
+}
+
+// Create inserts a new role with its permissions
+func (r *RoleRepoStorage) Create(ctx context.Context, role *models.Role) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create role: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO roles (name, description)
+		VALUES ($1, $2)
+		RETURNING built_in, created_at, updated_at
+	`
+	if err := tx.QueryRowContext(ctx, query, role.Name, role.Description).Scan(&role.BuiltIn, &role.CreatedAt, &role.UpdatedAt); err != nil {
+		return fmt.Errorf("failed to create role: %w", err)
+	}
+
+	if err := insertPermissions(ctx, tx, role); err != nil {
+		return fmt.Errorf("failed to create role: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to create role: %w", err)
+	}
+
+	return nil
+}
+
+// Update replaces the description and permissions of a role
+func (r *RoleRepoStorage) Update(ctx context.Context, role *models.Role) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to update role: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE roles SET description = $2, updated_at = NOW()
+		WHERE name = $1
+		RETURNING built_in, created_at, updated_at
+	`
+	if err := tx.QueryRowContext(ctx, query, role.Name, role.Description).Scan(&role.BuiltIn, &role.CreatedAt, &role.UpdatedAt); err != nil {
+		return fmt.Errorf("failed to update role: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM role_permissions WHERE role = $1`, role.Name); err != nil {
+		return fmt.Errorf("failed to update role: %w", err)
+	}
+
+	if err := insertPermissions(ctx, tx, role); err != nil {
+		return fmt.Errorf("failed to update role: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to update role: %w", err)
+	}
+
+	return nil
+}
+
+// Delete removes a role that is not built in. It returns false if no such role matched.
+func (r *RoleRepoStorage) Delete(ctx context.Context, name models.UserType) (bool, error) {
+	query := `DELETE FROM roles WHERE name = $1 AND NOT built_in`
+
+	result, err := r.db.ExecContext(ctx, query, name)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete role: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to delete role: %w", err)
+	}
+
+	return affected > 0, nil
+}
+
+// IsAssigned reports whether any user or pending invitation holds the role
+func (r *RoleRepoStorage) IsAssigned(ctx context.Context, name models.UserType) (bool, error) {
+	query := `
+		SELECT EXISTS(SELECT 1 FROM users WHERE user_type = $1)
+			OR EXISTS(SELECT 1 FROM invitations WHERE user_type = $1 AND accepted_at IS NULL AND revoked_at IS NULL)
+	`
+
+	var assigned bool
+	if err := r.db.QueryRowContext(ctx, query, name).Scan(&assigned); err != nil {
+		return false, fmt.Errorf("failed to check role assignment: %w", err)
+	}
+
+	return assigned, nil
+}
+
+func insertPermissions(ctx context.Context, tx *sql.Tx, role *models.Role) error {
+	query := `INSERT INTO role_permissions (role, permission) SELECT $1, unnest($2::text[]) ON CONFLICT DO NOTHING`
+
+	_, err := tx.ExecContext(ctx, query, role.Name, pq.Array(role.Permissions))
+	return err
+}
+
+func scanRole(row rowScanner) (*models.Role, error) {
+	var role models.Role
+
+	if err := row.Scan(
+		&role.Name, &role.Description, &role.BuiltIn, &role.CreatedAt, &role.UpdatedAt, pq.Array(&role.Permissions),
+	); err != nil {
+		return nil, err
+	}
+
+	return &role, nil
+}
@@ -0,0 +1,84 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/yhwbach/makerble/internal/models"
+	"github.com/yhwbach/makerble/internal/utils"
+)
+
+// sessionTouchInterval is how often the activity of a session is written
+const sessionTouchInterval = time.Minute
+
+// CachedSessionRepository keeps an in-process cache of session lookups in front of a
+// SessionRepository so the authenticator does not hit the database on every request.
+// Revocations made through the cache take effect immediately; revocations made by another
+// instance once the TTL ran out. Activity is written at most once a minute per session.
+type CachedSessionRepository struct {
+	SessionRepository
+	cache   *utils.LRUCache[uuid.UUID, *models.Session]
+	touched *utils.LRUCache[uuid.UUID, struct{}]
+	ttl     time.Duration
+}
+
+// NewCachedSessionRepository wraps repo with a cache of the given size that trusts a lookup for ttl
+func NewCachedSessionRepository(repo SessionRepository, size int, ttl time.Duration) *CachedSessionRepository {
+	return &CachedSessionRepository{
+		SessionRepository: repo,
+		cache:             utils.NewLRUCache[uuid.UUID, *models.Session](size),
+		touched:           utils.NewLRUCache[uuid.UUID, struct{}](size),
+		ttl:               ttl,
+	}
+}
+
+// FindByID answers from the cache when possible and falls back to the wrapped repository.
+// Sessions that do not exist are cached as well.
+func (c *CachedSessionRepository) FindByID(ctx context.Context, id uuid.UUID) (*models.Session, error) {
+	session, ok := c.cache.Get(id)
+	if !ok {
+		var err error
+		session, err = c.SessionRepository.FindByID(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if c.ttl > 0 {
+			c.cache.Set(id, session, time.Now().Add(c.ttl))
+		}
+	}
+
+	if session == nil {
+		return nil, nil
+	}
+	found := *session
+	return &found, nil
+}
+
+// Touch records activity on the session unless this instance did in the last minute
+func (c *CachedSessionRepository) Touch(ctx context.Context, id uuid.UUID, ipAddress string) error {
+	if _, ok := c.touched.Get(id); ok {
+		return nil
+	}
+
+	if err := c.SessionRepository.Touch(ctx, id, ipAddress); err != nil {
+		return err
+	}
+
+	c.touched.Set(id, struct{}{}, time.Now().Add(sessionTouchInterval))
+	return nil
+}
+
+// Revoke revokes the session and forgets its cached lookup
+func (c *CachedSessionRepository) Revoke(ctx context.Context, id uuid.UUID) error {
+	defer c.cache.Delete(id)
+	return c.SessionRepository.Revoke(ctx, id)
+}
+
+// RevokeAllForUser revokes the sessions of the user and forgets their cached lookups
+func (c *CachedSessionRepository) RevokeAllForUser(ctx context.Context, userID uuid.UUID, except *uuid.UUID) (int64, error) {
+	defer c.cache.DeleteFunc(func(_ uuid.UUID, session *models.Session) bool {
+		return session != nil && session.UserID == userID
+	})
+	return c.SessionRepository.RevokeAllForUser(ctx, userID, except)
+}
@@ -0,0 +1,59 @@
+package repository_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yhwbach/makerble/internal/models"
+	"github.com/yhwbach/makerble/internal/repository"
+	"github.com/yhwbach/makerble/internal/repository/mock"
+)
+
+func TestCachedSessionRepository(t *testing.T) {
+	ctx := context.Background()
+	backing := mock.NewMockRepoStorage().Sessions
+	cached := repository.NewCachedSessionRepository(backing, 10, time.Minute)
+
+	userID := uuid.New()
+	session := &models.Session{UserID: userID, ExpiresAt: time.Now().Add(time.Hour)}
+	require.NoError(t, cached.Create(ctx, session))
+
+	found, err := cached.FindByID(ctx, session.ID)
+	require.NoError(t, err)
+	require.NotNil(t, found)
+	assert.True(t, found.IsActive())
+
+	// Activity is written once a minute
+	require.NoError(t, cached.Touch(ctx, session.ID, "10.0.0.1"))
+	require.NoError(t, cached.Touch(ctx, session.ID, "10.0.0.2"))
+	stored, err := backing.FindByID(ctx, session.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.0.1", stored.IPAddress)
+
+	// Revoked behind the cache's back, e.g. by another instance: the lookup stays cached until
+	// its TTL runs out
+	require.NoError(t, backing.Revoke(ctx, session.ID))
+	found, err = cached.FindByID(ctx, session.ID)
+	require.NoError(t, err)
+	assert.True(t, found.IsActive())
+
+	// Revocations through the cache take effect immediately
+	_, err = cached.RevokeAllForUser(ctx, userID, nil)
+	require.NoError(t, err)
+	found, err = cached.FindByID(ctx, session.ID)
+	require.NoError(t, err)
+	assert.False(t, found.IsActive())
+
+	other := &models.Session{UserID: userID, ExpiresAt: time.Now().Add(time.Hour)}
+	require.NoError(t, cached.Create(ctx, other))
+	_, err = cached.FindByID(ctx, other.ID)
+	require.NoError(t, err)
+	require.NoError(t, cached.Revoke(ctx, other.ID))
+	found, err = cached.FindByID(ctx, other.ID)
+	require.NoError(t, err)
+	assert.False(t, found.IsActive())
+}
@@ -0,0 +1,155 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/yhwbach/makerble/internal/models"
+)
+
+type SessionRepoStorage struct {
+	db *sql.DB
+}
+
+const sessionColumns = `id, user_id, COALESCE(device, ''), COALESCE(user_agent, ''), COALESCE(ip_address, ''),
+	created_at, last_seen_at, expires_at, revoked_at`
+
+// Create inserts a new session and fills in its generated ID and timestamps
+func (r *SessionRepoStorage) Create(ctx context.Context, session *models.Session) error {
+	query := `
+		INSERT INTO sessions (user_id, device, user_agent, ip_address, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at, last_seen_at
+	`
+
+	err := r.db.QueryRowContext(ctx, query,
+		session.UserID, session.Device, session.UserAgent, session.IPAddress, session.ExpiresAt,
+	).Scan(&session.ID, &session.CreatedAt, &session.LastSeenAt)
+	if err != nil {
+		return fmt.Errorf("failed to create session: %w", err)
+	}
+
+	return nil
+}
+
+// FindByID retrieves a session by ID, returning nil if it does not exist
+func (r *SessionRepoStorage) FindByID(ctx context.Context, id uuid.UUID) (*models.Session, error) {
+	query := `SELECT ` + sessionColumns + ` FROM sessions WHERE id = $1`
+
+	session, err := scanSession(r.db.QueryRowContext(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get session: %w", err)
+	}
+
+	return session, nil
+}
+
+// ListActiveByUser retrieves the sessions of a user that are neither revoked nor expired
+func (r *SessionRepoStorage) ListActiveByUser(ctx context.Context, userID uuid.UUID) ([]models.Session, error) {
+	query := `SELECT ` + sessionColumns + `
+		FROM sessions
+		WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > NOW()
+		ORDER BY last_seen_at DESC`
+
+	rows, err := r.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+	defer rows.Close()
+
+	sessions := []models.Session{}
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list sessions: %w", err)
+		}
+		sessions = append(sessions, *session)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+
+	return sessions, nil
+}
+
+// Touch records activity on a session. Writes are throttled to once a minute per session.
+func (r *SessionRepoStorage) Touch(ctx context.Context, id uuid.UUID, ipAddress string) error {
+	query := `
+		UPDATE sessions
+		SET last_seen_at = NOW(), ip_address = $2
+		WHERE id = $1 AND last_seen_at < NOW() - INTERVAL '1 minute'
+	`
+
+	if _, err := r.db.ExecContext(ctx, query, id, ipAddress); err != nil {
+		return fmt.Errorf("failed to touch session: %w", err)
+	}
+
+	return nil
+}
+
+// Revoke revokes a single session
+func (r *SessionRepoStorage) Revoke(ctx context.Context, id uuid.UUID) error {
+	query := `UPDATE sessions SET revoked_at = NOW() WHERE id = $1 AND revoked_at IS NULL`
+
+	if _, err := r.db.ExecContext(ctx, query, id); err != nil {
+		return fmt.Errorf("failed to revoke session: %w", err)
+	}
+
+	return nil
+}
+
+// RevokeAllForUser revokes every active session of a user except the optional one to keep
+func (r *SessionRepoStorage) RevokeAllForUser(ctx context.Context, userID uuid.UUID, except *uuid.UUID) (int64, error) {
+	query := `
+		UPDATE sessions SET revoked_at = NOW()
+		WHERE user_id = $1 AND revoked_at IS NULL AND ($2::uuid IS NULL OR id <> $2)
+	`
+
+	result, err := r.db.ExecContext(ctx, query, userID, except)
+	if err != nil {
+		return 0, fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+
+	return result.RowsAffected()
+}
+
+// PurgeExpiredSessions deletes the sessions that expired before the given time. With dryRun it
+// only counts them.
+func (r *SessionRepoStorage) PurgeExpiredSessions(ctx context.Context, before time.Time, dryRun bool) (int64, error) {
+	count, err := purgeRows(ctx, r.db, `sessions WHERE expires_at <= $1`, dryRun, before)
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge expired sessions: %w", err)
+	}
+
+	return count, nil
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanSession(row rowScanner) (*models.Session, error) {
+	var session models.Session
+	var revokedAt sql.NullTime
+
+	if err := row.Scan(
+		&session.ID, &session.UserID, &session.Device, &session.UserAgent, &session.IPAddress,
+		&session.CreatedAt, &session.LastSeenAt, &session.ExpiresAt, &revokedAt,
+	); err != nil {
+		return nil, err
+	}
+
+	if revokedAt.Valid {
+		session.RevokedAt = &revokedAt.Time
+	}
+
+	return &session, nil
+}
@@ -0,0 +1,160 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/yhwbach/makerble/internal/models"
+)
+
+type clinicContextKey struct{}
+
+// WithClinic scopes the patient and user repositories to a clinic for requests made with the context
+func WithClinic(ctx context.Context, clinicID uuid.UUID) context.Context {
+	return context.WithValue(ctx, clinicContextKey{}, clinicID)
+}
+
+// ClinicFromContext returns the clinic the context is scoped to. Contexts without a clinic, such
+// as sign-in and admin commands, see every clinic.
+func ClinicFromContext(ctx context.Context) (uuid.UUID, bool) {
+	clinicID, ok := ctx.Value(clinicContextKey{}).(uuid.UUID)
+	return clinicID, ok
+}
+
+// clinicArg returns the argument for a `$n::uuid IS NULL OR clinic_id = $n` filter
+func clinicArg(ctx context.Context) *uuid.UUID {
+	if clinicID, ok := ClinicFromContext(ctx); ok {
+		return &clinicID
+	}
+	return nil
+}
+
+// clinicForInsert picks the clinic of a new row: the given clinic, else the clinic of the context,
+// else the default clinic
+func clinicForInsert(ctx context.Context, clinicID uuid.UUID) uuid.UUID {
+	if clinicID != uuid.Nil {
+		return clinicID
+	}
+	if clinicID, ok := ClinicFromContext(ctx); ok {
+		return clinicID
+	}
+	return models.DefaultClinicID
+}
+
+// tenantDB runs the queries of tenant scoped repositories. With row-level security, queries made
+// with a clinic in the context run in a transaction that sets app.clinic_id, which the policies
+// on the patients and users tables check in addition to the filters in the queries.
+type tenantDB struct {
+	*sql.DB
+	rowLevelSecurity bool
+}
+
+// BeginTx starts a transaction scoped to the clinic of the context
+func (t tenantDB) BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error) {
+	tx, err := t.DB.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	clinicID, ok := ClinicFromContext(ctx)
+	if !t.rowLevelSecurity || !ok {
+		return tx, nil
+	}
+
+	if _, err := tx.ExecContext(ctx, `SELECT set_config('app.clinic_id', $1, true)`, clinicID.String()); err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("failed to set clinic: %w", err)
+	}
+	return tx, nil
+}
+
+func (t tenantDB) scoped(ctx context.Context) bool {
+	_, ok := ClinicFromContext(ctx)
+	return t.rowLevelSecurity && ok
+}
+
+// ExecContext executes a statement within the clinic of the context
+func (t tenantDB) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	if !t.scoped(ctx) {
+		return t.DB.ExecContext(ctx, query, args...)
+	}
+
+	tx, err := t.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return result, tx.Commit()
+}
+
+// QueryRowContext runs a query returning a single row within the clinic of the context. The
+// query runs when the row is scanned.
+func (t tenantDB) QueryRowContext(ctx context.Context, query string, args ...any) rowScanner {
+	if !t.scoped(ctx) {
+		return t.DB.QueryRowContext(ctx, query, args...)
+	}
+	return &tenantRow{db: t, ctx: ctx, query: query, args: args}
+}
+
+// QueryContext runs a query within the clinic of the context. Closing the rows ends the transaction.
+func (t tenantDB) QueryContext(ctx context.Context, query string, args ...any) (*tenantRows, error) {
+	if !t.scoped(ctx) {
+		rows, err := t.DB.QueryContext(ctx, query, args...)
+		if err != nil {
+			return nil, err
+		}
+		return &tenantRows{Rows: rows}, nil
+	}
+
+	tx, err := t.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	return &tenantRows{Rows: rows, tx: tx}, nil
+}
+
+type tenantRow struct {
+	db    tenantDB
+	ctx   context.Context
+	query string
+	args  []any
+}
+
+func (r *tenantRow) Scan(dest ...any) error {
+	tx, err := r.db.BeginTx(r.ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := tx.QueryRowContext(r.ctx, r.query, r.args...).Scan(dest...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+type tenantRows struct {
+	*sql.Rows
+	tx *sql.Tx
+}
+
+func (r *tenantRows) Close() error {
+	err := r.Rows.Close()
+	if r.tx != nil {
+		r.tx.Rollback()
+	}
+	return err
+}
@@ -0,0 +1,62 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/yhwbach/makerble/internal/utils"
+)
+
+// CachedTokenRepository keeps an in-process LRU cache in front of a TokenRepository so the
+// authenticator does not hit the database on every request. A revoked token never becomes
+// valid again, so revocations are cached until the token expires. Tokens found valid are
+// only cached for a short time because another instance may revoke them meanwhile.
+type CachedTokenRepository struct {
+	TokenRepository
+	cache      *utils.LRUCache[string, bool]
+	validTTL   time.Duration
+	revokedTTL time.Duration
+}
+
+// NewCachedTokenRepository wraps repo with a cache of the given size. validTTL bounds how long a
+// valid result is trusted and revokedTTL how long a revocation read from the database is kept.
+func NewCachedTokenRepository(repo TokenRepository, size int, validTTL, revokedTTL time.Duration) *CachedTokenRepository {
+	return &CachedTokenRepository{
+		TokenRepository: repo,
+		cache:           utils.NewLRUCache[string, bool](size),
+		validTTL:        validTTL,
+		revokedTTL:      revokedTTL,
+	}
+}
+
+// InvalidateToken revokes the token and records the revocation in the cache until expiresAt
+func (c *CachedTokenRepository) InvalidateToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	if err := c.TokenRepository.InvalidateToken(ctx, jti, expiresAt); err != nil {
+		return err
+	}
+
+	c.cache.Set(jti, true, expiresAt)
+	return nil
+}
+
+// IsTokenInvalid answers from the cache when possible and falls back to the wrapped repository
+func (c *CachedTokenRepository) IsTokenInvalid(ctx context.Context, jti string) (bool, error) {
+	if invalid, ok := c.cache.Get(jti); ok {
+		return invalid, nil
+	}
+
+	invalid, err := c.TokenRepository.IsTokenInvalid(ctx, jti)
+	if err != nil {
+		return false, err
+	}
+
+	ttl := c.validTTL
+	if invalid {
+		ttl = c.revokedTTL
+	}
+	if ttl > 0 {
+		c.cache.Set(jti, invalid, time.Now().Add(ttl))
+	}
+
+	return invalid, nil
+}
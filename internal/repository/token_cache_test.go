@@ -0,0 +1,35 @@
+package repository_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yhwbach/makerble/internal/repository"
+	"github.com/yhwbach/makerble/internal/repository/mock"
+)
+
+func TestCachedTokenRepository(t *testing.T) {
+	ctx := context.Background()
+	backing := mock.NewMockRepoStorage().Tokens
+	cached := repository.NewCachedTokenRepository(backing, 10, time.Minute, time.Hour)
+
+	invalid, err := cached.IsTokenInvalid(ctx, "jti-1")
+	require.NoError(t, err)
+	assert.False(t, invalid)
+
+	// Revoked behind the cache's back, e.g. by another instance: the valid result stays
+	// cached until its TTL runs out
+	require.NoError(t, backing.InvalidateToken(ctx, "jti-1", time.Now().Add(time.Hour)))
+	invalid, err = cached.IsTokenInvalid(ctx, "jti-1")
+	require.NoError(t, err)
+	assert.False(t, invalid)
+
+	// Revocations through the cache take effect immediately
+	require.NoError(t, cached.InvalidateToken(ctx, "jti-2", time.Now().Add(time.Hour)))
+	invalid, err = cached.IsTokenInvalid(ctx, "jti-2")
+	require.NoError(t, err)
+	assert.True(t, invalid)
+}
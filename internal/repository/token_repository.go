@@ -7,21 +7,25 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/yhwbach/makerble/internal/utils"
 )
 
 type TokenRepoStorage struct {
 	db *sql.DB
 }
 
-func (r *TokenRepoStorage) InvalidateToken(ctx context.Context, token string, expiresAt time.Time) error {
+// InvalidateToken revokes the token with the given jti until it expires. Only a hash of
+// the jti is stored so the table never holds anything usable as a credential.
+func (r *TokenRepoStorage) InvalidateToken(ctx context.Context, jti string, expiresAt time.Time) error {
 	query := `
-		INSERT INTO invalid_tokens (token, expires_at)
+		INSERT INTO invalid_tokens (jti_hash, expires_at)
 		VALUES ($1, $2)
+		ON CONFLICT (jti_hash) DO UPDATE SET expires_at = EXCLUDED.expires_at
 		RETURNING id
 	`
 
 	var id uuid.UUID
-	err := r.db.QueryRowContext(ctx, query, token, expiresAt).Scan(&id)
+	err := r.db.QueryRowContext(ctx, query, utils.HashToken(jti), expiresAt).Scan(&id)
 	if err != nil {
 		return fmt.Errorf("failed to invalidate token: %w", err)
 	}
@@ -29,18 +33,19 @@ func (r *TokenRepoStorage) InvalidateToken(ctx context.Context, token string, ex
 	return nil
 }
 
-func (r *TokenRepoStorage) IsTokenInvalid(ctx context.Context, token string) (bool, error) {
+// IsTokenInvalid reports whether the token with the given jti has been revoked
+func (r *TokenRepoStorage) IsTokenInvalid(ctx context.Context, jti string) (bool, error) {
 	query := `
 		SELECT EXISTS(
 			SELECT 1 
 			FROM invalid_tokens 
-			WHERE token = $1 
+			WHERE jti_hash = $1 
 			AND expires_at > NOW()
 		)
 	`
 
 	var exists bool
-	err := r.db.QueryRowContext(ctx, query, token).Scan(&exists)
+	err := r.db.QueryRowContext(ctx, query, utils.HashToken(jti)).Scan(&exists)
 	if err != nil {
 		return false, fmt.Errorf("failed to check token validity: %w", err)
 	}
@@ -48,13 +53,13 @@ func (r *TokenRepoStorage) IsTokenInvalid(ctx context.Context, token string) (bo
 	return exists, nil
 }
 
-func (r *TokenRepoStorage) CleanupExpiredTokens(ctx context.Context) error {
-	query := `DELETE FROM invalid_tokens WHERE expires_at <= NOW()`
-
-	_, err := r.db.ExecContext(ctx, query)
+// PurgeInvalidTokens deletes the revocations of tokens that expired before the given time, which
+// are of no use anymore. With dryRun it only counts them.
+func (r *TokenRepoStorage) PurgeInvalidTokens(ctx context.Context, before time.Time, dryRun bool) (int64, error) {
+	count, err := purgeRows(ctx, r.db, `invalid_tokens WHERE expires_at <= $1`, dryRun, before)
 	if err != nil {
-		return fmt.Errorf("failed to cleanup expired tokens: %w", err)
+		return 0, fmt.Errorf("failed to purge invalid tokens: %w", err)
 	}
 
-	return nil
+	return count, nil
 }
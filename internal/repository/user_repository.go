@@ -13,9 +13,15 @@ import (
 )
 
 type UserRepoStorage struct {
-	db *sql.DB
+	db tenantDB
 }
 
+// ErrUserNotFound is returned when no user matches the lookup
+var ErrUserNotFound = errors.New("user not found")
+
+const userColumns = `id, username, password, email, full_name, user_type, clinic_id, auth_provider,
+	COALESCE(external_id, ''), is_active, deactivated_at, created_at, updated_at`
+
 func (r *UserRepoStorage) Create(ctx context.Context, user *schemas.UserRegister, hashedPassword string) (string, error) {
 	userModel := &models.User{
 		ID:        uuid.New(),
@@ -29,8 +35,8 @@ func (r *UserRepoStorage) Create(ctx context.Context, user *schemas.UserRegister
 	}
 	
 	query := `
-		INSERT INTO users (id, username, password, email, full_name, user_type)
-		VALUES ($1, $2, $3, $4, $5, $6)
+		INSERT INTO users (id, username, password, email, full_name, user_type, clinic_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
 		RETURNING id
 	`
 
@@ -39,7 +45,7 @@ func (r *UserRepoStorage) Create(ctx context.Context, user *schemas.UserRegister
 		ctx, query,
 		userModel.ID,
 		userModel.Username, userModel.Password, userModel.Email,
-		userModel.FullName, userModel.UserType,
+		userModel.FullName, userModel.UserType, clinicForInsert(ctx, user.ClinicID),
 	).Scan(&id)
 
 	if err != nil {
@@ -52,69 +58,93 @@ func (r *UserRepoStorage) Create(ctx context.Context, user *schemas.UserRegister
 // GetByID retrieves a user by ID
 func (r *UserRepoStorage) FindByID(ctx context.Context, id uuid.UUID) (*models.User, error) {
 	query := `
-		SELECT id, username, password, email, full_name, user_type, created_at, updated_at
+		SELECT ` + userColumns + `
 		FROM users
-		WHERE id = $1
+		WHERE id = $1 AND ($2::uuid IS NULL OR clinic_id = $2)
 	`
 
-	var user models.User
-	err := r.db.QueryRowContext(ctx, query, id).Scan(
-		&user.ID, &user.Username, &user.Password, &user.Email, &user.FullName,
-		&user.UserType, &user.CreatedAt, &user.UpdatedAt,
-	)
+	user, err := scanUser(r.db.QueryRowContext(ctx, query, id, clinicArg(ctx)))
 
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return nil, fmt.Errorf("user not found")
+			return nil, ErrUserNotFound
 		}
 		return nil, fmt.Errorf("failed to get user: %w", err)
 	}
 
-	return &user, nil
+	return user, nil
 }
 
 // GetByUsername retrieves a user by username
 func (r *UserRepoStorage) FindByUsername(ctx context.Context, username string) (*models.User, error) {
 	query := `
-		SELECT id, username, password, email, full_name, user_type, created_at, updated_at
+		SELECT ` + userColumns + `
 		FROM users
-		WHERE username = $1
+		WHERE username = $1 AND ($2::uuid IS NULL OR clinic_id = $2)
 	`
 
-	var user models.User
-	err := r.db.QueryRowContext(ctx, query, username).Scan(
-		&user.ID, &user.Username, &user.Password, &user.Email, &user.FullName,
-		&user.UserType, &user.CreatedAt, &user.UpdatedAt,
-	)
+	user, err := scanUser(r.db.QueryRowContext(ctx, query, username, clinicArg(ctx)))
 
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return nil, fmt.Errorf("user not found")
+			return nil, ErrUserNotFound
 		}
 		return nil, fmt.Errorf("failed to get user: %w", err)
 	}
 
-	return &user, nil
+	return user, nil
 }
 
 func (r *UserRepoStorage) FindByEmail(ctx context.Context, email string) (*models.User, error) {
 	query := `
-		SELECT id, username, password, email, full_name, user_type, created_at, updated_at
+		SELECT ` + userColumns + `
 		FROM users
-		WHERE email = $1
+		WHERE email = $1 AND ($2::uuid IS NULL OR clinic_id = $2)
 	`
-	var user models.User
-	err := r.db.QueryRowContext(ctx, query, email).Scan(
-		&user.ID, &user.Username, &user.Password, &user.Email, &user.FullName,
-		&user.UserType, &user.CreatedAt, &user.UpdatedAt,
-	)
+	user, err := scanUser(r.db.QueryRowContext(ctx, query, email, clinicArg(ctx)))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return nil, fmt.Errorf("user not found")
+			return nil, ErrUserNotFound
 		}
 		return nil, fmt.Errorf("failed to get user: %w", err)
 	}
-	return &user, nil
+	return user, nil
+}
+
+// FindByExternalID retrieves a user provisioned by an external identity provider
+func (r *UserRepoStorage) FindByExternalID(ctx context.Context, provider, externalID string) (*models.User, error) {
+	query := `SELECT ` + userColumns + ` FROM users
+		WHERE auth_provider = $1 AND external_id = $2 AND ($3::uuid IS NULL OR clinic_id = $3)`
+
+	user, err := scanUser(r.db.QueryRowContext(ctx, query, provider, externalID, clinicArg(ctx)))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrUserNotFound
+		}
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	return user, nil
+}
+
+// CreateExternal inserts a user provisioned from an external identity provider or a service
+// account. Such users have no local password.
+func (r *UserRepoStorage) CreateExternal(ctx context.Context, user *models.User) error {
+	query := `
+		INSERT INTO users (username, password, email, full_name, user_type, auth_provider, external_id, clinic_id)
+		VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''), $8)
+		RETURNING ` + userColumns
+
+	created, err := scanUser(r.db.QueryRowContext(ctx, query,
+		user.Username, models.UnusablePassword, user.Email, user.FullName, user.UserType, user.AuthProvider, user.ExternalID,
+		clinicForInsert(ctx, user.ClinicID),
+	))
+	if err != nil {
+		return fmt.Errorf("failed to create user: %w", err)
+	}
+
+	*user = *created
+	return nil
 }
 
 // UpdateByID updates a user by ID
@@ -126,34 +156,94 @@ func (r *UserRepoStorage) UpdateByID(ctx context.Context, id uuid.UUID, user *sc
 			full_name = COALESCE($3, full_name),
 			user_type = COALESCE($4, user_type),
 			updated_at = NOW()
-		WHERE id = $5
-		RETURNING id, username, email, full_name, user_type, created_at, updated_at
+		WHERE id = $5 AND ($6::uuid IS NULL OR clinic_id = $6)
+		RETURNING ` + userColumns + `
 	`
-	var updatedUser models.User
-	err := r.db.QueryRowContext(
+	updatedUser, err := scanUser(r.db.QueryRowContext(
 		ctx, query,
-		user.Username, user.Email, user.FullName, user.UserType, id,
-	).Scan(
-		&updatedUser.ID, &updatedUser.Username, &updatedUser.Email,
-		&updatedUser.FullName, &updatedUser.UserType, &updatedUser.CreatedAt,
-		&updatedUser.UpdatedAt,
-	)
+		user.Username, user.Email, user.FullName, user.UserType, id, clinicArg(ctx),
+	))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return nil, fmt.Errorf("user not found")
+			return nil, ErrUserNotFound
 		}
 		return nil, fmt.Errorf("failed to update user: %w", err)
 	}
-	return &updatedUser, nil
+	return updatedUser, nil
 }
 
+// FindAll retrieves all users ordered by creation date
+func (r *UserRepoStorage) FindAll(ctx context.Context) ([]models.User, error) {
+	query := `SELECT ` + userColumns + ` FROM users WHERE ($1::uuid IS NULL OR clinic_id = $1) ORDER BY created_at DESC`
+
+	rows, err := r.db.QueryContext(ctx, query, clinicArg(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list users: %w", err)
+	}
+	defer rows.Close()
+
+	users := []models.User{}
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list users: %w", err)
+		}
+		users = append(users, *user)
+	}
 
-// UsernameExists checks if a username already exists
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list users: %w", err)
+	}
+
+	return users, nil
+}
+
+// SetActive deactivates or reactivates a user
+func (r *UserRepoStorage) SetActive(ctx context.Context, id uuid.UUID, active bool) (*models.User, error) {
+	query := `
+		UPDATE users
+		SET is_active = $1,
+			deactivated_at = CASE WHEN $1 THEN NULL ELSE NOW() END,
+			updated_at = NOW()
+		WHERE id = $2 AND ($3::uuid IS NULL OR clinic_id = $3)
+		RETURNING ` + userColumns
+
+	user, err := scanUser(r.db.QueryRowContext(ctx, query, active, id, clinicArg(ctx)))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrUserNotFound
+		}
+		return nil, fmt.Errorf("failed to update user status: %w", err)
+	}
+	return user, nil
+}
+
+// UpdatePassword replaces the stored password hash of a user
+func (r *UserRepoStorage) UpdatePassword(ctx context.Context, id uuid.UUID, hashedPassword string) error {
+	query := `UPDATE users SET password = $1, updated_at = NOW() WHERE id = $2 AND ($3::uuid IS NULL OR clinic_id = $3)`
+
+	result, err := r.db.ExecContext(ctx, query, hashedPassword, id, clinicArg(ctx))
+	if err != nil {
+		return fmt.Errorf("failed to update password: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to update password: %w", err)
+	}
+	if affected == 0 {
+		return ErrUserNotFound
+	}
+
+	return nil
+}
+
+// UsernameExists checks if a username already exists in any clinic
 func (r *UserRepoStorage) UsernameExists(ctx context.Context, username string) (bool, error) {
 	query := "SELECT EXISTS(SELECT 1 FROM users WHERE username = $1)"
 	
 	var exists bool
-	err := r.db.QueryRowContext(ctx, query, username).Scan(&exists)
+	err := r.db.DB.QueryRowContext(ctx, query, username).Scan(&exists)
 	if err != nil {
 		return false, fmt.Errorf("failed to check username existence: %w", err)
 	}
@@ -161,15 +251,34 @@ func (r *UserRepoStorage) UsernameExists(ctx context.Context, username string) (
 	return exists, nil
 }
 
-// EmailExists checks if an email already exists
+// EmailExists checks if an email already exists in any clinic
 func (r *UserRepoStorage) EmailExists(ctx context.Context, email string) (bool, error) {
 	query := "SELECT EXISTS(SELECT 1 FROM users WHERE email = $1)"
 	
 	var exists bool
-	err := r.db.QueryRowContext(ctx, query, email).Scan(&exists)
+	err := r.db.DB.QueryRowContext(ctx, query, email).Scan(&exists)
 	if err != nil {
 		return false, fmt.Errorf("failed to check email existence: %w", err)
 	}
 	
 	return exists, nil
-}
\ No newline at end of file
+}
+
+func scanUser(row rowScanner) (*models.User, error) {
+	var user models.User
+	var deactivatedAt sql.NullTime
+
+	if err := row.Scan(
+		&user.ID, &user.Username, &user.Password, &user.Email, &user.FullName,
+		&user.UserType, &user.ClinicID, &user.AuthProvider, &user.ExternalID, &user.IsActive, &deactivatedAt,
+		&user.CreatedAt, &user.UpdatedAt,
+	); err != nil {
+		return nil, err
+	}
+
+	if deactivatedAt.Valid {
+		user.DeactivatedAt = &deactivatedAt.Time
+	}
+
+	return &user, nil
+}
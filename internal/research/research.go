@@ -0,0 +1,190 @@
+// Package research de-identifies patients for datasets shared with research partners. Direct
+// identifiers are dropped, dates are shifted by a per-patient offset, ages over 89 are bucketed,
+// addresses are reduced to the first three digits of the ZIP code, and patients are identified by
+// a pseudonym derived with a keyed hash, so the same patient keeps the same pseudonym and offset
+// across exports made with the same key. Medical history is free text and left out unless
+// explicitly included.
+package research
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/yhwbach/makerble/internal/config"
+	"github.com/yhwbach/makerble/internal/models"
+)
+
+// MinKeySize is the minimum size in bytes of the pseudonym key
+const MinKeySize = 32
+
+// MaxDateShift bounds the number of days the dates of a patient are shifted by, in either direction
+const MaxDateShift = 182
+
+// ErrNotConfigured is returned when no pseudonym key is configured
+var ErrNotConfigured = errors.New("no research pseudonym key configured")
+
+// Record is a de-identified patient
+type Record struct {
+	PseudonymID string `parquet:"pseudonym_id"`
+	// BirthDate is shifted, and withheld for patients aged over 89
+	BirthDate string `parquet:"birth_date"`
+	// Age is in whole years, or "90+"
+	Age    string `parquet:"age"`
+	Gender string `parquet:"gender"`
+	// ZIP3 is the first three digits of the ZIP code, "000" when unknown or sparsely populated
+	ZIP3 string `parquet:"zip3"`
+	// RegisteredOn is shifted like the birth date
+	RegisteredOn string `parquet:"registered_on"`
+	// MedicalHistory is only filled in by de-identifiers including it
+	MedicalHistory string `parquet:"medical_history"`
+}
+
+// header lists the CSV column of each field of a record, in order, except the medical history
+var header = []string{"pseudonym_id", "birth_date", "age", "gender", "zip3", "registered_on"}
+
+// fields are the patient fields a record is derived from, except the medical history
+var fields = []string{"date_of_birth", "gender", "address"}
+
+// restrictedZIP3 are the three-digit ZIP codes covering 20,000 people or fewer, which the HIPAA
+// Safe Harbor method requires to be replaced with 000
+var restrictedZIP3 = []string{
+	"036", "059", "063", "102", "203", "556", "692", "790", "821", "823", "830", "831", "878", "879", "884", "890", "893",
+}
+
+var zipPattern = regexp.MustCompile(`\b(\d{5})(?:-\d{4})?\b`)
+
+// Deidentifier turns patients into records
+type Deidentifier struct {
+	key []byte
+	// MedicalHistory includes the medical history in records. Clinical notes can name people,
+	// places and dates, so datasets including them are not de-identified under Safe Harbor and
+	// must be reviewed before they are shared.
+	MedicalHistory bool
+}
+
+// New creates a de-identifier deriving pseudonyms and date offsets with the key
+func New(key []byte) (*Deidentifier, error) {
+	if len(key) < MinKeySize {
+		return nil, fmt.Errorf("pseudonym key must be at least %d bytes, got %d", MinKeySize, len(key))
+	}
+	return &Deidentifier{key: key}, nil
+}
+
+// Load creates the de-identifier from the configured base64 encoded pseudonym key, including the
+// medical history if configured to
+func Load(cfg config.ResearchConfig) (*Deidentifier, error) {
+	if cfg.PseudonymKey == "" {
+		return nil, ErrNotConfigured
+	}
+
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(cfg.PseudonymKey))
+	if err != nil {
+		return nil, fmt.Errorf("invalid pseudonym key: %w", err)
+	}
+
+	d, err := New(key)
+	if err != nil {
+		return nil, err
+	}
+	d.MedicalHistory = cfg.IncludeMedicalHistory
+	return d, nil
+}
+
+// Header lists the CSV column of each field of the records, in order
+func (d *Deidentifier) Header() []string {
+	if d.MedicalHistory {
+		return append(slices.Clone(header), "medical_history")
+	}
+	return slices.Clone(header)
+}
+
+// Fields lists the patient fields the records are derived from
+func (d *Deidentifier) Fields() []string {
+	if d.MedicalHistory {
+		return append(slices.Clone(fields), "medical_history")
+	}
+	return slices.Clone(fields)
+}
+
+// Record de-identifies the patient as of now
+func (d *Deidentifier) Record(patient *models.Patient, now time.Time) Record {
+	record := Record{
+		PseudonymID: d.Pseudonym(patient.ID),
+		Gender:      string(patient.Gender),
+		ZIP3:        ZIP3(patient.Address),
+	}
+	if d.MedicalHistory {
+		record.MedicalHistory = patient.MedicalHistory
+	}
+
+	shift := d.DateShift(patient.ID)
+	if age := Age(patient.DateOfBirth, now); age > 89 {
+		record.Age = "90+"
+	} else {
+		record.Age = strconv.Itoa(age)
+		record.BirthDate = patient.DateOfBirth.AddDate(0, 0, shift).Format("2006-01-02")
+	}
+	if !patient.CreatedAt.IsZero() {
+		record.RegisteredOn = patient.CreatedAt.AddDate(0, 0, shift).Format("2006-01-02")
+	}
+
+	return record
+}
+
+// Pseudonym derives the stable pseudonymous ID of the patient
+func (d *Deidentifier) Pseudonym(patientID uuid.UUID) string {
+	sum := d.mac("pseudonym", patientID)
+	return hex.EncodeToString(sum[:16])
+}
+
+// DateShift derives the number of days the dates of the patient are shifted by. It is never zero
+// and at most MaxDateShift in either direction.
+func (d *Deidentifier) DateShift(patientID uuid.UUID) int {
+	value := binary.BigEndian.Uint64(d.mac("date shift", patientID))
+	days := int(value%MaxDateShift) + 1
+	if value&(1<<63) != 0 {
+		days = -days
+	}
+	return days
+}
+
+func (d *Deidentifier) mac(purpose string, patientID uuid.UUID) []byte {
+	h := hmac.New(sha256.New, d.key)
+	h.Write([]byte(purpose))
+	h.Write(patientID[:])
+	return h.Sum(nil)
+}
+
+// Age returns the age in whole years on the given day
+func Age(dateOfBirth, now time.Time) int {
+	age := now.Year() - dateOfBirth.Year()
+	if now.Month() < dateOfBirth.Month() || (now.Month() == dateOfBirth.Month() && now.Day() < dateOfBirth.Day()) {
+		age--
+	}
+	return max(age, 0)
+}
+
+// ZIP3 generalizes an address to the first three digits of its last ZIP code
+func ZIP3(address string) string {
+	matches := zipPattern.FindAllStringSubmatch(address, -1)
+	if len(matches) == 0 {
+		return "000"
+	}
+
+	prefix := matches[len(matches)-1][1][:3]
+	if slices.Contains(restrictedZIP3, prefix) {
+		return "000"
+	}
+	return prefix
+}
@@ -0,0 +1,158 @@
+package research
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/csv"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/parquet-go/parquet-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yhwbach/makerble/internal/config"
+	"github.com/yhwbach/makerble/internal/models"
+)
+
+var testKey = bytes.Repeat([]byte{7}, MinKeySize)
+
+func TestRecord(t *testing.T) {
+	d, err := New(testKey)
+	require.NoError(t, err)
+
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	patient := &models.Patient{
+		ID:             uuid.New(),
+		FullName:       "Jane Doe",
+		DateOfBirth:    time.Date(1990, 4, 12, 0, 0, 0, 0, time.UTC),
+		Gender:         models.Female,
+		Address:        "12 Main Street, Springfield, IL 62704-1234",
+		Phone:          "+1 555 0100",
+		Email:          "jane@example.com",
+		MedicalHistory: "Asthma",
+		CreatedAt:      time.Date(2024, 1, 15, 9, 30, 0, 0, time.UTC),
+	}
+
+	record := d.Record(patient, now)
+	assert.Equal(t, "35", record.Age)
+	assert.Equal(t, "female", record.Gender)
+	assert.Equal(t, "627", record.ZIP3)
+	assert.Empty(t, record.MedicalHistory, "medical history is left out by default")
+	assert.Len(t, record.PseudonymID, 32)
+	assert.NotContains(t, record.PseudonymID, patient.ID.String()[:8])
+
+	shift := d.DateShift(patient.ID)
+	assert.NotZero(t, shift)
+	assert.LessOrEqual(t, shift, MaxDateShift)
+	assert.GreaterOrEqual(t, shift, -MaxDateShift)
+	assert.Equal(t, patient.DateOfBirth.AddDate(0, 0, shift).Format("2006-01-02"), record.BirthDate)
+	assert.Equal(t, patient.CreatedAt.AddDate(0, 0, shift).Format("2006-01-02"), record.RegisteredOn,
+		"all dates of a patient are shifted by the same offset")
+
+	t.Run("stable", func(t *testing.T) {
+		again, err := New(append([]byte(nil), testKey...))
+		require.NoError(t, err)
+		assert.Equal(t, record, again.Record(patient, now))
+
+		other, err := New(bytes.Repeat([]byte{8}, MinKeySize))
+		require.NoError(t, err)
+		assert.NotEqual(t, record.PseudonymID, other.Record(patient, now).PseudonymID)
+	})
+
+	t.Run("medical history", func(t *testing.T) {
+		including, err := New(testKey)
+		require.NoError(t, err)
+		including.MedicalHistory = true
+		assert.Equal(t, "Asthma", including.Record(patient, now).MedicalHistory)
+		assert.Contains(t, including.Fields(), "medical_history")
+		assert.NotContains(t, d.Fields(), "medical_history")
+	})
+
+	t.Run("ages over 89", func(t *testing.T) {
+		elderly := *patient
+		elderly.DateOfBirth = time.Date(1930, 2, 1, 0, 0, 0, 0, time.UTC)
+
+		record := d.Record(&elderly, now)
+		assert.Equal(t, "90+", record.Age)
+		assert.Empty(t, record.BirthDate)
+	})
+}
+
+func TestAge(t *testing.T) {
+	dob := time.Date(1990, 4, 12, 0, 0, 0, 0, time.UTC)
+	assert.Equal(t, 34, Age(dob, time.Date(2025, 4, 11, 0, 0, 0, 0, time.UTC)))
+	assert.Equal(t, 35, Age(dob, time.Date(2025, 4, 12, 0, 0, 0, 0, time.UTC)))
+	assert.Equal(t, 0, Age(dob, time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)))
+}
+
+func TestZIP3(t *testing.T) {
+	assert.Equal(t, "101", ZIP3("350 5th Ave, New York, NY 10118"))
+	assert.Equal(t, "000", ZIP3("Box 12, Lyme, NH 03661"), "sparsely populated ZIP codes are suppressed")
+	assert.Equal(t, "000", ZIP3("Flat 2, 10 Downing Street, London"))
+	assert.Equal(t, "000", ZIP3(""))
+}
+
+func TestLoad(t *testing.T) {
+	_, err := Load(config.ResearchConfig{})
+	assert.ErrorIs(t, err, ErrNotConfigured)
+
+	_, err = Load(config.ResearchConfig{PseudonymKey: base64.StdEncoding.EncodeToString([]byte("short"))})
+	assert.Error(t, err)
+
+	d, err := Load(config.ResearchConfig{PseudonymKey: base64.StdEncoding.EncodeToString(testKey)})
+	require.NoError(t, err)
+	assert.False(t, d.MedicalHistory)
+
+	d, err = Load(config.ResearchConfig{PseudonymKey: base64.StdEncoding.EncodeToString(testKey), IncludeMedicalHistory: true})
+	require.NoError(t, err)
+	assert.True(t, d.MedicalHistory)
+}
+
+func TestWrite(t *testing.T) {
+	records := []Record{
+		{PseudonymID: "a1", BirthDate: "1990-06-01", Age: "35", Gender: "female", ZIP3: "627", RegisteredOn: "2024-03-01", MedicalHistory: "Asthma, mild"},
+		{PseudonymID: "b2", Age: "90+", Gender: "male", ZIP3: "000"},
+	}
+	d, err := New(testKey)
+	require.NoError(t, err)
+	including, err := New(testKey)
+	require.NoError(t, err)
+	including.MedicalHistory = true
+
+	t.Run("csv", func(t *testing.T) {
+		var out bytes.Buffer
+		require.NoError(t, including.Write(&out, "csv", records))
+
+		rows, err := csv.NewReader(&out).ReadAll()
+		require.NoError(t, err)
+		require.Len(t, rows, 3)
+		assert.Equal(t, including.Header(), rows[0])
+		assert.Equal(t, []string{"a1", "1990-06-01", "35", "female", "627", "2024-03-01", "Asthma, mild"}, rows[1])
+
+		out.Reset()
+		require.NoError(t, d.Write(&out, "csv", records))
+		rows, err = csv.NewReader(&out).ReadAll()
+		require.NoError(t, err)
+		assert.Equal(t, []string{"pseudonym_id", "birth_date", "age", "gender", "zip3", "registered_on"}, rows[0])
+		assert.Equal(t, []string{"a1", "1990-06-01", "35", "female", "627", "2024-03-01"}, rows[1])
+	})
+
+	t.Run("parquet", func(t *testing.T) {
+		var out bytes.Buffer
+		require.NoError(t, including.Write(&out, "parquet", records))
+
+		read, err := parquet.Read[Record](bytes.NewReader(out.Bytes()), int64(out.Len()))
+		require.NoError(t, err)
+		assert.Equal(t, records, read)
+
+		out.Reset()
+		require.NoError(t, d.Write(&out, "parquet", records))
+		file, err := parquet.OpenFile(bytes.NewReader(out.Bytes()), int64(out.Len()))
+		require.NoError(t, err)
+		_, ok := file.Schema().Lookup("medical_history")
+		assert.False(t, ok, "medical history is left out by default")
+	})
+
+	assert.Error(t, d.Write(&bytes.Buffer{}, "xlsx", records))
+}
@@ -0,0 +1,97 @@
+package research
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"slices"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// Formats lists the formats a dataset can be written in
+var Formats = []string{"csv", "parquet"}
+
+// IsValidFormat reports whether the format is one of the known formats
+func IsValidFormat(format string) bool {
+	return slices.Contains(Formats, format)
+}
+
+// ContentType returns the media type of the format
+func ContentType(format string) string {
+	if format == "parquet" {
+		return "application/vnd.apache.parquet"
+	}
+	return "text/csv; charset=utf-8"
+}
+
+// Write writes the records in the format, with the medical history if the de-identifier includes it
+func (d *Deidentifier) Write(w io.Writer, format string, records []Record) error {
+	switch format {
+	case "csv":
+		return d.WriteCSV(w, records)
+	case "parquet":
+		return d.WriteParquet(w, records)
+	default:
+		return fmt.Errorf("unsupported format %q", format)
+	}
+}
+
+// WriteCSV writes the records as CSV with a header row
+func (d *Deidentifier) WriteCSV(w io.Writer, records []Record) error {
+	out := csv.NewWriter(w)
+	if err := out.Write(d.Header()); err != nil {
+		return err
+	}
+
+	for _, record := range records {
+		row := []string{record.PseudonymID, record.BirthDate, record.Age, record.Gender, record.ZIP3, record.RegisteredOn}
+		if d.MedicalHistory {
+			row = append(row, record.MedicalHistory)
+		}
+		if err := out.Write(row); err != nil {
+			return err
+		}
+	}
+
+	out.Flush()
+	return out.Error()
+}
+
+// WriteParquet writes the records as a Parquet file
+func (d *Deidentifier) WriteParquet(w io.Writer, records []Record) error {
+	if d.MedicalHistory {
+		return writeParquet(w, records)
+	}
+
+	rows := make([]recordWithoutHistory, 0, len(records))
+	for _, record := range records {
+		rows = append(rows, recordWithoutHistory{
+			PseudonymID:  record.PseudonymID,
+			BirthDate:    record.BirthDate,
+			Age:          record.Age,
+			Gender:       record.Gender,
+			ZIP3:         record.ZIP3,
+			RegisteredOn: record.RegisteredOn,
+		})
+	}
+	return writeParquet(w, rows)
+}
+
+// recordWithoutHistory is the schema of Parquet files leaving the medical history out
+type recordWithoutHistory struct {
+	PseudonymID  string `parquet:"pseudonym_id"`
+	BirthDate    string `parquet:"birth_date"`
+	Age          string `parquet:"age"`
+	Gender       string `parquet:"gender"`
+	ZIP3         string `parquet:"zip3"`
+	RegisteredOn string `parquet:"registered_on"`
+}
+
+func writeParquet[T any](w io.Writer, rows []T) error {
+	out := parquet.NewGenericWriter[T](w)
+	if _, err := out.Write(rows); err != nil {
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,157 @@
+// Package retention applies the data retention policies: it deletes or archives what the system
+// no longer needs to keep, on a schedule, and reports what each run did.
+package retention
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/yhwbach/makerble/internal/config"
+	"github.com/yhwbach/makerble/internal/models"
+	"github.com/yhwbach/makerble/internal/repository"
+)
+
+// Policy archives or deletes the rows older than a cutoff
+type Policy struct {
+	Name   string
+	Action string
+	// Cutoff returns the time before which the policy applies, given the time of the run
+	Cutoff func(now time.Time) time.Time
+	// Apply archives or deletes the rows older than the cutoff, or only counts them with dryRun
+	Apply func(ctx context.Context, before time.Time, dryRun bool) (int64, error)
+}
+
+// Policies builds the policies enabled by the configuration
+func Policies(cfg config.RetentionConfig, repo repository.RepoStorage) []Policy {
+	daysAgo := func(days int) func(time.Time) time.Time {
+		return func(now time.Time) time.Time { return now.AddDate(0, 0, -days) }
+	}
+	yearsAgo := func(years int) func(time.Time) time.Time {
+		return func(now time.Time) time.Time { return now.AddDate(-years, 0, 0) }
+	}
+
+	hoursAgo := func(hours int) func(time.Time) time.Time {
+		return func(now time.Time) time.Time { return now.Add(-time.Duration(hours) * time.Hour) }
+	}
+
+	// Expired revocations, sessions and exports are of no use, so these policies are always on
+	policies := []Policy{
+		{
+			Name:   models.RetentionInvalidTokens,
+			Action: models.RetentionDeleted,
+			Cutoff: daysAgo(cfg.InvalidTokenDays),
+			Apply:  repo.Tokens.PurgeInvalidTokens,
+		},
+		{
+			Name:   models.RetentionExpiredSessions,
+			Action: models.RetentionDeleted,
+			Cutoff: daysAgo(cfg.SessionDays),
+			Apply:  repo.Sessions.PurgeExpiredSessions,
+		},
+		{
+			Name:   models.RetentionPatientExports,
+			Action: models.RetentionDeleted,
+			Cutoff: hoursAgo(cfg.PatientExportHours),
+			Apply:  repo.PatientExports.Purge,
+		},
+	}
+
+	if cfg.InactivePatientYears > 0 {
+		policies = append(policies, Policy{
+			Name:   models.RetentionInactivePatients,
+			Action: models.RetentionArchived,
+			Cutoff: yearsAgo(cfg.InactivePatientYears),
+			Apply:  repo.Patients.ArchiveInactive,
+		})
+	}
+
+	if cfg.AuditLogYears > 0 {
+		policies = append(policies, Policy{
+			Name:   models.RetentionAuditLog,
+			Action: models.RetentionDeleted,
+			Cutoff: yearsAgo(cfg.AuditLogYears),
+			Apply:  repo.Audit.Purge,
+		})
+	}
+
+	return policies
+}
+
+// Scheduler runs the retention policies at a fixed interval
+type Scheduler struct {
+	Policies []Policy
+	Interval time.Duration
+	DryRun   bool
+	// Runs stores the report of each run
+	Runs repository.RetentionRepository
+	// Now returns the current time, time.Now when nil
+	Now func() time.Time
+}
+
+// NewScheduler creates a scheduler for the policies enabled by the configuration
+func NewScheduler(cfg config.RetentionConfig, repo repository.RepoStorage) *Scheduler {
+	return &Scheduler{
+		Policies: Policies(cfg, repo),
+		Interval: cfg.Interval,
+		DryRun:   cfg.DryRun,
+		Runs:     repo.Retention,
+	}
+}
+
+// Start runs the policies every interval until the context is done
+func (s *Scheduler) Start(ctx context.Context) {
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.RunOnce(ctx, s.DryRun)
+		}
+	}
+}
+
+// RunOnce applies every policy and returns the report of the run. A failing policy does not stop
+// the others. The report is logged and stored.
+func (s *Scheduler) RunOnce(ctx context.Context, dryRun bool) *models.RetentionRun {
+	now := time.Now
+	if s.Now != nil {
+		now = s.Now
+	}
+
+	run := &models.RetentionRun{StartedAt: now(), DryRun: dryRun, Results: []models.RetentionResult{}}
+	for _, policy := range s.Policies {
+		result := models.RetentionResult{
+			Policy: policy.Name,
+			Action: policy.Action,
+			Before: policy.Cutoff(run.StartedAt),
+		}
+
+		count, err := policy.Apply(ctx, result.Before, dryRun)
+		if err != nil {
+			result.Error = err.Error()
+			slog.ErrorContext(ctx, "retention policy failed", "policy", policy.Name, "error", err)
+		}
+		result.Count = count
+
+		run.Results = append(run.Results, result)
+	}
+	run.FinishedAt = now()
+
+	attrs := []any{"dry_run", dryRun}
+	for _, result := range run.Results {
+		attrs = append(attrs, slog.Int64(result.Policy, result.Count))
+	}
+	slog.InfoContext(ctx, "retention run finished", attrs...)
+
+	if s.Runs != nil {
+		if err := s.Runs.Record(ctx, run); err != nil {
+			slog.ErrorContext(ctx, "failed to record retention run", "error", err)
+		}
+	}
+
+	return run
+}
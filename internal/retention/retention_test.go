@@ -0,0 +1,124 @@
+package retention
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yhwbach/makerble/internal/config"
+	"github.com/yhwbach/makerble/internal/models"
+	"github.com/yhwbach/makerble/internal/repository/mock"
+	"github.com/yhwbach/makerble/internal/schemas"
+)
+
+func TestPolicies(t *testing.T) {
+	repo := mock.NewMockRepoStorage()
+
+	names := func(policies []Policy) []string {
+		var names []string
+		for _, policy := range policies {
+			names = append(names, policy.Name)
+		}
+		return names
+	}
+
+	assert.Equal(t, []string{models.RetentionInvalidTokens, models.RetentionExpiredSessions, models.RetentionPatientExports},
+		names(Policies(config.RetentionConfig{}, repo)), "expired tokens, sessions and exports are always purged")
+
+	assert.Equal(t, []string{
+		models.RetentionInvalidTokens, models.RetentionExpiredSessions, models.RetentionPatientExports,
+		models.RetentionInactivePatients, models.RetentionAuditLog,
+	}, names(Policies(config.RetentionConfig{InactivePatientYears: 10, AuditLogYears: 6}, repo)))
+}
+
+func TestRunOnce(t *testing.T) {
+	ctx := context.Background()
+	repo := mock.NewMockRepoStorage()
+	now := time.Now()
+
+	require.NoError(t, repo.Tokens.InvalidateToken(ctx, "expired-long-ago", now.AddDate(0, 0, -10)))
+	require.NoError(t, repo.Tokens.InvalidateToken(ctx, "expired-recently", now.Add(-time.Hour)))
+	require.NoError(t, repo.Tokens.InvalidateToken(ctx, "still-valid", now.Add(time.Hour)))
+
+	staleID, err := repo.Patients.Create(ctx, uuid.New(), &schemas.PatientCreate{FullName: "Stale"}, now.AddDate(-12, 0, 0), nil)
+	require.NoError(t, err)
+	_, err = repo.Patients.Create(ctx, uuid.New(), &schemas.PatientCreate{FullName: "Recent"}, now.AddDate(-1, 0, 0), nil)
+	require.NoError(t, err)
+
+	scheduler := NewScheduler(config.RetentionConfig{InvalidTokenDays: 7, InactivePatientYears: 10}, repo)
+
+	results := func(run *models.RetentionRun) map[string]int64 {
+		counts := map[string]int64{}
+		for _, result := range run.Results {
+			counts[result.Policy] = result.Count
+		}
+		return counts
+	}
+
+	t.Run("dry run", func(t *testing.T) {
+		run := scheduler.RunOnce(ctx, true)
+		assert.True(t, run.DryRun)
+		assert.Equal(t, map[string]int64{
+			models.RetentionInvalidTokens:    1,
+			models.RetentionExpiredSessions:  0,
+			models.RetentionPatientExports:   0,
+			models.RetentionInactivePatients: 1,
+		}, results(run))
+
+		patients, err := repo.Patients.FindAll(ctx, schemas.PatientQuery{})
+		require.NoError(t, err)
+		assert.Len(t, patients, 2, "dry runs change nothing")
+	})
+
+	t.Run("run", func(t *testing.T) {
+		run := scheduler.RunOnce(ctx, false)
+		assert.False(t, run.Failed())
+		assert.Equal(t, int64(1), results(run)[models.RetentionInvalidTokens])
+		assert.Equal(t, int64(1), results(run)[models.RetentionInactivePatients])
+
+		patients, err := repo.Patients.FindAll(ctx, schemas.PatientQuery{})
+		require.NoError(t, err)
+		require.Len(t, patients, 1)
+		assert.Equal(t, "Recent", patients[0].FullName)
+
+		archived, err := repo.Patients.FindByID(ctx, uuid.MustParse(staleID), schemas.PatientQuery{})
+		require.NoError(t, err)
+		assert.NotNil(t, archived.ArchivedAt, "archived patients can still be looked up")
+
+		invalid, err := repo.Tokens.IsTokenInvalid(ctx, "still-valid")
+		require.NoError(t, err)
+		assert.True(t, invalid)
+	})
+
+	t.Run("reports", func(t *testing.T) {
+		runs, err := repo.Retention.List(ctx, 10)
+		require.NoError(t, err)
+		require.Len(t, runs, 2)
+		assert.False(t, runs[0].DryRun)
+		assert.True(t, runs[1].DryRun)
+	})
+
+	t.Run("failing policy", func(t *testing.T) {
+		failing := &Scheduler{Policies: []Policy{
+			{
+				Name:   "broken",
+				Action: models.RetentionDeleted,
+				Cutoff: func(now time.Time) time.Time { return now },
+				Apply: func(context.Context, time.Time, bool) (int64, error) {
+					return 0, errors.New("database unavailable")
+				},
+			},
+			scheduler.Policies[0],
+		}}
+
+		run := failing.RunOnce(ctx, false)
+		assert.True(t, run.Failed())
+		require.Len(t, run.Results, 2, "the other policies still run")
+		assert.Equal(t, "database unavailable", run.Results[0].Error)
+		assert.Empty(t, run.Results[1].Error)
+	})
+}
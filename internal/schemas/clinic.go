@@ -0,0 +1,14 @@
+package schemas
+
+import "github.com/google/uuid"
+
+// ClinicCreate represents a request to add a clinic
+type ClinicCreate struct {
+	Name string `json:"name"`
+}
+
+// PatientTransfer represents a request to move a patient to another clinic
+type PatientTransfer struct {
+	ClinicID uuid.UUID `json:"clinic_id"`
+	Reason   string    `json:"reason"`
+}
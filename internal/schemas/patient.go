@@ -1,6 +1,11 @@
 package schemas
 
 import (
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
 	"github.com/yhwbach/makerble/internal/models"
 )
 
@@ -26,6 +31,170 @@ type PatientUpdate struct {
 	MedicalHistory *string        `json:"medical_history,omitempty"`
 }
 
+// Fields returns the patient fields the request sets
+func (p *PatientCreate) Fields() []string {
+	var fields []string
+	for field, value := range map[string]string{
+		"full_name":       p.FullName,
+		"date_of_birth":   p.DateOfBirth,
+		"gender":          string(p.Gender),
+		"address":         p.Address,
+		"phone":           p.Phone,
+		"email":           p.Email,
+		"medical_history": p.MedicalHistory,
+	} {
+		if value != "" {
+			fields = append(fields, field)
+		}
+	}
+	slices.Sort(fields)
+	return fields
+}
+
+// Fields returns the patient fields the update changes
+func (p *PatientUpdate) Fields() []string {
+	var fields []string
+	for field, set := range map[string]bool{
+		"full_name":       p.FullName != nil,
+		"date_of_birth":   p.DateOfBirth != nil,
+		"gender":          p.Gender != nil,
+		"address":         p.Address != nil,
+		"phone":           p.Phone != nil,
+		"email":           p.Email != nil,
+		"medical_history": p.MedicalHistory != nil,
+	} {
+		if set {
+			fields = append(fields, field)
+		}
+	}
+	slices.Sort(fields)
+	return fields
+}
+
+// ClinicalFields returns the clinical fields the request sets
+func (p *PatientCreate) ClinicalFields() []string {
+	if p.MedicalHistory != "" {
+		return []string{"medical_history"}
+	}
+	return nil
+}
+
+// ClinicalFields returns the clinical fields the update changes
+func (p *PatientUpdate) ClinicalFields() []string {
+	if p.MedicalHistory != nil {
+		return []string{"medical_history"}
+	}
+	return nil
+}
+
+// PatientQuery restricts which patients a lookup returns
+type PatientQuery struct {
+	// CareTeamMember limits the result to the patients the user is currently assigned to or has
+	// emergency access to. Nil returns every patient.
+	CareTeamMember *uuid.UUID
+	// Consent limits the result to the patients currently giving this type of consent
+	Consent string
+	// IDs limits lists to these patients. Nil lists every patient.
+	IDs []uuid.UUID
+	// UpdatedSince limits lists to the patients created or updated since then
+	UpdatedSince *time.Time
+	// Search limits lists to the patients matching every criterion
+	Search []PatientCriterion
+	// Limit and Offset page lists. A zero limit lists every patient.
+	Limit  int
+	Offset int
+}
+
+// PatientCriterion matches the patients matching any of its conditions. A criterion without
+// conditions matches no patient.
+type PatientCriterion struct {
+	IDs        []uuid.UUID
+	Genders    []models.Gender
+	Names      []NameMatch
+	BirthDates []DateRange
+}
+
+// NameMatch matches full names. By default names match when they, or any of their words, start
+// with the value, ignoring case.
+type NameMatch struct {
+	Value string
+	// Exact matches the name as is
+	Exact bool
+	// Contains matches names containing the value, ignoring case
+	Contains bool
+}
+
+// DateRange matches the days in [From, Until), or those outside of it with Outside. A nil bound
+// leaves the range open.
+type DateRange struct {
+	From    *time.Time
+	Until   *time.Time
+	Outside bool
+}
+
+// Matches reports whether the patient matches the criterion, as lists matching it in the
+// database do
+func (c PatientCriterion) Matches(patient *models.Patient) bool {
+	return slices.Contains(c.IDs, patient.ID) ||
+		slices.Contains(c.Genders, patient.Gender) ||
+		slices.ContainsFunc(c.Names, func(name NameMatch) bool { return name.Matches(patient.FullName) }) ||
+		slices.ContainsFunc(c.BirthDates, func(dates DateRange) bool { return dates.Matches(patient.DateOfBirth) })
+}
+
+// Matches reports whether the full name matches
+func (m NameMatch) Matches(fullName string) bool {
+	switch {
+	case m.Exact:
+		return fullName == m.Value
+	case m.Contains:
+		return strings.Contains(strings.ToLower(fullName), strings.ToLower(m.Value))
+	}
+
+	value := strings.ToLower(m.Value)
+	if strings.HasPrefix(strings.ToLower(fullName), value) {
+		return true
+	}
+	return slices.ContainsFunc(strings.Fields(fullName), func(part string) bool {
+		return strings.HasPrefix(strings.ToLower(part), value)
+	})
+}
+
+// Matches reports whether the day of the date is in the range
+func (r DateRange) Matches(date time.Time) bool {
+	day := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.UTC)
+	in := (r.From == nil || !day.Before(*r.From)) && (r.Until == nil || day.Before(*r.Until))
+	return in != r.Outside
+}
+
+// CareTeamAssign represents a request to add a user to the care team of a patient
+type CareTeamAssign struct {
+	UserID     uuid.UUID  `json:"user_id"`
+	Role       string     `json:"role"`
+	ValidFrom  *time.Time `json:"valid_from,omitempty"` // Defaults to now
+	ValidUntil *time.Time `json:"valid_until,omitempty"`
+}
+
+// ConsentCreate represents a request to record a patient's consent
+type ConsentCreate struct {
+	Type        string     `json:"type"`
+	Scope       string     `json:"scope,omitempty"`
+	GrantedAt   *time.Time `json:"granted_at,omitempty"` // Defaults to now
+	DocumentRef string     `json:"document_ref,omitempty"`
+}
+
+// EmergencyAccessRequest represents a request for emergency access to a patient outside of the care team
+type EmergencyAccessRequest struct {
+	Justification string `json:"justification"`
+}
+
+// EmergencyAccessQuery narrows down the emergency access report
+type EmergencyAccessQuery struct {
+	Since     *time.Time
+	Until     *time.Time
+	PatientID *uuid.UUID
+	UserID    *uuid.UUID
+}
+
 type PaginationQuery struct {
 	Page     int `json:"page" form:"page,default=1"`
 	PageSize int `json:"page_size" form:"page_size,default=10"`
@@ -49,4 +218,44 @@ type Patients struct {
 type PatientCreateResponse struct {
 	Message string `json:"message"`
 	PatientID string `json:"patient_id"`
-}
\ No newline at end of file
+}
+
+// AuditQuery narrows down the audit log
+type AuditQuery struct {
+	// PatientID matches events about the patient, including lists that returned it
+	PatientID *uuid.UUID
+	ActorID   *uuid.UUID
+	Action    string
+	Since     *time.Time
+	Until     *time.Time
+	Limit     int
+}
+
+// PatientExport is everything held about a patient, compiled for a right-of-access request
+type PatientExport struct {
+	ExportedAt      time.Time                     `json:"exported_at"`
+	ExportedBy      uuid.UUID                     `json:"exported_by"`
+	Patient         *models.Patient               `json:"patient"`
+	Clinic          *models.Clinic                `json:"clinic,omitempty"`
+	RegisteredBy    *PatientExportUser            `json:"registered_by,omitempty"` // Absent when the user is gone or in another clinic
+	Consents        []models.Consent              `json:"consents"`
+	CareTeam        []models.CareTeamAssignment   `json:"care_team"`
+	EmergencyAccess []models.EmergencyAccessGrant `json:"emergency_access"`
+	AuditEvents     []PatientExportEvent          `json:"audit_events"` // Oldest first
+}
+
+// PatientExportEvent is an audit event about the patient in a patient export. The details of
+// events, which can name other patients, and the addresses and requests of staff are left out.
+type PatientExportEvent struct {
+	OccurredAt time.Time  `json:"occurred_at"`
+	Action     string     `json:"action"`
+	ActorID    *uuid.UUID `json:"actor_id,omitempty"`
+	Fields     []string   `json:"fields"`
+}
+
+// PatientExportUser identifies a user in a patient export without disclosing their account details
+type PatientExportUser struct {
+	ID       uuid.UUID       `json:"id"`
+	FullName string          `json:"full_name"`
+	UserType models.UserType `json:"user_type"`
+}
@@ -1,6 +1,8 @@
 package schemas
 
 import (
+	"time"
+
 	"github.com/google/uuid"
 	"github.com/yhwbach/makerble/internal/models"
 )
@@ -13,10 +15,34 @@ type UserUpdate struct {
 	UserType *models.UserType `json:"user_type,omitempty"`
 }
 
+// UserProfileUpdate represents a request to update the profile fields of a user
+type UserProfileUpdate struct {
+	Username *string `json:"username,omitempty"`
+	Email    *string `json:"email,omitempty"`
+	FullName *string `json:"full_name,omitempty"`
+}
+
+// RoleChange represents a request to change the role of a user
+type RoleChange struct {
+	UserType models.UserType `json:"user_type"`
+}
+
+// PasswordReset represents a request by an admin to set a new password for a user
+type PasswordReset struct {
+	NewPassword string `json:"new_password"`
+}
+
+// UserListResponse represents a list of users
+type UserListResponse struct {
+	Users []models.User `json:"users"`
+	Total int           `json:"total"`
+}
+
 // UserLogin represents login request body
 type UserLogin struct {
-	Username string `json:"username"`
-	Password string `json:"password"`
+	Username   string `json:"username"`
+	Password   string `json:"password"`
+	DeviceName string `json:"device_name,omitempty"`
 }
 
 // UserRegister represents registration request body
@@ -25,7 +51,17 @@ type UserRegister struct {
 	Password string          `json:"password"`
 	Email    string          `json:"email"`
 	FullName string          `json:"full_name"`
-	UserType models.UserType `json:"user_type"`
+	UserType models.UserType `json:"user_type"` // Ignored when registering with an invitation
+	// InvitationToken is required unless open registration is enabled
+	InvitationToken string `json:"invitation_token,omitempty"`
+	// ClinicID is taken from the invitation. Users registering without one join the default clinic.
+	ClinicID uuid.UUID `json:"-"`
+}
+
+// PasswordChange represents a request to change the password of the logged in user
+type PasswordChange struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
 }
 
 // TokenResponse represents the response after successful authentication
@@ -40,3 +76,68 @@ type UserRegisterResponse struct {
 	Message string    `json:"message"`
 	UserID  uuid.UUID `json:"user_id"`
 }
+
+// SessionResponse represents a login session of the current user
+type SessionResponse struct {
+	models.Session
+	Current bool `json:"current"`
+}
+
+// SessionsRevokedResponse represents the response after revoking sessions
+type SessionsRevokedResponse struct {
+	Message string `json:"message"`
+	Revoked int64  `json:"revoked"`
+}
+
+// InvitationCreate represents a request by an admin to invite a new user
+type InvitationCreate struct {
+	Email    string          `json:"email"`
+	UserType models.UserType `json:"user_type"`
+	// ClinicID invites into another clinic than the admin's own, which requires clinic.manage
+	ClinicID *uuid.UUID `json:"clinic_id,omitempty"`
+}
+
+// InvitationCreateResponse represents a newly created invitation. The token is only returned once.
+type InvitationCreateResponse struct {
+	Invitation models.Invitation `json:"invitation"`
+	Token      string            `json:"token"`
+}
+
+// ServiceAccountCreate represents a request by an admin to create a service account
+type ServiceAccountCreate struct {
+	Username string `json:"username"`
+	FullName string `json:"full_name"`
+	Email    string `json:"email,omitempty"` // Defaults to an address that cannot receive mail
+}
+
+// APIKeyCreate represents a request by an admin to issue an API key to a service account
+type APIKeyCreate struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// APIKeyRotate represents a request to replace an API key. The old key keeps working for the
+// grace period, e.g. "24h", so clients can be switched over; without one it is revoked at once.
+type APIKeyRotate struct {
+	GracePeriod string `json:"grace_period,omitempty"`
+}
+
+// APIKeyCreateResponse represents a newly issued API key. The key is only returned once.
+type APIKeyCreateResponse struct {
+	APIKey models.APIKey `json:"api_key"`
+	Key    string        `json:"key"`
+}
+
+// RoleCreate represents a request by an admin to define a new role
+type RoleCreate struct {
+	Name        models.UserType `json:"name"`
+	Description string          `json:"description"`
+	Permissions []string        `json:"permissions"`
+}
+
+// RoleUpdate represents a request by an admin to change the permissions of a role
+type RoleUpdate struct {
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+}
@@ -0,0 +1,386 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/yhwbach/makerble/internal/models"
+	"github.com/yhwbach/makerble/internal/schemas"
+)
+
+// @Summary List users
+// @Description List all users (Admin only)
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} schemas.UserListResponse
+// @Failure 403,500 {object} ErrorResponse
+// @Router /admin/users [get]
+func (a *Application) listUsersHandler(w http.ResponseWriter, r *http.Request) {
+	users, err := a.Repo.Users.FindAll(r.Context())
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error fetching users")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, schemas.UserListResponse{
+		Users: users,
+		Total: len(users),
+	})
+}
+
+// @Summary Get user
+// @Description Get a user by ID (Admin only)
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "User ID"
+// @Success 200 {object} models.User
+// @Failure 400,403,404 {object} ErrorResponse
+// @Router /admin/users/{id} [get]
+func (a *Application) getUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := a.userFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, user)
+}
+
+// @Summary Update user
+// @Description Update the username, email or full name of a user (Admin only)
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "User ID"
+// @Param profile body schemas.UserProfileUpdate true "Profile update"
+// @Success 200 {object} models.User
+// @Failure 400,403,404,409,500 {object} ErrorResponse
+// @Router /admin/users/{id} [patch]
+func (a *Application) updateUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := a.userFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	var update schemas.UserProfileUpdate
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	a.updateProfile(w, r, user, update)
+}
+
+// @Summary Deactivate user
+// @Description Deactivate a user and revoke all of their sessions (Admin only)
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "User ID"
+// @Success 200 {object} models.User
+// @Failure 400,403,404,500 {object} ErrorResponse
+// @Router /admin/users/{id}/deactivate [post]
+func (a *Application) deactivateUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := a.userFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	if adminID, err := currentUserID(r); err != nil || adminID == user.ID {
+		respondWithError(w, http.StatusBadRequest, "You cannot deactivate your own account")
+		return
+	}
+
+	updated, err := a.Repo.Users.SetActive(r.Context(), user.ID, false)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error deactivating user")
+		return
+	}
+
+	if _, err := a.Repo.Sessions.RevokeAllForUser(r.Context(), user.ID, nil); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error revoking sessions")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, updated)
+}
+
+// @Summary Reactivate user
+// @Description Reactivate a deactivated user (Admin only)
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "User ID"
+// @Success 200 {object} models.User
+// @Failure 400,403,404,500 {object} ErrorResponse
+// @Router /admin/users/{id}/reactivate [post]
+func (a *Application) reactivateUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := a.userFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	updated, err := a.Repo.Users.SetActive(r.Context(), user.ID, true)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error reactivating user")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, updated)
+}
+
+// @Summary Reset user password
+// @Description Set a new password for a user and revoke all of their sessions (Admin only)
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "User ID"
+// @Param password body schemas.PasswordReset true "New password"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} ValidationErrorResponse
+// @Failure 403,404,500 {object} ErrorResponse
+// @Router /admin/users/{id}/reset-password [post]
+func (a *Application) resetUserPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := a.userFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	if user.AuthProvider != models.LocalProvider {
+		respondWithError(w, http.StatusBadRequest, "Password is managed by the identity provider")
+		return
+	}
+
+	var reset schemas.PasswordReset
+	if err := json.NewDecoder(r.Body).Decode(&reset); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	violations, err := a.PasswordPolicy.Validate(reset.NewPassword, user.Username, user.Email)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error resetting password")
+		return
+	}
+
+	if len(violations) > 0 {
+		respondWithValidationErrors(w, http.StatusBadRequest, "Password does not meet the password policy", violations)
+		return
+	}
+
+	hashedPassword, err := a.PasswordHasher.Hash(reset.NewPassword)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error resetting password")
+		return
+	}
+
+	if err := a.Repo.Users.UpdatePassword(r.Context(), user.ID, hashedPassword); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error resetting password")
+		return
+	}
+
+	if _, err := a.Repo.Sessions.RevokeAllForUser(r.Context(), user.ID, nil); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error revoking sessions")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]string{"message": "Password reset successfully"})
+}
+
+// @Summary Change user role
+// @Description Change the role of a user and revoke their sessions so the new role takes effect (Admin only)
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "User ID"
+// @Param role body schemas.RoleChange true "New role"
+// @Success 200 {object} models.User
+// @Failure 400,403,404,500 {object} ErrorResponse
+// @Router /admin/users/{id}/role [put]
+func (a *Application) changeUserRoleHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := a.userFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	var change schemas.RoleChange
+	if err := json.NewDecoder(r.Body).Decode(&change); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	valid, err := a.roleExists(r.Context(), change.UserType)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error changing role")
+		return
+	}
+	if !valid {
+		respondWithError(w, http.StatusBadRequest, "Invalid user type")
+		return
+	}
+
+	allowed, err := a.canAssignRole(r, change.UserType)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error changing role")
+		return
+	}
+	if !allowed {
+		respondWithError(w, http.StatusForbidden, "Access denied")
+		return
+	}
+
+	if user.UserType == models.Service {
+		respondWithError(w, http.StatusBadRequest, "Service accounts cannot be given a role")
+		return
+	}
+
+	if adminID, err := currentUserID(r); err != nil || adminID == user.ID {
+		respondWithError(w, http.StatusBadRequest, "You cannot change your own role")
+		return
+	}
+
+	updated, err := a.Repo.Users.UpdateByID(r.Context(), user.ID, &schemas.UserUpdate{UserType: &change.UserType})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error changing role")
+		return
+	}
+
+	// Tokens carry the role, so existing sessions must not outlive the change
+	if _, err := a.Repo.Sessions.RevokeAllForUser(r.Context(), user.ID, nil); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error revoking sessions")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, updated)
+}
+
+// @Summary List user sessions
+// @Description List the active sessions of a user (Admin only)
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "User ID"
+// @Success 200 {array} models.Session
+// @Failure 400,403,404,500 {object} ErrorResponse
+// @Router /admin/users/{id}/sessions [get]
+func (a *Application) listUserSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := a.userFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	sessions, err := a.Repo.Sessions.ListActiveByUser(r.Context(), user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error fetching sessions")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, sessions)
+}
+
+// @Summary Revoke user sessions
+// @Description Revoke every session of a user, e.g. after a device was lost (Admin only)
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "User ID"
+// @Success 200 {object} schemas.SessionsRevokedResponse
+// @Failure 400,403,404,500 {object} ErrorResponse
+// @Router /admin/users/{id}/sessions [delete]
+func (a *Application) revokeUserSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := a.userFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	revoked, err := a.Repo.Sessions.RevokeAllForUser(r.Context(), user.ID, nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error revoking sessions")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, schemas.SessionsRevokedResponse{
+		Message: "Sessions revoked successfully",
+		Revoked: revoked,
+	})
+}
+
+// @Summary Revoke user session
+// @Description Revoke a single session of a user (Admin only)
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "User ID"
+// @Param sessionID path string true "Session ID"
+// @Success 204 "No Content"
+// @Failure 400,403,404,500 {object} ErrorResponse
+// @Router /admin/users/{id}/sessions/{sessionID} [delete]
+func (a *Application) revokeUserSessionHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := a.userFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	sessionID, err := uuid.Parse(chi.URLParam(r, "sessionID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid session ID")
+		return
+	}
+
+	session, err := a.Repo.Sessions.FindByID(r.Context(), sessionID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error fetching session")
+		return
+	}
+	if session == nil || session.UserID != user.ID {
+		respondWithError(w, http.StatusNotFound, "Session not found")
+		return
+	}
+
+	if err := a.Repo.Sessions.Revoke(r.Context(), sessionID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error revoking session")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// userFromURL loads the user identified by the {id} URL parameter, responding with an error if it cannot
+func (a *Application) userFromURL(w http.ResponseWriter, r *http.Request) (*models.User, bool) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID")
+		return nil, false
+	}
+
+	user, err := a.Repo.Users.FindByID(r.Context(), id)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "User not found")
+		return nil, false
+	}
+
+	allowed, err := a.canAssignRole(r, user.UserType)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error fetching user")
+		return nil, false
+	}
+	if !allowed {
+		respondWithError(w, http.StatusForbidden, "Access denied")
+		return nil, false
+	}
+
+	return user, true
+}
@@ -6,31 +6,120 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"strings"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
-	"github.com/go-chi/jwtauth/v5"
 
 	httpSwagger "github.com/swaggo/http-swagger"
 	_ "github.com/yhwbach/makerble/docs"
+	"github.com/yhwbach/makerble/internal/auth"
 	"github.com/yhwbach/makerble/internal/config"
+	"github.com/yhwbach/makerble/internal/fhir"
+	"github.com/yhwbach/makerble/internal/exporter"
+	"github.com/yhwbach/makerble/internal/importer"
+	"github.com/yhwbach/makerble/internal/models"
+	"github.com/yhwbach/makerble/internal/notify"
 	"github.com/yhwbach/makerble/internal/repository"
+	"github.com/yhwbach/makerble/internal/research"
 	"github.com/yhwbach/makerble/internal/utils"
 )
 
 type Application struct {
-	Config     *config.Config
-	Repo       repository.RepoStorage
-	JWTManager *utils.JWTManager
+	Config         *config.Config
+	Repo           repository.RepoStorage
+	JWTManager     *utils.JWTManager
+	PasswordPolicy *utils.PasswordPolicy
+	PasswordHasher utils.PasswordHasher
+	Authenticator  auth.Authenticator
+	Notifier       notify.Notifier
+
+	// OIDC is the single sign-on provider, nil when single sign-on is disabled
+	OIDC *auth.OIDCProvider
+
+	// Research de-identifies research exports, nil when no pseudonym key is configured
+	Research *research.Deidentifier
+
+	// Imports runs bulk patient imports in the background
+	Imports *importer.Importer
+
+	// Exports runs FHIR bulk data exports of patients in the background
+	Exports *exporter.Exporter
 }
 
 func NewApplication(cfg *config.Config, repo repository.RepoStorage, jwtManager *utils.JWTManager) *Application {
+	hasher := NewPasswordHasher(cfg.Password)
+
 	return &Application{
-		Config:     cfg,
-		Repo:       repo,
-		JWTManager: jwtManager,
+		Config:         cfg,
+		Repo:           repo,
+		JWTManager:     jwtManager,
+		PasswordPolicy: NewPasswordPolicy(cfg.Password),
+		PasswordHasher: hasher,
+		Authenticator:  auth.Chain{&auth.LocalAuthenticator{Users: repo.Users, Hasher: hasher}},
+		Notifier:       notify.New(cfg.SMTP),
+		Imports:        importer.New(cfg.Import, repo),
+		Exports:        exporter.New(cfg.Export, repo),
+	}
+}
+
+// NewAuthenticator builds the chain of password authentication backends listed in the configuration
+func NewAuthenticator(cfg *config.Config, repo repository.RepoStorage, hasher utils.PasswordHasher) (auth.Authenticator, error) {
+	var chain auth.Chain
+	for _, backend := range cfg.Auth.Backends {
+		switch strings.TrimSpace(backend) {
+		case "local":
+			chain = append(chain, &auth.LocalAuthenticator{
+				Users:      repo.Users,
+				Hasher:     hasher,
+				AdminsOnly: cfg.Auth.LocalAdminsOnly,
+			})
+		case "ldap":
+			ldapAuth, err := auth.NewLDAPAuthenticator(cfg.LDAP, auth.NewProvisioner(repo))
+			if err != nil {
+				return nil, err
+			}
+			chain = append(chain, ldapAuth)
+		case "":
+		default:
+			return nil, fmt.Errorf("unknown authentication backend %q", backend)
+		}
+	}
+
+	if len(chain) == 0 {
+		return nil, fmt.Errorf("no authentication backend configured")
+	}
+	return chain, nil
+}
+
+// NewPasswordPolicy builds the password policy described by the configuration
+func NewPasswordPolicy(cfg config.PasswordConfig) *utils.PasswordPolicy {
+	policy := &utils.PasswordPolicy{
+		MinLength:     cfg.MinLength,
+		MaxBytes:      cfg.MaxBytes,
+		RequireUpper:  cfg.RequireUpper,
+		RequireLower:  cfg.RequireLower,
+		RequireDigit:  cfg.RequireDigit,
+		RequireSymbol: cfg.RequireSymbol,
+	}
+	if cfg.BreachedDir != "" {
+		policy.Breached = utils.NewBreachedPasswords(cfg.BreachedDir)
 	}
+	return policy
+}
+
+// NewPasswordHasher builds the password hasher described by the configuration
+func NewPasswordHasher(cfg config.PasswordConfig) utils.PasswordHasher {
+	return utils.NewPasswordHasher(
+		cfg.HashAlgorithm,
+		utils.Argon2Params{
+			Memory:      uint32(cfg.Argon2Memory),
+			Iterations:  uint32(cfg.Argon2Iterations),
+			Parallelism: uint8(cfg.Argon2Parallelism),
+		},
+		cfg.BcryptCost,
+	)
 }
 
 func GetProjectRoot() (string, error) {
@@ -54,6 +143,9 @@ func GetProjectRoot() (string, error) {
     }
 }
 
+// requestTimeout bounds the time taken by requests other than bulk exports
+const requestTimeout = 60 * time.Second
+
 func (a *Application) Mount() http.Handler {
 	r := chi.NewRouter()
 
@@ -62,11 +154,21 @@ func (a *Application) Mount() http.Handler {
 	// Basic middleware
 	r.Use(middleware.RealIP)
 	r.Use(middleware.RequestID)
-	r.Use(middleware.Logger)
+	r.Use(a.logRequests)
 	r.Use(middleware.Recoverer)
-	r.Use(middleware.Timeout(60 * time.Second))
+
+	// Bulk exports stream for as long as the client keeps reading, so they are routed ahead of the
+	// request timeout applied to everything else
+	r.Group(func(r chi.Router) {
+		r.Use(middleware.NoCache)
+		export := chi.Chain(a.verifier, a.authenticator, a.require(models.PermissionPatientRead), a.require(models.PermissionPatientBulkExport))
+
+		r.With(export...).Get(prefix+"/patients/export", a.bulkExportPatientsHandler)
+		r.With(fhirOutcomes).With(export...).Get("/fhir/bulk/{id}/Patient.ndjson", a.fhirBulkExportFileHandler)
+	})
 
 	r.Route(prefix, func(r chi.Router) {
+		r.Use(middleware.Timeout(requestTimeout))
 		r.Use(middleware.NoCache)
 		r.Use(middleware.Compress(5, "application/json"))
 
@@ -76,37 +178,163 @@ func (a *Application) Mount() http.Handler {
 		// Auth routes (no authentication required)
 		r.Post("/register", a.registerHandler)
 		r.Post("/login", a.loginHandler)
+		r.Get("/auth/oidc/login", a.oidcLoginHandler)
+		r.Get("/auth/oidc/callback", a.oidcCallbackHandler)
 
 		// Protected routes
 		r.Group(func(r chi.Router) {
-			r.Use(jwtauth.Verifier(a.JWTManager.Auth))
+			r.Use(a.verifier)
 			r.Use(a.authenticator)
 
-			r.Post("/logout", a.logoutHandler)
+			// Account routes, not available to API keys
+			r.Group(func(r chi.Router) {
+				r.Use(a.usersOnly)
+
+				r.Post("/logout", a.logoutHandler)
+				r.Get("/me", a.getMeHandler)
+				r.Patch("/me", a.updateMeHandler)
+				r.Put("/me/password", a.changePasswordHandler)
+
+				// Session routes
+				r.Route("/sessions", func(r chi.Router) {
+					r.Get("/", a.listSessionsHandler)
+					r.Delete("/", a.revokeAllSessionsHandler)
+					r.Delete("/{id}", a.revokeSessionHandler)
+				})
+			})
 
 			// Patient routes
 			r.Route("/patients", func(r chi.Router) {
-				r.Get("/", a.listPatientsHandler)
-				r.Get("/{id}", a.getPatientHandler)
-
-				// Receptionist only routes
-				r.Group(func(r chi.Router) {
-					r.Use(a.receptionistOnly)
-					r.Put("/{id}", a.updatePatientLimitedHandler)
-					r.Delete("/{id}", a.deletePatientHandler)
+				r.With(a.require(models.PermissionPatientRead)).Get("/", a.listPatientsHandler)
+				r.With(a.require(models.PermissionPatientRead)).Get("/{id}", a.getPatientHandler)
+				r.With(a.require(models.PermissionPatientCreate)).Post("/", a.createPatientHandler)
+				r.With(a.require(models.PermissionPatientUpdateDemographics)).Put("/{id}", a.updatePatientLimitedHandler)
+				r.With(a.require(models.PermissionPatientUpdateClinical)).Patch("/{id}", a.updatePatientHandler)
+				r.With(a.require(models.PermissionPatientDelete)).Delete("/{id}", a.deletePatientHandler)
+
+				r.With(a.usersOnly, a.require(models.PermissionPatientEmergencyAccess)).Post("/{id}/emergency-access", a.requestEmergencyAccessHandler)
+				r.With(a.usersOnly, a.require(models.PermissionPatientTransfer)).Post("/{id}/transfer", a.transferPatientHandler)
+				r.With(a.usersOnly, a.require(models.PermissionPatientExport)).Get("/{id}/export", a.exportPatientHandler)
+
+				r.Route("/imports", func(r chi.Router) {
+					r.Use(a.usersOnly, a.require(models.PermissionPatientImport))
+					r.Get("/", a.listPatientImportsHandler)
+					r.Post("/", a.createPatientImportHandler)
+					r.Get("/{id}", a.getPatientImportHandler)
+					r.Get("/{id}/report", a.patientImportReportHandler)
+				})
+
+				r.Route("/{id}/consents", func(r chi.Router) {
+					r.With(a.require(models.PermissionPatientRead)).Get("/", a.listConsentsHandler)
+					r.With(a.require(models.PermissionConsentManage)).Post("/", a.recordConsentHandler)
+					r.With(a.require(models.PermissionConsentManage)).Delete("/{consentID}", a.revokeConsentHandler)
+				})
+
+				r.Route("/{id}/care-team", func(r chi.Router) {
+					r.Use(a.require(models.PermissionCareTeamManage))
+					r.Get("/", a.listCareTeamHandler)
+					r.Post("/", a.assignCareTeamHandler)
+					r.Delete("/{assignmentID}", a.endCareTeamAssignmentHandler)
+				})
+			})
+
+			r.With(a.usersOnly, a.require(models.PermissionAuditRead)).Get("/audit", a.listAuditEventsHandler)
+
+			// Admin routes
+			r.Route("/admin", func(r chi.Router) {
+				r.Use(a.usersOnly)
+
+				r.Route("/users", func(r chi.Router) {
+					r.Use(a.require(models.PermissionUserManage))
+					r.Get("/", a.listUsersHandler)
+					r.Get("/{id}", a.getUserHandler)
+					r.Patch("/{id}", a.updateUserHandler)
+					r.Post("/{id}/deactivate", a.deactivateUserHandler)
+					r.Post("/{id}/reactivate", a.reactivateUserHandler)
+					r.Post("/{id}/reset-password", a.resetUserPasswordHandler)
+					r.Put("/{id}/role", a.changeUserRoleHandler)
+					r.Get("/{id}/sessions", a.listUserSessionsHandler)
+					r.Delete("/{id}/sessions", a.revokeUserSessionsHandler)
+					r.Delete("/{id}/sessions/{sessionID}", a.revokeUserSessionHandler)
+				})
+
+				r.Route("/invitations", func(r chi.Router) {
+					r.Use(a.require(models.PermissionInvitationManage))
+					r.Get("/", a.listInvitationsHandler)
+					r.Post("/", a.createInvitationHandler)
+					r.Delete("/{id}", a.revokeInvitationHandler)
+				})
+
+				r.Route("/service-accounts", func(r chi.Router) {
+					r.Use(a.require(models.PermissionServiceAccountManage))
+					r.Get("/", a.listServiceAccountsHandler)
+					r.Post("/", a.createServiceAccountHandler)
+					r.Get("/{id}/keys", a.listAPIKeysHandler)
+					r.Post("/{id}/keys", a.createAPIKeyHandler)
+					r.Post("/{id}/keys/{keyID}/rotate", a.rotateAPIKeyHandler)
+					r.Delete("/{id}/keys/{keyID}", a.revokeAPIKeyHandler)
+				})
+
+				r.With(a.require(models.PermissionEmergencyAccessReview)).Get("/emergency-access", a.emergencyAccessReportHandler)
+				r.With(a.require(models.PermissionResearchExport)).Get("/research-export", a.researchExportHandler)
+				r.With(a.require(models.PermissionAuditRead)).Get("/retention/runs", a.listRetentionRunsHandler)
+
+				r.Route("/clinics", func(r chi.Router) {
+					r.Use(a.require(models.PermissionClinicManage))
+					r.Get("/", a.listClinicsHandler)
+					r.Post("/", a.createClinicHandler)
 				})
 
-				// Doctor only routes
-				r.Group(func(r chi.Router) {
-					r.Use(a.doctorOnly)
-					r.Post("/", a.createPatientHandler)
-					r.Patch("/{id}", a.updatePatientHandler)
+				r.Route("/roles", func(r chi.Router) {
+					r.Use(a.require(models.PermissionRoleManage))
+					r.Get("/", a.listRolesHandler)
+					r.Post("/", a.createRoleHandler)
+					r.Get("/{name}", a.getRoleHandler)
+					r.Put("/{name}", a.updateRoleHandler)
+					r.Delete("/{name}", a.deleteRoleHandler)
 				})
 			})
 		})
 
 	})
 
+	// FHIR API for the systems of partner hospitals
+	r.Route("/fhir", func(r chi.Router) {
+		r.Use(middleware.Timeout(requestTimeout))
+		r.Use(middleware.NoCache)
+		r.Use(fhirOutcomes)
+		r.NotFound(func(w http.ResponseWriter, r *http.Request) {
+			respondWithOutcome(w, http.StatusNotFound, fhir.ErrorIssue(fhir.IssueNotSupported, "Unknown resource or interaction"))
+		})
+		r.MethodNotAllowed(func(w http.ResponseWriter, r *http.Request) {
+			respondWithOutcome(w, http.StatusMethodNotAllowed, fhir.ErrorIssue(fhir.IssueNotSupported, "Interaction not supported"))
+		})
+
+		r.Get("/metadata", a.fhirCapabilitiesHandler)
+
+		r.Group(func(r chi.Router) {
+			r.Use(a.verifier)
+			r.Use(a.authenticator)
+
+			r.Route("/Patient", func(r chi.Router) {
+				r.With(a.require(models.PermissionPatientRead)).Get("/", a.fhirSearchPatientsHandler)
+				r.With(a.require(models.PermissionPatientRead)).Post("/_search", a.fhirSearchPatientsHandler)
+				r.With(a.require(models.PermissionPatientRead)).Get("/{id}", a.fhirReadPatientHandler)
+				r.With(a.require(models.PermissionPatientCreate)).Post("/", a.fhirCreatePatientHandler)
+				r.With(a.require(models.PermissionPatientRead), a.require(models.PermissionPatientBulkExport)).Get("/$export", a.fhirBulkExportHandler)
+				// Updates check either update permission themselves
+				r.Put("/{id}", a.fhirUpdatePatientHandler)
+			})
+
+			// Status and files of bulk exports, as linked from the kick-off response
+			r.Route("/bulk/{id}", func(r chi.Router) {
+				r.Use(a.require(models.PermissionPatientRead), a.require(models.PermissionPatientBulkExport))
+				r.Get("/", a.fhirBulkExportStatusHandler)
+				r.Delete("/", a.fhirCancelBulkExportHandler)
+			})
+		})
+	})
+
 	r.Get("/docs/*", httpSwagger.Handler(
 		httpSwagger.URL("http://localhost:5000/docs/swagger.json"),
 	))
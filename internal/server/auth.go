@@ -2,23 +2,30 @@ package server
 
 import (
 	"encoding/json"
+	"errors"
+	"log/slog"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/go-chi/jwtauth/v5"
 	"github.com/google/uuid"
+	"github.com/yhwbach/makerble/internal/auth"
+	"github.com/yhwbach/makerble/internal/models"
 	"github.com/yhwbach/makerble/internal/schemas"
 	"github.com/yhwbach/makerble/internal/utils"
 )
 
 // @Summary Register new user
-// @Description Register a new doctor or receptionist
+// @Description Register a new user with an admin issued invitation. The invitation determines the role.
+// @Description Without an invitation registration is only possible when open registration is enabled.
 // @Tags auth
 // @Accept json
 // @Produce json
 // @Param user body schemas.UserRegister true "User registration info"
 // @Success 201 {object} schemas.UserRegisterResponse
-// @Failure 400 {object} ErrorResponse
+// @Failure 400 {object} ValidationErrorResponse
+// @Failure 403,409 {object} ErrorResponse
 // @Router /register [post]
 func (a *Application) registerHandler(w http.ResponseWriter, r *http.Request) {
 	var user schemas.UserRegister
@@ -27,6 +34,19 @@ func (a *Application) registerHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if user.InvitationToken == "" {
+		if !a.Config.Auth.OpenRegistration {
+			respondWithError(w, http.StatusForbidden, "Registration requires an invitation")
+			return
+		}
+
+		// Admins are only created by other admins or the admin command
+		if user.UserType != models.Doctor && user.UserType != models.Receptionist {
+			respondWithError(w, http.StatusBadRequest, "Invalid user type")
+			return
+		}
+	}
+
 	// Check if user email already exists
 	existingUserByEmail, err := a.Repo.Users.FindByEmail(r.Context(), user.Email)
 
@@ -52,14 +72,52 @@ func (a *Application) registerHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	hashedPassword, err := utils.HashPassword(user.Password)
+	violations, err := a.PasswordPolicy.Validate(user.Password, user.Username, user.Email)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error processing registration")
+		return
+	}
+
+	if len(violations) > 0 {
+		respondWithValidationErrors(w, http.StatusBadRequest, "Password does not meet the password policy", violations)
+		return
+	}
+
+	hashedPassword, err := a.PasswordHasher.Hash(user.Password)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Error processing registration")
 		return
 	}
 
+	// Claim the invitation only once everything else checked out so a typo does not burn it
+	var invitation *models.Invitation
+	if user.InvitationToken != "" {
+		invitation, err = a.Repo.Invitations.Claim(r.Context(), user.InvitationToken)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Error processing registration")
+			return
+		}
+		if invitation == nil {
+			respondWithError(w, http.StatusForbidden, "Invalid or expired invitation")
+			return
+		}
+
+		if !strings.EqualFold(strings.TrimSpace(user.Email), invitation.Email) {
+			a.releaseInvitation(r, invitation)
+			respondWithError(w, http.StatusForbidden, "Invitation was issued for a different email address")
+			return
+		}
+
+		// The role and clinic are chosen by the admin who issued the invitation
+		user.UserType = invitation.UserType
+		user.ClinicID = invitation.ClinicID
+	}
+
 	userID, err := a.Repo.Users.Create(r.Context(), &user, hashedPassword)
 	if err != nil {
+		if invitation != nil {
+			a.releaseInvitation(r, invitation)
+		}
 		respondWithError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
@@ -70,6 +128,12 @@ func (a *Application) registerHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if invitation != nil {
+		if err := a.Repo.Invitations.MarkAccepted(r.Context(), invitation.ID, userIDUUID); err != nil {
+			slog.ErrorContext(r.Context(), "failed to record acceptance of invitation", "invitation_id", invitation.ID, "error", err)
+		}
+	}
+
 	respondWithJSON(w, http.StatusCreated, schemas.UserRegisterResponse{
 		UserID:   userIDUUID,
 		Message: "User registered successfully",
@@ -77,13 +141,13 @@ func (a *Application) registerHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 // @Summary Login user
-// @Description Login for doctors and receptionists
+// @Description Login with a username and password, checked against the configured authentication backends
 // @Tags auth
 // @Accept json
 // @Produce json
 // @Param credentials body schemas.UserLogin true "Login credentials"
 // @Success 200 {object} schemas.TokenResponse
-// @Failure 401 {object} ErrorResponse
+// @Failure 401,403,409,503 {object} ErrorResponse
 // @Router /login [post]
 func (a *Application) loginHandler(w http.ResponseWriter, r *http.Request) {
 	var login schemas.UserLogin
@@ -92,28 +156,18 @@ func (a *Application) loginHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := a.Repo.Users.FindByUsername(r.Context(), login.Username)
+	user, err := a.Authenticator.Authenticate(r.Context(), login.Username, login.Password)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Invalid credentials")
+		respondWithAuthError(w, r, err)
 		return
 	}
 
-	if err := utils.CheckPassword(login.Password, user.Password); err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Invalid credentials")
+	if !user.IsActive {
+		respondWithError(w, http.StatusForbidden, "Account is deactivated")
 		return
 	}
 
-	token, err := a.JWTManager.GenerateToken(user.ID, string(user.UserType))
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Error generating token")
-		return
-	}
-
-	respondWithJSON(w, http.StatusOK, schemas.TokenResponse{
-		AccessToken: token,
-		TokenType:   "Bearer",
-		UserType:    string(user.UserType),
-	})
+	a.issueToken(w, r, user, login.DeviceName)
 }
 
 // @Summary Logout user
@@ -132,9 +186,9 @@ func (a *Application) logoutHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	tokenStr, err := utils.ExtractBearerToken(r.Header.Get("Authorization"))
+	tokenID, err := utils.GetTokenIDFromContext(r.Context())
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Invalid authorization header")
+		respondWithError(w, http.StatusUnauthorized, "Invalid token")
 		return
 	}
 
@@ -151,10 +205,156 @@ func (a *Application) logoutHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Invalidate the token
-	if err := a.Repo.Tokens.InvalidateToken(r.Context(), tokenStr, expirationTime); err != nil {
+	if err := a.Repo.Tokens.InvalidateToken(r.Context(), tokenID, expirationTime); err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Error invalidating token")
 		return
 	}
 
+	sessionID, err := utils.GetSessionIDFromContext(r.Context())
+	if err == nil {
+		if err := a.Repo.Sessions.Revoke(r.Context(), sessionID); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Error revoking session")
+			return
+		}
+	}
+
 	respondWithJSON(w, http.StatusOK, map[string]string{"message": "Successfully logged out"})
 }
+
+// @Summary Change password
+// @Description Change the password of the logged in user. The current password must be provided again and all other sessions are revoked.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param passwords body schemas.PasswordChange true "Current and new password"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} ValidationErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Router /me/password [put]
+func (a *Application) changePasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var change schemas.PasswordChange
+	if err := json.NewDecoder(r.Body).Decode(&change); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	userID, err := utils.GetUserIDFromContext(r.Context())
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid token")
+		return
+	}
+
+	id, err := uuid.Parse(userID)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid token")
+		return
+	}
+
+	user, err := a.Repo.Users.FindByID(r.Context(), id)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid credentials")
+		return
+	}
+
+	if user.AuthProvider != models.LocalProvider {
+		respondWithError(w, http.StatusBadRequest, "Password is managed by the identity provider")
+		return
+	}
+
+	// Re-authenticate before allowing the password to be replaced
+	if err := a.PasswordHasher.Verify(change.CurrentPassword, user.Password); err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid credentials")
+		return
+	}
+
+	violations, err := a.PasswordPolicy.Validate(change.NewPassword, user.Username, user.Email)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error changing password")
+		return
+	}
+
+	if change.NewPassword == change.CurrentPassword {
+		violations = append(violations, "new password must differ from the current password")
+	}
+
+	if len(violations) > 0 {
+		respondWithValidationErrors(w, http.StatusBadRequest, "Password does not meet the password policy", violations)
+		return
+	}
+
+	hashedPassword, err := a.PasswordHasher.Hash(change.NewPassword)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error changing password")
+		return
+	}
+
+	if err := a.Repo.Users.UpdatePassword(r.Context(), user.ID, hashedPassword); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error changing password")
+		return
+	}
+
+	// Sign out every other device that may have been using the old password
+	currentSessionID, err := utils.GetSessionIDFromContext(r.Context())
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid token")
+		return
+	}
+	if _, err := a.Repo.Sessions.RevokeAllForUser(r.Context(), user.ID, &currentSessionID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error revoking sessions")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]string{"message": "Password changed successfully"})
+}
+
+// issueToken opens a session for an authenticated user and responds with a token bound to it
+func (a *Application) issueToken(w http.ResponseWriter, r *http.Request, user *models.User, deviceName string) {
+	session := &models.Session{
+		UserID:    user.ID,
+		Device:    describeDevice(deviceName, r.UserAgent()),
+		UserAgent: r.UserAgent(),
+		IPAddress: r.RemoteAddr,
+		ExpiresAt: time.Now().Add(a.JWTManager.Expiry),
+	}
+	if err := a.Repo.Sessions.Create(r.Context(), session); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error creating session")
+		return
+	}
+
+	token, err := a.JWTManager.GenerateToken(user.ID, string(user.UserType), user.ClinicID, session.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error generating token")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, schemas.TokenResponse{
+		AccessToken: token,
+		TokenType:   "Bearer",
+		UserType:    string(user.UserType),
+	})
+}
+
+// respondWithAuthError maps an authentication or provisioning error to a response
+func respondWithAuthError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, auth.ErrInvalidCredentials):
+		respondWithError(w, http.StatusUnauthorized, "Invalid credentials")
+	case errors.Is(err, auth.ErrNoRole):
+		respondWithError(w, http.StatusForbidden, "Your account is not assigned a role in this application")
+	case errors.Is(err, auth.ErrNoEmail):
+		respondWithError(w, http.StatusForbidden, "Your account has no email address")
+	case errors.Is(err, auth.ErrEmailTaken):
+		respondWithError(w, http.StatusConflict, "An account with this email already exists")
+	default:
+		slog.ErrorContext(r.Context(), "authentication failed", "error", err)
+		respondWithError(w, http.StatusServiceUnavailable, "Authentication service unavailable")
+	}
+}
+
+// releaseInvitation makes a claimed invitation usable again after a failed registration
+func (a *Application) releaseInvitation(r *http.Request, invitation *models.Invitation) {
+	if err := a.Repo.Invitations.Release(r.Context(), invitation.ID); err != nil {
+		slog.ErrorContext(r.Context(), "failed to release invitation", "invitation_id", invitation.ID, "error", err)
+	}
+}
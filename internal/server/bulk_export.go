@@ -0,0 +1,181 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/yhwbach/makerble/internal/exporter"
+	"github.com/yhwbach/makerble/internal/models"
+	"github.com/yhwbach/makerble/internal/schemas"
+)
+
+// exportFlushRows is the number of patients buffered before a streamed export is written out
+const exportFlushRows = 100
+
+// exportWriteTimeout is the time given to each write of a streamed export, which as a whole may
+// take longer than the write timeout of the server
+const exportWriteTimeout = 30 * time.Second
+
+// defaultExportAuditBatchSize applies when the configuration does not set the audit batch size
+const defaultExportAuditBatchSize = 1000
+
+// @Summary Export patients
+// @Description Export the patients the caller can list as CSV or NDJSON, streamed as they are read (requires
+// @Description patient.read and patient.bulk_export). Only patients consenting to data sharing are exported.
+// @Description Clinical fields are left out without patient.read.clinical.
+// @Description NDJSON lines hold the patients as listed by GET /patients.
+// @Tags patients
+// @Produce text/csv
+// @Produce application/x-ndjson
+// @Security BearerAuth
+// @Param format query string false "csv (default) or ndjson"
+// @Param updated_since query string false "Only patients created or updated at or after this time (RFC 3339)"
+// @Success 200 {file} file
+// @Failure 400 {object} ValidationErrorResponse
+// @Failure 403,500 {object} ErrorResponse
+// @Router /patients/export [get]
+func (a *Application) bulkExportPatientsHandler(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	format := params.Get("format")
+	if format == "" {
+		format = exporter.FormatCSV
+	}
+	if !exporter.IsValidFormat(format) {
+		respondWithValidationErrors(w, http.StatusBadRequest, "Invalid export format", exporter.Formats)
+		return
+	}
+
+	query, err := a.sharedPatientQuery(r)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error fetching patients")
+		return
+	}
+	if value := params.Get("updated_since"); value != "" {
+		since, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			respondWithValidationErrors(w, http.StatusBadRequest, "Invalid query parameters", []string{"updated_since"})
+			return
+		}
+		query.UpdatedSince = &since
+	}
+
+	readClinical, err := a.can(r, models.PermissionPatientReadClinical)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error checking permissions")
+		return
+	}
+	fields := models.PatientFields
+	if !readClinical {
+		fields = slices.DeleteFunc(slices.Clone(fields), func(field string) bool {
+			return slices.Contains(models.PatientClinicalFields, field)
+		})
+	}
+
+	// The patients are selected up front so that the access is on record before anything is
+	// disclosed; the export is then limited to them
+	query.IDs, err = a.Repo.Patients.FindIDs(r.Context(), query)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error fetching patients")
+		return
+	}
+	err = a.auditPatientExport(r, query.IDs, fields, map[string]interface{}{
+		"format": format,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error recording access")
+		return
+	}
+
+	w.Header().Set("Content-Type", exporter.ContentType(format))
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="patients-%s.%s"`, time.Now().UTC().Format("20060102"), format))
+	w.WriteHeader(http.StatusOK)
+
+	buffered := bufio.NewWriter(w)
+	out, err := exporter.NewWriter(buffered, format, readClinical)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to start patient export", "error", err)
+		return
+	}
+	a.streamPatients(w, r, query, !readClinical, out, buffered)
+}
+
+// streamPatients writes the patients matching the query to the response as they are read,
+// redacting their clinical fields if asked to. The response is flushed every exportFlushRows
+// patients, extending the write deadline each time; exports are routed outside the request
+// timeout and run until the client goes away. The status is sent by then, so errors are logged
+// and cut the response short.
+func (a *Application) streamPatients(w http.ResponseWriter, r *http.Request, query schemas.PatientQuery, redact bool, out exporter.Writer, buffered *bufio.Writer) {
+	// The writers of the middleware unwrap to the connection's, which always sets deadlines
+	controller := http.NewResponseController(w)
+	controller.SetWriteDeadline(time.Now().Add(exportWriteTimeout))
+	flush := func() error {
+		controller.SetWriteDeadline(time.Now().Add(exportWriteTimeout))
+		if err := out.Flush(); err != nil {
+			return err
+		}
+		return buffered.Flush()
+	}
+
+	rows := 0
+	err := a.Repo.Patients.Stream(r.Context(), query, func(patient schemas.Patients) error {
+		if redact {
+			patient.RedactClinical()
+		}
+		if err := out.Write(patient); err != nil {
+			return err
+		}
+		rows++
+		if rows%exportFlushRows == 0 {
+			return flush()
+		}
+		return nil
+	})
+	if err == nil {
+		err = flush()
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "patient export stopped", "rows", rows, "error", err)
+	}
+}
+
+// auditPatientExport records an export of the patients in the audit log, in events listing at
+// most the configured audit batch size of patients each so that no single event grows with the
+// export. Every event carries the details given along with the total number of patients exported.
+func (a *Application) auditPatientExport(r *http.Request, patientIDs []uuid.UUID, fields []string, details map[string]interface{}) error {
+	batchSize := a.Config.Export.AuditBatchSize
+	if batchSize <= 0 {
+		batchSize = defaultExportAuditBatchSize
+	}
+
+	// An empty export is recorded too
+	batches := slices.Collect(slices.Chunk(patientIDs, batchSize))
+	if len(batches) == 0 {
+		batches = [][]uuid.UUID{nil}
+	}
+
+	for _, batch := range batches {
+		event := maps.Clone(details)
+		event["patient_ids"] = patientIDStrings(batch)
+		event["count"] = len(batch)
+		event["total"] = len(patientIDs)
+		if err := a.auditPatientAccess(r, models.AuditActionPatientBulkExport, nil, fields, event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// patientIDStrings lists patient IDs as recorded in audit event details
+func patientIDStrings(ids []uuid.UUID) []string {
+	values := make([]string, 0, len(ids))
+	for _, id := range ids {
+		values = append(values, id.String())
+	}
+	return values
+}
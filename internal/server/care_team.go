@@ -0,0 +1,155 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/yhwbach/makerble/internal/models"
+	"github.com/yhwbach/makerble/internal/repository"
+	"github.com/yhwbach/makerble/internal/schemas"
+)
+
+// @Summary List care team
+// @Description List the current and past care team assignments of a patient (requires care_team.manage)
+// @Tags patients
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Patient ID"
+// @Success 200 {array} models.CareTeamAssignment
+// @Failure 400,403,404,500 {object} ErrorResponse
+// @Router /patients/{id}/care-team [get]
+func (a *Application) listCareTeamHandler(w http.ResponseWriter, r *http.Request) {
+	patient, ok := a.patientFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	assignments, err := a.Repo.CareTeams.ListByPatient(r.Context(), patient.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error fetching care team")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, assignments)
+}
+
+// @Summary Assign care team member
+// @Description Give a user access to a patient as a member of its care team (requires care_team.manage)
+// @Tags patients
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Patient ID"
+// @Param assignment body schemas.CareTeamAssign true "Assignment"
+// @Success 201 {object} models.CareTeamAssignment
+// @Failure 400 {object} ValidationErrorResponse
+// @Failure 403,404,500 {object} ErrorResponse
+// @Router /patients/{id}/care-team [post]
+func (a *Application) assignCareTeamHandler(w http.ResponseWriter, r *http.Request) {
+	patient, ok := a.patientFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	var request schemas.CareTeamAssign
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	if !models.IsValidCareTeamRole(request.Role) {
+		respondWithValidationErrors(w, http.StatusBadRequest, "Invalid care team role", models.CareTeamRoles)
+		return
+	}
+
+	validFrom := time.Now()
+	if request.ValidFrom != nil {
+		validFrom = *request.ValidFrom
+	}
+	if request.ValidUntil != nil && !request.ValidUntil.After(validFrom) {
+		respondWithError(w, http.StatusBadRequest, "valid_until must be after valid_from")
+		return
+	}
+
+	user, err := a.Repo.Users.FindByID(r.Context(), request.UserID)
+	if err != nil && !errors.Is(err, repository.ErrUserNotFound) {
+		respondWithError(w, http.StatusInternalServerError, "Error assigning care team member")
+		return
+	}
+	if user == nil || !user.IsActive || user.UserType == models.Service {
+		respondWithError(w, http.StatusBadRequest, "User not found or inactive")
+		return
+	}
+
+	assignedBy, err := currentUserID(r)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error processing request")
+		return
+	}
+
+	assignment := models.CareTeamAssignment{
+		PatientID:  patient.ID,
+		UserID:     user.ID,
+		Role:       request.Role,
+		ValidFrom:  validFrom,
+		ValidUntil: request.ValidUntil,
+		AssignedBy: assignedBy,
+	}
+	if err := a.Repo.CareTeams.Create(r.Context(), &assignment); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error assigning care team member")
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, assignment)
+}
+
+// @Summary End care team assignment
+// @Description Remove a user from the care team of a patient. The assignment is kept as history. (requires care_team.manage)
+// @Tags patients
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Patient ID"
+// @Param assignmentID path string true "Assignment ID"
+// @Success 204
+// @Failure 400,403,404,500 {object} ErrorResponse
+// @Router /patients/{id}/care-team/{assignmentID} [delete]
+func (a *Application) endCareTeamAssignmentHandler(w http.ResponseWriter, r *http.Request) {
+	patient, ok := a.patientFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	assignmentID, err := uuid.Parse(chi.URLParam(r, "assignmentID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid assignment ID")
+		return
+	}
+
+	assignment, err := a.Repo.CareTeams.FindByID(r.Context(), assignmentID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error ending assignment")
+		return
+	}
+	if assignment == nil || assignment.PatientID != patient.ID {
+		respondWithError(w, http.StatusNotFound, "Assignment not found")
+		return
+	}
+
+	ended, err := a.Repo.CareTeams.End(r.Context(), assignment.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error ending assignment")
+		return
+	}
+	if !ended {
+		respondWithError(w, http.StatusNotFound, "Assignment not found")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
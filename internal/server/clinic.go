@@ -0,0 +1,140 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/yhwbach/makerble/internal/models"
+	"github.com/yhwbach/makerble/internal/schemas"
+)
+
+// @Summary List clinics
+// @Description List all clinics (requires clinic.manage)
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {array} models.Clinic
+// @Failure 403,500 {object} ErrorResponse
+// @Router /admin/clinics [get]
+func (a *Application) listClinicsHandler(w http.ResponseWriter, r *http.Request) {
+	clinics, err := a.Repo.Clinics.FindAll(r.Context())
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error fetching clinics")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, clinics)
+}
+
+// @Summary Create clinic
+// @Description Add a clinic. Users join it through invitations. (requires clinic.manage)
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param clinic body schemas.ClinicCreate true "Clinic"
+// @Success 201 {object} models.Clinic
+// @Failure 400,403,409,500 {object} ErrorResponse
+// @Router /admin/clinics [post]
+func (a *Application) createClinicHandler(w http.ResponseWriter, r *http.Request) {
+	var request schemas.ClinicCreate
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	name := strings.TrimSpace(request.Name)
+	if name == "" {
+		respondWithError(w, http.StatusBadRequest, "Clinic name is required")
+		return
+	}
+
+	existing, err := a.Repo.Clinics.FindByName(r.Context(), name)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error creating clinic")
+		return
+	}
+	if existing != nil {
+		respondWithError(w, http.StatusConflict, "Clinic already exists")
+		return
+	}
+
+	clinic := models.Clinic{Name: name}
+	if err := a.Repo.Clinics.Create(r.Context(), &clinic); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error creating clinic")
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, clinic)
+}
+
+// @Summary Transfer patient
+// @Description Move a patient to another clinic (requires patient.transfer). The patient leaves the care team
+// @Description and emergency access of the current clinic behind, and the transfer is recorded.
+// @Tags patients
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Patient ID"
+// @Param transfer body schemas.PatientTransfer true "Transfer"
+// @Success 201 {object} models.PatientTransfer
+// @Failure 400,403,404,500 {object} ErrorResponse
+// @Router /patients/{id}/transfer [post]
+func (a *Application) transferPatientHandler(w http.ResponseWriter, r *http.Request) {
+	patient, ok := a.patientFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	var request schemas.PatientTransfer
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	reason := strings.TrimSpace(request.Reason)
+	if reason == "" {
+		respondWithError(w, http.StatusBadRequest, "A reason is required")
+		return
+	}
+
+	clinic, err := a.Repo.Clinics.FindByID(r.Context(), request.ClinicID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error transferring patient")
+		return
+	}
+	if clinic == nil {
+		respondWithError(w, http.StatusBadRequest, "Clinic not found")
+		return
+	}
+	if clinic.ID == patient.ClinicID {
+		respondWithError(w, http.StatusBadRequest, "Patient already belongs to the clinic")
+		return
+	}
+
+	userID, err := currentUserID(r)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error processing request")
+		return
+	}
+
+	transfer := models.PatientTransfer{
+		PatientID:     patient.ID,
+		ToClinicID:    clinic.ID,
+		Reason:        reason,
+		TransferredBy: userID,
+	}
+	transferred, err := a.Repo.Patients.Transfer(r.Context(), &transfer)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error transferring patient")
+		return
+	}
+	if !transferred {
+		respondWithError(w, http.StatusNotFound, "Patient not found")
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, transfer)
+}
@@ -0,0 +1,143 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/yhwbach/makerble/internal/models"
+	"github.com/yhwbach/makerble/internal/schemas"
+)
+
+// @Summary List consents
+// @Description List the current and revoked consents of a patient (requires patient.read)
+// @Tags patients
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Patient ID"
+// @Success 200 {array} models.Consent
+// @Failure 400,403,404,500 {object} ErrorResponse
+// @Router /patients/{id}/consents [get]
+func (a *Application) listConsentsHandler(w http.ResponseWriter, r *http.Request) {
+	patient, ok := a.patientFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	consents, err := a.Repo.Consents.ListByPatient(r.Context(), patient.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error fetching consents")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, consents)
+}
+
+// @Summary Record consent
+// @Description Record a consent given by a patient, with a reference to the signed document (requires consent.manage)
+// @Tags patients
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Patient ID"
+// @Param consent body schemas.ConsentCreate true "Consent"
+// @Success 201 {object} models.Consent
+// @Failure 400 {object} ValidationErrorResponse
+// @Failure 403,404,500 {object} ErrorResponse
+// @Router /patients/{id}/consents [post]
+func (a *Application) recordConsentHandler(w http.ResponseWriter, r *http.Request) {
+	patient, ok := a.patientFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	var request schemas.ConsentCreate
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	if !models.IsValidConsentType(request.Type) {
+		respondWithValidationErrors(w, http.StatusBadRequest, "Invalid consent type", models.ConsentTypes)
+		return
+	}
+
+	// Consent cannot be recorded ahead of the patient giving it
+	if request.GrantedAt != nil && request.GrantedAt.After(time.Now()) {
+		respondWithError(w, http.StatusBadRequest, "granted_at cannot be in the future")
+		return
+	}
+
+	recordedBy, err := currentUserID(r)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error processing request")
+		return
+	}
+
+	consent := models.Consent{
+		PatientID:   patient.ID,
+		Type:        request.Type,
+		Scope:       strings.TrimSpace(request.Scope),
+		DocumentRef: strings.TrimSpace(request.DocumentRef),
+		RecordedBy:  recordedBy,
+	}
+	if request.GrantedAt != nil {
+		consent.GrantedAt = *request.GrantedAt
+	}
+	if err := a.Repo.Consents.Create(r.Context(), &consent); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error recording consent")
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, consent)
+}
+
+// @Summary Revoke consent
+// @Description Record that a patient withdrew a consent. The consent is kept as history. (requires consent.manage)
+// @Tags patients
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Patient ID"
+// @Param consentID path string true "Consent ID"
+// @Success 204
+// @Failure 400,403,404,500 {object} ErrorResponse
+// @Router /patients/{id}/consents/{consentID} [delete]
+func (a *Application) revokeConsentHandler(w http.ResponseWriter, r *http.Request) {
+	patient, ok := a.patientFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	consentID, err := uuid.Parse(chi.URLParam(r, "consentID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid consent ID")
+		return
+	}
+
+	consent, err := a.Repo.Consents.FindByID(r.Context(), consentID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error revoking consent")
+		return
+	}
+	if consent == nil || consent.PatientID != patient.ID {
+		respondWithError(w, http.StatusNotFound, "Consent not found")
+		return
+	}
+
+	revoked, err := a.Repo.Consents.Revoke(r.Context(), consent.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error revoking consent")
+		return
+	}
+	if !revoked {
+		respondWithError(w, http.StatusNotFound, "Consent not found")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,171 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/yhwbach/makerble/internal/models"
+	"github.com/yhwbach/makerble/internal/notify"
+	"github.com/yhwbach/makerble/internal/schemas"
+)
+
+// minJustificationLength keeps reviewers from having to chase placeholder justifications
+const minJustificationLength = 20
+
+// @Summary Request emergency access
+// @Description Break the glass: get time-boxed access to a patient outside of the care team (requires patient.emergency_access).
+// @Description The access is recorded as a high-severity audit event and reported to the compliance mailbox.
+// @Tags patients
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Patient ID"
+// @Param request body schemas.EmergencyAccessRequest true "Justification"
+// @Success 201 {object} models.EmergencyAccessGrant
+// @Failure 400,403,404,500 {object} ErrorResponse
+// @Router /patients/{id}/emergency-access [post]
+func (a *Application) requestEmergencyAccessHandler(w http.ResponseWriter, r *http.Request) {
+	patientID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid patient ID")
+		return
+	}
+
+	var request schemas.EmergencyAccessRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	justification := strings.TrimSpace(request.Justification)
+	if len([]rune(justification)) < minJustificationLength {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("A justification of at least %d characters is required", minJustificationLength))
+		return
+	}
+
+	// The patient is by definition outside of the caller's care team
+	patient, err := a.Repo.Patients.FindByID(r.Context(), patientID, schemas.PatientQuery{})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error granting emergency access")
+		return
+	}
+	if patient == nil {
+		respondWithError(w, http.StatusNotFound, "Patient not found")
+		return
+	}
+
+	userID, err := currentUserID(r)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error processing request")
+		return
+	}
+
+	grant := models.EmergencyAccessGrant{
+		PatientID:     patient.ID,
+		UserID:        userID,
+		Justification: justification,
+		ExpiresAt:     time.Now().Add(a.Config.EmergencyAccess.Duration),
+	}
+	if err := a.Repo.EmergencyAccess.Create(r.Context(), &grant); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error granting emergency access")
+		return
+	}
+
+	// Access must not wait for the mail server
+	go a.notifyEmergencyAccess(grant)
+
+	respondWithJSON(w, http.StatusCreated, grant)
+}
+
+// @Summary Emergency access report
+// @Description List break-the-glass events, most recent first (requires emergency_access.review)
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param since query string false "Only events at or after this time (RFC 3339)"
+// @Param until query string false "Only events before this time (RFC 3339)"
+// @Param patient_id query string false "Only events for this patient"
+// @Param user_id query string false "Only events by this user"
+// @Success 200 {array} models.EmergencyAccessGrant
+// @Failure 400,403,500 {object} ErrorResponse
+// @Router /admin/emergency-access [get]
+func (a *Application) emergencyAccessReportHandler(w http.ResponseWriter, r *http.Request) {
+	var filter schemas.EmergencyAccessQuery
+	var invalid []string
+	params := r.URL.Query()
+
+	for name, dest := range map[string]**time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if value := params.Get(name); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				invalid = append(invalid, name)
+				continue
+			}
+			*dest = &t
+		}
+	}
+	for name, dest := range map[string]**uuid.UUID{"patient_id": &filter.PatientID, "user_id": &filter.UserID} {
+		if value := params.Get(name); value != "" {
+			id, err := uuid.Parse(value)
+			if err != nil {
+				invalid = append(invalid, name)
+				continue
+			}
+			*dest = &id
+		}
+	}
+
+	if len(invalid) > 0 {
+		slices.Sort(invalid)
+		respondWithValidationErrors(w, http.StatusBadRequest, "Invalid query parameters", invalid)
+		return
+	}
+
+	grants, err := a.Repo.EmergencyAccess.List(r.Context(), filter)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error fetching emergency access report")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, grants)
+}
+
+// notifyEmergencyAccess tells the compliance mailbox about emergency access. Failures are only
+// logged: the grant is already recorded and shows up in the report.
+func (a *Application) notifyEmergencyAccess(grant models.EmergencyAccessGrant) {
+	mailbox := a.Config.EmergencyAccess.ComplianceMailbox
+	if mailbox == "" {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	requester := grant.UserID.String()
+	if user, err := a.Repo.Users.FindByID(ctx, grant.UserID); err == nil {
+		requester = fmt.Sprintf("%s (%s)", user.FullName, user.Username)
+	}
+
+	err := a.Notifier.Notify(ctx, notify.Message{
+		To:      []string{mailbox},
+		Subject: "Emergency access granted to patient " + grant.PatientID.String(),
+		Body: fmt.Sprintf(
+			"%s used emergency access to patient %s.\n\nGranted: %s\nExpires: %s\nGrant: %s\n\nJustification:\n%s\n",
+			requester, grant.PatientID,
+			grant.GrantedAt.Format(time.RFC3339), grant.ExpiresAt.Format(time.RFC3339), grant.ID,
+			grant.Justification,
+		),
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to notify compliance of emergency access", "grant_id", grant.ID, "error", err)
+	}
+}
@@ -0,0 +1,209 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/yhwbach/makerble/internal/models"
+	"github.com/yhwbach/makerble/internal/schemas"
+)
+
+// Formats a patient export can be downloaded in
+var patientExportFormats = []string{"json", "html"}
+
+// @Summary Export patient
+// @Description Export everything held about a patient for a right-of-access request (requires patient.export):
+// @Description demographics, medical history, the registering user, consents, care team, emergency access and
+// @Description the audit events about the patient. The export is complete, clinical fields included.
+// @Description format=html returns a printable document.
+// @Tags patients
+// @Produce json
+// @Produce html
+// @Security BearerAuth
+// @Param id path string true "Patient ID"
+// @Param format query string false "json (default) or html"
+// @Success 200 {object} schemas.PatientExport
+// @Failure 400 {object} ValidationErrorResponse
+// @Failure 403,404,500 {object} ErrorResponse
+// @Router /patients/{id}/export [get]
+func (a *Application) exportPatientHandler(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "html" {
+		respondWithValidationErrors(w, http.StatusBadRequest, "Invalid export format", patientExportFormats)
+		return
+	}
+
+	patient, ok := a.patientFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	export, err := a.compilePatientExport(r, patient)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error exporting patient")
+		return
+	}
+
+	err = a.auditPatientAccess(r, models.AuditActionPatientExport, &patient.ID, models.PatientFields, map[string]interface{}{
+		"format": format,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error recording access")
+		return
+	}
+
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="patient-%s.%s"`, patient.ID, format))
+
+	if format == "json" {
+		respondWithJSON(w, http.StatusOK, export)
+		return
+	}
+
+	var document bytes.Buffer
+	if err := patientExportTemplate.Execute(&document, export); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error exporting patient")
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(document.Bytes())
+}
+
+// compilePatientExport gathers everything held about the patient
+func (a *Application) compilePatientExport(r *http.Request, patient *models.Patient) (*schemas.PatientExport, error) {
+	ctx := r.Context()
+
+	exportedBy, err := currentUserID(r)
+	if err != nil {
+		return nil, err
+	}
+
+	export := &schemas.PatientExport{
+		ExportedAt: time.Now().UTC(),
+		ExportedBy: exportedBy,
+		Patient:    patient,
+	}
+
+	if export.Clinic, err = a.Repo.Clinics.FindByID(ctx, patient.ClinicID); err != nil {
+		return nil, err
+	}
+
+	registeredBy, err := a.Repo.Users.FindByID(ctx, patient.RegisteredBy)
+	if err != nil {
+		return nil, err
+	}
+	if registeredBy != nil {
+		export.RegisteredBy = &schemas.PatientExportUser{
+			ID:       registeredBy.ID,
+			FullName: registeredBy.FullName,
+			UserType: registeredBy.UserType,
+		}
+	}
+
+	if export.Consents, err = a.Repo.Consents.ListByPatient(ctx, patient.ID); err != nil {
+		return nil, err
+	}
+	if export.CareTeam, err = a.Repo.CareTeams.ListByPatient(ctx, patient.ID); err != nil {
+		return nil, err
+	}
+	if export.EmergencyAccess, err = a.Repo.EmergencyAccess.List(ctx, schemas.EmergencyAccessQuery{PatientID: &patient.ID}); err != nil {
+		return nil, err
+	}
+	events, err := a.Repo.Audit.ListByPatient(ctx, patient.ID)
+	if err != nil {
+		return nil, err
+	}
+	export.AuditEvents = make([]schemas.PatientExportEvent, 0, len(events))
+	for _, event := range events {
+		export.AuditEvents = append(export.AuditEvents, schemas.PatientExportEvent{
+			OccurredAt: event.OccurredAt,
+			Action:     event.Action,
+			ActorID:    event.ActorID,
+			Fields:     event.Fields,
+		})
+	}
+
+	return export, nil
+}
+
+var patientExportTemplate = template.Must(template.New("export").Funcs(template.FuncMap{
+	"date": func(t time.Time) string { return t.Format("2006-01-02") },
+	"datetime": func(t time.Time) string {
+		return t.UTC().Format("2006-01-02 15:04 MST")
+	},
+	"optionalDatetime": func(t *time.Time) string {
+		if t == nil {
+			return ""
+		}
+		return t.UTC().Format("2006-01-02 15:04 MST")
+	},
+	"join": strings.Join,
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Patient record: {{.Patient.FullName}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; width: 100%; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: 0.3em 0.5em; text-align: left; vertical-align: top; }
+th { background: #f0f0f0; }
+.empty { color: #777; }
+@media print { body { margin: 0; } }
+</style>
+</head>
+<body>
+<h1>Patient record: {{.Patient.FullName}}</h1>
+<p>Exported on {{datetime .ExportedAt}} by user {{.ExportedBy}}.</p>
+
+<h2>Demographics</h2>
+<table>
+<tr><th>Patient ID</th><td>{{.Patient.ID}}</td></tr>
+<tr><th>Full name</th><td>{{.Patient.FullName}}</td></tr>
+<tr><th>Date of birth</th><td>{{date .Patient.DateOfBirth}}</td></tr>
+<tr><th>Gender</th><td>{{.Patient.Gender}}</td></tr>
+<tr><th>Address</th><td>{{.Patient.Address}}</td></tr>
+<tr><th>Phone</th><td>{{.Patient.Phone}}</td></tr>
+<tr><th>Email</th><td>{{.Patient.Email}}</td></tr>
+<tr><th>Clinic</th><td>{{with .Clinic}}{{.Name}}{{else}}{{.Patient.ClinicID}}{{end}}</td></tr>
+<tr><th>Registered by</th><td>{{with .RegisteredBy}}{{.FullName}} ({{.UserType}}){{else}}{{.Patient.RegisteredBy}}{{end}}</td></tr>
+<tr><th>Registered on</th><td>{{datetime .Patient.CreatedAt}}</td></tr>
+<tr><th>Last updated</th><td>{{datetime .Patient.UpdatedAt}}</td></tr>
+</table>
+
+<h2>Medical history</h2>
+{{if .Patient.MedicalHistory}}<p>{{.Patient.MedicalHistory}}</p>{{else}}<p class="empty">None recorded.</p>{{end}}
+
+<h2>Consents</h2>
+{{if .Consents}}<table>
+<tr><th>Type</th><th>Scope</th><th>Granted</th><th>Revoked</th><th>Document</th></tr>
+{{range .Consents}}<tr><td>{{.Type}}</td><td>{{.Scope}}</td><td>{{datetime .GrantedAt}}</td><td>{{optionalDatetime .RevokedAt}}</td><td>{{.DocumentRef}}</td></tr>
+{{end}}</table>{{else}}<p class="empty">None recorded.</p>{{end}}
+
+<h2>Care team</h2>
+{{if .CareTeam}}<table>
+<tr><th>User</th><th>Role</th><th>From</th><th>Until</th></tr>
+{{range .CareTeam}}<tr><td>{{.UserID}}</td><td>{{.Role}}</td><td>{{datetime .ValidFrom}}</td><td>{{optionalDatetime .ValidUntil}}</td></tr>
+{{end}}</table>{{else}}<p class="empty">None recorded.</p>{{end}}
+
+<h2>Emergency access</h2>
+{{if .EmergencyAccess}}<table>
+<tr><th>User</th><th>Justification</th><th>Granted</th><th>Expires</th></tr>
+{{range .EmergencyAccess}}<tr><td>{{if .Username}}{{.Username}}{{else}}{{.UserID}}{{end}}</td><td>{{.Justification}}</td><td>{{datetime .GrantedAt}}</td><td>{{datetime .ExpiresAt}}</td></tr>
+{{end}}</table>{{else}}<p class="empty">None recorded.</p>{{end}}
+
+<h2>Access log</h2>
+{{if .AuditEvents}}<table>
+<tr><th>Time</th><th>Action</th><th>User</th><th>Fields</th></tr>
+{{range .AuditEvents}}<tr><td>{{datetime .OccurredAt}}</td><td>{{.Action}}</td><td>{{with .ActorID}}{{.}}{{end}}</td><td>{{join .Fields ", "}}</td></tr>
+{{end}}</table>{{else}}<p class="empty">None recorded.</p>{{end}}
+</body>
+</html>
+`))
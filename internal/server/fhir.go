@@ -0,0 +1,320 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yhwbach/makerble/internal/fhir"
+	"github.com/yhwbach/makerble/internal/models"
+	"github.com/yhwbach/makerble/internal/schemas"
+)
+
+// The FHIR API lives outside of /api/v1 and is not part of the Swagger documentation; its
+// capability statement at /fhir/metadata describes it instead.
+
+// fhirCapabilitiesHandler responds with the capability statement of the FHIR API
+func (a *Application) fhirCapabilitiesHandler(w http.ResponseWriter, r *http.Request) {
+	respondWithFHIR(w, http.StatusOK, fhir.Capabilities(fhirBaseURL(r), time.Now()))
+}
+
+// fhirReadPatientHandler responds with a Patient resource (requires patient.read). As everywhere
+// on the FHIR API, only patients consenting to data sharing are found.
+func (a *Application) fhirReadPatientHandler(w http.ResponseWriter, r *http.Request) {
+	patient, ok := a.sharedPatientFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	if err := a.auditPatientAccess(r, models.AuditActionPatientRead, &patient.ID, fhir.PatientFields, nil); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error recording access")
+		return
+	}
+
+	respondWithFHIR(w, http.StatusOK, fhir.FromPatient(patient))
+}
+
+// fhirSearchPatientsHandler responds with a bundle of the Patient resources matching the search
+// (requires patient.read), a page at a time. Searches are sent as query parameters, or as a form
+// to _search.
+func (a *Application) fhirSearchPatientsHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		respondWithOutcome(w, http.StatusBadRequest, fhir.ErrorIssue(fhir.IssueInvalid, "Invalid search parameters"))
+		return
+	}
+
+	search, issues := fhir.ParseSearch(r.Form)
+	if len(issues) > 0 {
+		respondWithOutcome(w, http.StatusBadRequest, issues...)
+		return
+	}
+
+	query, err := a.sharedPatientQuery(r)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error fetching patients")
+		return
+	}
+	query.Search = search.Criteria()
+
+	total, err := a.Repo.Patients.Count(r.Context(), query)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error fetching patients")
+		return
+	}
+	query.Limit, query.Offset = search.Count, search.Offset
+	patients, err := a.Repo.Patients.FindAll(r.Context(), query)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error fetching patients")
+		return
+	}
+
+	baseURL := fhirBaseURL(r)
+	bundle := &fhir.Bundle{
+		ResourceType: "Bundle",
+		Type:         "searchset",
+		Total:        total,
+		Link:         []fhir.BundleLink{{Relation: "self", URL: searchPageURL(baseURL, r.Form, search.Count, search.Offset)}},
+		Entry:        []fhir.BundleEntry{},
+	}
+	if search.Offset > 0 {
+		bundle.Link = append(bundle.Link, fhir.BundleLink{
+			Relation: "previous",
+			URL:      searchPageURL(baseURL, r.Form, search.Count, max(search.Offset-search.Count, 0)),
+		})
+	}
+	if search.Offset+search.Count < total {
+		bundle.Link = append(bundle.Link, fhir.BundleLink{
+			Relation: "next",
+			URL:      searchPageURL(baseURL, r.Form, search.Count, search.Offset+search.Count),
+		})
+	}
+
+	patientIDs := []string{}
+	for _, patient := range patients {
+		patientIDs = append(patientIDs, patient.ID.String())
+		bundle.Entry = append(bundle.Entry, fhir.BundleEntry{
+			FullURL:  baseURL + "/Patient/" + patient.ID.String(),
+			Resource: fhir.FromPatient(patient.Patient),
+			Search:   &fhir.EntrySearch{Mode: "match"},
+		})
+	}
+
+	err = a.auditPatientAccess(r, models.AuditActionPatientList, nil, fhir.PatientFields, map[string]interface{}{
+		"patient_ids": patientIDs,
+		"count":       len(patientIDs),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error recording access")
+		return
+	}
+
+	respondWithFHIR(w, http.StatusOK, bundle)
+}
+
+// searchPageURL is the URL of a page of the results of a search
+func searchPageURL(baseURL string, form url.Values, count, offset int) string {
+	query := url.Values{}
+	for key, values := range form {
+		query[key] = values
+	}
+	query.Set("_count", strconv.Itoa(count))
+	query.Set("_offset", strconv.Itoa(offset))
+	return baseURL + "/Patient?" + query.Encode()
+}
+
+// fhirCreatePatientHandler creates a patient from a Patient resource (requires patient.create).
+// The ID of the resource, if any, is ignored.
+func (a *Application) fhirCreatePatientHandler(w http.ResponseWriter, r *http.Request) {
+	resource, ok := decodeFHIRPatient(w, r)
+	if !ok {
+		return
+	}
+
+	create := resource.PatientCreate()
+	dateOfBirth, _ := time.Parse("2006-01-02", create.DateOfBirth)
+
+	createdID, err := a.registerPatient(r, create, dateOfBirth)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error creating patient")
+		return
+	}
+
+	// The caller may not be able to read the patient back, e.g. service accounts without data sharing
+	// consent, so it is loaded without restriction
+	patient, err := a.Repo.Patients.FindByID(r.Context(), createdID, schemas.PatientQuery{})
+	if err != nil || patient == nil {
+		respondWithError(w, http.StatusInternalServerError, "Error fetching patient")
+		return
+	}
+
+	w.Header().Set("Location", fhirBaseURL(r)+"/Patient/"+createdID.String())
+	respondWithFHIR(w, http.StatusCreated, fhir.FromPatient(patient))
+}
+
+// fhirUpdatePatientHandler replaces a patient with a Patient resource (requires
+// patient.update.demographics or patient.update.clinical). As on the REST API, only
+// patient.update.clinical allows changing the birth date or gender. Patients cannot be created
+// with an update.
+func (a *Application) fhirUpdatePatientHandler(w http.ResponseWriter, r *http.Request) {
+	demographics, err := a.can(r, models.PermissionPatientUpdateDemographics)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error checking permissions")
+		return
+	}
+	clinical, err := a.can(r, models.PermissionPatientUpdateClinical)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error checking permissions")
+		return
+	}
+	if !demographics && !clinical {
+		respondWithError(w, http.StatusForbidden, "Access denied")
+		return
+	}
+
+	existing, ok := a.sharedPatientFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	resource, ok := decodeFHIRPatient(w, r)
+	if !ok {
+		return
+	}
+	if resource.ID != existing.ID.String() {
+		respondWithOutcome(w, http.StatusBadRequest,
+			fhir.ErrorIssue(fhir.IssueInvalid, "Resource ID must match the ID in the URL", "Patient.id"))
+		return
+	}
+
+	update := resource.PatientUpdate(existing)
+	if (update.DateOfBirth != nil || update.Gender != nil) && !clinical {
+		respondWithOutcome(w, http.StatusForbidden, fhir.ErrorIssue(fhir.IssueForbidden,
+			"Not allowed to change the birth date or gender", "Patient.birthDate", "Patient.gender"))
+		return
+	}
+
+	fields := update.Fields()
+	patient := existing
+	if len(fields) > 0 {
+		event, err := patientAuditEvent(r, models.AuditActionPatientUpdate, &existing.ID, fields, nil)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Error updating patient")
+			return
+		}
+		updated, err := a.Repo.Patients.UpdateByID(r.Context(), existing.ID, update, event)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Error updating patient")
+			return
+		}
+		if updated == nil {
+			respondWithError(w, http.StatusNotFound, "Patient not found")
+			return
+		}
+		patient = updated
+	}
+
+	respondWithFHIR(w, http.StatusOK, fhir.FromPatient(patient))
+}
+
+// decodeFHIRPatient reads a valid Patient resource from the request body, responding with an
+// operation outcome otherwise
+func decodeFHIRPatient(w http.ResponseWriter, r *http.Request) (*fhir.Patient, bool) {
+	var resource fhir.Patient
+	if err := json.NewDecoder(r.Body).Decode(&resource); err != nil {
+		respondWithOutcome(w, http.StatusBadRequest, fhir.ErrorIssue(fhir.IssueInvalid, "Invalid Patient resource"))
+		return nil, false
+	}
+
+	if issues := resource.Validate(); len(issues) > 0 {
+		respondWithOutcome(w, http.StatusUnprocessableEntity, issues...)
+		return nil, false
+	}
+
+	return &resource, true
+}
+
+// fhirBaseURL is the URL of the FHIR API as reached by the caller
+func fhirBaseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return (&url.URL{Scheme: scheme, Host: r.Host, Path: "/fhir"}).String()
+}
+
+func respondWithFHIR(w http.ResponseWriter, code int, resource interface{}) {
+	response, _ := json.Marshal(resource)
+	w.Header().Set("Content-Type", fhir.ContentType)
+	w.WriteHeader(code)
+	w.Write(response)
+}
+
+func respondWithOutcome(w http.ResponseWriter, code int, issues ...fhir.Issue) {
+	respondWithFHIR(w, code, fhir.NewOperationOutcome(issues...))
+}
+
+// fhirOutcomes turns the errors of the shared handlers and middleware, such as authentication
+// errors, into operation outcomes, so that FHIR clients only ever receive FHIR resources
+func fhirOutcomes(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ow := &outcomeWriter{ResponseWriter: w}
+		next.ServeHTTP(ow, r)
+		if ow.status == 0 {
+			return
+		}
+
+		message := strings.TrimSpace(ow.body.String())
+		var body ValidationErrorResponse
+		if json.Unmarshal(ow.body.Bytes(), &body) == nil {
+			message = body.Message
+			if len(body.Errors) > 0 {
+				message += ": " + strings.Join(body.Errors, ", ")
+			}
+		}
+		if message == "" {
+			message = http.StatusText(ow.status)
+		}
+
+		respondWithOutcome(w, ow.status, fhir.ErrorIssue(fhir.StatusIssueCode(ow.status), message))
+	})
+}
+
+// outcomeWriter holds back error responses that are not FHIR resources
+type outcomeWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+	wrote  bool
+}
+
+func (w *outcomeWriter) WriteHeader(code int) {
+	if w.wrote {
+		return
+	}
+	w.wrote = true
+
+	if code >= http.StatusBadRequest && w.Header().Get("Content-Type") != fhir.ContentType {
+		w.status = code
+		return
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// Unwrap gives http.ResponseController access to the connection, e.g. to extend write deadlines
+func (w *outcomeWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *outcomeWriter) Write(b []byte) (int, error) {
+	if !w.wrote {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.status != 0 {
+		return w.body.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
@@ -0,0 +1,180 @@
+package server
+
+import (
+	"bufio"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/yhwbach/makerble/internal/exporter"
+	"github.com/yhwbach/makerble/internal/fhir"
+	"github.com/yhwbach/makerble/internal/models"
+)
+
+// fhirBulkExportHandler starts a bulk export of the patients the caller can list and who consent
+// to data sharing (requires patient.read and patient.bulk_export). The export runs in the
+// background; the response points to its status, which links the file to download once complete.
+func (a *Application) fhirBulkExportHandler(w http.ResponseWriter, r *http.Request) {
+	if !strings.Contains(r.Header.Get("Prefer"), "respond-async") {
+		respondWithOutcome(w, http.StatusBadRequest,
+			fhir.ErrorIssue(fhir.IssueInvalid, "Bulk exports are asynchronous and require the Prefer: respond-async header"))
+		return
+	}
+
+	request, issues := fhir.ParseExport(r.URL.Query())
+	if len(issues) > 0 {
+		respondWithOutcome(w, http.StatusBadRequest, issues...)
+		return
+	}
+
+	query, err := a.sharedPatientQuery(r)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error starting export")
+		return
+	}
+	userID, err := currentUserID(r)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error starting export")
+		return
+	}
+
+	requestURL := fhirBaseURL(r) + "/Patient/$export"
+	if r.URL.RawQuery != "" {
+		requestURL += "?" + r.URL.RawQuery
+	}
+	job := &exporter.Job{
+		Export:  &models.PatientExport{CreatedBy: userID, Request: requestURL},
+		Query:   query,
+		Request: request,
+	}
+	if err := a.Exports.Start(r.Context(), job); err != nil {
+		slog.ErrorContext(r.Context(), "failed to start patient export", "error", err)
+		respondWithError(w, http.StatusInternalServerError, "Error starting export")
+		return
+	}
+
+	w.Header().Set("Content-Location", fhirBaseURL(r)+"/bulk/"+job.Export.ID.String())
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// fhirBulkExportStatusHandler responds with the status of a bulk export: 202 with its progress
+// while it runs, the manifest listing its files once complete, or an operation outcome if it
+// failed
+func (a *Application) fhirBulkExportStatusHandler(w http.ResponseWriter, r *http.Request) {
+	export, ok := a.patientExportFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	switch export.Status {
+	case models.PatientExportCompleted:
+	case models.PatientExportFailed:
+		respondWithOutcome(w, http.StatusInternalServerError, fhir.ErrorIssue(fhir.IssueException, export.Error))
+		return
+	default:
+		w.Header().Set("X-Progress", export.Status)
+		w.Header().Set("Retry-After", "5")
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	manifest := fhir.ExportManifest{
+		TransactionTime:     export.CreatedAt.UTC().Format(time.RFC3339),
+		Request:             export.Request,
+		RequiresAccessToken: true,
+		Output:              []fhir.ExportOutput{},
+		Error:               []fhir.ExportOutput{},
+	}
+	if len(export.PatientIDs) > 0 {
+		manifest.Output = append(manifest.Output, fhir.ExportOutput{
+			Type:  "Patient",
+			URL:   fhirBaseURL(r) + "/bulk/" + export.ID.String() + "/Patient.ndjson",
+			Count: len(export.PatientIDs),
+		})
+	}
+
+	respondWithJSON(w, http.StatusOK, manifest)
+}
+
+// fhirCancelBulkExportHandler cancels a bulk export, or deletes its files once complete
+func (a *Application) fhirCancelBulkExportHandler(w http.ResponseWriter, r *http.Request) {
+	export, ok := a.patientExportFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	if _, err := a.Repo.PatientExports.Delete(r.Context(), export.ID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error deleting export")
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// fhirBulkExportFileHandler streams the Patient resources of a completed bulk export as NDJSON.
+// The patients are those the export selected, read as they are now; patients deleted since, or
+// that the caller no longer has access to, are left out.
+func (a *Application) fhirBulkExportFileHandler(w http.ResponseWriter, r *http.Request) {
+	export, ok := a.patientExportFromURL(w, r)
+	if !ok {
+		return
+	}
+	if export.Status != models.PatientExportCompleted {
+		respondWithOutcome(w, http.StatusNotFound, fhir.ErrorIssue(fhir.IssueNotFound, "The export is not complete"))
+		return
+	}
+
+	query, err := a.sharedPatientQuery(r)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error fetching patients")
+		return
+	}
+	query.IDs = export.PatientIDs
+
+	// The download is recorded with every patient the export selected, including any left out since
+	err = a.auditPatientExport(r, export.PatientIDs, fhir.PatientFields, map[string]interface{}{
+		"export_id": export.ID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error recording access")
+		return
+	}
+
+	w.Header().Set("Content-Type", fhir.NDJSONContentType)
+	w.WriteHeader(http.StatusOK)
+
+	buffered := bufio.NewWriter(w)
+	a.streamPatients(w, r, query, false, exporter.NewFHIRWriter(buffered), buffered)
+}
+
+// patientExportFromURL loads the bulk export identified by the {id} URL parameter, responding
+// with an error unless the caller started it
+func (a *Application) patientExportFromURL(w http.ResponseWriter, r *http.Request) (*models.PatientExport, bool) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		respondWithOutcome(w, http.StatusBadRequest, fhir.ErrorIssue(fhir.IssueInvalid, "Invalid export ID"))
+		return nil, false
+	}
+
+	userID, err := currentUserID(r)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error fetching export")
+		return nil, false
+	}
+
+	export, err := a.Repo.PatientExports.FindByID(r.Context(), id)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error fetching export")
+		return nil, false
+	}
+	// Exports are only available to the caller who started them
+	if export == nil || export.CreatedBy != userID {
+		respondWithOutcome(w, http.StatusNotFound, fhir.ErrorIssue(fhir.IssueNotFound, "Export not found"))
+		return nil, false
+	}
+
+	return export, true
+}
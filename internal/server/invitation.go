@@ -0,0 +1,172 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/yhwbach/makerble/internal/models"
+	"github.com/yhwbach/makerble/internal/repository"
+	"github.com/yhwbach/makerble/internal/schemas"
+	"github.com/yhwbach/makerble/internal/utils"
+)
+
+// @Summary Create invitation
+// @Description Invite a person to register with the given role (Admin only). The token is only returned once.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param invitation body schemas.InvitationCreate true "Invitation"
+// @Success 201 {object} schemas.InvitationCreateResponse
+// @Failure 400,403,409,500 {object} ErrorResponse
+// @Router /admin/invitations [post]
+func (a *Application) createInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	var request schemas.InvitationCreate
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	email := strings.TrimSpace(request.Email)
+	if !strings.Contains(email, "@") {
+		respondWithError(w, http.StatusBadRequest, "Invalid email address")
+		return
+	}
+
+	valid, err := a.roleExists(r.Context(), request.UserType)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error creating invitation")
+		return
+	}
+	if !valid {
+		respondWithError(w, http.StatusBadRequest, "Invalid user type")
+		return
+	}
+
+	exists, err := a.Repo.Users.EmailExists(r.Context(), email)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error creating invitation")
+		return
+	}
+	if exists {
+		respondWithError(w, http.StatusConflict, "Email already exists")
+		return
+	}
+
+	adminID, err := currentUserID(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid token")
+		return
+	}
+
+	allowed, err := a.canAssignRole(r, request.UserType)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error creating invitation")
+		return
+	}
+	if !allowed {
+		respondWithError(w, http.StatusForbidden, "Access denied")
+		return
+	}
+
+	// Invitations are for the admin's clinic unless a clinic manager picks another one
+	var clinicID uuid.UUID
+	if ownClinic, _ := repository.ClinicFromContext(r.Context()); request.ClinicID != nil && *request.ClinicID != ownClinic {
+		allowed, err := a.can(r, models.PermissionClinicManage)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Error creating invitation")
+			return
+		}
+		if !allowed {
+			respondWithError(w, http.StatusForbidden, "Access denied")
+			return
+		}
+
+		clinic, err := a.Repo.Clinics.FindByID(r.Context(), *request.ClinicID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Error creating invitation")
+			return
+		}
+		if clinic == nil {
+			respondWithError(w, http.StatusBadRequest, "Clinic not found")
+			return
+		}
+		clinicID = clinic.ID
+	}
+
+	token, err := utils.GenerateSecureToken(32)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error creating invitation")
+		return
+	}
+
+	invitation := models.Invitation{
+		Email:     email,
+		UserType:  request.UserType,
+		ClinicID:  clinicID,
+		InvitedBy: adminID,
+		ExpiresAt: time.Now().Add(a.Config.Auth.InvitationExpiry),
+	}
+	if err := a.Repo.Invitations.Create(r.Context(), &invitation, token); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error creating invitation")
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, schemas.InvitationCreateResponse{
+		Invitation: invitation,
+		Token:      token,
+	})
+}
+
+// @Summary List invitations
+// @Description List all invitations (Admin only)
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {array} models.Invitation
+// @Failure 403,500 {object} ErrorResponse
+// @Router /admin/invitations [get]
+func (a *Application) listInvitationsHandler(w http.ResponseWriter, r *http.Request) {
+	invitations, err := a.Repo.Invitations.FindAll(r.Context())
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error fetching invitations")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, invitations)
+}
+
+// @Summary Revoke invitation
+// @Description Revoke a pending invitation (Admin only)
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Invitation ID"
+// @Success 204 "No Content"
+// @Failure 400,403,404,500 {object} ErrorResponse
+// @Router /admin/invitations/{id} [delete]
+func (a *Application) revokeInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid invitation ID")
+		return
+	}
+
+	revoked, err := a.Repo.Invitations.Revoke(r.Context(), id)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error revoking invitation")
+		return
+	}
+	if !revoked {
+		respondWithError(w, http.StatusNotFound, "Pending invitation not found")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -1,13 +1,154 @@
 package server
 
 import (
+	"context"
+	"log/slog"
 	"net/http"
+	"strings"
+	"time"
 
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/jwtauth/v5"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+	"github.com/yhwbach/makerble/internal/logging"
 	"github.com/yhwbach/makerble/internal/models"
+	"github.com/yhwbach/makerble/internal/repository"
 	"github.com/yhwbach/makerble/internal/utils"
 )
 
+type contextKey string
+
+// apiKeyContextKey holds the API key a request was authenticated with. It is unexported so
+// nothing outside the verifier can mark a request as API key authenticated.
+const apiKeyContextKey contextKey = "api_key"
+
+// verifier authenticates requests presenting an API key, in the X-API-Key header or as a bearer
+// token, and hands every other request to the JWT verifier
+func (a *Application) verifier(next http.Handler) http.Handler {
+	jwtVerifier := jwtauth.Verifier(a.JWTManager.Auth)(next)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := apiKeyFromRequest(r)
+		if key == "" {
+			jwtVerifier.ServeHTTP(w, r)
+			return
+		}
+
+		prefix, ok := utils.ParseAPIKey(key)
+		if !ok {
+			respondWithError(w, http.StatusUnauthorized, "Invalid API key")
+			return
+		}
+
+		apiKey, err := a.Repo.APIKeys.FindByKey(r.Context(), prefix, key)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Error checking API key")
+			return
+		}
+		if apiKey == nil || !apiKey.IsActive() {
+			respondWithError(w, http.StatusUnauthorized, "Invalid API key")
+			return
+		}
+
+		user, err := a.Repo.Users.FindByID(r.Context(), apiKey.UserID)
+		if err != nil || !user.IsActive || user.UserType != models.Service {
+			respondWithError(w, http.StatusUnauthorized, "Invalid API key")
+			return
+		}
+
+		if err := a.Repo.APIKeys.Touch(r.Context(), apiKey.ID, r.RemoteAddr); err != nil {
+			slog.ErrorContext(r.Context(), "failed to update API key", "api_key_id", apiKey.ID, "error", err)
+		}
+
+		// Handlers read the caller from the JWT claims, so API key requests carry equivalent claims
+		token := jwt.New()
+		if err := token.Set("user_id", user.ID.String()); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Error checking API key")
+			return
+		}
+		if err := token.Set("user_type", string(user.UserType)); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Error checking API key")
+			return
+		}
+		if err := token.Set("clinic_id", user.ClinicID.String()); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Error checking API key")
+			return
+		}
+
+		logging.AddAttrs(r.Context(), slog.String("user_id", user.ID.String()), slog.String("api_key_id", apiKey.ID.String()))
+
+		ctx := jwtauth.NewContext(r.Context(), token, nil)
+		ctx = context.WithValue(ctx, apiKeyContextKey, apiKey)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// apiKeyFromRequest returns the API key presented with the request, if any
+func apiKeyFromRequest(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+
+	token, err := utils.ExtractBearerToken(r.Header.Get("Authorization"))
+	if err == nil && strings.HasPrefix(token, utils.APIKeyPrefix) {
+		return token
+	}
+	return ""
+}
+
+// apiKeyFromContext returns the API key the request was authenticated with, or nil for users
+func apiKeyFromContext(ctx context.Context) *models.APIKey {
+	apiKey, _ := ctx.Value(apiKeyContextKey).(*models.APIKey)
+	return apiKey
+}
+
+// logRequests logs every request once it completes. The request is identified by its route pattern
+// rather than its path, and headers, query strings and bodies are left out, as they may hold
+// personal data or credentials. Records logged while handling the request carry its ID and route,
+// and the caller once authenticated.
+func (a *Application) logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ctx := logging.NewContext(r.Context(),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+			slog.Any("route", routePattern{chi.RouteContext(r.Context())}),
+		)
+
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r.WithContext(ctx))
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		level := slog.LevelInfo
+		if status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+
+		slog.LogAttrs(ctx, level, "request",
+			slog.String("method", r.Method),
+			slog.Int("status", status),
+			slog.Int("bytes", ww.BytesWritten()),
+			slog.Duration("duration", time.Since(start)),
+		)
+	})
+}
+
+// routePattern logs as the pattern of the route the request matched, which is only known once
+// the request was routed
+type routePattern struct {
+	rctx *chi.Context
+}
+
+func (p routePattern) LogValue() slog.Value {
+	if p.rctx == nil {
+		return slog.StringValue("")
+	}
+	return slog.StringValue(p.rctx.RoutePattern())
+}
+
 func (a *Application) authenticator(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		_, _, err := jwtauth.FromContext(r.Context())
@@ -16,14 +157,28 @@ func (a *Application) authenticator(next http.Handler) http.Handler {
 			return
 		}
 
-		tokenStr, err := utils.ExtractBearerToken(r.Header.Get("Authorization"))
+		// Every repository query of the request is scoped to the clinic of the caller
+		clinicID, err := utils.GetClinicIDFromContext(r.Context())
+		if err != nil {
+			respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+		r = r.WithContext(repository.WithClinic(r.Context(), clinicID))
+
+		// API keys were fully checked by the verifier and have no token ID or session
+		if apiKeyFromContext(r.Context()) != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		tokenID, err := utils.GetTokenIDFromContext(r.Context())
 		if err != nil {
-			respondWithError(w, http.StatusUnauthorized, "Invalid authorization header")
+			respondWithError(w, http.StatusUnauthorized, "Unauthorized")
 			return
 		}
 
 		// Check if token is invalidated
-		isInvalid, err := a.Repo.Tokens.IsTokenInvalid(r.Context(), tokenStr)
+		isInvalid, err := a.Repo.Tokens.IsTokenInvalid(r.Context(), tokenID)
 		if err != nil {
 			respondWithError(w, http.StatusInternalServerError, "Error checking token validity")
 			return
@@ -34,28 +189,83 @@ func (a *Application) authenticator(next http.Handler) http.Handler {
 			return
 		}
 
-		next.ServeHTTP(w, r)
-	})
-}
+		sessionID, err := utils.GetSessionIDFromContext(r.Context())
+		if err != nil {
+			respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+			return
+		}
 
-func (a *Application) receptionistOnly(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		userType, err := utils.GetUserTypeFromContext(r.Context())
-		if err != nil || userType != string(models.Receptionist) {
-			respondWithError(w, http.StatusForbidden, "Access denied")
+		userID, err := utils.GetUserIDFromContext(r.Context())
+		if err != nil {
+			respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+
+		session, err := a.Repo.Sessions.FindByID(r.Context(), sessionID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Error checking session")
+			return
+		}
+
+		if session == nil || !session.IsActive() || session.UserID.String() != userID {
+			respondWithError(w, http.StatusUnauthorized, "Session has been revoked")
 			return
 		}
+
+		if err := a.Repo.Sessions.Touch(r.Context(), session.ID, r.RemoteAddr); err != nil {
+			slog.ErrorContext(r.Context(), "failed to update session", "session_id", session.ID, "error", err)
+		}
+
+		logging.AddAttrs(r.Context(), slog.String("user_id", userID))
 		next.ServeHTTP(w, r)
 	})
 }
 
-func (a *Application) doctorOnly(next http.Handler) http.Handler {
+// usersOnly rejects requests authenticated with an API key, e.g. for account and session management
+func (a *Application) usersOnly(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		userType, err := utils.GetUserTypeFromContext(r.Context())
-		if err != nil || userType != string(models.Doctor) {
-			respondWithError(w, http.StatusForbidden, "Access denied")
+		if apiKeyFromContext(r.Context()) != nil {
+			respondWithError(w, http.StatusForbidden, "Not available to API keys")
 			return
 		}
 		next.ServeHTTP(w, r)
 	})
 }
+
+// require lets the request through when the caller holds the permission
+func (a *Application) require(permission string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			allowed, err := a.can(r, permission)
+			if err != nil {
+				respondWithError(w, http.StatusInternalServerError, "Error checking permissions")
+				return
+			}
+			if !allowed {
+				respondWithError(w, http.StatusForbidden, "Access denied")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// can reports whether the caller holds the permission. API keys hold the permissions of their
+// scopes and users those of their role.
+func (a *Application) can(r *http.Request, permission string) (bool, error) {
+	if apiKey := apiKeyFromContext(r.Context()); apiKey != nil {
+		return apiKey.HasPermission(permission), nil
+	}
+
+	userType, err := utils.GetUserTypeFromContext(r.Context())
+	if err != nil {
+		return false, nil
+	}
+
+	role, err := a.Repo.Roles.FindByName(r.Context(), models.UserType(userType))
+	if err != nil {
+		return false, err
+	}
+
+	return role != nil && role.HasPermission(permission), nil
+}
@@ -0,0 +1,170 @@
+package server
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/jwtauth/v5"
+	"github.com/yhwbach/makerble/internal/auth"
+	"github.com/yhwbach/makerble/internal/models"
+	"github.com/yhwbach/makerble/internal/utils"
+)
+
+const (
+	oidcStateCookie  = "makerble_oidc"
+	oidcStatePurpose = "oidc_state"
+	oidcStateExpiry  = 10 * time.Minute
+)
+
+// @Summary Start single sign-on
+// @Description Redirect to the identity provider to sign in with OpenID Connect
+// @Tags auth
+// @Success 302 "Redirect to the identity provider"
+// @Failure 404,502 {object} ErrorResponse
+// @Router /auth/oidc/login [get]
+func (a *Application) oidcLoginHandler(w http.ResponseWriter, r *http.Request) {
+	if a.OIDC == nil {
+		respondWithError(w, http.StatusNotFound, "Single sign-on is not enabled")
+		return
+	}
+
+	state, err1 := utils.GenerateSecureToken(32)
+	nonce, err2 := utils.GenerateSecureToken(32)
+	verifier, err3 := utils.GenerateSecureToken(32)
+	if err := errors.Join(err1, err2, err3); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error starting single sign-on")
+		return
+	}
+
+	authURL, err := a.OIDC.AuthCodeURL(r.Context(), state, nonce, verifier)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to build OIDC authorization URL", "error", err)
+		respondWithError(w, http.StatusBadGateway, "Identity provider is unavailable")
+		return
+	}
+
+	// The state, nonce and PKCE verifier travel in a signed cookie so no server side storage is needed
+	_, cookie, err := a.JWTManager.Auth.Encode(map[string]interface{}{
+		"purpose":  oidcStatePurpose,
+		"state":    state,
+		"nonce":    nonce,
+		"verifier": verifier,
+		"exp":      time.Now().Add(oidcStateExpiry).Unix(),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error starting single sign-on")
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    cookie,
+		Path:     "/api/v1/auth/oidc",
+		MaxAge:   int(oidcStateExpiry.Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// @Summary Complete single sign-on
+// @Description Exchange the authorization code returned by the identity provider for a token.
+// @Description Users are provisioned on their first sign-in and their role is synced from their groups on every sign-in.
+// @Tags auth
+// @Produce json
+// @Param code query string true "Authorization code"
+// @Param state query string true "State"
+// @Success 200 {object} schemas.TokenResponse
+// @Failure 400,401,403,404,409 {object} ErrorResponse
+// @Router /auth/oidc/callback [get]
+func (a *Application) oidcCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	if a.OIDC == nil {
+		respondWithError(w, http.StatusNotFound, "Single sign-on is not enabled")
+		return
+	}
+
+	// The state cookie is single use
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Path:     "/api/v1/auth/oidc",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	if errCode := r.URL.Query().Get("error"); errCode != "" {
+		respondWithError(w, http.StatusUnauthorized, "Sign-in was rejected by the identity provider: "+errCode)
+		return
+	}
+
+	stored, ok := a.oidcState(r)
+	if !ok || r.URL.Query().Get("state") != stored["state"] {
+		respondWithError(w, http.StatusBadRequest, "Invalid or expired sign-in state")
+		return
+	}
+
+	code := r.URL.Query().Get("code")
+	if code == "" {
+		respondWithError(w, http.StatusBadRequest, "Missing authorization code")
+		return
+	}
+
+	identity, err := a.OIDC.Exchange(r.Context(), code, stored["verifier"], stored["nonce"])
+	if err != nil {
+		slog.WarnContext(r.Context(), "OIDC sign-in failed", "error", err)
+		respondWithError(w, http.StatusUnauthorized, "Single sign-on failed")
+		return
+	}
+
+	role, ok := a.OIDC.Role(identity.Groups)
+	if !ok {
+		respondWithAuthError(w, r, auth.ErrNoRole)
+		return
+	}
+
+	user, err := auth.NewProvisioner(a.Repo).Provision(r.Context(), models.OIDCProvider, identity, role)
+	if err != nil {
+		respondWithAuthError(w, r, err)
+		return
+	}
+
+	if !user.IsActive {
+		respondWithError(w, http.StatusForbidden, "Account is deactivated")
+		return
+	}
+
+	a.issueToken(w, r, user, "")
+}
+
+// oidcState returns the values stored in the sign-in state cookie if it is authentic and unexpired
+func (a *Application) oidcState(r *http.Request) (map[string]string, bool) {
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil {
+		return nil, false
+	}
+
+	token, err := jwtauth.VerifyToken(a.JWTManager.Auth, cookie.Value)
+	if err != nil {
+		return nil, false
+	}
+
+	claims := token.PrivateClaims()
+	if claims["purpose"] != oidcStatePurpose {
+		return nil, false
+	}
+
+	state := make(map[string]string, 3)
+	for _, key := range []string{"state", "nonce", "verifier"} {
+		value, ok := claims[key].(string)
+		if !ok || value == "" {
+			return nil, false
+		}
+		state[key] = value
+	}
+	return state, true
+}
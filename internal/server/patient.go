@@ -2,29 +2,32 @@ package server
 
 import (
 	"encoding/json"
+	"log/slog"
 	"net/http"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
+	"github.com/yhwbach/makerble/internal/models"
 	"github.com/yhwbach/makerble/internal/schemas"
 	"github.com/yhwbach/makerble/internal/utils"
 )
 
 // @Summary Create patient
-// @Description Create a new patient (Receptionist only)
+// @Description Create a new patient (requires patient.create)
 // @Tags patients
 // @Accept json
 // @Produce json
 // @Security BearerAuth
 // @Param patient body schemas.PatientCreate true "Patient information"
 // @Success 201 {object} schemas.PatientCreateResponse
-// @Failure 400,403 {object} ErrorResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 403 {object} ValidationErrorResponse
 // @Router /patients [post]
 func (a *Application) createPatientHandler(w http.ResponseWriter, r *http.Request) {
 	var patient schemas.PatientCreate
 	if err := json.NewDecoder(r.Body).Decode(&patient); err != nil {
-		println("Error decoding request body:", err)
+		slog.DebugContext(r.Context(), "invalid patient payload", "error", err)
 		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
 		return
 	}
@@ -36,21 +39,11 @@ func (a *Application) createPatientHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	userID, err := utils.GetUserIDFromContext(r.Context())
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Error processing request")
+	if !a.allowClinicalFields(w, r, patient.ClinicalFields()) {
 		return
 	}
 
-	registeredByID, err := uuid.Parse(userID)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Error processing request")
-		return
-	}
-
-	// Create a modified patient with the parsed date
-	patientToCreate := patient
-	patientID, err := a.Repo.Patients.Create(r.Context(), registeredByID, &patientToCreate, dateOfBirth)
+	createdID, err := a.registerPatient(r, &patient, dateOfBirth)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Error creating patient")
 		return
@@ -58,12 +51,55 @@ func (a *Application) createPatientHandler(w http.ResponseWriter, r *http.Reques
 
 	respondWithJSON(w, http.StatusCreated, schemas.PatientCreateResponse{
 		Message:   "Patient created successfully",
-		PatientID: patientID,
+		PatientID: createdID.String(),
 	})
 }
 
+// registerPatient creates the patient on behalf of the caller and records it in the audit log
+func (a *Application) registerPatient(r *http.Request, patient *schemas.PatientCreate, dateOfBirth time.Time) (uuid.UUID, error) {
+	userID, err := utils.GetUserIDFromContext(r.Context())
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	registeredByID, err := uuid.Parse(userID)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	query, err := a.patientQuery(r)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	event, err := patientAuditEvent(r, models.AuditActionPatientCreate, nil, patient.Fields(), nil)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	patientID, err := a.Repo.Patients.Create(r.Context(), registeredByID, patient, dateOfBirth, event)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	createdID := uuid.MustParse(patientID)
+
+	// Users limited to their care team would otherwise lose sight of the patient they just registered
+	if query.CareTeamMember != nil {
+		err := a.Repo.CareTeams.Create(r.Context(), &models.CareTeamAssignment{
+			PatientID:  createdID,
+			UserID:     registeredByID,
+			Role:       models.CareTeamAttending,
+			AssignedBy: registeredByID,
+		})
+		if err != nil {
+			return uuid.Nil, err
+		}
+	}
+
+	return createdID, nil
+}
+
 // @Summary List patients
-// @Description Get all patients
+// @Description Get all patients. Clinical fields are withheld without patient.read.clinical.
 // @Tags patients
 // @Accept json
 // @Produce json
@@ -72,12 +108,44 @@ func (a *Application) createPatientHandler(w http.ResponseWriter, r *http.Reques
 // @Failure 403,500 {object} ErrorResponse
 // @Router /patients [get]
 func (a *Application) listPatientsHandler(w http.ResponseWriter, r *http.Request) {
-	patients, err := a.Repo.Patients.FindAll(r.Context())
+	query, err := a.patientQuery(r)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error fetching patients")
+		return
+	}
+
+	patients, err := a.Repo.Patients.FindAll(r.Context(), query)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Error fetching patients")
 		return
 	}
 
+	readClinical, err := a.can(r, models.PermissionPatientReadClinical)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error fetching patients")
+		return
+	}
+	if !readClinical {
+		for _, patient := range patients {
+			patient.RedactClinical()
+		}
+	}
+
+	patientIDs := make([]string, 0, len(patients))
+	var fields []string
+	for _, patient := range patients {
+		patientIDs = append(patientIDs, patient.ID.String())
+		fields = patient.VisibleFields()
+	}
+	err = a.auditPatientAccess(r, models.AuditActionPatientList, nil, fields, map[string]interface{}{
+		"patient_ids": patientIDs,
+		"count":       len(patients),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error recording access")
+		return
+	}
+
 	respondWithJSON(w, http.StatusOK, schemas.PatientListResponse{
 		Patients: patients,
 		Total:    len(patients),
@@ -87,7 +155,7 @@ func (a *Application) listPatientsHandler(w http.ResponseWriter, r *http.Request
 }
 
 // @Summary Get patient
-// @Description Get patient by ID
+// @Description Get patient by ID. Clinical fields are withheld without patient.read.clinical.
 // @Tags patients
 // @Accept json
 // @Produce json
@@ -97,19 +165,18 @@ func (a *Application) listPatientsHandler(w http.ResponseWriter, r *http.Request
 // @Failure 403,404,500 {object} ErrorResponse
 // @Router /patients/{id} [get]
 func (a *Application) getPatientHandler(w http.ResponseWriter, r *http.Request) {
-	id, err := uuid.Parse(chi.URLParam(r, "id"))
-	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid patient ID")
+	patient, ok := a.patientFromURL(w, r)
+	if !ok {
 		return
 	}
 
-	patient, err := a.Repo.Patients.FindByID(r.Context(), id)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Error fetching patient")
+	if err := a.redactPatient(r, patient); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error checking permissions")
 		return
 	}
-	if patient == nil {
-		respondWithError(w, http.StatusNotFound, "Patient not found")
+
+	if err := a.auditPatientAccess(r, models.AuditActionPatientRead, &patient.ID, patient.VisibleFields(), nil); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error recording access")
 		return
 	}
 
@@ -117,7 +184,8 @@ func (a *Application) getPatientHandler(w http.ResponseWriter, r *http.Request)
 }
 
 // @Summary Update patient
-// @Description Update patient information (Receptionist only)
+// @Description Update patient contact information (requires patient.update.demographics).
+// @Description Clinical fields are rejected without patient.update.clinical.
 // @Tags patients
 // @Accept json
 // @Produce json
@@ -125,14 +193,15 @@ func (a *Application) getPatientHandler(w http.ResponseWriter, r *http.Request)
 // @Param id path string true "Patient ID"
 // @Param patient body schemas.PatientUpdate true "Patient update information"
 // @Success 200 {object} models.Patient
-// @Failure 400,403,404,500 {object} ErrorResponse
+// @Failure 400,404,500 {object} ErrorResponse
+// @Failure 403 {object} ValidationErrorResponse
 // @Router /patients/{id} [put]
 func (a *Application) updatePatientLimitedHandler(w http.ResponseWriter, r *http.Request) {
-	id, err := uuid.Parse(chi.URLParam(r, "id"))
-	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid patient ID")
+	existing, ok := a.patientFromURL(w, r)
+	if !ok {
 		return
 	}
+	id := existing.ID
 
 	var update schemas.PatientUpdate
 	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
@@ -140,14 +209,24 @@ func (a *Application) updatePatientLimitedHandler(w http.ResponseWriter, r *http
 		return
 	}
 
+	if !a.allowClinicalFields(w, r, update.ClinicalFields()) {
+		return
+	}
+
 	limitedUpdate := schemas.PatientUpdate{
 		FullName:       update.FullName,
 		Email:         update.Email,
 		Phone:         update.Phone,
 		Address:       update.Address,
+		MedicalHistory: update.MedicalHistory,
 	}
 
-	patient, err := a.Repo.Patients.UpdateByID(r.Context(), id, &limitedUpdate)
+	event, err := patientAuditEvent(r, models.AuditActionPatientUpdate, &id, limitedUpdate.Fields(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error updating patient")
+		return
+	}
+	patient, err := a.Repo.Patients.UpdateByID(r.Context(), id, &limitedUpdate, event)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Error updating patient")
 		return
@@ -157,11 +236,11 @@ func (a *Application) updatePatientLimitedHandler(w http.ResponseWriter, r *http
 		return
 	}
 
-	respondWithJSON(w, http.StatusOK, patient)
+	a.respondWithPatient(w, r, http.StatusOK, patient)
 }
 
 // @Summary Update patient medical info
-// @Description Update patient medical information (Doctor only)
+// @Description Update patient medical information (requires patient.update.clinical)
 // @Tags patients
 // @Accept json
 // @Produce json
@@ -172,11 +251,11 @@ func (a *Application) updatePatientLimitedHandler(w http.ResponseWriter, r *http
 // @Failure 400,403,404,500 {object} ErrorResponse
 // @Router /patients/{id} [patch]
 func (a *Application) updatePatientHandler(w http.ResponseWriter, r *http.Request) {
-	id, err := uuid.Parse(chi.URLParam(r, "id"))
-	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid patient ID")
+	existing, ok := a.patientFromURL(w, r)
+	if !ok {
 		return
 	}
+	id := existing.ID
 
 	var update schemas.PatientUpdate
 	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
@@ -184,7 +263,12 @@ func (a *Application) updatePatientHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	patient, err := a.Repo.Patients.UpdateByID(r.Context(), id, &update)
+	event, err := patientAuditEvent(r, models.AuditActionPatientUpdate, &id, update.Fields(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error updating patient medical info")
+		return
+	}
+	patient, err := a.Repo.Patients.UpdateByID(r.Context(), id, &update, event)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Error updating patient medical info")
 		return
@@ -194,11 +278,11 @@ func (a *Application) updatePatientHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	respondWithJSON(w, http.StatusOK, patient)
+	a.respondWithPatient(w, r, http.StatusOK, patient)
 }
 
 // @Summary Delete patient
-// @Description Delete a patient (Receptionist only)
+// @Description Delete a patient (requires patient.delete)
 // @Tags patients
 // @Accept json
 // @Produce json
@@ -208,13 +292,18 @@ func (a *Application) updatePatientHandler(w http.ResponseWriter, r *http.Reques
 // @Failure 403,404,500 {object} ErrorResponse
 // @Router /patients/{id} [delete]
 func (a *Application) deletePatientHandler(w http.ResponseWriter, r *http.Request) {
-	id, err := uuid.Parse(chi.URLParam(r, "id"))
-	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid patient ID")
+	existing, ok := a.patientFromURL(w, r)
+	if !ok {
 		return
 	}
+	id := existing.ID
 
-	err = a.Repo.Patients.DeleteByID(r.Context(), id)
+	event, err := patientAuditEvent(r, models.AuditActionPatientDelete, &id, nil, nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error deleting patient")
+		return
+	}
+	err = a.Repo.Patients.DeleteByID(r.Context(), id, event)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Error deleting patient")
 		return
@@ -222,3 +311,112 @@ func (a *Application) deletePatientHandler(w http.ResponseWriter, r *http.Reques
 
 	w.WriteHeader(http.StatusNoContent)
 }
+
+// patientQuery limits patient lookups to the caller's care team unless they may see every patient.
+// Service accounts have no care team; they are third parties and only see patients who consented
+// to data sharing.
+func (a *Application) patientQuery(r *http.Request) (schemas.PatientQuery, error) {
+	if apiKeyFromContext(r.Context()) != nil {
+		return schemas.PatientQuery{Consent: models.ConsentDataSharing}, nil
+	}
+
+	readAll, err := a.can(r, models.PermissionPatientReadAll)
+	if err != nil || readAll {
+		return schemas.PatientQuery{}, err
+	}
+
+	userID, err := currentUserID(r)
+	if err != nil {
+		return schemas.PatientQuery{}, err
+	}
+	return schemas.PatientQuery{CareTeamMember: &userID}, nil
+}
+
+// sharedPatientQuery limits patient lookups like patientQuery, and to the patients who consented
+// to data sharing. Exports and integrations such as FHIR use it: patients leave the system through
+// them whoever the caller is.
+func (a *Application) sharedPatientQuery(r *http.Request) (schemas.PatientQuery, error) {
+	query, err := a.patientQuery(r)
+	query.Consent = models.ConsentDataSharing
+	return query, err
+}
+
+// patientFromURL loads the patient identified by the {id} URL parameter, responding with an error
+// if it does not exist or the caller has no access to it
+func (a *Application) patientFromURL(w http.ResponseWriter, r *http.Request) (*models.Patient, bool) {
+	return a.findPatientFromURL(w, r, a.patientQuery)
+}
+
+// sharedPatientFromURL loads the patient identified by the {id} URL parameter like patientFromURL,
+// responding with an error unless it consented to data sharing
+func (a *Application) sharedPatientFromURL(w http.ResponseWriter, r *http.Request) (*models.Patient, bool) {
+	return a.findPatientFromURL(w, r, a.sharedPatientQuery)
+}
+
+func (a *Application) findPatientFromURL(w http.ResponseWriter, r *http.Request, patientQuery func(*http.Request) (schemas.PatientQuery, error)) (*models.Patient, bool) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid patient ID")
+		return nil, false
+	}
+
+	query, err := patientQuery(r)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error fetching patient")
+		return nil, false
+	}
+
+	patient, err := a.Repo.Patients.FindByID(r.Context(), id, query)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error fetching patient")
+		return nil, false
+	}
+	if patient == nil {
+		respondWithError(w, http.StatusNotFound, "Patient not found")
+		return nil, false
+	}
+
+	return patient, true
+}
+
+// respondWithPatient responds with the patient, withholding the clinical fields unless the caller may read them
+func (a *Application) respondWithPatient(w http.ResponseWriter, r *http.Request, code int, patient *models.Patient) {
+	if err := a.redactPatient(r, patient); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error checking permissions")
+		return
+	}
+
+	respondWithJSON(w, code, patient)
+}
+
+// redactPatient withholds the clinical fields of the patient unless the caller may read them
+func (a *Application) redactPatient(r *http.Request, patient *models.Patient) error {
+	readClinical, err := a.can(r, models.PermissionPatientReadClinical)
+	if err != nil {
+		return err
+	}
+	if !readClinical {
+		patient.RedactClinical()
+	}
+	return nil
+}
+
+// allowClinicalFields rejects a request setting clinical fields the caller may not write, listing
+// the forbidden fields. It reports whether the request may proceed.
+func (a *Application) allowClinicalFields(w http.ResponseWriter, r *http.Request, fields []string) bool {
+	if len(fields) == 0 {
+		return true
+	}
+
+	allowed, err := a.can(r, models.PermissionPatientUpdateClinical)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error checking permissions")
+		return false
+	}
+	if !allowed {
+		respondWithValidationErrors(w, http.StatusForbidden, "Not allowed to change clinical fields", fields)
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,239 @@
+package server
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"path/filepath"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/yhwbach/makerble/internal/importer"
+	"github.com/yhwbach/makerble/internal/models"
+)
+
+// @Summary Import patients
+// @Description Import patients from a CSV or XLSX file (requires patient.import). The first row names the columns,
+// @Description which map to the fields of a patient by name, e.g. "Date of Birth" or "DOB" for date_of_birth;
+// @Description mapping overrides them. The file is checked and the import accepted, then rows are validated,
+// @Description checked for duplicates and inserted in the background. Importing medical_history also requires
+// @Description patient.update.clinical.
+// @Tags patients
+// @Accept multipart/form-data
+// @Produce json
+// @Security BearerAuth
+// @Param file formData file true "CSV or XLSX file, the first sheet being imported"
+// @Param format formData string false "csv or xlsx, taken from the file name by default"
+// @Param mapping formData string false "JSON object mapping column names to patient fields, empty to leave a column out"
+// @Success 202 {object} models.PatientImport
+// @Failure 400,403 {object} ValidationErrorResponse
+// @Failure 413,500 {object} ErrorResponse
+// @Router /patients/imports [post]
+func (a *Application) createPatientImportHandler(w http.ResponseWriter, r *http.Request) {
+	maxSize := int64(a.Config.Import.MaxFileMB) << 20
+	r.Body = http.MaxBytesReader(w, r.Body, maxSize+1<<20)
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			respondWithError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("The file must not exceed %d MB", a.Config.Import.MaxFileMB))
+			return
+		}
+		respondWithError(w, http.StatusBadRequest, "Invalid multipart form")
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		respondWithValidationErrors(w, http.StatusBadRequest, "Missing import file", []string{"file"})
+		return
+	}
+	defer file.Close()
+	if header.Size > maxSize {
+		respondWithError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("The file must not exceed %d MB", a.Config.Import.MaxFileMB))
+		return
+	}
+
+	format := r.FormValue("format")
+	if format == "" {
+		format = importer.FormatOf(header.Filename)
+	}
+	if !importer.IsValidFormat(format) {
+		respondWithValidationErrors(w, http.StatusBadRequest, "Invalid import format", importer.Formats)
+		return
+	}
+
+	var mapping map[string]string
+	if value := r.FormValue("mapping"); value != "" {
+		if err := json.Unmarshal([]byte(value), &mapping); err != nil {
+			respondWithValidationErrors(w, http.StatusBadRequest, "Invalid column mapping", []string{"mapping"})
+			return
+		}
+	}
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error reading import file")
+		return
+	}
+
+	rows, err := importer.Read(format, data)
+	if err != nil {
+		slog.DebugContext(r.Context(), "invalid import file", "error", err)
+		respondWithValidationErrors(w, http.StatusBadRequest, "Invalid import file", []string{err.Error()})
+		return
+	}
+	if len(rows) < 2 {
+		respondWithValidationErrors(w, http.StatusBadRequest, "Invalid import file", []string{"the file has no patient rows"})
+		return
+	}
+
+	columns, problems := importer.MapColumns(rows[0].Cells, mapping)
+	if len(problems) > 0 {
+		respondWithValidationErrors(w, http.StatusBadRequest, "Invalid column mapping", problems)
+		return
+	}
+
+	if !a.allowClinicalFields(w, r, columns.ClinicalFields()) {
+		return
+	}
+
+	userID, err := currentUserID(r)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error starting import")
+		return
+	}
+
+	filename := []rune(filepath.Base(header.Filename))
+	if len(filename) > 255 {
+		filename = filename[:255]
+	}
+
+	job := &importer.Job{
+		Import: &models.PatientImport{
+			CreatedBy: userID,
+			Filename:  string(filename),
+			Format:    format,
+		},
+		Columns: columns,
+		Rows:    rows[1:],
+	}
+	if err := a.Imports.Start(r.Context(), job); err != nil {
+		slog.ErrorContext(r.Context(), "failed to start patient import", "error", err)
+		respondWithError(w, http.StatusInternalServerError, "Error starting import")
+		return
+	}
+
+	w.Header().Set("Location", "/api/v1/patients/imports/"+job.Import.ID.String())
+	respondWithJSON(w, http.StatusAccepted, job.Import)
+}
+
+// @Summary List patient imports
+// @Description List the latest patient imports of the caller's clinic, most recent first (requires patient.import)
+// @Tags patients
+// @Produce json
+// @Security BearerAuth
+// @Param limit query int false "Maximum number of imports (default 20, at most 500)"
+// @Success 200 {array} models.PatientImport
+// @Failure 400 {object} ValidationErrorResponse
+// @Failure 403,500 {object} ErrorResponse
+// @Router /patients/imports [get]
+func (a *Application) listPatientImportsHandler(w http.ResponseWriter, r *http.Request) {
+	limit := 20
+	if value := r.URL.Query().Get("limit"); value != "" {
+		var err error
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > 500 {
+			respondWithValidationErrors(w, http.StatusBadRequest, "Invalid query parameters", []string{"limit"})
+			return
+		}
+	}
+
+	imports, err := a.Repo.PatientImports.List(r.Context(), limit)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error fetching imports")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, imports)
+}
+
+// @Summary Get patient import
+// @Description Get the status and progress of a patient import (requires patient.import)
+// @Tags patients
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Import ID"
+// @Success 200 {object} models.PatientImport
+// @Failure 400,403,404,500 {object} ErrorResponse
+// @Router /patients/imports/{id} [get]
+func (a *Application) getPatientImportHandler(w http.ResponseWriter, r *http.Request) {
+	patientImport, ok := a.patientImportFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, patientImport)
+}
+
+// @Summary Patient import report
+// @Description Download the problems found in the rows of a patient import as CSV, one line per problem with the
+// @Description number of the row in the file (requires patient.import). Rows not listed were imported; the report
+// @Description of an import still running is partial.
+// @Tags patients
+// @Produce text/csv
+// @Security BearerAuth
+// @Param id path string true "Import ID"
+// @Success 200 {file} file
+// @Failure 400,403,404,500 {object} ErrorResponse
+// @Router /patients/imports/{id}/report [get]
+func (a *Application) patientImportReportHandler(w http.ResponseWriter, r *http.Request) {
+	patientImport, ok := a.patientImportFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	importErrors, err := a.Repo.PatientImports.ListErrors(r.Context(), patientImport.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error fetching import report")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="patient-import-%s-report.csv"`, patientImport.ID))
+	w.WriteHeader(http.StatusOK)
+
+	report := csv.NewWriter(w)
+	report.Write([]string{"row", "field", "error"})
+	for _, importError := range importErrors {
+		report.Write([]string{strconv.Itoa(importError.Row), importError.Field, importError.Message})
+	}
+	report.Flush()
+}
+
+// patientImportFromURL loads the import identified by the {id} URL parameter, responding with an
+// error if it does not exist in the caller's clinic
+func (a *Application) patientImportFromURL(w http.ResponseWriter, r *http.Request) (*models.PatientImport, bool) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid import ID")
+		return nil, false
+	}
+
+	patientImport, err := a.Repo.PatientImports.FindByID(r.Context(), id)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error fetching import")
+		return nil, false
+	}
+	if patientImport == nil {
+		respondWithError(w, http.StatusNotFound, "Import not found")
+		return nil, false
+	}
+
+	return patientImport, true
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/yhwbach/makerble/internal/models"
+	"github.com/yhwbach/makerble/internal/research"
+	"github.com/yhwbach/makerble/internal/schemas"
+)
+
+// @Summary Research export
+// @Description Export the patients of the caller's clinic who consent to research as a de-identified dataset
+// @Description (requires research.export). Direct identifiers are removed, dates shifted per patient, ages over 89
+// @Description bucketed, addresses reduced to three-digit ZIP codes, and patients identified by stable pseudonyms.
+// @Description Medical history is only included when RESEARCH_INCLUDE_MEDICAL_HISTORY is enabled.
+// @Tags admin
+// @Produce text/csv
+// @Produce application/vnd.apache.parquet
+// @Security BearerAuth
+// @Param format query string false "csv (default) or parquet"
+// @Success 200 {file} file
+// @Failure 400 {object} ValidationErrorResponse
+// @Failure 403,404,500 {object} ErrorResponse
+// @Router /admin/research-export [get]
+func (a *Application) researchExportHandler(w http.ResponseWriter, r *http.Request) {
+	if a.Research == nil {
+		respondWithError(w, http.StatusNotFound, "Research export is not enabled")
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "csv"
+	}
+	if !research.IsValidFormat(format) {
+		respondWithValidationErrors(w, http.StatusBadRequest, "Invalid export format", research.Formats)
+		return
+	}
+
+	patients, err := a.Repo.Patients.FindAll(r.Context(), schemas.PatientQuery{Consent: models.ConsentResearch})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error fetching patients")
+		return
+	}
+
+	now := time.Now()
+	records := make([]research.Record, 0, len(patients))
+	patientIDs := make([]string, 0, len(patients))
+	for _, patient := range patients {
+		records = append(records, a.Research.Record(patient.Patient, now))
+		patientIDs = append(patientIDs, patient.ID.String())
+	}
+
+	var dataset bytes.Buffer
+	if err := a.Research.Write(&dataset, format, records); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error exporting patients")
+		return
+	}
+
+	err = a.auditPatientAccess(r, models.AuditActionResearchExport, nil, a.Research.Fields(), map[string]interface{}{
+		"patient_ids": patientIDs,
+		"count":       len(patients),
+		"format":      format,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error recording access")
+		return
+	}
+
+	w.Header().Set("Content-Type", research.ContentType(format))
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="research-%s.%s"`, now.UTC().Format("20060102"), format))
+	w.WriteHeader(http.StatusOK)
+	w.Write(dataset.Bytes())
+}
@@ -9,10 +9,20 @@ type ErrorResponse struct {
 	Message string `json:"message"`
 }
 
+// ValidationErrorResponse is returned when a request fails one or more validation rules
+type ValidationErrorResponse struct {
+	Message string   `json:"message"`
+	Errors  []string `json:"errors"`
+}
+
 func respondWithError(w http.ResponseWriter, code int, message string) {
 	respondWithJSON(w, code, ErrorResponse{Message: message})
 }
 
+func respondWithValidationErrors(w http.ResponseWriter, code int, message string, errors []string) {
+	respondWithJSON(w, code, ValidationErrorResponse{Message: message, Errors: errors})
+}
+
 func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
 	response, _ := json.Marshal(payload)
 	w.Header().Set("Content-Type", "application/json")
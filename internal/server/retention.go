@@ -0,0 +1,37 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// @Summary Retention runs
+// @Description List the reports of the latest data retention runs, most recent first (requires audit.read)
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param limit query int false "Maximum number of runs (default 20, at most 500)"
+// @Success 200 {array} models.RetentionRun
+// @Failure 400 {object} ValidationErrorResponse
+// @Failure 403,500 {object} ErrorResponse
+// @Router /admin/retention/runs [get]
+func (a *Application) listRetentionRunsHandler(w http.ResponseWriter, r *http.Request) {
+	limit := 20
+	if value := r.URL.Query().Get("limit"); value != "" {
+		var err error
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > 500 {
+			respondWithValidationErrors(w, http.StatusBadRequest, "Invalid query parameters", []string{"limit"})
+			return
+		}
+	}
+
+	runs, err := a.Repo.Retention.List(r.Context(), limit)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error fetching retention runs")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, runs)
+}
@@ -0,0 +1,258 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/yhwbach/makerble/internal/models"
+	"github.com/yhwbach/makerble/internal/schemas"
+)
+
+var roleNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_-]{1,49}$`)
+
+// @Summary List roles
+// @Description List all roles with their permissions (requires role.manage)
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {array} models.Role
+// @Failure 403,500 {object} ErrorResponse
+// @Router /admin/roles [get]
+func (a *Application) listRolesHandler(w http.ResponseWriter, r *http.Request) {
+	roles, err := a.Repo.Roles.FindAll(r.Context())
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error fetching roles")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, roles)
+}
+
+// @Summary Get role
+// @Description Get a role with its permissions (requires role.manage)
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param name path string true "Role name"
+// @Success 200 {object} models.Role
+// @Failure 403,404,500 {object} ErrorResponse
+// @Router /admin/roles/{name} [get]
+func (a *Application) getRoleHandler(w http.ResponseWriter, r *http.Request) {
+	role, ok := a.roleFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, role)
+}
+
+// @Summary Create role
+// @Description Define a new role from a set of permissions (requires role.manage)
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param role body schemas.RoleCreate true "Role"
+// @Success 201 {object} models.Role
+// @Failure 400 {object} ValidationErrorResponse
+// @Failure 403,409,500 {object} ErrorResponse
+// @Router /admin/roles [post]
+func (a *Application) createRoleHandler(w http.ResponseWriter, r *http.Request) {
+	var request schemas.RoleCreate
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	if !roleNamePattern.MatchString(string(request.Name)) || request.Name == models.Service {
+		respondWithError(w, http.StatusBadRequest, "Role names must be 2 to 50 lowercase letters, digits, hyphens or underscores")
+		return
+	}
+
+	permissions, ok := validPermissions(w, request.Permissions)
+	if !ok {
+		return
+	}
+
+	existing, err := a.Repo.Roles.FindByName(r.Context(), request.Name)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error creating role")
+		return
+	}
+	if existing != nil {
+		respondWithError(w, http.StatusConflict, "Role already exists")
+		return
+	}
+
+	role := models.Role{
+		Name:        request.Name,
+		Description: strings.TrimSpace(request.Description),
+		Permissions: permissions,
+	}
+	if err := a.Repo.Roles.Create(r.Context(), &role); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error creating role")
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, role)
+}
+
+// @Summary Update role
+// @Description Replace the description and permissions of a role (requires role.manage).
+// @Description Changes apply to every user holding the role.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param name path string true "Role name"
+// @Param role body schemas.RoleUpdate true "Role"
+// @Success 200 {object} models.Role
+// @Failure 400 {object} ValidationErrorResponse
+// @Failure 403,404,500 {object} ErrorResponse
+// @Router /admin/roles/{name} [put]
+func (a *Application) updateRoleHandler(w http.ResponseWriter, r *http.Request) {
+	existing, ok := a.roleFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	var request schemas.RoleUpdate
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	permissions, ok := validPermissions(w, request.Permissions)
+	if !ok {
+		return
+	}
+
+	// Otherwise nobody could repair the roles without direct database access
+	if existing.Name == models.Operator && !slices.Contains(permissions, models.PermissionRoleManage) {
+		respondWithError(w, http.StatusBadRequest, "The operator role must keep the role.manage permission")
+		return
+	}
+
+	role := models.Role{
+		Name:        existing.Name,
+		Description: strings.TrimSpace(request.Description),
+		Permissions: permissions,
+	}
+	if err := a.Repo.Roles.Update(r.Context(), &role); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error updating role")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, role)
+}
+
+// @Summary Delete role
+// @Description Delete a role that is neither built in nor assigned to anyone (requires role.manage)
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param name path string true "Role name"
+// @Success 204 "No Content"
+// @Failure 400,403,404,409,500 {object} ErrorResponse
+// @Router /admin/roles/{name} [delete]
+func (a *Application) deleteRoleHandler(w http.ResponseWriter, r *http.Request) {
+	role, ok := a.roleFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	if role.BuiltIn {
+		respondWithError(w, http.StatusBadRequest, "Built-in roles cannot be deleted")
+		return
+	}
+
+	assigned, err := a.Repo.Roles.IsAssigned(r.Context(), role.Name)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error deleting role")
+		return
+	}
+	if assigned {
+		respondWithError(w, http.StatusConflict, "Role is assigned to users or pending invitations")
+		return
+	}
+
+	if _, err := a.Repo.Roles.Delete(r.Context(), role.Name); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error deleting role")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// roleFromURL loads the role identified by the {name} URL parameter, responding with an error if it cannot
+func (a *Application) roleFromURL(w http.ResponseWriter, r *http.Request) (*models.Role, bool) {
+	role, err := a.Repo.Roles.FindByName(r.Context(), models.UserType(chi.URLParam(r, "name")))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error fetching role")
+		return nil, false
+	}
+	if role == nil {
+		respondWithError(w, http.StatusNotFound, "Role not found")
+		return nil, false
+	}
+
+	return role, true
+}
+
+// roleExists reports whether the user type names a role that can be assigned to people
+func (a *Application) roleExists(ctx context.Context, userType models.UserType) (bool, error) {
+	if userType == "" || userType == models.Service {
+		return false, nil
+	}
+
+	role, err := a.Repo.Roles.FindByName(ctx, userType)
+	if err != nil {
+		return false, err
+	}
+	return role != nil, nil
+}
+
+// canAssignRole reports whether the caller may give the role to people or take it away from them.
+// Roles granting deployment permissions reach every clinic, so clinic admins cannot.
+func (a *Application) canAssignRole(r *http.Request, userType models.UserType) (bool, error) {
+	role, err := a.Repo.Roles.FindByName(r.Context(), userType)
+	if err != nil {
+		return false, err
+	}
+	if role == nil || !role.IsDeploymentLevel() {
+		return true, nil
+	}
+
+	return a.can(r, models.PermissionClinicManage)
+}
+
+// validPermissions checks and deduplicates the permissions of a role, responding with the
+// unknown ones if there are any
+func validPermissions(w http.ResponseWriter, permissions []string) ([]string, bool) {
+	var unknown []string
+	valid := []string{}
+	for _, permission := range permissions {
+		switch {
+		case !models.IsValidPermission(permission):
+			unknown = append(unknown, permission)
+		case !slices.Contains(valid, permission):
+			valid = append(valid, permission)
+		}
+	}
+
+	if len(unknown) > 0 {
+		respondWithValidationErrors(w, http.StatusBadRequest, "Unknown permissions", unknown)
+		return nil, false
+	}
+
+	slices.Sort(valid)
+	return valid, true
+}
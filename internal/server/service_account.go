@@ -0,0 +1,352 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/yhwbach/makerble/internal/models"
+	"github.com/yhwbach/makerble/internal/schemas"
+	"github.com/yhwbach/makerble/internal/utils"
+)
+
+// maxAPIKeyGracePeriod caps how long a rotated key keeps working next to its replacement
+const maxAPIKeyGracePeriod = 7 * 24 * time.Hour
+
+// @Summary Create service account
+// @Description Create an account for another system to call the API with API keys (Admin only)
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param account body schemas.ServiceAccountCreate true "Service account"
+// @Success 201 {object} models.User
+// @Failure 400,403,409,500 {object} ErrorResponse
+// @Router /admin/service-accounts [post]
+func (a *Application) createServiceAccountHandler(w http.ResponseWriter, r *http.Request) {
+	var request schemas.ServiceAccountCreate
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	username := strings.TrimSpace(request.Username)
+	if username == "" {
+		respondWithError(w, http.StatusBadRequest, "Username is required")
+		return
+	}
+
+	email := strings.TrimSpace(request.Email)
+	if email == "" {
+		email = username + "@service-accounts.invalid"
+	} else if !strings.Contains(email, "@") {
+		respondWithError(w, http.StatusBadRequest, "Invalid email address")
+		return
+	}
+
+	fullName := strings.TrimSpace(request.FullName)
+	if fullName == "" {
+		fullName = username
+	}
+
+	exists, err := a.Repo.Users.UsernameExists(r.Context(), username)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error creating service account")
+		return
+	}
+	if exists {
+		respondWithError(w, http.StatusConflict, "Username already exists")
+		return
+	}
+
+	exists, err = a.Repo.Users.EmailExists(r.Context(), email)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error creating service account")
+		return
+	}
+	if exists {
+		respondWithError(w, http.StatusConflict, "Email already exists")
+		return
+	}
+
+	account := models.User{
+		Username:     username,
+		Email:        email,
+		FullName:     fullName,
+		UserType:     models.Service,
+		AuthProvider: models.APIKeyProvider,
+	}
+	if err := a.Repo.Users.CreateExternal(r.Context(), &account); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error creating service account")
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, account)
+}
+
+// @Summary List service accounts
+// @Description List all service accounts (Admin only)
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {array} models.User
+// @Failure 403,500 {object} ErrorResponse
+// @Router /admin/service-accounts [get]
+func (a *Application) listServiceAccountsHandler(w http.ResponseWriter, r *http.Request) {
+	users, err := a.Repo.Users.FindAll(r.Context())
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error fetching service accounts")
+		return
+	}
+
+	accounts := []models.User{}
+	for _, user := range users {
+		if user.UserType == models.Service {
+			accounts = append(accounts, user)
+		}
+	}
+
+	respondWithJSON(w, http.StatusOK, accounts)
+}
+
+// @Summary Create API key
+// @Description Issue an API key with the given scopes to a service account (Admin only). The key is only returned once.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Service account ID"
+// @Param key body schemas.APIKeyCreate true "API key"
+// @Success 201 {object} schemas.APIKeyCreateResponse
+// @Failure 400,403,404,500 {object} ErrorResponse
+// @Router /admin/service-accounts/{id}/keys [post]
+func (a *Application) createAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	account, ok := a.serviceAccountFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	var request schemas.APIKeyCreate
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	name := strings.TrimSpace(request.Name)
+	if name == "" {
+		respondWithError(w, http.StatusBadRequest, "Name is required")
+		return
+	}
+
+	if len(request.Scopes) == 0 {
+		respondWithError(w, http.StatusBadRequest, "At least one scope is required")
+		return
+	}
+	for _, scope := range request.Scopes {
+		if !models.IsValidScope(scope) {
+			respondWithError(w, http.StatusBadRequest, "Invalid scope: "+scope)
+			return
+		}
+	}
+
+	if request.ExpiresAt != nil && !request.ExpiresAt.After(time.Now()) {
+		respondWithError(w, http.StatusBadRequest, "Expiry must be in the future")
+		return
+	}
+
+	a.issueAPIKey(w, r, &models.APIKey{
+		UserID:    account.ID,
+		Name:      name,
+		Scopes:    request.Scopes,
+		ExpiresAt: request.ExpiresAt,
+	})
+}
+
+// @Summary List API keys
+// @Description List the API keys of a service account, including revoked and expired ones (Admin only)
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Service account ID"
+// @Success 200 {array} models.APIKey
+// @Failure 400,403,404,500 {object} ErrorResponse
+// @Router /admin/service-accounts/{id}/keys [get]
+func (a *Application) listAPIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	account, ok := a.serviceAccountFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	apiKeys, err := a.Repo.APIKeys.ListByUser(r.Context(), account.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error fetching API keys")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, apiKeys)
+}
+
+// @Summary Rotate API key
+// @Description Issue a replacement for an API key with the same name and scopes (Admin only).
+// @Description The old key is revoked at once or, with a grace period, expires when it ends.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Service account ID"
+// @Param keyID path string true "API key ID"
+// @Param rotation body schemas.APIKeyRotate false "Rotation options"
+// @Success 201 {object} schemas.APIKeyCreateResponse
+// @Failure 400,403,404,500 {object} ErrorResponse
+// @Router /admin/service-accounts/{id}/keys/{keyID}/rotate [post]
+func (a *Application) rotateAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	apiKey, ok := a.apiKeyFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	if !apiKey.IsActive() {
+		respondWithError(w, http.StatusBadRequest, "Only active API keys can be rotated")
+		return
+	}
+
+	var request schemas.APIKeyRotate
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+			return
+		}
+	}
+
+	var gracePeriod time.Duration
+	if request.GracePeriod != "" {
+		var err error
+		gracePeriod, err = time.ParseDuration(request.GracePeriod)
+		if err != nil || gracePeriod < 0 || gracePeriod > maxAPIKeyGracePeriod {
+			respondWithError(w, http.StatusBadRequest, "Grace period must be a duration of at most 168h")
+			return
+		}
+	}
+
+	if gracePeriod > 0 {
+		if err := a.Repo.APIKeys.ExpireAt(r.Context(), apiKey.ID, time.Now().Add(gracePeriod)); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Error rotating API key")
+			return
+		}
+	} else if _, err := a.Repo.APIKeys.Revoke(r.Context(), apiKey.ID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error rotating API key")
+		return
+	}
+
+	// The replacement keeps the expiry of the old key unless that falls within the grace period
+	a.issueAPIKey(w, r, &models.APIKey{
+		UserID:    apiKey.UserID,
+		Name:      apiKey.Name,
+		Scopes:    apiKey.Scopes,
+		ExpiresAt: apiKey.ExpiresAt,
+	})
+}
+
+// @Summary Revoke API key
+// @Description Revoke an API key immediately (Admin only)
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Service account ID"
+// @Param keyID path string true "API key ID"
+// @Success 204 "No Content"
+// @Failure 400,403,404,500 {object} ErrorResponse
+// @Router /admin/service-accounts/{id}/keys/{keyID} [delete]
+func (a *Application) revokeAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	apiKey, ok := a.apiKeyFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	revoked, err := a.Repo.APIKeys.Revoke(r.Context(), apiKey.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error revoking API key")
+		return
+	}
+	if !revoked {
+		respondWithError(w, http.StatusNotFound, "Active API key not found")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// issueAPIKey generates a key, stores it and responds with the key in plain text
+func (a *Application) issueAPIKey(w http.ResponseWriter, r *http.Request, apiKey *models.APIKey) {
+	adminID, err := currentUserID(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid token")
+		return
+	}
+
+	key, prefix, err := utils.GenerateAPIKey()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error creating API key")
+		return
+	}
+
+	apiKey.Prefix = prefix
+	apiKey.CreatedBy = adminID
+	if err := a.Repo.APIKeys.Create(r.Context(), apiKey, key); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error creating API key")
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, schemas.APIKeyCreateResponse{
+		APIKey: *apiKey,
+		Key:    key,
+	})
+}
+
+// serviceAccountFromURL loads the service account identified by the {id} URL parameter
+func (a *Application) serviceAccountFromURL(w http.ResponseWriter, r *http.Request) (*models.User, bool) {
+	user, ok := a.userFromURL(w, r)
+	if !ok {
+		return nil, false
+	}
+
+	if user.UserType != models.Service {
+		respondWithError(w, http.StatusNotFound, "Service account not found")
+		return nil, false
+	}
+
+	return user, true
+}
+
+// apiKeyFromURL loads the API key identified by the {keyID} URL parameter, which must belong to
+// the service account identified by {id}
+func (a *Application) apiKeyFromURL(w http.ResponseWriter, r *http.Request) (*models.APIKey, bool) {
+	account, ok := a.serviceAccountFromURL(w, r)
+	if !ok {
+		return nil, false
+	}
+
+	keyID, err := uuid.Parse(chi.URLParam(r, "keyID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid API key ID")
+		return nil, false
+	}
+
+	apiKey, err := a.Repo.APIKeys.FindByID(r.Context(), keyID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error fetching API key")
+		return nil, false
+	}
+	if apiKey == nil || apiKey.UserID != account.ID {
+		respondWithError(w, http.StatusNotFound, "API key not found")
+		return nil, false
+	}
+
+	return apiKey, true
+}
@@ -0,0 +1,189 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/yhwbach/makerble/internal/models"
+	"github.com/yhwbach/makerble/internal/schemas"
+	"github.com/yhwbach/makerble/internal/utils"
+)
+
+// @Summary List sessions
+// @Description List the active sessions of the logged in user
+// @Tags sessions
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {array} schemas.SessionResponse
+// @Failure 401,500 {object} ErrorResponse
+// @Router /sessions [get]
+func (a *Application) listSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, sessionID, err := currentUserAndSession(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid token")
+		return
+	}
+
+	sessions, err := a.Repo.Sessions.ListActiveByUser(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error fetching sessions")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, toSessionResponses(sessions, sessionID))
+}
+
+// @Summary Revoke session
+// @Description Revoke one of the sessions of the logged in user
+// @Tags sessions
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Session ID"
+// @Success 204 "No Content"
+// @Failure 400,401,404,500 {object} ErrorResponse
+// @Router /sessions/{id} [delete]
+func (a *Application) revokeSessionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid session ID")
+		return
+	}
+
+	userID, _, err := currentUserAndSession(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid token")
+		return
+	}
+
+	session, err := a.Repo.Sessions.FindByID(r.Context(), id)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error fetching session")
+		return
+	}
+	if session == nil || session.UserID != userID {
+		respondWithError(w, http.StatusNotFound, "Session not found")
+		return
+	}
+
+	if err := a.Repo.Sessions.Revoke(r.Context(), id); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error revoking session")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// @Summary Revoke all sessions
+// @Description Revoke every session of the logged in user, optionally keeping the current one
+// @Tags sessions
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param keep_current query bool false "Keep the session making this request"
+// @Success 200 {object} schemas.SessionsRevokedResponse
+// @Failure 401,500 {object} ErrorResponse
+// @Router /sessions [delete]
+func (a *Application) revokeAllSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, sessionID, err := currentUserAndSession(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid token")
+		return
+	}
+
+	var except *uuid.UUID
+	if r.URL.Query().Get("keep_current") == "true" {
+		except = &sessionID
+	}
+
+	revoked, err := a.Repo.Sessions.RevokeAllForUser(r.Context(), userID, except)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error revoking sessions")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, schemas.SessionsRevokedResponse{
+		Message: "Sessions revoked successfully",
+		Revoked: revoked,
+	})
+}
+
+// currentUserAndSession returns the user and session IDs carried by the request token
+func currentUserAndSession(r *http.Request) (uuid.UUID, uuid.UUID, error) {
+	userID, err := currentUserID(r)
+	if err != nil {
+		return uuid.Nil, uuid.Nil, err
+	}
+
+	sessionID, err := utils.GetSessionIDFromContext(r.Context())
+	if err != nil {
+		return uuid.Nil, uuid.Nil, err
+	}
+
+	return userID, sessionID, nil
+}
+
+func toSessionResponses(sessions []models.Session, currentSessionID uuid.UUID) []schemas.SessionResponse {
+	responses := make([]schemas.SessionResponse, 0, len(sessions))
+	for _, session := range sessions {
+		responses = append(responses, schemas.SessionResponse{
+			Session: session,
+			Current: session.ID == currentSessionID,
+		})
+	}
+	return responses
+}
+
+// describeDevice returns a short human readable device label, preferring the name the client supplied
+func describeDevice(deviceName, userAgent string) string {
+	if name := strings.TrimSpace(deviceName); name != "" {
+		if len(name) > 255 {
+			name = name[:255]
+		}
+		return name
+	}
+
+	ua := strings.ToLower(userAgent)
+
+	var platform string
+	switch {
+	case strings.Contains(ua, "iphone"), strings.Contains(ua, "ipad"):
+		platform = "iOS"
+	case strings.Contains(ua, "android"):
+		platform = "Android"
+	case strings.Contains(ua, "windows"):
+		platform = "Windows"
+	case strings.Contains(ua, "mac os"), strings.Contains(ua, "macintosh"):
+		platform = "macOS"
+	case strings.Contains(ua, "linux"):
+		platform = "Linux"
+	}
+
+	var client string
+	switch {
+	case strings.Contains(ua, "edg/"):
+		client = "Edge"
+	case strings.Contains(ua, "firefox/"):
+		client = "Firefox"
+	case strings.Contains(ua, "chrome/"):
+		client = "Chrome"
+	case strings.Contains(ua, "safari/"):
+		client = "Safari"
+	case strings.Contains(ua, "curl/"):
+		client = "curl"
+	}
+
+	switch {
+	case client != "" && platform != "":
+		return client + " on " + platform
+	case client != "":
+		return client
+	case platform != "":
+		return platform
+	default:
+		return "Unknown device"
+	}
+}
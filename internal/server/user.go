@@ -0,0 +1,134 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/yhwbach/makerble/internal/models"
+	"github.com/yhwbach/makerble/internal/schemas"
+	"github.com/yhwbach/makerble/internal/utils"
+)
+
+// @Summary Get current user
+// @Description Get the profile of the logged in user
+// @Tags users
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} models.User
+// @Failure 401,404 {object} ErrorResponse
+// @Router /me [get]
+func (a *Application) getMeHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := currentUserID(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid token")
+		return
+	}
+
+	user, err := a.Repo.Users.FindByID(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "User not found")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, user)
+}
+
+// @Summary Update current user
+// @Description Update the username, email or full name of the logged in user
+// @Tags users
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param profile body schemas.UserProfileUpdate true "Profile update"
+// @Success 200 {object} models.User
+// @Failure 400,401,404,409,500 {object} ErrorResponse
+// @Router /me [patch]
+func (a *Application) updateMeHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := currentUserID(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid token")
+		return
+	}
+
+	var update schemas.UserProfileUpdate
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	user, err := a.Repo.Users.FindByID(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "User not found")
+		return
+	}
+
+	a.updateProfile(w, r, user, update)
+}
+
+// updateProfile applies a profile update to the user after checking the new username and email are free
+func (a *Application) updateProfile(w http.ResponseWriter, r *http.Request, user *models.User, update schemas.UserProfileUpdate) {
+	if update.Username != nil {
+		username := strings.TrimSpace(*update.Username)
+		if username == "" {
+			respondWithError(w, http.StatusBadRequest, "Username must not be empty")
+			return
+		}
+		if username != user.Username {
+			exists, err := a.Repo.Users.UsernameExists(r.Context(), username)
+			if err != nil {
+				respondWithError(w, http.StatusInternalServerError, "Error updating user")
+				return
+			}
+			if exists {
+				respondWithError(w, http.StatusConflict, "Username already exists")
+				return
+			}
+		}
+		update.Username = &username
+	}
+
+	if update.Email != nil {
+		email := strings.TrimSpace(*update.Email)
+		if !strings.Contains(email, "@") {
+			respondWithError(w, http.StatusBadRequest, "Invalid email address")
+			return
+		}
+		if !strings.EqualFold(email, user.Email) {
+			exists, err := a.Repo.Users.EmailExists(r.Context(), email)
+			if err != nil {
+				respondWithError(w, http.StatusInternalServerError, "Error updating user")
+				return
+			}
+			if exists {
+				respondWithError(w, http.StatusConflict, "Email already exists")
+				return
+			}
+		}
+		update.Email = &email
+	}
+
+	updated, err := a.Repo.Users.UpdateByID(r.Context(), user.ID, &schemas.UserUpdate{
+		Username: update.Username,
+		Email:    update.Email,
+		FullName: update.FullName,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error updating user")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, updated)
+}
+
+// currentUserID returns the ID of the user the request token was issued to
+func currentUserID(r *http.Request) (uuid.UUID, error) {
+	userID, err := utils.GetUserIDFromContext(r.Context())
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	return uuid.Parse(userID)
+}
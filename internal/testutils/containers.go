@@ -68,6 +68,20 @@ func runMigrations(db *sql.DB) error {
 		"000001_create_users_table.up.sql",
 		"000002_create_patients_table.up.sql",
 		"000003_create_invalid_tokens_table.up.sql",
+		"000004_create_sessions_table.up.sql",
+		"000005_hash_invalid_tokens.up.sql",
+		"000006_add_user_status.up.sql",
+		"000007_create_invitations_table.up.sql",
+		"000008_add_user_external_identity.up.sql",
+		"000009_create_api_keys_table.up.sql",
+		"000010_create_roles_tables.up.sql",
+		"000011_add_clinical_read_permission.up.sql",
+		"000012_create_care_team_assignments_table.up.sql",
+		"000013_create_emergency_access_tables.up.sql",
+		"000014_create_patient_consents_table.up.sql",
+		"000015_create_clinics_table.up.sql",
+		"000016_add_audit_hash_chain.up.sql",
+		"000017_encrypt_patient_fields.up.sql",
 	}
 
 	for _, migration := range migrations {
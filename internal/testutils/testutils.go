@@ -2,6 +2,7 @@ package testutils
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
@@ -11,19 +12,29 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/require"
+	"github.com/yhwbach/makerble/internal/auth"
 	"github.com/yhwbach/makerble/internal/config"
+	"github.com/yhwbach/makerble/internal/encryption"
+	"github.com/yhwbach/makerble/internal/models"
 	"github.com/yhwbach/makerble/internal/repository"
+	"github.com/yhwbach/makerble/internal/research"
+	"github.com/yhwbach/makerble/internal/schemas"
 	"github.com/yhwbach/makerble/internal/server"
 	"github.com/yhwbach/makerble/internal/utils"
 )
 
+// TestMasterKey is the master key test servers encrypt patient fields with
+const TestMasterKey = "dGVzdC1tYXN0ZXIta2V5LTMyLWJ5dGVzLWxvbmchISE="
+
 type TestServer struct {
 	App        *server.Application
 	TestServer *httptest.Server
 	DB         *TestDatabase
 }
 
-func NewTestServer(t *testing.T) *TestServer {
+// NewTestServer starts the API against a fresh test database. Options may adjust the configuration
+// before the application is created.
+func NewTestServer(t *testing.T, opts ...func(*config.Config)) *TestServer {
 	testDB := SetupTestDB(t)
 
 	cfg := &config.Config{
@@ -31,14 +42,48 @@ func NewTestServer(t *testing.T) *TestServer {
 			Secret: "test_secret",
 			Expiry: time.Hour,
 		},
+		Auth: config.AuthConfig{
+			OpenRegistration: true,
+			InvitationExpiry: time.Hour,
+		},
+		Encryption: config.EncryptionConfig{
+			MasterKey: TestMasterKey,
+		},
+	}
+	for _, opt := range opts {
+		opt(cfg)
 	}
 
-	repo := repository.NewRepoStorage(testDB.DB)
+	keys, err := encryption.Load(cfg.Encryption)
+	require.NoError(t, err)
+
+	repo := repository.NewRepoStorage(testDB.DB, cfg.Database.RowLevelSecurity, keys)
 	jwtManager := utils.NewJWTManager(cfg.JWT.Secret, cfg.JWT.Expiry)
 	app := server.NewApplication(cfg, repo, jwtManager)
 
+	if len(cfg.Auth.Backends) > 0 {
+		authenticator, err := server.NewAuthenticator(cfg, repo, app.PasswordHasher)
+		require.NoError(t, err)
+		app.Authenticator = authenticator
+	}
+
+	if cfg.Research.PseudonymKey != "" {
+		app.Research, err = research.Load(cfg.Research)
+		require.NoError(t, err)
+	}
+
 	testServer := httptest.NewServer(app.Mount())
 
+	if cfg.OIDC.Enabled {
+		if cfg.OIDC.RedirectURL == "" {
+			cfg.OIDC.RedirectURL = testServer.URL + "/api/v1/auth/oidc/callback"
+		}
+
+		provider, err := auth.NewOIDCProvider(cfg.OIDC, nil)
+		require.NoError(t, err)
+		app.OIDC = provider
+	}
+
 	return &TestServer{
 		App:        app,
 		TestServer: testServer,
@@ -51,8 +96,42 @@ func (ts *TestServer) Close() {
 	ts.DB.Close()
 }
 
-func GenerateTestToken(t *testing.T, userID uuid.UUID, userType string, jwtManager *utils.JWTManager) string {
-	token, err := jwtManager.GenerateToken(userID, userType)
+// CreateTestUser inserts a user of the given type into the default clinic and returns its ID
+func CreateTestUser(t *testing.T, ts *TestServer, userType models.UserType) uuid.UUID {
+	return CreateTestUserInClinic(t, ts, userType, models.DefaultClinicID)
+}
+
+// CreateTestUserInClinic inserts a user of the given type into a clinic and returns its ID
+func CreateTestUserInClinic(t *testing.T, ts *TestServer, userType models.UserType, clinicID uuid.UUID) uuid.UUID {
+	suffix := uuid.NewString()[:8]
+	hashedPassword, err := ts.App.PasswordHasher.Hash("Test-Password-1")
+	require.NoError(t, err)
+
+	id, err := ts.App.Repo.Users.Create(context.Background(), &schemas.UserRegister{
+		Username: "user_" + suffix,
+		Email:    suffix + "@example.com",
+		FullName: "Test User",
+		UserType: userType,
+		ClinicID: clinicID,
+	}, hashedPassword)
+	require.NoError(t, err)
+
+	return uuid.MustParse(id)
+}
+
+// GenerateTestToken opens a session for the user and returns a token bound to it
+func GenerateTestToken(t *testing.T, ts *TestServer, userID uuid.UUID, userType string) string {
+	user, err := ts.App.Repo.Users.FindByID(context.Background(), userID)
+	require.NoError(t, err)
+
+	session := &models.Session{
+		UserID:    userID,
+		Device:    "test",
+		ExpiresAt: time.Now().Add(ts.App.JWTManager.Expiry),
+	}
+	require.NoError(t, ts.App.Repo.Sessions.Create(context.Background(), session))
+
+	token, err := ts.App.JWTManager.GenerateToken(userID, userType, user.ClinicID, session.ID)
 	require.NoError(t, err)
 	return token
 }
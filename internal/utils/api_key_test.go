@@ -0,0 +1,27 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAPIKey(t *testing.T) {
+	key, prefix, err := GenerateAPIKey()
+	require.NoError(t, err)
+	assert.Contains(t, key, APIKeyPrefix+prefix+"_")
+
+	parsed, ok := ParseAPIKey(key)
+	assert.True(t, ok)
+	assert.Equal(t, prefix, parsed)
+
+	other, _, err := GenerateAPIKey()
+	require.NoError(t, err)
+	assert.NotEqual(t, key, other)
+
+	for _, invalid := range []string{"", "mk_", "mk_0123abcd", "mk_0123abcd_", "mk_xyz_secret", "eyJhbGciOiJIUzI1NiJ9.e30.sig"} {
+		_, ok := ParseAPIKey(invalid)
+		assert.False(t, ok, invalid)
+	}
+}
@@ -25,11 +25,14 @@ func NewJWTManager(secret string, expiry time.Duration) *JWTManager {
 	}
 }
 
-// GenerateToken generates a new JWT token for a user
-func (m *JWTManager) GenerateToken(userID uuid.UUID, userType string) (string, error) {
+// GenerateToken generates a new JWT token for a user of a clinic bound to a login session
+func (m *JWTManager) GenerateToken(userID uuid.UUID, userType string, clinicID uuid.UUID, sessionID uuid.UUID) (string, error) {
 	claims := map[string]interface{}{
 		"user_id":   userID.String(),
 		"user_type": userType,
+		"clinic_id": clinicID.String(),
+		"sid":       sessionID.String(),
+		"jti":       uuid.NewString(),
 		"exp":       time.Now().Add(m.Expiry).Unix(),
 	}
 
@@ -71,6 +74,50 @@ func GetUserTypeFromContext(ctx context.Context) (string, error) {
 	return userType, nil
 }
 
+// GetClinicIDFromContext extracts the clinic ID from the JWT claims in the context
+func GetClinicIDFromContext(ctx context.Context) (uuid.UUID, error) {
+	_, claims, err := jwtauth.FromContext(ctx)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	clinicID, ok := claims["clinic_id"].(string)
+	if !ok {
+		return uuid.Nil, fmt.Errorf("invalid clinic_id in token")
+	}
+
+	return uuid.Parse(clinicID)
+}
+
+// GetTokenIDFromContext extracts the token ID (jti) from the JWT claims in the context
+func GetTokenIDFromContext(ctx context.Context) (string, error) {
+	token, _, err := jwtauth.FromContext(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	if token == nil || token.JwtID() == "" {
+		return "", fmt.Errorf("invalid jti in token")
+	}
+
+	return token.JwtID(), nil
+}
+
+// GetSessionIDFromContext extracts the session ID from the JWT claims in the context
+func GetSessionIDFromContext(ctx context.Context) (uuid.UUID, error) {
+	_, claims, err := jwtauth.FromContext(ctx)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	sessionID, ok := claims["sid"].(string)
+	if !ok {
+		return uuid.Nil, fmt.Errorf("invalid sid in token")
+	}
+
+	return uuid.Parse(sessionID)
+}
+
 // ExtractBearerToken extracts the token from the Authorization header
 func ExtractBearerToken(authHeader string) (string, error) {
 	if len(authHeader) > 7 && authHeader[:7] == "Bearer " {
@@ -17,8 +17,28 @@ func TestJWTManager(t *testing.T) {
 		userID := uuid.New()
 		userType := "doctor"
 
-		token, err := manager.GenerateToken(userID, userType)
+		token, err := manager.GenerateToken(userID, userType, uuid.New(), uuid.New())
 		assert.NoError(t, err)
 		assert.NotEmpty(t, token)
 	})
+
+	t.Run("SessionClaims", func(t *testing.T) {
+		clinicID := uuid.New()
+		sessionID := uuid.New()
+
+		token, err := manager.GenerateToken(uuid.New(), "doctor", clinicID, sessionID)
+		assert.NoError(t, err)
+
+		decoded, err := manager.Auth.Decode(token)
+		assert.NoError(t, err)
+
+		clinic, _ := decoded.Get("clinic_id")
+		assert.Equal(t, clinicID.String(), clinic)
+
+		sid, _ := decoded.Get("sid")
+		assert.Equal(t, sessionID.String(), sid)
+
+		jti, _ := decoded.Get("jti")
+		assert.NotEmpty(t, jti)
+	})
 }
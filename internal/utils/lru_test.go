@@ -0,0 +1,61 @@
+package utils
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLRUCache(t *testing.T) {
+	now := time.Now()
+	cache := NewLRUCache[string, bool](2)
+	cache.now = func() time.Time { return now }
+
+	t.Run("EvictsLeastRecentlyUsed", func(t *testing.T) {
+		cache.Set("a", true, now.Add(time.Minute))
+		cache.Set("b", true, now.Add(time.Minute))
+
+		_, ok := cache.Get("a")
+		assert.True(t, ok)
+
+		cache.Set("c", true, now.Add(time.Minute))
+
+		_, ok = cache.Get("b")
+		assert.False(t, ok)
+		_, ok = cache.Get("a")
+		assert.True(t, ok)
+		assert.Equal(t, 2, cache.Len())
+	})
+
+	t.Run("ExpiresEntries", func(t *testing.T) {
+		cache.Set("d", false, now.Add(time.Second))
+
+		value, ok := cache.Get("d")
+		assert.True(t, ok)
+		assert.False(t, value)
+
+		now = now.Add(2 * time.Second)
+		_, ok = cache.Get("d")
+		assert.False(t, ok)
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		cache.Set("e", true, now.Add(time.Minute))
+		cache.Delete("e")
+
+		_, ok := cache.Get("e")
+		assert.False(t, ok)
+	})
+
+	t.Run("DeleteFunc", func(t *testing.T) {
+		cache.Set("f", true, now.Add(time.Minute))
+		cache.Set("g", false, now.Add(time.Minute))
+		cache.DeleteFunc(func(_ string, value bool) bool { return value })
+
+		_, ok := cache.Get("f")
+		assert.False(t, ok)
+		_, ok = cache.Get("g")
+		assert.True(t, ok)
+	})
+}
@@ -1,21 +1,245 @@
 package utils
 
 import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"strings"
 
+	"golang.org/x/crypto/argon2"
 	"golang.org/x/crypto/bcrypt"
 )
 
-// HashPassword creates a bcrypt hash of the password
-func HashPassword(password string) (string, error) {
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+// Supported password hashing algorithms
+const (
+	Argon2id = "argon2id"
+	Bcrypt   = "bcrypt"
+)
+
+// ErrPasswordMismatch is returned when a password does not match the stored hash
+var ErrPasswordMismatch = errors.New("password does not match")
+
+// PasswordHasher hashes and verifies passwords
+type PasswordHasher interface {
+	// Hash creates an encoded hash of the password
+	Hash(password string) (string, error)
+	// Verify checks the password against an encoded hash and returns ErrPasswordMismatch on mismatch
+	Verify(password, encodedHash string) error
+	// NeedsRehash reports whether the encoded hash was produced with outdated parameters
+	NeedsRehash(encodedHash string) bool
+}
+
+// Argon2Params holds the tunable argon2id parameters
+type Argon2Params struct {
+	Memory      uint32 // KiB
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// DefaultArgon2Params are the argon2id parameters used when none are configured
+var DefaultArgon2Params = Argon2Params{
+	Memory:      64 * 1024,
+	Iterations:  3,
+	Parallelism: 2,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+// Argon2idHasher hashes passwords with argon2id and stores them in PHC string format
+type Argon2idHasher struct {
+	Params Argon2Params
+}
+
+// Hash creates a PHC formatted argon2id hash of the password
+func (h *Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.Params.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	key := argon2.IDKey([]byte(password), salt, h.Params.Iterations, h.Params.Memory, h.Params.Parallelism, h.Params.KeyLength)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, h.Params.Memory, h.Params.Iterations, h.Params.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// Verify checks the password against a PHC formatted argon2id hash
+func (h *Argon2idHasher) Verify(password, encodedHash string) error {
+	params, salt, key, err := decodeArgon2id(encodedHash)
+	if err != nil {
+		return err
+	}
+
+	candidate := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+	if subtle.ConstantTimeCompare(key, candidate) != 1 {
+		return ErrPasswordMismatch
+	}
+
+	return nil
+}
+
+// NeedsRehash reports whether the hash was created with different argon2id parameters
+func (h *Argon2idHasher) NeedsRehash(encodedHash string) bool {
+	params, salt, _, err := decodeArgon2id(encodedHash)
+	if err != nil {
+		return true
+	}
+
+	return params.Memory != h.Params.Memory ||
+		params.Iterations != h.Params.Iterations ||
+		params.Parallelism != h.Params.Parallelism ||
+		params.KeyLength != h.Params.KeyLength ||
+		uint32(len(salt)) != h.Params.SaltLength
+}
+
+// decodeArgon2id parses a hash of the form $argon2id$v=19$m=65536,t=3,p=2$<salt>$<key>
+func decodeArgon2id(encodedHash string) (Argon2Params, []byte, []byte, error) {
+	var params Argon2Params
+
+	parts := strings.Split(encodedHash, "$")
+	if len(parts) != 6 || parts[1] != Argon2id {
+		return params, nil, nil, fmt.Errorf("invalid argon2id hash")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return params, nil, nil, fmt.Errorf("invalid argon2id hash: %w", err)
+	}
+	if version != argon2.Version {
+		return params, nil, nil, fmt.Errorf("unsupported argon2 version %d", version)
+	}
+
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism); err != nil {
+		return params, nil, nil, fmt.Errorf("invalid argon2id hash: %w", err)
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, fmt.Errorf("invalid argon2id salt: %w", err)
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return params, nil, nil, fmt.Errorf("invalid argon2id key: %w", err)
+	}
+
+	params.SaltLength = uint32(len(salt))
+	params.KeyLength = uint32(len(key))
+
+	return params, salt, key, nil
+}
+
+// BcryptHasher hashes passwords with bcrypt at a configurable cost
+type BcryptHasher struct {
+	Cost int
+}
+
+// Hash creates a bcrypt hash of the password
+func (h *BcryptHasher) Hash(password string) (string, error) {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), h.Cost)
 	if err != nil {
 		return "", fmt.Errorf("failed to hash password: %w", err)
 	}
 	return string(hashedPassword), nil
 }
 
-// CheckPassword checks if the provided password matches the stored hash
-func CheckPassword(password, hashedPassword string) error {
-	return bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
-}
\ No newline at end of file
+// Verify checks the password against a bcrypt hash
+func (h *BcryptHasher) Verify(password, encodedHash string) error {
+	err := bcrypt.CompareHashAndPassword([]byte(encodedHash), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return ErrPasswordMismatch
+	}
+	return err
+}
+
+// NeedsRehash reports whether the hash was created with a different bcrypt cost
+func (h *BcryptHasher) NeedsRehash(encodedHash string) bool {
+	cost, err := bcrypt.Cost([]byte(encodedHash))
+	return err != nil || cost != h.Cost
+}
+
+// multiHasher hashes with the preferred algorithm and verifies hashes of any supported algorithm
+type multiHasher struct {
+	algorithm string
+	argon2id  *Argon2idHasher
+	bcrypt    *BcryptHasher
+}
+
+// NewPasswordHasher creates a hasher producing hashes with the given algorithm. Hashes
+// produced by the other supported algorithms still verify and are reported as needing a rehash.
+func NewPasswordHasher(algorithm string, argon2Params Argon2Params, bcryptCost int) PasswordHasher {
+	if argon2Params.Memory == 0 {
+		argon2Params.Memory = DefaultArgon2Params.Memory
+	}
+	if argon2Params.Iterations == 0 {
+		argon2Params.Iterations = DefaultArgon2Params.Iterations
+	}
+	if argon2Params.Parallelism == 0 {
+		argon2Params.Parallelism = DefaultArgon2Params.Parallelism
+	}
+	if argon2Params.SaltLength == 0 {
+		argon2Params.SaltLength = DefaultArgon2Params.SaltLength
+	}
+	if argon2Params.KeyLength == 0 {
+		argon2Params.KeyLength = DefaultArgon2Params.KeyLength
+	}
+	if bcryptCost == 0 {
+		bcryptCost = bcrypt.DefaultCost
+	}
+	if algorithm != Bcrypt {
+		algorithm = Argon2id
+	}
+
+	return &multiHasher{
+		algorithm: algorithm,
+		argon2id:  &Argon2idHasher{Params: argon2Params},
+		bcrypt:    &BcryptHasher{Cost: bcryptCost},
+	}
+}
+
+func (h *multiHasher) Hash(password string) (string, error) {
+	if h.algorithm == Bcrypt {
+		return h.bcrypt.Hash(password)
+	}
+	return h.argon2id.Hash(password)
+}
+
+func (h *multiHasher) Verify(password, encodedHash string) error {
+	switch hashAlgorithm(encodedHash) {
+	case Argon2id:
+		return h.argon2id.Verify(password, encodedHash)
+	case Bcrypt:
+		return h.bcrypt.Verify(password, encodedHash)
+	default:
+		return fmt.Errorf("unsupported password hash format")
+	}
+}
+
+func (h *multiHasher) NeedsRehash(encodedHash string) bool {
+	if hashAlgorithm(encodedHash) != h.algorithm {
+		return true
+	}
+	if h.algorithm == Bcrypt {
+		return h.bcrypt.NeedsRehash(encodedHash)
+	}
+	return h.argon2id.NeedsRehash(encodedHash)
+}
+
+// hashAlgorithm identifies the algorithm of an encoded hash from its prefix
+func hashAlgorithm(encodedHash string) string {
+	switch {
+	case strings.HasPrefix(encodedHash, "$argon2id$"):
+		return Argon2id
+	case strings.HasPrefix(encodedHash, "$2a$"), strings.HasPrefix(encodedHash, "$2b$"), strings.HasPrefix(encodedHash, "$2y$"):
+		return Bcrypt
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,138 @@
+package utils
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+// PasswordPolicy describes the rules a password must satisfy before it is accepted
+type PasswordPolicy struct {
+	MinLength     int
+	MaxBytes      int
+	RequireUpper  bool
+	RequireLower  bool
+	RequireDigit  bool
+	RequireSymbol bool
+	Breached      *BreachedPasswords
+}
+
+// Validate checks the password against the policy and returns every rule it violates.
+// The identifiers (username, email) must not appear in the password.
+func (p *PasswordPolicy) Validate(password string, identifiers ...string) ([]string, error) {
+	var violations []string
+
+	if strings.TrimSpace(password) == "" {
+		return []string{"password must not be empty"}, nil
+	}
+
+	if p.MinLength > 0 && len([]rune(password)) < p.MinLength {
+		violations = append(violations, fmt.Sprintf("password must be at least %d characters long", p.MinLength))
+	}
+
+	if p.MaxBytes > 0 && len(password) > p.MaxBytes {
+		violations = append(violations, fmt.Sprintf("password must be at most %d bytes long", p.MaxBytes))
+	}
+
+	var hasUpper, hasLower, hasDigit, hasSymbol bool
+	for _, c := range password {
+		switch {
+		case unicode.IsUpper(c):
+			hasUpper = true
+		case unicode.IsLower(c):
+			hasLower = true
+		case unicode.IsDigit(c):
+			hasDigit = true
+		case unicode.IsPunct(c) || unicode.IsSymbol(c) || unicode.IsSpace(c):
+			hasSymbol = true
+		}
+	}
+
+	if p.RequireUpper && !hasUpper {
+		violations = append(violations, "password must contain an uppercase letter")
+	}
+	if p.RequireLower && !hasLower {
+		violations = append(violations, "password must contain a lowercase letter")
+	}
+	if p.RequireDigit && !hasDigit {
+		violations = append(violations, "password must contain a digit")
+	}
+	if p.RequireSymbol && !hasSymbol {
+		violations = append(violations, "password must contain a symbol")
+	}
+
+	lowered := strings.ToLower(password)
+	for _, identifier := range identifiers {
+		// Only compare the local part of email addresses
+		identifier, _, _ = strings.Cut(strings.ToLower(identifier), "@")
+		if len(identifier) >= 3 && strings.Contains(lowered, identifier) {
+			violations = append(violations, "password must not contain your username or email")
+			break
+		}
+	}
+
+	if p.Breached != nil {
+		breached, err := p.Breached.Contains(password)
+		if err != nil {
+			return nil, err
+		}
+		if breached {
+			violations = append(violations, "password has appeared in a data breach, choose a different one")
+		}
+	}
+
+	return violations, nil
+}
+
+// BreachedPasswords checks passwords against a local breached-password corpus laid out
+// like the k-anonymity range API: one file per 5 character SHA-1 prefix, each line
+// holding the remaining 35 character hash suffix optionally followed by ":count".
+type BreachedPasswords struct {
+	Dir string
+}
+
+// NewBreachedPasswords creates a breached password checker reading range files from dir
+func NewBreachedPasswords(dir string) *BreachedPasswords {
+	return &BreachedPasswords{Dir: dir}
+}
+
+// Contains reports whether the password hash is present in the breached corpus
+func (b *BreachedPasswords) Contains(password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:5], hash[5:]
+
+	file, err := os.Open(filepath.Join(b.Dir, prefix))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			// A missing range file means no breached hash shares the prefix, but a
+			// missing directory is a misconfiguration that must not pass silently
+			if _, statErr := os.Stat(b.Dir); statErr != nil {
+				return false, fmt.Errorf("failed to open breached password directory: %w", statErr)
+			}
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to read breached password range: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		candidate, _, _ := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if strings.EqualFold(candidate, suffix) {
+			return true, nil
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return false, fmt.Errorf("failed to read breached password range: %w", err)
+	}
+
+	return false, nil
+}
@@ -0,0 +1,77 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPasswordPolicy(t *testing.T) {
+	policy := &PasswordPolicy{
+		MinLength:    12,
+		MaxBytes:     72,
+		RequireUpper: true,
+		RequireLower: true,
+		RequireDigit: true,
+	}
+
+	t.Run("ValidPassword", func(t *testing.T) {
+		violations, err := policy.Validate("Correct-Horse-42", "jdoe", "jdoe@example.com")
+		require.NoError(t, err)
+		assert.Empty(t, violations)
+	})
+
+	t.Run("EmptyPassword", func(t *testing.T) {
+		violations, err := policy.Validate("   ")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"password must not be empty"}, violations)
+	})
+
+	t.Run("TooShortAndMissingClasses", func(t *testing.T) {
+		violations, err := policy.Validate("short")
+		require.NoError(t, err)
+		assert.Len(t, violations, 3)
+	})
+
+	t.Run("TooLong", func(t *testing.T) {
+		long := "Aa1" + string(make([]byte, 80))
+		violations, err := policy.Validate(long)
+		require.NoError(t, err)
+		assert.Contains(t, violations, "password must be at most 72 bytes long")
+	})
+
+	t.Run("ContainsIdentifier", func(t *testing.T) {
+		violations, err := policy.Validate("MyNameIsJdoe2024", "someone", "jdoe@example.com")
+		require.NoError(t, err)
+		assert.Contains(t, violations, "password must not contain your username or email")
+	})
+}
+
+func TestBreachedPasswords(t *testing.T) {
+	dir := t.TempDir()
+
+	// SHA-1 of "Password1234" is 5B96672AE7709EAB297550CAE362D5BEE468C57D
+	err := os.WriteFile(filepath.Join(dir, "5B966"), []byte("72AE7709EAB297550CAE362D5BEE468C57D:42\r\nFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFF:1\n"), 0o600)
+	require.NoError(t, err)
+
+	breached := NewBreachedPasswords(dir)
+
+	found, err := breached.Contains("Password1234")
+	require.NoError(t, err)
+	assert.True(t, found)
+
+	found, err = breached.Contains("Unlisted-Passw0rd")
+	require.NoError(t, err)
+	assert.False(t, found)
+
+	_, err = NewBreachedPasswords(filepath.Join(dir, "missing")).Contains("Password1234")
+	assert.Error(t, err)
+
+	policy := &PasswordPolicy{Breached: breached}
+	violations, err := policy.Validate("Password1234")
+	require.NoError(t, err)
+	assert.Len(t, violations, 1)
+}
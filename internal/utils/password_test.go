@@ -0,0 +1,48 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPasswordHasher(t *testing.T) {
+	params := Argon2Params{Memory: 8 * 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+	hasher := NewPasswordHasher(Argon2id, params, 4)
+
+	t.Run("Argon2idRoundTrip", func(t *testing.T) {
+		hash, err := hasher.Hash("Correct-Horse-42")
+		require.NoError(t, err)
+		assert.Contains(t, hash, "$argon2id$v=19$m=8192,t=1,p=1$")
+
+		assert.NoError(t, hasher.Verify("Correct-Horse-42", hash))
+		assert.ErrorIs(t, hasher.Verify("wrong", hash), ErrPasswordMismatch)
+		assert.False(t, hasher.NeedsRehash(hash))
+	})
+
+	t.Run("OutdatedArgon2idParams", func(t *testing.T) {
+		weaker := NewPasswordHasher(Argon2id, Argon2Params{Memory: 4 * 1024, Iterations: 1, Parallelism: 1}, 4)
+		hash, err := weaker.Hash("Correct-Horse-42")
+		require.NoError(t, err)
+
+		assert.NoError(t, hasher.Verify("Correct-Horse-42", hash))
+		assert.True(t, hasher.NeedsRehash(hash))
+	})
+
+	t.Run("LegacyBcryptHash", func(t *testing.T) {
+		legacy := NewPasswordHasher(Bcrypt, params, 4)
+		hash, err := legacy.Hash("Correct-Horse-42")
+		require.NoError(t, err)
+		assert.False(t, legacy.NeedsRehash(hash))
+
+		assert.NoError(t, hasher.Verify("Correct-Horse-42", hash))
+		assert.ErrorIs(t, hasher.Verify("wrong", hash), ErrPasswordMismatch)
+		assert.True(t, hasher.NeedsRehash(hash))
+	})
+
+	t.Run("UnknownFormat", func(t *testing.T) {
+		assert.Error(t, hasher.Verify("Correct-Horse-42", "plaintext"))
+		assert.True(t, hasher.NeedsRehash("plaintext"))
+	})
+}
@@ -0,0 +1,7 @@
+DELETE FROM invalid_tokens;
+
+DROP INDEX IF EXISTS idx_invalid_tokens_jti_hash;
+ALTER TABLE invalid_tokens DROP COLUMN IF EXISTS jti_hash;
+ALTER TABLE invalid_tokens ADD COLUMN token TEXT NOT NULL;
+
+CREATE INDEX IF NOT EXISTS idx_invalid_tokens_token ON invalid_tokens(token);
@@ -0,0 +1,9 @@
+-- Revocations used to store raw bearer tokens. Tokens issued before sessions were
+-- introduced are already rejected for lacking a session, so the rows can be dropped.
+DELETE FROM invalid_tokens;
+
+DROP INDEX IF EXISTS idx_invalid_tokens_token;
+ALTER TABLE invalid_tokens DROP COLUMN IF EXISTS token;
+ALTER TABLE invalid_tokens ADD COLUMN jti_hash CHAR(64) NOT NULL;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_invalid_tokens_jti_hash ON invalid_tokens(jti_hash);
@@ -0,0 +1,33 @@
+CREATE TABLE IF NOT EXISTS roles (
+    name VARCHAR(50) PRIMARY KEY,
+    description TEXT NOT NULL DEFAULT '',
+    built_in BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS role_permissions (
+    role VARCHAR(50) NOT NULL REFERENCES roles(name) ON DELETE CASCADE,
+    permission VARCHAR(100) NOT NULL,
+    PRIMARY KEY (role, permission)
+);
+
+INSERT INTO roles (name, description, built_in) VALUES
+    ('doctor', 'Registers patients and maintains their medical history', TRUE),
+    ('receptionist', 'Maintains patient contact details', TRUE),
+    ('admin', 'Manages users, roles and service accounts', TRUE)
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO role_permissions (role, permission) VALUES
+    ('doctor', 'patient.read'),
+    ('doctor', 'patient.create'),
+    ('doctor', 'patient.update.clinical'),
+    ('receptionist', 'patient.read'),
+    ('receptionist', 'patient.update.demographics'),
+    ('receptionist', 'patient.delete'),
+    ('admin', 'patient.read'),
+    ('admin', 'user.manage'),
+    ('admin', 'invitation.manage'),
+    ('admin', 'service_account.manage'),
+    ('admin', 'role.manage')
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,3 @@
+INSERT INTO role_permissions (role, permission) VALUES
+    ('doctor', 'patient.read.clinical')
+ON CONFLICT DO NOTHING;
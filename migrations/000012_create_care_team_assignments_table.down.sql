@@ -0,0 +1,2 @@
+DELETE FROM role_permissions WHERE permission IN ('patient.read.all', 'care_team.manage');
+DROP TABLE IF EXISTS care_team_assignments;
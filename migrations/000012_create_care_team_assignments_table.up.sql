@@ -0,0 +1,26 @@
+CREATE TABLE IF NOT EXISTS care_team_assignments (
+    id UUID DEFAULT uuid_generate_v4() PRIMARY KEY,
+    patient_id UUID NOT NULL REFERENCES patients(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    role VARCHAR(50) NOT NULL,
+    valid_from TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    valid_until TIMESTAMP WITH TIME ZONE,
+    assigned_by UUID NOT NULL REFERENCES users(id),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_care_team_assignments_user_id ON care_team_assignments(user_id, patient_id);
+CREATE INDEX IF NOT EXISTS idx_care_team_assignments_patient_id ON care_team_assignments(patient_id);
+
+INSERT INTO role_permissions (role, permission) VALUES
+    ('receptionist', 'patient.read.all'),
+    ('receptionist', 'care_team.manage'),
+    ('admin', 'patient.read.all')
+ON CONFLICT DO NOTHING;
+
+-- Doctors keep access to the patients they registered
+INSERT INTO care_team_assignments (patient_id, user_id, role, assigned_by)
+SELECT p.id, p.registered_by, 'attending', p.registered_by
+FROM patients p
+JOIN users u ON u.id = p.registered_by
+WHERE u.user_type = 'doctor';
@@ -0,0 +1,3 @@
+DELETE FROM role_permissions WHERE permission IN ('patient.emergency_access', 'emergency_access.review');
+DROP TABLE IF EXISTS emergency_access_grants;
+DROP TABLE IF EXISTS audit_events;
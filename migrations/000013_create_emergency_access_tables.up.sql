@@ -0,0 +1,29 @@
+CREATE TABLE IF NOT EXISTS audit_events (
+    id BIGSERIAL PRIMARY KEY,
+    occurred_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    severity VARCHAR(20) NOT NULL,
+    action VARCHAR(100) NOT NULL,
+    actor_id UUID REFERENCES users(id),
+    patient_id UUID,
+    details JSONB
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_events_patient_id ON audit_events(patient_id);
+CREATE INDEX IF NOT EXISTS idx_audit_events_occurred_at ON audit_events(occurred_at);
+
+CREATE TABLE IF NOT EXISTS emergency_access_grants (
+    id UUID DEFAULT uuid_generate_v4() PRIMARY KEY,
+    patient_id UUID NOT NULL REFERENCES patients(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    justification TEXT NOT NULL,
+    granted_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_emergency_access_grants_user_id ON emergency_access_grants(user_id, patient_id);
+CREATE INDEX IF NOT EXISTS idx_emergency_access_grants_granted_at ON emergency_access_grants(granted_at);
+
+INSERT INTO role_permissions (role, permission) VALUES
+    ('doctor', 'patient.emergency_access'),
+    ('admin', 'emergency_access.review')
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,2 @@
+DELETE FROM role_permissions WHERE permission = 'consent.manage';
+DROP TABLE IF EXISTS patient_consents;
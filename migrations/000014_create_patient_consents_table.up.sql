@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS patient_consents (
+    id UUID DEFAULT uuid_generate_v4() PRIMARY KEY,
+    patient_id UUID NOT NULL REFERENCES patients(id) ON DELETE CASCADE,
+    consent_type VARCHAR(50) NOT NULL,
+    scope TEXT NOT NULL DEFAULT '',
+    granted_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    revoked_at TIMESTAMP WITH TIME ZONE,
+    document_ref TEXT NOT NULL DEFAULT '',
+    recorded_by UUID NOT NULL REFERENCES users(id),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_patient_consents_patient_id ON patient_consents(patient_id, consent_type);
+
+INSERT INTO role_permissions (role, permission) VALUES
+    ('doctor', 'consent.manage'),
+    ('receptionist', 'consent.manage')
+ON CONFLICT DO NOTHING;
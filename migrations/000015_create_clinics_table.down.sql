@@ -0,0 +1,14 @@
+DELETE FROM role_permissions WHERE permission IN ('patient.transfer', 'clinic.manage');
+
+DROP POLICY IF EXISTS clinic_isolation ON users;
+DROP POLICY IF EXISTS clinic_isolation ON patients;
+ALTER TABLE users NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE users DISABLE ROW LEVEL SECURITY;
+ALTER TABLE patients NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE patients DISABLE ROW LEVEL SECURITY;
+
+DROP TABLE IF EXISTS patient_transfers;
+ALTER TABLE invitations DROP COLUMN IF EXISTS clinic_id;
+ALTER TABLE patients DROP COLUMN IF EXISTS clinic_id;
+ALTER TABLE users DROP COLUMN IF EXISTS clinic_id;
+DROP TABLE IF EXISTS clinics;
@@ -0,0 +1,57 @@
+CREATE TABLE IF NOT EXISTS clinics (
+    id UUID DEFAULT uuid_generate_v4() PRIMARY KEY,
+    name VARCHAR(255) NOT NULL UNIQUE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Everything that existed before clinics belongs to the default clinic
+INSERT INTO clinics (id, name) VALUES ('00000000-0000-0000-0000-000000000001', 'Main clinic')
+ON CONFLICT DO NOTHING;
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS clinic_id UUID NOT NULL
+    DEFAULT '00000000-0000-0000-0000-000000000001' REFERENCES clinics(id);
+ALTER TABLE users ALTER COLUMN clinic_id DROP DEFAULT;
+CREATE INDEX IF NOT EXISTS idx_users_clinic_id ON users(clinic_id);
+
+ALTER TABLE patients ADD COLUMN IF NOT EXISTS clinic_id UUID NOT NULL
+    DEFAULT '00000000-0000-0000-0000-000000000001' REFERENCES clinics(id);
+ALTER TABLE patients ALTER COLUMN clinic_id DROP DEFAULT;
+CREATE INDEX IF NOT EXISTS idx_patients_clinic_id ON patients(clinic_id);
+
+-- Invited users join the clinic of the invitation
+ALTER TABLE invitations ADD COLUMN IF NOT EXISTS clinic_id UUID NOT NULL
+    DEFAULT '00000000-0000-0000-0000-000000000001' REFERENCES clinics(id);
+ALTER TABLE invitations ALTER COLUMN clinic_id DROP DEFAULT;
+
+CREATE TABLE IF NOT EXISTS patient_transfers (
+    id UUID DEFAULT uuid_generate_v4() PRIMARY KEY,
+    patient_id UUID NOT NULL REFERENCES patients(id) ON DELETE CASCADE,
+    from_clinic_id UUID NOT NULL REFERENCES clinics(id),
+    to_clinic_id UUID NOT NULL REFERENCES clinics(id),
+    reason TEXT NOT NULL,
+    transferred_by UUID NOT NULL REFERENCES users(id),
+    transferred_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_patient_transfers_patient_id ON patient_transfers(patient_id);
+
+-- Row-level security applies once app.clinic_id is set, which the API does per transaction when
+-- DB_ROW_LEVEL_SECURITY is enabled. Without it, e.g. for migrations and admin commands, every row
+-- stays visible. FORCE makes the policies apply to the table owner the API usually connects as.
+ALTER TABLE patients ENABLE ROW LEVEL SECURITY;
+ALTER TABLE patients FORCE ROW LEVEL SECURITY;
+ALTER TABLE users ENABLE ROW LEVEL SECURITY;
+ALTER TABLE users FORCE ROW LEVEL SECURITY;
+
+-- Transfers move patients out of the clinic after checking the patient belongs to it, with
+-- app.clinic_id cleared for the move
+CREATE POLICY clinic_isolation ON patients
+    USING (COALESCE(current_setting('app.clinic_id', true), '') = '' OR clinic_id = current_setting('app.clinic_id', true)::uuid);
+
+CREATE POLICY clinic_isolation ON users
+    USING (COALESCE(current_setting('app.clinic_id', true), '') = '' OR clinic_id = current_setting('app.clinic_id', true)::uuid);
+
+INSERT INTO role_permissions (role, permission) VALUES
+    ('admin', 'patient.transfer'),
+    ('admin', 'clinic.manage')
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,19 @@
+DELETE FROM role_permissions WHERE permission = 'audit.read';
+
+DROP TRIGGER IF EXISTS audit_events_no_truncate ON audit_events;
+DROP TRIGGER IF EXISTS audit_events_no_update ON audit_events;
+DROP TRIGGER IF EXISTS audit_events_chain ON audit_events;
+DROP FUNCTION IF EXISTS audit_events_append_only();
+DROP FUNCTION IF EXISTS audit_events_chain();
+DROP FUNCTION IF EXISTS audit_event_hash(audit_events);
+
+DROP INDEX IF EXISTS idx_audit_events_details_patient_ids;
+DROP INDEX IF EXISTS idx_audit_events_actor_id;
+
+ALTER TABLE audit_events
+    DROP COLUMN IF EXISTS hash,
+    DROP COLUMN IF EXISTS prev_hash,
+    DROP COLUMN IF EXISTS fields,
+    DROP COLUMN IF EXISTS ip_address,
+    DROP COLUMN IF EXISTS request_id,
+    DROP COLUMN IF EXISTS clinic_id;
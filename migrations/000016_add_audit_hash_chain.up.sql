@@ -0,0 +1,73 @@
+ALTER TABLE audit_events
+    ADD COLUMN IF NOT EXISTS clinic_id UUID,
+    ADD COLUMN IF NOT EXISTS request_id VARCHAR(255) NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS ip_address VARCHAR(255) NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS fields TEXT[] NOT NULL DEFAULT '{}',
+    ADD COLUMN IF NOT EXISTS prev_hash VARCHAR(64) NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS hash VARCHAR(64) NOT NULL DEFAULT '';
+
+CREATE INDEX IF NOT EXISTS idx_audit_events_actor_id ON audit_events(actor_id);
+CREATE INDEX IF NOT EXISTS idx_audit_events_details_patient_ids ON audit_events USING GIN ((details -> 'patient_ids'));
+
+-- The hash covers every column and the hash of the previous event. Changing, removing or
+-- reordering events breaks the chain. The audit verify command recomputes it independently.
+CREATE OR REPLACE FUNCTION audit_event_hash(e audit_events) RETURNS TEXT AS $$
+    SELECT encode(sha256(convert_to(
+        e.prev_hash || E'\n' ||
+        e.id::text || E'\n' ||
+        to_char(e.occurred_at AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS.US"Z"') || E'\n' ||
+        e.severity || E'\n' ||
+        e.action || E'\n' ||
+        COALESCE(e.actor_id::text, '') || E'\n' ||
+        COALESCE(e.patient_id::text, '') || E'\n' ||
+        COALESCE(e.clinic_id::text, '') || E'\n' ||
+        e.request_id || E'\n' ||
+        e.ip_address || E'\n' ||
+        array_to_string(e.fields, ',') || E'\n' ||
+        COALESCE(e.details::text, ''),
+    'UTF8')), 'hex')
+$$ LANGUAGE sql STABLE;
+
+CREATE OR REPLACE FUNCTION audit_events_chain() RETURNS trigger AS $$
+BEGIN
+    -- Events are chained one at a time, and numbered while holding the lock so that ids follow
+    -- the chain even when transactions commit out of order
+    PERFORM pg_advisory_xact_lock(hashtext('audit_events'));
+    NEW.id := nextval(pg_get_serial_sequence('audit_events', 'id'));
+    NEW.occurred_at := clock_timestamp();
+    NEW.prev_hash := COALESCE((SELECT hash FROM audit_events ORDER BY id DESC LIMIT 1), '');
+    NEW.hash := audit_event_hash(NEW);
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+-- Chain the events recorded before the chain existed, oldest first
+DO $$
+DECLARE
+    event audit_events;
+    previous TEXT := '';
+BEGIN
+    FOR event IN SELECT * FROM audit_events ORDER BY id LOOP
+        event.prev_hash := previous;
+        previous := audit_event_hash(event);
+        UPDATE audit_events SET prev_hash = event.prev_hash, hash = previous WHERE id = event.id;
+    END LOOP;
+END;
+$$;
+
+CREATE TRIGGER audit_events_chain BEFORE INSERT ON audit_events
+    FOR EACH ROW EXECUTE FUNCTION audit_events_chain();
+CREATE TRIGGER audit_events_no_update BEFORE UPDATE OR DELETE ON audit_events
+    FOR EACH ROW EXECUTE FUNCTION audit_events_append_only();
+CREATE TRIGGER audit_events_no_truncate BEFORE TRUNCATE ON audit_events
+    FOR EACH STATEMENT EXECUTE FUNCTION audit_events_append_only();
+
+INSERT INTO role_permissions (role, permission) VALUES
+    ('admin', 'audit.read')
+ON CONFLICT DO NOTHING;
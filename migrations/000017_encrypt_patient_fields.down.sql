@@ -0,0 +1,21 @@
+-- The database cannot decrypt the encrypted fields, dropping them would lose them
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM patients WHERE data_key IS NOT NULL) THEN
+        RAISE EXCEPTION 'patients have encrypted fields that would be lost';
+    END IF;
+END
+$$;
+
+ALTER TABLE patients DROP CONSTRAINT IF EXISTS patients_no_plaintext_when_encrypted;
+DROP INDEX IF EXISTS idx_patients_data_key_id;
+DROP INDEX IF EXISTS idx_patients_email_index;
+
+ALTER TABLE patients
+    DROP COLUMN IF EXISTS email_index,
+    DROP COLUMN IF EXISTS encrypted_medical_history,
+    DROP COLUMN IF EXISTS encrypted_email,
+    DROP COLUMN IF EXISTS encrypted_phone,
+    DROP COLUMN IF EXISTS encrypted_address,
+    DROP COLUMN IF EXISTS data_key,
+    DROP COLUMN IF EXISTS data_key_id;
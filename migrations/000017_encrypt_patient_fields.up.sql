@@ -0,0 +1,19 @@
+-- Sensitive fields are encrypted by the application with a data key per patient, stored wrapped
+-- by a master key that never reaches the database. Existing patients keep their plaintext columns
+-- until the encrypt-patients admin command encrypts them.
+ALTER TABLE patients
+    ADD COLUMN IF NOT EXISTS data_key_id VARCHAR(16),
+    ADD COLUMN IF NOT EXISTS data_key BYTEA,
+    ADD COLUMN IF NOT EXISTS encrypted_address BYTEA,
+    ADD COLUMN IF NOT EXISTS encrypted_phone BYTEA,
+    ADD COLUMN IF NOT EXISTS encrypted_email BYTEA,
+    ADD COLUMN IF NOT EXISTS encrypted_medical_history BYTEA,
+    ADD COLUMN IF NOT EXISTS email_index BYTEA;
+
+-- The blind index takes over the uniqueness of emails
+CREATE UNIQUE INDEX IF NOT EXISTS idx_patients_email_index ON patients(email_index);
+CREATE INDEX IF NOT EXISTS idx_patients_data_key_id ON patients(data_key_id);
+
+ALTER TABLE patients ADD CONSTRAINT patients_no_plaintext_when_encrypted CHECK (
+    data_key IS NULL OR (address IS NULL AND phone IS NULL AND email IS NULL AND medical_history IS NULL)
+);
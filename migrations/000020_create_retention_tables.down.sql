@@ -0,0 +1,12 @@
+DROP TABLE IF EXISTS retention_runs;
+
+CREATE OR REPLACE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TABLE IF EXISTS audit_checkpoints;
+
+DROP INDEX IF EXISTS idx_patients_updated_at;
+ALTER TABLE patients DROP COLUMN IF EXISTS archived_at;
@@ -0,0 +1,32 @@
+ALTER TABLE patients ADD COLUMN IF NOT EXISTS archived_at TIMESTAMP WITH TIME ZONE;
+CREATE INDEX IF NOT EXISTS idx_patients_updated_at ON patients(updated_at) WHERE archived_at IS NULL;
+
+-- Purging audit events removes the oldest part of the chain. The checkpoint keeps the hash of the
+-- last event purged, which the oldest remaining event chains from.
+CREATE TABLE IF NOT EXISTS audit_checkpoints (
+    id BIGSERIAL PRIMARY KEY,
+    purged_through BIGINT NOT NULL,
+    head_hash VARCHAR(64) NOT NULL,
+    events_purged BIGINT NOT NULL,
+    purged_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Deletes are only allowed to the retention purge, which sets app.audit_purge for its transaction
+CREATE OR REPLACE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'DELETE' AND current_setting('app.audit_purge', true) = 'on' THEN
+        RETURN OLD;
+    END IF;
+    RAISE EXCEPTION 'audit_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TABLE IF NOT EXISTS retention_runs (
+    id UUID DEFAULT uuid_generate_v4() PRIMARY KEY,
+    started_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    finished_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    dry_run BOOLEAN NOT NULL,
+    results JSONB NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_retention_runs_started_at ON retention_runs(started_at);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS hl7_messages;
+DROP TABLE IF EXISTS patient_identifiers;
@@ -0,0 +1,34 @@
+-- Identifiers other systems know patients by, e.g. the medical record number of a hospital,
+-- unique within a clinic per assigning authority
+CREATE TABLE IF NOT EXISTS patient_identifiers (
+    id UUID DEFAULT uuid_generate_v4() PRIMARY KEY,
+    patient_id UUID NOT NULL REFERENCES patients(id) ON DELETE CASCADE,
+    clinic_id UUID NOT NULL REFERENCES clinics(id),
+    system VARCHAR(255) NOT NULL,
+    value VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (clinic_id, system, value)
+);
+
+CREATE INDEX IF NOT EXISTS idx_patient_identifiers_patient_id ON patient_identifiers(patient_id);
+
+-- Raw HL7 v2 messages as received, kept for replay. They hold patient data, so they are encrypted
+-- by the application with a data key per message, like the sensitive patient fields.
+CREATE TABLE IF NOT EXISTS hl7_messages (
+    id UUID DEFAULT uuid_generate_v4() PRIMARY KEY,
+    clinic_id UUID NOT NULL REFERENCES clinics(id),
+    sending_application VARCHAR(255) NOT NULL DEFAULT '',
+    control_id VARCHAR(255) NOT NULL DEFAULT '',
+    message_type VARCHAR(16) NOT NULL DEFAULT '',
+    data_key_id VARCHAR(16) NOT NULL,
+    data_key BYTEA NOT NULL,
+    encrypted_raw BYTEA NOT NULL,
+    status VARCHAR(16) NOT NULL,
+    error TEXT NOT NULL DEFAULT '',
+    patient_id UUID,
+    received_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    processed_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS idx_hl7_messages_control_id ON hl7_messages(sending_application, control_id);
+CREATE INDEX IF NOT EXISTS idx_hl7_messages_status ON hl7_messages(status, received_at);
@@ -0,0 +1,3 @@
+DELETE FROM role_permissions WHERE permission = 'patient.import';
+DROP TABLE IF EXISTS patient_import_errors;
+DROP TABLE IF EXISTS patient_imports;
@@ -0,0 +1,34 @@
+-- Bulk imports of patients from spreadsheets, run in the background
+CREATE TABLE IF NOT EXISTS patient_imports (
+    id UUID DEFAULT uuid_generate_v4() PRIMARY KEY,
+    clinic_id UUID NOT NULL REFERENCES clinics(id),
+    created_by UUID NOT NULL REFERENCES users(id),
+    filename VARCHAR(255) NOT NULL DEFAULT '',
+    format VARCHAR(16) NOT NULL,
+    status VARCHAR(16) NOT NULL,
+    total_rows INTEGER NOT NULL DEFAULT 0,
+    imported_rows INTEGER NOT NULL DEFAULT 0,
+    invalid_rows INTEGER NOT NULL DEFAULT 0,
+    duplicate_rows INTEGER NOT NULL DEFAULT 0,
+    error TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    finished_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS idx_patient_imports_clinic_id ON patient_imports(clinic_id, created_at);
+
+-- The problems found in the rows of an import, reported by row number. Messages never quote the
+-- values of the file, as the table is not encrypted.
+CREATE TABLE IF NOT EXISTS patient_import_errors (
+    id BIGSERIAL PRIMARY KEY,
+    import_id UUID NOT NULL REFERENCES patient_imports(id) ON DELETE CASCADE,
+    row_number INTEGER NOT NULL,
+    field VARCHAR(32) NOT NULL DEFAULT '',
+    message TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_patient_import_errors_import_id ON patient_import_errors(import_id, row_number);
+
+INSERT INTO role_permissions (role, permission) VALUES
+    ('admin', 'patient.import')
+ON CONFLICT DO NOTHING;
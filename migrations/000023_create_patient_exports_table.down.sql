@@ -0,0 +1,2 @@
+DELETE FROM role_permissions WHERE permission = 'patient.bulk_export';
+DROP TABLE IF EXISTS patient_exports;
@@ -0,0 +1,19 @@
+-- FHIR bulk data exports of patients, run in the background. The patients selected are recorded
+-- instead of the exported files, which are read from the patients table when downloaded.
+CREATE TABLE IF NOT EXISTS patient_exports (
+    id UUID DEFAULT uuid_generate_v4() PRIMARY KEY,
+    clinic_id UUID NOT NULL REFERENCES clinics(id),
+    created_by UUID NOT NULL REFERENCES users(id),
+    request TEXT NOT NULL,
+    status VARCHAR(16) NOT NULL,
+    patient_ids UUID[] NOT NULL DEFAULT '{}',
+    error TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    finished_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS idx_patient_exports_created_at ON patient_exports(created_at);
+
+INSERT INTO role_permissions (role, permission) VALUES
+    ('admin', 'patient.bulk_export')
+ON CONFLICT DO NOTHING;
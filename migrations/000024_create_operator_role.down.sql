@@ -0,0 +1,9 @@
+INSERT INTO role_permissions (role, permission) VALUES
+    ('admin', 'patient.transfer'),
+    ('admin', 'role.manage'),
+    ('admin', 'clinic.manage')
+ON CONFLICT DO NOTHING;
+
+UPDATE users SET user_type = 'admin' WHERE user_type = 'operator';
+UPDATE invitations SET user_type = 'admin' WHERE user_type = 'operator';
+DELETE FROM roles WHERE name = 'operator';
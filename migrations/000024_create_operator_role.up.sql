@@ -0,0 +1,17 @@
+-- Clinics and roles are shared by every clinic, so managing them moves from the admins of each clinic
+-- to operators, who run the deployment
+INSERT INTO roles (name, description, built_in) VALUES
+    ('operator', 'Runs the deployment: manages clinics, roles and patient transfers', TRUE)
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO role_permissions (role, permission) VALUES
+    ('operator', 'patient.read'),
+    ('operator', 'patient.read.all'),
+    ('operator', 'patient.transfer'),
+    ('operator', 'invitation.manage'),
+    ('operator', 'role.manage'),
+    ('operator', 'clinic.manage')
+ON CONFLICT DO NOTHING;
+
+DELETE FROM role_permissions
+WHERE role = 'admin' AND permission IN ('patient.transfer', 'role.manage', 'clinic.manage');
@@ -0,0 +1,62 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yhwbach/makerble/internal/models"
+	"github.com/yhwbach/makerble/internal/schemas"
+	"github.com/yhwbach/makerble/internal/testutils"
+)
+
+func TestAdminUserManagement(t *testing.T) {
+	ts := testutils.NewTestServer(t)
+	defer ts.Close()
+
+	adminID := testutils.CreateTestUser(t, ts, models.Admin)
+	adminToken := testutils.GenerateTestToken(t, ts, adminID, string(models.Admin))
+
+	doctorID := testutils.CreateTestUser(t, ts, models.Doctor)
+	doctorToken := testutils.GenerateTestToken(t, ts, doctorID, string(models.Doctor))
+
+	t.Run("non-admin is denied", func(t *testing.T) {
+		resp := testutils.MakeRequest(t, ts, http.MethodGet, "/api/v1/admin/users", nil, doctorToken)
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	})
+
+	t.Run("list users", func(t *testing.T) {
+		resp := testutils.MakeRequest(t, ts, http.MethodGet, "/api/v1/admin/users", nil, adminToken)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var users schemas.UserListResponse
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&users))
+		assert.Equal(t, 2, users.Total)
+	})
+
+	t.Run("deactivate revokes sessions", func(t *testing.T) {
+		resp := testutils.MakeRequest(t, ts, http.MethodPost, "/api/v1/admin/users/"+doctorID.String()+"/deactivate", nil, adminToken)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		resp = testutils.MakeRequest(t, ts, http.MethodGet, "/api/v1/me", nil, doctorToken)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	})
+
+	t.Run("admin cannot deactivate themselves", func(t *testing.T) {
+		resp := testutils.MakeRequest(t, ts, http.MethodPost, "/api/v1/admin/users/"+adminID.String()+"/deactivate", nil, adminToken)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+
+	t.Run("update own profile", func(t *testing.T) {
+		fullName := "Head Administrator"
+		resp := testutils.MakeRequest(t, ts, http.MethodPatch, "/api/v1/me", schemas.UserProfileUpdate{FullName: &fullName}, adminToken)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var user models.User
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&user))
+		assert.Equal(t, fullName, user.FullName)
+		assert.Equal(t, models.Admin, user.UserType)
+	})
+}
@@ -0,0 +1,129 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yhwbach/makerble/internal/models"
+	"github.com/yhwbach/makerble/internal/schemas"
+	"github.com/yhwbach/makerble/internal/testutils"
+)
+
+func TestServiceAccountAPIKeys(t *testing.T) {
+	ts := testutils.NewTestServer(t)
+	defer ts.Close()
+
+	adminID := testutils.CreateTestUser(t, ts, models.Admin)
+	adminToken := testutils.GenerateTestToken(t, ts, adminID, string(models.Admin))
+
+	resp := testutils.MakeRequest(t, ts, http.MethodPost, "/api/v1/admin/service-accounts", schemas.ServiceAccountCreate{
+		Username: "lab-system",
+		FullName: "Lab System",
+	}, adminToken)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	var account models.User
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&account))
+	assert.Equal(t, models.Service, account.UserType)
+
+	createKey := func(scopes ...string) schemas.APIKeyCreateResponse {
+		resp := testutils.MakeRequest(t, ts, http.MethodPost, "/api/v1/admin/service-accounts/"+account.ID.String()+"/keys", schemas.APIKeyCreate{
+			Name:   "results import",
+			Scopes: scopes,
+		}, adminToken)
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+		var created schemas.APIKeyCreateResponse
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&created))
+		return created
+	}
+
+	readKey := createKey(models.ScopePatientsRead)
+	assert.Contains(t, readKey.Key, readKey.APIKey.Prefix)
+
+	t.Run("scoped access", func(t *testing.T) {
+		resp := testutils.MakeRequest(t, ts, http.MethodGet, "/api/v1/patients", nil, readKey.Key)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		resp = testutils.MakeRequest(t, ts, http.MethodPost, "/api/v1/patients", schemas.PatientCreate{
+			FullName: "Jane Doe",
+		}, readKey.Key)
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	})
+
+	t.Run("account endpoints are for users", func(t *testing.T) {
+		resp := testutils.MakeRequest(t, ts, http.MethodGet, "/api/v1/me", nil, readKey.Key)
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+		resp = testutils.MakeRequest(t, ts, http.MethodGet, "/api/v1/admin/users", nil, readKey.Key)
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	})
+
+	t.Run("service accounts cannot log in", func(t *testing.T) {
+		resp := testutils.MakeRequest(t, ts, http.MethodPost, "/api/v1/login", schemas.UserLogin{
+			Username: "lab-system",
+			Password: models.UnusablePassword,
+		}, "")
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	})
+
+	t.Run("unknown key", func(t *testing.T) {
+		resp := testutils.MakeRequest(t, ts, http.MethodGet, "/api/v1/patients", nil, readKey.Key+"x")
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	})
+
+	t.Run("rotation with grace period", func(t *testing.T) {
+		resp := testutils.MakeRequest(t, ts, http.MethodPost,
+			"/api/v1/admin/service-accounts/"+account.ID.String()+"/keys/"+readKey.APIKey.ID.String()+"/rotate",
+			schemas.APIKeyRotate{GracePeriod: "1h"}, adminToken)
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+		var rotated schemas.APIKeyCreateResponse
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&rotated))
+		assert.Equal(t, readKey.APIKey.Scopes, rotated.APIKey.Scopes)
+		assert.NotEqual(t, readKey.Key, rotated.Key)
+
+		// Both keys work until the grace period ends
+		for _, key := range []string{readKey.Key, rotated.Key} {
+			resp := testutils.MakeRequest(t, ts, http.MethodGet, "/api/v1/patients", nil, key)
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+		}
+	})
+
+	t.Run("revocation and last use", func(t *testing.T) {
+		resp := testutils.MakeRequest(t, ts, http.MethodDelete,
+			"/api/v1/admin/service-accounts/"+account.ID.String()+"/keys/"+readKey.APIKey.ID.String(), nil, adminToken)
+		require.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+		resp = testutils.MakeRequest(t, ts, http.MethodGet, "/api/v1/patients", nil, readKey.Key)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+		resp = testutils.MakeRequest(t, ts, http.MethodGet, "/api/v1/admin/service-accounts/"+account.ID.String()+"/keys", nil, adminToken)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var keys []models.APIKey
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&keys))
+		require.Len(t, keys, 2)
+		for _, key := range keys {
+			if key.ID == readKey.APIKey.ID {
+				assert.NotNil(t, key.RevokedAt)
+				assert.NotNil(t, key.LastUsedAt)
+			}
+		}
+	})
+	t.Run("bulk export", func(t *testing.T) {
+		exportKey := createKey(models.ScopePatientsRead, models.ScopePatientsExport)
+		resp := testutils.MakeRequest(t, ts, http.MethodGet, "/api/v1/patients/export?format=csv", nil, exportKey.Key)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		resp = kickOffExport(t, ts, exportKey.Key, "")
+		assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+
+		readOnly := createKey(models.ScopePatientsRead)
+		resp = testutils.MakeRequest(t, ts, http.MethodGet, "/api/v1/patients/export?format=csv", nil, readOnly.Key)
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	})
+}
@@ -0,0 +1,133 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yhwbach/makerble/internal/models"
+	"github.com/yhwbach/makerble/internal/schemas"
+	"github.com/yhwbach/makerble/internal/server"
+	"github.com/yhwbach/makerble/internal/testutils"
+)
+
+func TestAuditLog(t *testing.T) {
+	ts := testutils.NewTestServer(t)
+	defer ts.Close()
+
+	doctorID := testutils.CreateTestUser(t, ts, models.Doctor)
+	doctorToken := testutils.GenerateTestToken(t, ts, doctorID, string(models.Doctor))
+	receptionistID := testutils.CreateTestUser(t, ts, models.Receptionist)
+	receptionistToken := testutils.GenerateTestToken(t, ts, receptionistID, string(models.Receptionist))
+	adminID := testutils.CreateTestUser(t, ts, models.Admin)
+	adminToken := testutils.GenerateTestToken(t, ts, adminID, string(models.Admin))
+
+	resp := testutils.MakeRequest(t, ts, http.MethodPost, "/api/v1/patients", schemas.PatientCreate{
+		FullName:       "Jane Doe",
+		DateOfBirth:    "1990-04-12",
+		Gender:         models.Female,
+		Email:          "jane@example.com",
+		MedicalHistory: "Asthma",
+	}, doctorToken)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	var created schemas.PatientCreateResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&created))
+	patientID := uuid.MustParse(created.PatientID)
+	patientPath := "/api/v1/patients/" + created.PatientID
+
+	resp = testutils.MakeRequest(t, ts, http.MethodGet, patientPath, nil, receptionistToken)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	resp = testutils.MakeRequest(t, ts, http.MethodGet, "/api/v1/patients", nil, doctorToken)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	history := "Asthma, penicillin allergy"
+	resp = testutils.MakeRequest(t, ts, http.MethodPatch, patientPath, schemas.PatientUpdate{
+		MedicalHistory: &history,
+	}, doctorToken)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	auditLog := func(query string) []models.AuditEvent {
+		resp := testutils.MakeRequest(t, ts, http.MethodGet, "/api/v1/audit"+query, nil, adminToken)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var events []models.AuditEvent
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&events))
+		return events
+	}
+
+	t.Run("admins only", func(t *testing.T) {
+		resp := testutils.MakeRequest(t, ts, http.MethodGet, "/api/v1/audit", nil, doctorToken)
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+		resp = testutils.MakeRequest(t, ts, http.MethodGet, "/api/v1/audit?patient_id=jane&limit=5000", nil, adminToken)
+		require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+		var body server.ValidationErrorResponse
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+		assert.Equal(t, []string{"limit", "patient_id"}, body.Errors)
+	})
+
+	t.Run("who looked at the patient", func(t *testing.T) {
+		events := auditLog("?patient_id=" + patientID.String())
+		require.Len(t, events, 4)
+
+		actions := make([]string, len(events))
+		for i, event := range events {
+			actions[i] = event.Action
+			assert.NotEmpty(t, event.RequestID)
+			assert.NotEmpty(t, event.IPAddress)
+		}
+		assert.Equal(t, []string{
+			models.AuditActionPatientUpdate,
+			models.AuditActionPatientList,
+			models.AuditActionPatientRead,
+			models.AuditActionPatientCreate,
+		}, actions)
+
+		update, list, read := events[0], events[1], events[2]
+		assert.Equal(t, doctorID, *update.ActorID)
+		assert.Equal(t, []string{"medical_history"}, update.Fields)
+		assert.Nil(t, list.PatientID)
+		assert.Equal(t, receptionistID, *read.ActorID)
+		assert.Equal(t, patientID, *read.PatientID)
+		assert.NotContains(t, read.Fields, "medical_history", "receptionists do not see clinical fields")
+	})
+
+	t.Run("filters", func(t *testing.T) {
+		events := auditLog("?action=patient.read&actor_id=" + receptionistID.String())
+		assert.Len(t, events, 1)
+
+		events = auditLog("?limit=2")
+		assert.Len(t, events, 2)
+	})
+
+	t.Run("chain", func(t *testing.T) {
+		verification, err := ts.App.Repo.Audit.Verify(context.Background())
+		require.NoError(t, err)
+		assert.True(t, verification.Valid())
+		assert.EqualValues(t, 4, verification.Events)
+	})
+
+	t.Run("tampering is detected", func(t *testing.T) {
+		db := ts.DB.DB
+
+		_, err := db.Exec(`UPDATE audit_events SET ip_address = '192.0.2.1'`)
+		require.Error(t, err, "the log is append-only")
+
+		// Someone with control over the database can get around the triggers, but not the chain
+		_, err = db.Exec(`ALTER TABLE audit_events DISABLE TRIGGER audit_events_no_update`)
+		require.NoError(t, err)
+		_, err = db.Exec(`UPDATE audit_events SET actor_id = $1 WHERE action = 'patient.read'`, doctorID)
+		require.NoError(t, err)
+
+		verification, err := ts.App.Repo.Audit.Verify(context.Background())
+		require.NoError(t, err)
+		assert.False(t, verification.Valid())
+		assert.EqualValues(t, 1, verification.Events)
+	})
+}
@@ -0,0 +1,114 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yhwbach/makerble/internal/models"
+	"github.com/yhwbach/makerble/internal/schemas"
+	"github.com/yhwbach/makerble/internal/testutils"
+)
+
+func TestCareTeamAccess(t *testing.T) {
+	ts := testutils.NewTestServer(t)
+	defer ts.Close()
+
+	attendingID := testutils.CreateTestUser(t, ts, models.Doctor)
+	attendingToken := testutils.GenerateTestToken(t, ts, attendingID, string(models.Doctor))
+	consultantID := testutils.CreateTestUser(t, ts, models.Doctor)
+	consultantToken := testutils.GenerateTestToken(t, ts, consultantID, string(models.Doctor))
+	receptionistID := testutils.CreateTestUser(t, ts, models.Receptionist)
+	receptionistToken := testutils.GenerateTestToken(t, ts, receptionistID, string(models.Receptionist))
+
+	resp := testutils.MakeRequest(t, ts, http.MethodPost, "/api/v1/patients", schemas.PatientCreate{
+		FullName:    "Jane Doe",
+		DateOfBirth: "1990-04-12",
+		Gender:      models.Female,
+		Email:       "jane@example.com",
+	}, attendingToken)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	var created schemas.PatientCreateResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&created))
+	patientPath := "/api/v1/patients/" + created.PatientID
+
+	listPatients := func(token string) []schemas.Patients {
+		resp := testutils.MakeRequest(t, ts, http.MethodGet, "/api/v1/patients", nil, token)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var list schemas.PatientListResponse
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&list))
+		return list.Patients
+	}
+
+	t.Run("registering doctor is assigned", func(t *testing.T) {
+		resp := testutils.MakeRequest(t, ts, http.MethodGet, patientPath, nil, attendingToken)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Len(t, listPatients(attendingToken), 1)
+	})
+
+	t.Run("unassigned doctors are denied", func(t *testing.T) {
+		resp := testutils.MakeRequest(t, ts, http.MethodGet, patientPath, nil, consultantToken)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+		history := "Overwritten"
+		resp = testutils.MakeRequest(t, ts, http.MethodPatch, patientPath, schemas.PatientUpdate{
+			MedicalHistory: &history,
+		}, consultantToken)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+		assert.Empty(t, listPatients(consultantToken))
+	})
+
+	t.Run("doctors cannot manage the care team", func(t *testing.T) {
+		resp := testutils.MakeRequest(t, ts, http.MethodPost, patientPath+"/care-team", schemas.CareTeamAssign{
+			UserID: attendingID,
+			Role:   models.CareTeamConsulting,
+		}, attendingToken)
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	})
+
+	var assignment models.CareTeamAssignment
+	t.Run("receptionists assign doctors", func(t *testing.T) {
+		resp := testutils.MakeRequest(t, ts, http.MethodPost, patientPath+"/care-team", schemas.CareTeamAssign{
+			UserID: consultantID,
+			Role:   "surgeon",
+		}, receptionistToken)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+		resp = testutils.MakeRequest(t, ts, http.MethodPost, patientPath+"/care-team", schemas.CareTeamAssign{
+			UserID: consultantID,
+			Role:   models.CareTeamConsulting,
+		}, receptionistToken)
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&assignment))
+		assert.Equal(t, receptionistID, assignment.AssignedBy)
+
+		resp = testutils.MakeRequest(t, ts, http.MethodGet, patientPath, nil, consultantToken)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Len(t, listPatients(consultantToken), 1)
+	})
+
+	t.Run("ended assignments revoke access", func(t *testing.T) {
+		path := patientPath + "/care-team/" + assignment.ID.String()
+		resp := testutils.MakeRequest(t, ts, http.MethodDelete, path, nil, receptionistToken)
+		require.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+		resp = testutils.MakeRequest(t, ts, http.MethodDelete, path, nil, receptionistToken)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+		resp = testutils.MakeRequest(t, ts, http.MethodGet, patientPath, nil, consultantToken)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+		// The assignment is kept as history
+		resp = testutils.MakeRequest(t, ts, http.MethodGet, patientPath+"/care-team", nil, receptionistToken)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var assignments []models.CareTeamAssignment
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&assignments))
+		assert.Len(t, assignments, 2)
+	})
+}
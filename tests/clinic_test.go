@@ -0,0 +1,188 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yhwbach/makerble/internal/models"
+	"github.com/yhwbach/makerble/internal/schemas"
+	"github.com/yhwbach/makerble/internal/testutils"
+)
+
+func TestClinicIsolation(t *testing.T) {
+	ts := testutils.NewTestServer(t)
+	defer ts.Close()
+
+	adminID := testutils.CreateTestUser(t, ts, models.Admin)
+	adminToken := testutils.GenerateTestToken(t, ts, adminID, string(models.Admin))
+	operatorID := testutils.CreateTestUser(t, ts, models.Operator)
+	operatorToken := testutils.GenerateTestToken(t, ts, operatorID, string(models.Operator))
+	doctorID := testutils.CreateTestUser(t, ts, models.Doctor)
+	doctorToken := testutils.GenerateTestToken(t, ts, doctorID, string(models.Doctor))
+
+	resp := testutils.MakeRequest(t, ts, http.MethodPost, "/api/v1/admin/clinics", schemas.ClinicCreate{Name: "North clinic"}, adminToken)
+	require.Equal(t, http.StatusForbidden, resp.StatusCode, "clinics are managed by operators")
+
+	resp = testutils.MakeRequest(t, ts, http.MethodPost, "/api/v1/admin/clinics", schemas.ClinicCreate{Name: "North clinic"}, operatorToken)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	var north models.Clinic
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&north))
+
+	resp = testutils.MakeRequest(t, ts, http.MethodPost, "/api/v1/admin/clinics", schemas.ClinicCreate{Name: "North clinic"}, operatorToken)
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+
+	northAdminID := testutils.CreateTestUserInClinic(t, ts, models.Admin, north.ID)
+	northAdminToken := testutils.GenerateTestToken(t, ts, northAdminID, string(models.Admin))
+	northReceptionistID := testutils.CreateTestUserInClinic(t, ts, models.Receptionist, north.ID)
+	northReceptionistToken := testutils.GenerateTestToken(t, ts, northReceptionistID, string(models.Receptionist))
+
+	resp = testutils.MakeRequest(t, ts, http.MethodPost, "/api/v1/patients", schemas.PatientCreate{
+		FullName:    "Jane Doe",
+		DateOfBirth: "1990-04-12",
+		Gender:      models.Female,
+		Email:       "jane@example.com",
+	}, doctorToken)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	var created schemas.PatientCreateResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&created))
+	patientPath := "/api/v1/patients/" + created.PatientID
+
+	listPatients := func(token string) []schemas.Patients {
+		resp := testutils.MakeRequest(t, ts, http.MethodGet, "/api/v1/patients", nil, token)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var list schemas.PatientListResponse
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&list))
+		return list.Patients
+	}
+
+	t.Run("patients are hidden from other clinics", func(t *testing.T) {
+		resp := testutils.MakeRequest(t, ts, http.MethodGet, patientPath, nil, northReceptionistToken)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+		resp = testutils.MakeRequest(t, ts, http.MethodDelete, patientPath, nil, northReceptionistToken)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+		assert.Empty(t, listPatients(northReceptionistToken))
+	})
+
+	t.Run("users are hidden from other clinics", func(t *testing.T) {
+		resp := testutils.MakeRequest(t, ts, http.MethodGet, "/api/v1/admin/users", nil, northAdminToken)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var users schemas.UserListResponse
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&users))
+		assert.Equal(t, 2, users.Total)
+
+		resp = testutils.MakeRequest(t, ts, http.MethodGet, "/api/v1/admin/users/"+doctorID.String(), nil, northAdminToken)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+
+	t.Run("transfer validation", func(t *testing.T) {
+		resp := testutils.MakeRequest(t, ts, http.MethodPost, patientPath+"/transfer", schemas.PatientTransfer{
+			ClinicID: north.ID,
+			Reason:   "Moved house",
+		}, doctorToken)
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+		resp = testutils.MakeRequest(t, ts, http.MethodPost, patientPath+"/transfer", schemas.PatientTransfer{
+			ClinicID: north.ID,
+			Reason:   "Moved house",
+		}, adminToken)
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode, "transfers are made by operators")
+
+		resp = testutils.MakeRequest(t, ts, http.MethodPost, patientPath+"/transfer", schemas.PatientTransfer{
+			ClinicID: north.ID,
+		}, operatorToken)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+		resp = testutils.MakeRequest(t, ts, http.MethodPost, patientPath+"/transfer", schemas.PatientTransfer{
+			ClinicID: models.DefaultClinicID,
+			Reason:   "Moved house",
+		}, operatorToken)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+		// Patients can only be transferred out of the caller's clinic
+		northOperatorID := testutils.CreateTestUserInClinic(t, ts, models.Operator, north.ID)
+		northOperatorToken := testutils.GenerateTestToken(t, ts, northOperatorID, string(models.Operator))
+		resp = testutils.MakeRequest(t, ts, http.MethodPost, patientPath+"/transfer", schemas.PatientTransfer{
+			ClinicID: north.ID,
+			Reason:   "Moved house",
+		}, northOperatorToken)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+
+	t.Run("transfer moves the patient", func(t *testing.T) {
+		resp := testutils.MakeRequest(t, ts, http.MethodPost, patientPath+"/transfer", schemas.PatientTransfer{
+			ClinicID: north.ID,
+			Reason:   "Moved house",
+		}, operatorToken)
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+		var transfer models.PatientTransfer
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&transfer))
+		assert.Equal(t, models.DefaultClinicID, transfer.FromClinicID)
+		assert.Equal(t, north.ID, transfer.ToClinicID)
+		assert.Equal(t, operatorID, transfer.TransferredBy)
+
+		// The care team of the previous clinic loses access
+		resp = testutils.MakeRequest(t, ts, http.MethodGet, patientPath, nil, doctorToken)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+		resp = testutils.MakeRequest(t, ts, http.MethodGet, patientPath, nil, northReceptionistToken)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var patient models.Patient
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&patient))
+		assert.Equal(t, north.ID, patient.ClinicID)
+		assert.Len(t, listPatients(northReceptionistToken), 1)
+	})
+
+	t.Run("invitations into another clinic", func(t *testing.T) {
+		resp := testutils.MakeRequest(t, ts, http.MethodPost, "/api/v1/admin/invitations", schemas.InvitationCreate{
+			Email:    "nurse@example.com",
+			UserType: models.Admin,
+			ClinicID: &models.DefaultClinicID,
+		}, northAdminToken)
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode, "admins only invite into their own clinic")
+
+		resp = testutils.MakeRequest(t, ts, http.MethodPost, "/api/v1/admin/invitations", schemas.InvitationCreate{
+			Email:    "nurse@example.com",
+			UserType: models.Receptionist,
+			ClinicID: &north.ID,
+		}, operatorToken)
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+		var invitation schemas.InvitationCreateResponse
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&invitation))
+		assert.Equal(t, north.ID, invitation.Invitation.ClinicID)
+
+		invitationPath := "/api/v1/admin/invitations/" + invitation.Invitation.ID.String()
+		resp = testutils.MakeRequest(t, ts, http.MethodDelete, invitationPath, nil, operatorToken)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode, "only admins of the clinic revoke its invitations")
+
+		resp = testutils.MakeRequest(t, ts, http.MethodDelete, invitationPath, nil, northAdminToken)
+		assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	})
+
+	t.Run("admins cannot hand out operator access", func(t *testing.T) {
+		resp := testutils.MakeRequest(t, ts, http.MethodPost, "/api/v1/admin/invitations", schemas.InvitationCreate{
+			Email:    "ops@example.com",
+			UserType: models.Operator,
+		}, northAdminToken)
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+		resp = testutils.MakeRequest(t, ts, http.MethodPut, "/api/v1/admin/users/"+northReceptionistID.String()+"/role", schemas.RoleChange{
+			UserType: models.Operator,
+		}, northAdminToken)
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+		resp = testutils.MakeRequest(t, ts, http.MethodPost, "/api/v1/admin/users/"+operatorID.String()+"/reset-password", nil, adminToken)
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode, "admins do not manage the accounts of operators")
+	})
+}
@@ -0,0 +1,125 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yhwbach/makerble/internal/models"
+	"github.com/yhwbach/makerble/internal/schemas"
+	"github.com/yhwbach/makerble/internal/testutils"
+)
+
+// giveConsent records the patient's consent of the type, as a user with consent.manage
+func giveConsent(t *testing.T, ts *testutils.TestServer, token, patientID, consentType string) {
+	resp := testutils.MakeRequest(t, ts, http.MethodPost, "/api/v1/patients/"+patientID+"/consents", schemas.ConsentCreate{
+		Type: consentType,
+	}, token)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+}
+
+func TestPatientConsents(t *testing.T) {
+	ts := testutils.NewTestServer(t)
+	defer ts.Close()
+
+	doctorID := testutils.CreateTestUser(t, ts, models.Doctor)
+	doctorToken := testutils.GenerateTestToken(t, ts, doctorID, string(models.Doctor))
+	receptionistID := testutils.CreateTestUser(t, ts, models.Receptionist)
+	receptionistToken := testutils.GenerateTestToken(t, ts, receptionistID, string(models.Receptionist))
+	adminID := testutils.CreateTestUser(t, ts, models.Admin)
+	adminToken := testutils.GenerateTestToken(t, ts, adminID, string(models.Admin))
+
+	resp := testutils.MakeRequest(t, ts, http.MethodPost, "/api/v1/admin/service-accounts", schemas.ServiceAccountCreate{
+		Username: "insurer",
+		FullName: "Insurer Integration",
+	}, adminToken)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	var account models.User
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&account))
+
+	resp = testutils.MakeRequest(t, ts, http.MethodPost, "/api/v1/admin/service-accounts/"+account.ID.String()+"/keys", schemas.APIKeyCreate{
+		Name:   "claims",
+		Scopes: []string{models.ScopePatientsRead},
+	}, adminToken)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	var key schemas.APIKeyCreateResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&key))
+
+	resp = testutils.MakeRequest(t, ts, http.MethodPost, "/api/v1/patients", schemas.PatientCreate{
+		FullName:    "Jane Doe",
+		DateOfBirth: "1990-04-12",
+		Gender:      models.Female,
+		Email:       "jane@example.com",
+	}, doctorToken)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	var created schemas.PatientCreateResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&created))
+	patientPath := "/api/v1/patients/" + created.PatientID
+
+	integrationSees := func() bool {
+		resp := testutils.MakeRequest(t, ts, http.MethodGet, "/api/v1/patients", nil, key.Key)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var list schemas.PatientListResponse
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&list))
+
+		resp = testutils.MakeRequest(t, ts, http.MethodGet, patientPath, nil, key.Key)
+		assert.Equal(t, len(list.Patients) == 1, resp.StatusCode == http.StatusOK)
+		return len(list.Patients) == 1
+	}
+
+	t.Run("integrations skip patients without data sharing consent", func(t *testing.T) {
+		assert.False(t, integrationSees())
+	})
+
+	var consent models.Consent
+	t.Run("receptionists record consent", func(t *testing.T) {
+		resp := testutils.MakeRequest(t, ts, http.MethodPost, patientPath+"/consents", schemas.ConsentCreate{
+			Type: "everything",
+		}, receptionistToken)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+		resp = testutils.MakeRequest(t, ts, http.MethodPost, patientPath+"/consents", schemas.ConsentCreate{
+			Type:        models.ConsentDataSharing,
+			Scope:       "Insurance claims",
+			DocumentRef: "dms://consents/2024/0042.pdf",
+		}, receptionistToken)
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&consent))
+		assert.Equal(t, receptionistID, consent.RecordedBy)
+
+		assert.True(t, integrationSees())
+
+		resp = testutils.MakeRequest(t, ts, http.MethodGet, patientPath, nil, doctorToken)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var patient models.Patient
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&patient))
+		assert.Equal(t, []string{models.ConsentDataSharing}, patient.Consents)
+	})
+
+	t.Run("revoked consent stops sharing", func(t *testing.T) {
+		path := patientPath + "/consents/" + consent.ID.String()
+		resp := testutils.MakeRequest(t, ts, http.MethodDelete, path, nil, receptionistToken)
+		require.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+		resp = testutils.MakeRequest(t, ts, http.MethodDelete, path, nil, receptionistToken)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+		assert.False(t, integrationSees())
+
+		// The revoked consent is kept as history
+		resp = testutils.MakeRequest(t, ts, http.MethodGet, patientPath+"/consents", nil, doctorToken)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var consents []models.Consent
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&consents))
+		require.Len(t, consents, 1)
+		assert.NotNil(t, consents[0].RevokedAt)
+	})
+}
@@ -0,0 +1,113 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yhwbach/makerble/internal/config"
+	"github.com/yhwbach/makerble/internal/models"
+	"github.com/yhwbach/makerble/internal/notify"
+	"github.com/yhwbach/makerble/internal/schemas"
+	"github.com/yhwbach/makerble/internal/testutils"
+)
+
+type recordingNotifier chan notify.Message
+
+func (n recordingNotifier) Notify(ctx context.Context, msg notify.Message) error {
+	n <- msg
+	return nil
+}
+
+func TestEmergencyAccess(t *testing.T) {
+	ts := testutils.NewTestServer(t, func(cfg *config.Config) {
+		cfg.EmergencyAccess.Duration = time.Hour
+		cfg.EmergencyAccess.ComplianceMailbox = "compliance@example.com"
+	})
+	defer ts.Close()
+
+	notifications := make(recordingNotifier, 1)
+	ts.App.Notifier = notifications
+
+	attendingID := testutils.CreateTestUser(t, ts, models.Doctor)
+	attendingToken := testutils.GenerateTestToken(t, ts, attendingID, string(models.Doctor))
+	doctorID := testutils.CreateTestUser(t, ts, models.Doctor)
+	doctorToken := testutils.GenerateTestToken(t, ts, doctorID, string(models.Doctor))
+	receptionistID := testutils.CreateTestUser(t, ts, models.Receptionist)
+	receptionistToken := testutils.GenerateTestToken(t, ts, receptionistID, string(models.Receptionist))
+	adminID := testutils.CreateTestUser(t, ts, models.Admin)
+	adminToken := testutils.GenerateTestToken(t, ts, adminID, string(models.Admin))
+
+	resp := testutils.MakeRequest(t, ts, http.MethodPost, "/api/v1/patients", schemas.PatientCreate{
+		FullName:    "Jane Doe",
+		DateOfBirth: "1990-04-12",
+		Gender:      models.Female,
+		Email:       "jane@example.com",
+	}, attendingToken)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	var created schemas.PatientCreateResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&created))
+	patientPath := "/api/v1/patients/" + created.PatientID
+
+	t.Run("justification is required", func(t *testing.T) {
+		resp := testutils.MakeRequest(t, ts, http.MethodPost, patientPath+"/emergency-access", schemas.EmergencyAccessRequest{
+			Justification: "urgent",
+		}, doctorToken)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+		resp = testutils.MakeRequest(t, ts, http.MethodGet, patientPath, nil, doctorToken)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+
+	t.Run("receptionists cannot break the glass", func(t *testing.T) {
+		resp := testutils.MakeRequest(t, ts, http.MethodPost, patientPath+"/emergency-access", schemas.EmergencyAccessRequest{
+			Justification: "Patient unconscious in the emergency department",
+		}, receptionistToken)
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	})
+
+	t.Run("grants time-boxed access and notifies compliance", func(t *testing.T) {
+		resp := testutils.MakeRequest(t, ts, http.MethodPost, patientPath+"/emergency-access", schemas.EmergencyAccessRequest{
+			Justification: "Patient unconscious in the emergency department",
+		}, doctorToken)
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+		var grant models.EmergencyAccessGrant
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&grant))
+		assert.WithinDuration(t, time.Now().Add(time.Hour), grant.ExpiresAt, time.Minute)
+
+		resp = testutils.MakeRequest(t, ts, http.MethodGet, patientPath, nil, doctorToken)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		select {
+		case msg := <-notifications:
+			assert.Equal(t, []string{"compliance@example.com"}, msg.To)
+			assert.Contains(t, msg.Body, "Patient unconscious in the emergency department")
+		case <-time.After(5 * time.Second):
+			t.Fatal("compliance was not notified")
+		}
+	})
+
+	t.Run("report lists break-glass events", func(t *testing.T) {
+		resp := testutils.MakeRequest(t, ts, http.MethodGet, "/api/v1/admin/emergency-access", nil, doctorToken)
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+		resp = testutils.MakeRequest(t, ts, http.MethodGet, "/api/v1/admin/emergency-access?since=yesterday", nil, adminToken)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+		resp = testutils.MakeRequest(t, ts, http.MethodGet, "/api/v1/admin/emergency-access?patient_id="+created.PatientID, nil, adminToken)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var grants []models.EmergencyAccessGrant
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&grants))
+		require.Len(t, grants, 1)
+		assert.Equal(t, doctorID, grants[0].UserID)
+		assert.NotEmpty(t, grants[0].Username)
+		assert.Equal(t, "Patient unconscious in the emergency department", grants[0].Justification)
+	})
+}
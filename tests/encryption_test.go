@@ -0,0 +1,148 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yhwbach/makerble/internal/encryption"
+	"github.com/yhwbach/makerble/internal/models"
+	"github.com/yhwbach/makerble/internal/repository"
+	"github.com/yhwbach/makerble/internal/schemas"
+	"github.com/yhwbach/makerble/internal/testutils"
+)
+
+func TestPatientEncryption(t *testing.T) {
+	ts := testutils.NewTestServer(t)
+	defer ts.Close()
+
+	ctx := context.Background()
+	db := ts.DB.DB
+
+	doctorID := testutils.CreateTestUser(t, ts, models.Doctor)
+	doctorToken := testutils.GenerateTestToken(t, ts, doctorID, string(models.Doctor))
+
+	resp := testutils.MakeRequest(t, ts, http.MethodPost, "/api/v1/patients", schemas.PatientCreate{
+		FullName:       "Jane Doe",
+		DateOfBirth:    "1990-04-12",
+		Gender:         models.Female,
+		Address:        "1 High Street",
+		Phone:          "+44 20 7946 0000",
+		Email:          "jane@example.com",
+		MedicalHistory: "Asthma",
+	}, doctorToken)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	var created schemas.PatientCreateResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&created))
+	patientID := uuid.MustParse(created.PatientID)
+
+	t.Run("sensitive fields are not stored in plaintext", func(t *testing.T) {
+		var email, medicalHistory sql.NullString
+		var encryptedEmail, encryptedHistory []byte
+		err := db.QueryRow(`SELECT email, medical_history, encrypted_email, encrypted_medical_history FROM patients WHERE id = $1`, patientID).
+			Scan(&email, &medicalHistory, &encryptedEmail, &encryptedHistory)
+		require.NoError(t, err)
+
+		assert.False(t, email.Valid)
+		assert.False(t, medicalHistory.Valid)
+		assert.NotContains(t, string(encryptedEmail), "jane@example.com")
+		assert.NotContains(t, string(encryptedHistory), "Asthma")
+
+		resp := testutils.MakeRequest(t, ts, http.MethodGet, "/api/v1/patients/"+created.PatientID, nil, doctorToken)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var patient models.Patient
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&patient))
+		assert.Equal(t, "jane@example.com", patient.Email)
+		assert.Equal(t, "Asthma", patient.MedicalHistory)
+	})
+
+	t.Run("lookup by email", func(t *testing.T) {
+		patient, err := ts.App.Repo.Patients.FindByEmail(ctx, "Jane@Example.com")
+		require.NoError(t, err)
+		require.NotNil(t, patient)
+		assert.Equal(t, patientID, patient.ID)
+	})
+
+	t.Run("partial update keeps the other fields", func(t *testing.T) {
+		phone := "+44 20 7946 0001"
+		patient, err := ts.App.Repo.Patients.UpdateByID(ctx, patientID, &schemas.PatientUpdate{Phone: &phone}, nil)
+		require.NoError(t, err)
+		assert.Equal(t, phone, patient.Phone)
+		assert.Equal(t, "1 High Street", patient.Address)
+		assert.Equal(t, "Asthma", patient.MedicalHistory)
+	})
+
+	// A patient stored before field encryption
+	legacyID := uuid.New()
+	_, err := db.Exec(`INSERT INTO patients (id, full_name, date_of_birth, gender, address, phone, email, medical_history, registered_by)
+		VALUES ($1, 'John Doe', '1985-01-02', 'male', '2 High Street', '+44 20 7946 0002', 'john@example.com', 'Diabetes', $2)`,
+		legacyID, doctorID)
+	require.NoError(t, err)
+
+	t.Run("plaintext patients are encrypted", func(t *testing.T) {
+		patient, err := ts.App.Repo.Patients.FindByEmail(ctx, "john@example.com")
+		require.NoError(t, err)
+		require.NotNil(t, patient)
+		assert.Equal(t, "Diabetes", patient.MedicalHistory)
+
+		n, err := ts.App.Repo.Patients.EncryptPlaintext(ctx, 10)
+		require.NoError(t, err)
+		assert.Equal(t, 1, n)
+
+		n, err = ts.App.Repo.Patients.EncryptPlaintext(ctx, 10)
+		require.NoError(t, err)
+		assert.Zero(t, n)
+
+		patient, err = ts.App.Repo.Patients.FindByEmail(ctx, "john@example.com")
+		require.NoError(t, err)
+		require.NotNil(t, patient)
+		assert.Equal(t, legacyID, patient.ID)
+		assert.Equal(t, "Diabetes", patient.MedicalHistory)
+	})
+
+	t.Run("key rotation", func(t *testing.T) {
+		oldKey, err := base64.StdEncoding.DecodeString(testutils.TestMasterKey)
+		require.NoError(t, err)
+		newKey := bytes.Repeat([]byte{7}, encryption.KeySize)
+
+		keys, err := encryption.NewKeyRing(newKey, oldKey)
+		require.NoError(t, err)
+		repo := repository.NewRepoStorage(db, false, keys)
+
+		// Patients are found by email before their keys are rotated
+		patient, err := repo.Patients.FindByEmail(ctx, "jane@example.com")
+		require.NoError(t, err)
+		require.NotNil(t, patient)
+
+		n, err := repo.Patients.RotateKeys(ctx, 1)
+		require.NoError(t, err)
+		assert.Equal(t, 1, n)
+		n, err = repo.Patients.RotateKeys(ctx, 10)
+		require.NoError(t, err)
+		assert.Equal(t, 1, n)
+
+		// The old key is no longer needed
+		keys, err = encryption.NewKeyRing(newKey)
+		require.NoError(t, err)
+		repo = repository.NewRepoStorage(db, false, keys)
+
+		patient, err = repo.Patients.FindByEmail(ctx, "jane@example.com")
+		require.NoError(t, err)
+		require.NotNil(t, patient)
+		assert.Equal(t, "Asthma", patient.MedicalHistory)
+
+		patient, err = repo.Patients.FindByID(ctx, legacyID, schemas.PatientQuery{})
+		require.NoError(t, err)
+		require.NotNil(t, patient)
+		assert.Equal(t, "2 High Street", patient.Address)
+	})
+}
@@ -0,0 +1,143 @@
+package tests
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yhwbach/makerble/internal/models"
+	"github.com/yhwbach/makerble/internal/schemas"
+	"github.com/yhwbach/makerble/internal/server"
+	"github.com/yhwbach/makerble/internal/testutils"
+)
+
+func TestPatientExport(t *testing.T) {
+	ts := testutils.NewTestServer(t)
+	defer ts.Close()
+
+	doctorID := testutils.CreateTestUser(t, ts, models.Doctor)
+	doctorToken := testutils.GenerateTestToken(t, ts, doctorID, string(models.Doctor))
+	adminID := testutils.CreateTestUser(t, ts, models.Admin)
+	adminToken := testutils.GenerateTestToken(t, ts, adminID, string(models.Admin))
+
+	resp := testutils.MakeRequest(t, ts, http.MethodPost, "/api/v1/patients", schemas.PatientCreate{
+		FullName:       "Jane Doe",
+		DateOfBirth:    "1990-04-12",
+		Gender:         models.Female,
+		Email:          "jane@example.com",
+		MedicalHistory: "Asthma",
+	}, doctorToken)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	var created schemas.PatientCreateResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&created))
+	patientPath := "/api/v1/patients/" + created.PatientID
+
+	resp = testutils.MakeRequest(t, ts, http.MethodPost, patientPath+"/consents", schemas.ConsentCreate{
+		Type:        models.ConsentTreatment,
+		DocumentRef: "forms/jane-treatment.pdf",
+	}, doctorToken)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	resp = testutils.MakeRequest(t, ts, http.MethodGet, patientPath, nil, doctorToken)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	t.Run("requires patient.export", func(t *testing.T) {
+		resp := testutils.MakeRequest(t, ts, http.MethodGet, patientPath+"/export", nil, doctorToken)
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	})
+
+	t.Run("invalid format", func(t *testing.T) {
+		resp := testutils.MakeRequest(t, ts, http.MethodGet, patientPath+"/export?format=xml", nil, adminToken)
+		require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+		var body server.ValidationErrorResponse
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+		assert.Equal(t, []string{"json", "html"}, body.Errors)
+	})
+
+	t.Run("json", func(t *testing.T) {
+		resp := testutils.MakeRequest(t, ts, http.MethodGet, patientPath+"/export", nil, adminToken)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Contains(t, resp.Header.Get("Content-Disposition"), "patient-"+created.PatientID+".json")
+
+		var export schemas.PatientExport
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&export))
+
+		assert.Equal(t, adminID, export.ExportedBy)
+		assert.Equal(t, "jane@example.com", export.Patient.Email)
+		assert.Equal(t, "Asthma", export.Patient.MedicalHistory, "exports are complete")
+		require.NotNil(t, export.RegisteredBy)
+		assert.Equal(t, doctorID, export.RegisteredBy.ID)
+		require.NotNil(t, export.Clinic)
+		assert.Equal(t, models.DefaultClinicID, export.Clinic.ID)
+
+		require.Len(t, export.Consents, 1)
+		assert.Equal(t, "forms/jane-treatment.pdf", export.Consents[0].DocumentRef)
+		require.Len(t, export.CareTeam, 1)
+		assert.Equal(t, doctorID, export.CareTeam[0].UserID)
+		assert.Empty(t, export.EmergencyAccess)
+
+		actions := make([]string, len(export.AuditEvents))
+		for i, event := range export.AuditEvents {
+			actions[i] = event.Action
+		}
+		assert.Equal(t, []string{models.AuditActionPatientCreate, models.AuditActionPatientRead}, actions)
+	})
+
+	t.Run("html", func(t *testing.T) {
+		resp := testutils.MakeRequest(t, ts, http.MethodGet, patientPath+"/export?format=html", nil, adminToken)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "text/html; charset=utf-8", resp.Header.Get("Content-Type"))
+
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Contains(t, string(body), "Patient record: Jane Doe")
+		assert.Contains(t, string(body), "Asthma")
+		assert.Contains(t, string(body), "forms/jane-treatment.pdf")
+	})
+
+	t.Run("exports are audited", func(t *testing.T) {
+		resp := testutils.MakeRequest(t, ts, http.MethodGet, "/api/v1/audit?action=patient.export&patient_id="+created.PatientID, nil, adminToken)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var events []models.AuditEvent
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&events))
+		require.Len(t, events, 2)
+		assert.Equal(t, adminID, *events[0].ActorID)
+		assert.Equal(t, "html", events[0].Details["format"])
+		assert.Contains(t, events[0].Fields, "medical_history")
+	})
+
+	t.Run("other patients are not disclosed", func(t *testing.T) {
+		resp := testutils.MakeRequest(t, ts, http.MethodPost, "/api/v1/patients", schemas.PatientCreate{
+			FullName:    "Sam Roe",
+			DateOfBirth: "1980-01-01",
+			Gender:      models.Male,
+		}, doctorToken)
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+		var other schemas.PatientCreateResponse
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&other))
+
+		// The list is recorded with the IDs of both patients
+		resp = testutils.MakeRequest(t, ts, http.MethodGet, "/api/v1/patients", nil, doctorToken)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		resp = testutils.MakeRequest(t, ts, http.MethodGet, patientPath+"/export", nil, adminToken)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+
+		var export schemas.PatientExport
+		require.NoError(t, json.Unmarshal(body, &export))
+		require.NotEmpty(t, export.AuditEvents)
+		assert.Equal(t, models.AuditActionPatientList, export.AuditEvents[len(export.AuditEvents)-1].Action)
+
+		assert.NotContains(t, string(body), other.PatientID)
+		assert.NotContains(t, string(body), "ip_address")
+		assert.NotContains(t, string(body), "request_id")
+	})
+}
@@ -0,0 +1,194 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yhwbach/makerble/internal/fhir"
+	"github.com/yhwbach/makerble/internal/models"
+	"github.com/yhwbach/makerble/internal/testutils"
+)
+
+func TestFHIRPatient(t *testing.T) {
+	ts := testutils.NewTestServer(t)
+	defer ts.Close()
+
+	doctorID := testutils.CreateTestUser(t, ts, models.Doctor)
+	doctorToken := testutils.GenerateTestToken(t, ts, doctorID, string(models.Doctor))
+	receptionistID := testutils.CreateTestUser(t, ts, models.Receptionist)
+	receptionistToken := testutils.GenerateTestToken(t, ts, receptionistID, string(models.Receptionist))
+
+	outcome := func(resp *http.Response) *fhir.OperationOutcome {
+		assert.Equal(t, fhir.ContentType, resp.Header.Get("Content-Type"))
+
+		var outcome fhir.OperationOutcome
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&outcome))
+		assert.Equal(t, "OperationOutcome", outcome.ResourceType)
+		require.NotEmpty(t, outcome.Issue)
+		return &outcome
+	}
+
+	t.Run("capability statement", func(t *testing.T) {
+		resp := testutils.MakeRequest(t, ts, http.MethodGet, "/fhir/metadata", nil, "")
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, fhir.ContentType, resp.Header.Get("Content-Type"))
+
+		var capabilities fhir.CapabilityStatement
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&capabilities))
+		assert.Equal(t, fhir.Version, capabilities.FHIRVersion)
+		assert.Equal(t, "Patient", capabilities.Rest[0].Resource[0].Type)
+	})
+
+	t.Run("errors are operation outcomes", func(t *testing.T) {
+		resp := testutils.MakeRequest(t, ts, http.MethodGet, "/fhir/Patient", nil, "")
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+		assert.Equal(t, fhir.IssueLogin, outcome(resp).Issue[0].Code)
+
+		resp = testutils.MakeRequest(t, ts, http.MethodGet, "/fhir/Observation", nil, doctorToken)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+		outcome(resp)
+	})
+
+	resource := fhir.Patient{
+		ResourceType: "Patient",
+		Name:         []fhir.HumanName{{Use: "official", Given: []string{"Jane"}, Family: "Doe"}},
+		Telecom:      []fhir.ContactPoint{{System: "phone", Value: "+1 555 0100"}, {System: "email", Value: "jane@example.com"}},
+		Gender:       "female",
+		BirthDate:    "1990-04-12",
+		Address:      []fhir.Address{{Line: []string{"12 Main Street"}, City: "Springfield"}},
+	}
+
+	var created fhir.Patient
+	t.Run("create", func(t *testing.T) {
+		invalid := resource
+		invalid.Gender = "unknown"
+		resp := testutils.MakeRequest(t, ts, http.MethodPost, "/fhir/Patient", invalid, doctorToken)
+		assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+		assert.Equal(t, []string{"Patient.gender"}, outcome(resp).Issue[0].Expression)
+
+		resp = testutils.MakeRequest(t, ts, http.MethodPost, "/fhir/Patient", resource, doctorToken)
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&created))
+		assert.NotEmpty(t, created.ID)
+		assert.Contains(t, resp.Header.Get("Location"), "/fhir/Patient/"+created.ID)
+		assert.Equal(t, "Jane Doe", created.Name[0].Text)
+		assert.Equal(t, "12 Main Street, Springfield", created.Address[0].Text)
+		giveConsent(t, ts, doctorToken, created.ID, models.ConsentDataSharing)
+
+		// The patient is the same one the REST API serves
+		resp = testutils.MakeRequest(t, ts, http.MethodGet, "/api/v1/patients/"+created.ID, nil, doctorToken)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var patient models.Patient
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&patient))
+		assert.Equal(t, "Jane Doe", patient.FullName)
+		assert.Equal(t, "jane@example.com", patient.Email)
+	})
+
+	t.Run("read", func(t *testing.T) {
+		resp := testutils.MakeRequest(t, ts, http.MethodGet, "/fhir/Patient/"+created.ID, nil, receptionistToken)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var read fhir.Patient
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&read))
+		assert.Equal(t, created, read)
+
+		resp = testutils.MakeRequest(t, ts, http.MethodGet, "/fhir/Patient/00000000-0000-0000-0000-000000000000", nil, doctorToken)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+		assert.Equal(t, fhir.IssueNotFound, outcome(resp).Issue[0].Code)
+	})
+
+	t.Run("search", func(t *testing.T) {
+		search := func(query string) *fhir.Bundle {
+			resp := testutils.MakeRequest(t, ts, http.MethodGet, "/fhir/Patient?"+query, nil, doctorToken)
+			require.Equal(t, http.StatusOK, resp.StatusCode)
+
+			var bundle fhir.Bundle
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&bundle))
+			assert.Equal(t, "searchset", bundle.Type)
+			return &bundle
+		}
+
+		assert.Equal(t, 1, search("name=jane&gender=female&birthdate=ge1990-01-01").Total)
+		assert.Equal(t, 1, search("identifier=urn:uuid:"+created.ID).Total)
+		assert.Equal(t, 0, search("birthdate=1991").Total)
+		assert.Equal(t, 0, search("name=oe").Total, "names match from the start of a word")
+		assert.Equal(t, 0, search("name=j%25").Total, "wildcards are matched as is")
+
+		other := resource
+		other.Name = []fhir.HumanName{{Use: "official", Given: []string{"John"}, Family: "Doe"}}
+		other.Telecom = nil
+		resp := testutils.MakeRequest(t, ts, http.MethodPost, "/fhir/Patient", other, doctorToken)
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+		var otherCreated fhir.Patient
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&otherCreated))
+		giveConsent(t, ts, doctorToken, otherCreated.ID, models.ConsentDataSharing)
+
+		page := search("name=doe&_count=1")
+		assert.Equal(t, 2, page.Total)
+		require.Len(t, page.Entry, 1)
+		require.Len(t, page.Link, 2)
+		assert.Equal(t, "next", page.Link[1].Relation)
+		assert.Contains(t, page.Link[1].URL, "_offset=1")
+
+		next := search("name=doe&_count=1&_offset=1")
+		assert.Equal(t, 2, next.Total)
+		require.Len(t, next.Entry, 1)
+		assert.NotEqual(t, page.Entry[0].FullURL, next.Entry[0].FullURL)
+		require.Len(t, next.Link, 2)
+		assert.Equal(t, "previous", next.Link[1].Relation)
+
+		resp = testutils.MakeRequest(t, ts, http.MethodGet, "/fhir/Patient?birthdate=12/04/1990", nil, doctorToken)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		outcome(resp)
+	})
+
+	t.Run("patients not consenting to data sharing", func(t *testing.T) {
+		withheld := resource
+		withheld.Name = []fhir.HumanName{{Use: "official", Given: []string{"Sam"}, Family: "Roe"}}
+		withheld.Telecom = nil
+		resp := testutils.MakeRequest(t, ts, http.MethodPost, "/fhir/Patient", withheld, doctorToken)
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&withheld))
+
+		resp = testutils.MakeRequest(t, ts, http.MethodGet, "/fhir/Patient/"+withheld.ID, nil, doctorToken)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+		outcome(resp)
+
+		resp = testutils.MakeRequest(t, ts, http.MethodGet, "/fhir/Patient?name=roe", nil, doctorToken)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var bundle fhir.Bundle
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&bundle))
+		assert.Zero(t, bundle.Total)
+
+		resp = testutils.MakeRequest(t, ts, http.MethodGet, "/api/v1/patients/"+withheld.ID, nil, doctorToken)
+		assert.Equal(t, http.StatusOK, resp.StatusCode, "the patient is still there for the care team")
+	})
+
+	t.Run("update", func(t *testing.T) {
+		update := created
+		update.Telecom = []fhir.ContactPoint{{System: "email", Value: "jane.doe@example.com"}}
+
+		resp := testutils.MakeRequest(t, ts, http.MethodPut, "/fhir/Patient/"+created.ID, update, receptionistToken)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var updated fhir.Patient
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&updated))
+		assert.Equal(t, update.Telecom, updated.Telecom, "the phone number left out is cleared")
+
+		update.BirthDate = "1990-04-21"
+		resp = testutils.MakeRequest(t, ts, http.MethodPut, "/fhir/Patient/"+created.ID, update, receptionistToken)
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode, "receptionists cannot change the birth date")
+		outcome(resp)
+
+		resp = testutils.MakeRequest(t, ts, http.MethodPut, "/fhir/Patient/"+created.ID, update, doctorToken)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		update.ID = ""
+		resp = testutils.MakeRequest(t, ts, http.MethodPut, "/fhir/Patient/"+created.ID, update, doctorToken)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		outcome(resp)
+	})
+}
@@ -0,0 +1,153 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yhwbach/makerble/internal/config"
+	"github.com/yhwbach/makerble/internal/hl7"
+	"github.com/yhwbach/makerble/internal/models"
+	"github.com/yhwbach/makerble/internal/testutils"
+)
+
+func TestHL7(t *testing.T) {
+	ts := testutils.NewTestServer(t)
+	defer ts.Close()
+
+	ctx := context.Background()
+	receptionistID := testutils.CreateTestUser(t, ts, models.Receptionist)
+	receptionistToken := testutils.GenerateTestToken(t, ts, receptionistID, string(models.Receptionist))
+
+	accountID := testutils.CreateTestUser(t, ts, models.Service)
+	account, err := ts.App.Repo.Users.FindByID(ctx, accountID)
+	require.NoError(t, err)
+
+	processor, err := hl7.NewProcessor(ctx, config.HL7Config{ServiceAccount: account.Username}, ts.App.Repo)
+	require.NoError(t, err)
+
+	_, err = hl7.NewProcessor(ctx, config.HL7Config{ServiceAccount: "unknown"}, ts.App.Repo)
+	assert.Error(t, err)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	serverCtx, stop := context.WithCancel(ctx)
+	defer stop()
+	go (&hl7.Server{Handler: processor}).Serve(serverCtx, listener)
+
+	client, err := hl7.Dial(listener.Addr().String())
+	require.NoError(t, err)
+	defer client.Close()
+
+	send := func(event, controlID string, segments ...string) (string, *hl7.Message) {
+		msh := "MSH|^~\\&|REGISTRATION|HOSPITAL|MAKERBLE|CLINIC|20250601120000||ADT^" + event + "|" + controlID + "|P|2.5"
+		raw, err := client.Send(strings.Join(append([]string{msh}, segments...), "\r"))
+		require.NoError(t, err)
+
+		ack, err := hl7.Parse(raw)
+		require.NoError(t, err)
+		assert.Equal(t, controlID, ack.Segment("MSA").Field(2))
+		code, _ := hl7.AckCode(ack)
+		return code, ack
+	}
+
+	getPatient := func(system, value string) *models.Patient {
+		found, err := ts.App.Repo.Patients.FindByIdentifier(ctx, models.PatientIdentifier{System: system, Value: value})
+		require.NoError(t, err)
+		require.NotNil(t, found)
+
+		// The patients are the ones the REST API serves
+		resp := testutils.MakeRequest(t, ts, http.MethodGet, "/api/v1/patients/"+found.ID.String(), nil, receptionistToken)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var patient models.Patient
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&patient))
+		return &patient
+	}
+
+	t.Run("register and update", func(t *testing.T) {
+		code, _ := send("A04", "MSG1",
+			`PID|1||MRN1^^^HOSP^MR||Doe^Jane||19900412|F|||12 Main Street^^Springfield||^PRN^PH^^^^555-0100~^NET^Internet^jane@example.com`)
+		require.Equal(t, hl7.AcceptAccept, code)
+
+		patient := getPatient("HOSP", "MRN1")
+		assert.Equal(t, "Jane Doe", patient.FullName)
+		assert.Equal(t, "1990-04-12", patient.DateOfBirth.Format("2006-01-02"))
+		assert.Equal(t, "12 Main Street, Springfield", patient.Address)
+		assert.Equal(t, "555-0100", patient.Phone)
+		assert.Equal(t, "jane@example.com", patient.Email)
+
+		code, _ = send("A08", "MSG2", `PID|1||MRN1^^^HOSP^MR||Doe^Jane^Mary||||||""`)
+		require.Equal(t, hl7.AcceptAccept, code)
+
+		patient = getPatient("HOSP", "MRN1")
+		assert.Equal(t, "Jane Mary Doe", patient.FullName)
+		assert.Empty(t, patient.Address)
+		assert.Equal(t, "555-0100", patient.Phone)
+	})
+
+	t.Run("raw messages are stored encrypted", func(t *testing.T) {
+		messages, err := ts.App.Repo.HL7Messages.List(ctx, models.HL7MessageProcessed, 10)
+		require.NoError(t, err)
+		require.Len(t, messages, 2)
+		assert.Contains(t, messages[0].Raw, "Doe^Jane")
+
+		var leaked bool
+		err = ts.DB.DB.QueryRow(`SELECT position('Doe^Jane'::bytea in encrypted_raw) > 0 FROM hl7_messages WHERE id = $1`,
+			messages[0].ID).Scan(&leaked)
+		require.NoError(t, err)
+		assert.False(t, leaked)
+	})
+
+	t.Run("errors and replay", func(t *testing.T) {
+		code, ack := send("A04", "MSG3", `PID|1||MRN2^^^HOSP^MR||Roe^Sam||19800101|X`)
+		assert.Equal(t, hl7.AcceptError, code)
+		assert.Equal(t, "PID^1^8", ack.Segment("ERR").Field(2))
+
+		code, _ = send("A04", "MSG3", `PID|1||MRN2^^^HOSP^MR||Roe^Sam||19800101|X`)
+		assert.Equal(t, hl7.AcceptError, code, "failed messages are processed again when sent again")
+
+		code, _ = send("A03", "MSG4", `PID|1||MRN2^^^HOSP^MR`)
+		assert.Equal(t, hl7.AcceptReject, code)
+
+		failed, err := ts.App.Repo.HL7Messages.List(ctx, models.HL7MessageFailed, 10)
+		require.NoError(t, err)
+		require.Len(t, failed, 2)
+
+		replayed, err := processor.Replay(ctx, failed[0].ID)
+		require.NoError(t, err)
+		assert.Equal(t, models.HL7MessageFailed, replayed.Status)
+	})
+
+	t.Run("merge", func(t *testing.T) {
+		code, _ := send("A04", "MSG5", `PID|1||MRN9^^^HOSP^MR||Doe^Jane||19900412|F||||||^PRN^PH^^^^555-0199`)
+		require.Equal(t, hl7.AcceptAccept, code)
+		duplicate := getPatient("HOSP", "MRN9")
+
+		code, _ = send("A40", "MSG6", `PID|1||MRN1^^^HOSP^MR`, `MRG|MRN9^^^HOSP^MR`)
+		require.Equal(t, hl7.AcceptAccept, code)
+
+		survivor := getPatient("HOSP", "MRN9")
+		assert.NotEqual(t, duplicate.ID, survivor.ID, "the identifiers of the duplicate lead to the survivor")
+		assert.Equal(t, "Jane Mary Doe", survivor.FullName)
+
+		resp := testutils.MakeRequest(t, ts, http.MethodGet, "/api/v1/patients/"+duplicate.ID.String(), nil, receptionistToken)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+		events, err := ts.App.Repo.Audit.ListByPatient(ctx, survivor.ID)
+		require.NoError(t, err)
+		var merges int
+		for _, event := range events {
+			if event.Action == models.AuditActionPatientMerge {
+				merges++
+				assert.Equal(t, accountID, *event.ActorID)
+				assert.Equal(t, duplicate.ID.String(), event.Details["merged_patient_id"])
+			}
+		}
+		assert.Equal(t, 1, merges)
+	})
+}
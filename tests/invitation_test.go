@@ -0,0 +1,70 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yhwbach/makerble/internal/models"
+	"github.com/yhwbach/makerble/internal/schemas"
+	"github.com/yhwbach/makerble/internal/testutils"
+)
+
+func TestInvitationRegistration(t *testing.T) {
+	ts := testutils.NewTestServer(t)
+	defer ts.Close()
+
+	ts.App.Config.Auth.OpenRegistration = false
+
+	adminID := testutils.CreateTestUser(t, ts, models.Admin)
+	adminToken := testutils.GenerateTestToken(t, ts, adminID, string(models.Admin))
+
+	resp := testutils.MakeRequest(t, ts, http.MethodPost, "/api/v1/admin/invitations", schemas.InvitationCreate{
+		Email:    "reception@example.com",
+		UserType: models.Receptionist,
+	}, adminToken)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	var invitation schemas.InvitationCreateResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&invitation))
+	require.NotEmpty(t, invitation.Token)
+
+	register := func(email, token string) *http.Response {
+		return testutils.MakeRequest(t, ts, http.MethodPost, "/api/v1/register", schemas.UserRegister{
+			Username:        "reception",
+			Password:        "Front-Desk-2024",
+			Email:           email,
+			FullName:        "Front Desk",
+			UserType:        models.Doctor,
+			InvitationToken: token,
+		}, "")
+	}
+
+	t.Run("without invitation", func(t *testing.T) {
+		assert.Equal(t, http.StatusForbidden, register("reception@example.com", "").StatusCode)
+	})
+
+	t.Run("different email", func(t *testing.T) {
+		assert.Equal(t, http.StatusForbidden, register("someone@example.com", invitation.Token).StatusCode)
+	})
+
+	t.Run("valid invitation assigns invited role", func(t *testing.T) {
+		require.Equal(t, http.StatusCreated, register("reception@example.com", invitation.Token).StatusCode)
+
+		resp := testutils.MakeRequest(t, ts, http.MethodPost, "/api/v1/login", schemas.UserLogin{
+			Username: "reception",
+			Password: "Front-Desk-2024",
+		}, "")
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var token schemas.TokenResponse
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&token))
+		assert.Equal(t, string(models.Receptionist), token.UserType)
+	})
+
+	t.Run("invitation is single use", func(t *testing.T) {
+		assert.Equal(t, http.StatusForbidden, register("reception@example.com", invitation.Token).StatusCode)
+	})
+}
@@ -0,0 +1,79 @@
+package tests
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/jimlambrt/gldap/testdirectory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yhwbach/makerble/internal/config"
+	"github.com/yhwbach/makerble/internal/models"
+	"github.com/yhwbach/makerble/internal/schemas"
+	"github.com/yhwbach/makerble/internal/testutils"
+)
+
+func TestLDAPLogin(t *testing.T) {
+	users := testdirectory.NewUsers(t, []string{"alice"},
+		testdirectory.WithMembersOf(t, testdirectory.NewMemberOf(t, []string{"physicians"})...))
+	directory := testdirectory.Start(t,
+		testdirectory.WithNoTLS(t),
+		testdirectory.WithDefaults(t, &testdirectory.Defaults{
+			Users:              users,
+			AllowAnonymousBind: true,
+		}),
+	)
+
+	ts := testutils.NewTestServer(t, func(cfg *config.Config) {
+		cfg.Auth.Backends = []string{"ldap", "local"}
+		cfg.Auth.LocalAdminsOnly = true
+		cfg.LDAP = config.LDAPConfig{
+			URL:            fmt.Sprintf("ldap://%s:%d", directory.Host(), directory.Port()),
+			Timeout:        5 * time.Second,
+			BaseDN:         testdirectory.DefaultUserDN,
+			UserFilter:     "(cn={username})",
+			IDAttribute:    "entryUUID",
+			EmailAttribute: "email",
+			NameAttribute:  "name",
+			RoleMapping:    map[string]string{"physicians": "doctor"},
+		}
+	})
+	defer ts.Close()
+
+	login := func(username, password string) *http.Response {
+		return testutils.MakeRequest(t, ts, http.MethodPost, "/api/v1/login", schemas.UserLogin{
+			Username: username,
+			Password: password,
+		}, "")
+	}
+
+	t.Run("directory user", func(t *testing.T) {
+		resp := login("alice", "password")
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var token schemas.TokenResponse
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&token))
+		assert.Equal(t, string(models.Doctor), token.UserType)
+
+		assert.Equal(t, http.StatusUnauthorized, login("alice", "wrong").StatusCode)
+	})
+
+	t.Run("break-glass admin", func(t *testing.T) {
+		adminID := testutils.CreateTestUser(t, ts, models.Admin)
+		admin, err := ts.App.Repo.Users.FindByID(t.Context(), adminID)
+		require.NoError(t, err)
+
+		assert.Equal(t, http.StatusOK, login(admin.Username, "Test-Password-1").StatusCode)
+	})
+
+	t.Run("local non-admin", func(t *testing.T) {
+		doctorID := testutils.CreateTestUser(t, ts, models.Doctor)
+		doctor, err := ts.App.Repo.Users.FindByID(t.Context(), doctorID)
+		require.NoError(t, err)
+
+		assert.Equal(t, http.StatusUnauthorized, login(doctor.Username, "Test-Password-1").StatusCode)
+	})
+}
@@ -0,0 +1,73 @@
+package tests
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yhwbach/makerble/internal/config"
+	"github.com/yhwbach/makerble/internal/logging"
+	"github.com/yhwbach/makerble/internal/models"
+	"github.com/yhwbach/makerble/internal/schemas"
+	"github.com/yhwbach/makerble/internal/testutils"
+)
+
+func TestRequestLogging(t *testing.T) {
+	ts := testutils.NewTestServer(t)
+	defer ts.Close()
+
+	var buf bytes.Buffer
+	logger, err := logging.New(config.LogConfig{Level: "debug", Format: "json"}, &buf)
+	require.NoError(t, err)
+
+	previous := slog.Default()
+	slog.SetDefault(logger)
+	defer slog.SetDefault(previous)
+
+	doctorID := testutils.CreateTestUser(t, ts, models.Doctor)
+	doctorToken := testutils.GenerateTestToken(t, ts, doctorID, string(models.Doctor))
+
+	resp := testutils.MakeRequest(t, ts, http.MethodPost, "/api/v1/patients", schemas.PatientCreate{
+		FullName:    "Jane Doe",
+		DateOfBirth: "1990-04-12",
+		Gender:      models.Female,
+		Email:       "jane@example.com",
+		Phone:       "+44 20 7946 0000",
+	}, doctorToken)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	var created schemas.PatientCreateResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&created))
+
+	resp = testutils.MakeRequest(t, ts, http.MethodGet, "/api/v1/patients/"+created.PatientID+"?email=jane@example.com", nil, doctorToken)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	logged := buf.String()
+	assert.NotContains(t, logged, "jane@example.com")
+	assert.NotContains(t, logged, "7946")
+	assert.NotContains(t, logged, doctorToken)
+	assert.NotContains(t, logged, created.PatientID, "paths are logged as route patterns")
+
+	var requests []map[string]interface{}
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		var record map[string]interface{}
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &record))
+		if record["msg"] == "request" {
+			requests = append(requests, record)
+		}
+	}
+	require.Len(t, requests, 2)
+
+	read := requests[1]
+	assert.Equal(t, http.MethodGet, read["method"])
+	assert.Equal(t, "/api/v1/patients/{id}", read["route"])
+	assert.EqualValues(t, http.StatusOK, read["status"])
+	assert.Equal(t, doctorID.String(), read["user_id"])
+	assert.NotEmpty(t, read["request_id"])
+}
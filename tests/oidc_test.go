@@ -0,0 +1,114 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/cookiejar"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yhwbach/makerble/internal/config"
+	"github.com/yhwbach/makerble/internal/models"
+	"github.com/yhwbach/makerble/internal/schemas"
+	"github.com/yhwbach/makerble/internal/testutils"
+)
+
+func TestOIDCLogin(t *testing.T) {
+	idp := testutils.NewMockOIDCProvider(t)
+	ts := testutils.NewTestServer(t, func(cfg *config.Config) {
+		cfg.OIDC = idp.Config(map[string]string{
+			"physicians": "doctor",
+			"front-desk": "receptionist",
+		})
+	})
+	defer ts.Close()
+
+	// ssoLogin walks through the redirects like a browser and returns the final response
+	ssoLogin := func(t *testing.T) *http.Response {
+		jar, err := cookiejar.New(nil)
+		require.NoError(t, err)
+
+		client := &http.Client{Jar: jar}
+		resp, err := client.Get(ts.TestServer.URL + "/api/v1/auth/oidc/login")
+		require.NoError(t, err)
+		return resp
+	}
+
+	user := testutils.MockOIDCUser{
+		Subject:  "sso-subject-1",
+		Email:    "house@hospital.example",
+		Name:     "Gregory House",
+		Username: "ghouse",
+		Groups:   []string{"physicians"},
+	}
+
+	t.Run("provisions user on first login", func(t *testing.T) {
+		idp.SetUser(user)
+
+		resp := ssoLogin(t)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var token schemas.TokenResponse
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&token))
+		assert.Equal(t, string(models.Doctor), token.UserType)
+
+		resp = testutils.MakeRequest(t, ts, http.MethodGet, "/api/v1/me", nil, token.AccessToken)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var me models.User
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&me))
+		assert.Equal(t, "ghouse", me.Username)
+		assert.Equal(t, "house@hospital.example", me.Email)
+		assert.Equal(t, models.OIDCProvider, me.AuthProvider)
+
+		// Provisioned users have no local password
+		resp = testutils.MakeRequest(t, ts, http.MethodPost, "/api/v1/login", schemas.UserLogin{
+			Username: "ghouse",
+			Password: "!",
+		}, "")
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	})
+
+	t.Run("syncs role on later logins", func(t *testing.T) {
+		user.Groups = []string{"front-desk"}
+		idp.SetUser(user)
+
+		resp := ssoLogin(t)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var token schemas.TokenResponse
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&token))
+		assert.Equal(t, string(models.Receptionist), token.UserType)
+	})
+
+	t.Run("rejects users without a mapped group", func(t *testing.T) {
+		idp.SetUser(testutils.MockOIDCUser{
+			Subject: "sso-subject-2",
+			Email:   "visitor@hospital.example",
+			Groups:  []string{"visitors"},
+		})
+
+		assert.Equal(t, http.StatusForbidden, ssoLogin(t).StatusCode)
+	})
+
+	t.Run("does not take over local accounts", func(t *testing.T) {
+		localID := testutils.CreateTestUser(t, ts, models.Doctor)
+		local, err := ts.App.Repo.Users.FindByID(t.Context(), localID)
+		require.NoError(t, err)
+
+		idp.SetUser(testutils.MockOIDCUser{
+			Subject: "sso-subject-3",
+			Email:   local.Email,
+			Groups:  []string{"physicians"},
+		})
+
+		assert.Equal(t, http.StatusConflict, ssoLogin(t).StatusCode)
+	})
+
+	t.Run("rejects forged state", func(t *testing.T) {
+		resp, err := http.Get(ts.TestServer.URL + "/api/v1/auth/oidc/callback?code=abc&state=forged")
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+}
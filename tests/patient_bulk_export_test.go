@@ -0,0 +1,172 @@
+package tests
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yhwbach/makerble/internal/config"
+	"github.com/yhwbach/makerble/internal/fhir"
+	"github.com/yhwbach/makerble/internal/models"
+	"github.com/yhwbach/makerble/internal/schemas"
+	"github.com/yhwbach/makerble/internal/testutils"
+)
+
+// kickOffExport requests a FHIR bulk export of patients with the query given
+func kickOffExport(t *testing.T, ts *testutils.TestServer, token, query string) *http.Response {
+	req, err := http.NewRequest(http.MethodGet, ts.TestServer.URL+"/fhir/Patient/$export?"+query, nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", fhir.ContentType)
+	req.Header.Set("Prefer", "respond-async")
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	return resp
+}
+
+func TestPatientBulkExport(t *testing.T) {
+	ts := testutils.NewTestServer(t, func(cfg *config.Config) {
+		cfg.Export.AuditBatchSize = 2
+	})
+	defer ts.Close()
+
+	adminID := testutils.CreateTestUser(t, ts, models.Admin)
+	adminToken := testutils.GenerateTestToken(t, ts, adminID, string(models.Admin))
+	doctorToken := testutils.GenerateTestToken(t, ts, testutils.CreateTestUser(t, ts, models.Doctor), string(models.Doctor))
+
+	for _, patient := range []schemas.PatientCreate{
+		{FullName: "Ann Poe", DateOfBirth: "1995-05-05", Gender: models.Female},
+		{FullName: "Jane Doe", DateOfBirth: "1990-04-12", Gender: models.Female, Email: "jane@example.com", MedicalHistory: "Asthma"},
+		{FullName: "Sam Roe", DateOfBirth: "1980-01-01", Gender: models.Male},
+		{FullName: "Kim Lee", DateOfBirth: "2001-02-03", Gender: models.Female},
+	} {
+		resp := testutils.MakeRequest(t, ts, http.MethodPost, "/api/v1/patients", patient, doctorToken)
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+		// Ann Poe does not consent to data sharing and is never exported
+		if patient.FullName != "Ann Poe" {
+			var created schemas.PatientCreateResponse
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&created))
+			giveConsent(t, ts, doctorToken, created.PatientID, models.ConsentDataSharing)
+		}
+	}
+
+	t.Run("requires patient.bulk_export", func(t *testing.T) {
+		resp := testutils.MakeRequest(t, ts, http.MethodGet, "/api/v1/patients/export", nil, doctorToken)
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+		resp = kickOffExport(t, ts, doctorToken, "")
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	})
+
+	t.Run("csv", func(t *testing.T) {
+		resp := testutils.MakeRequest(t, ts, http.MethodGet, "/api/v1/patients/export?format=csv", nil, adminToken)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "text/csv; charset=utf-8", resp.Header.Get("Content-Type"))
+		assert.Contains(t, resp.Header.Get("Content-Disposition"), "attachment")
+
+		records, err := csv.NewReader(resp.Body).ReadAll()
+		require.NoError(t, err)
+		require.Len(t, records, 4)
+		assert.NotContains(t, records[0], "medical_history", "admins do not read clinical fields")
+		assert.Equal(t, "Kim Lee", records[1][1], "the newest patients come first")
+		for _, record := range records {
+			assert.NotContains(t, record, "Ann Poe")
+		}
+	})
+
+	t.Run("ndjson", func(t *testing.T) {
+		resp := testutils.MakeRequest(t, ts, http.MethodGet, "/api/v1/patients/export?format=ndjson", nil, adminToken)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "application/x-ndjson", resp.Header.Get("Content-Type"))
+
+		var names []string
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			var patient schemas.Patients
+			require.NoError(t, json.Unmarshal(scanner.Bytes(), &patient))
+			assert.Empty(t, patient.MedicalHistory)
+			names = append(names, patient.FullName)
+		}
+		require.NoError(t, scanner.Err())
+		assert.ElementsMatch(t, []string{"Jane Doe", "Sam Roe", "Kim Lee"}, names, "patients without data sharing consent are left out")
+
+		events, err := ts.App.Repo.Audit.List(context.Background(), schemas.AuditQuery{Action: models.AuditActionPatientBulkExport})
+		require.NoError(t, err)
+		var exported []interface{}
+		for _, event := range events {
+			if event.Details["format"] != "ndjson" {
+				continue
+			}
+			patientIDs, _ := event.Details["patient_ids"].([]interface{})
+			assert.LessOrEqual(t, len(patientIDs), 2, "exports are recorded in batches")
+			assert.EqualValues(t, 3, event.Details["total"])
+			exported = append(exported, patientIDs...)
+		}
+		assert.Len(t, exported, 3)
+	})
+
+	t.Run("rejects invalid parameters", func(t *testing.T) {
+		resp := testutils.MakeRequest(t, ts, http.MethodGet, "/api/v1/patients/export?format=xml", nil, adminToken)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+		resp = testutils.MakeRequest(t, ts, http.MethodGet, "/api/v1/patients/export?updated_since=yesterday", nil, adminToken)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+		resp = testutils.MakeRequest(t, ts, http.MethodGet, "/fhir/Patient/$export", nil, adminToken)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "the Prefer header is required")
+
+		resp = kickOffExport(t, ts, adminToken, "_type=Observation")
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+
+	t.Run("fhir bulk export", func(t *testing.T) {
+		resp := kickOffExport(t, ts, adminToken, "_typeFilter="+url.QueryEscape("Patient?gender=female"))
+		require.Equal(t, http.StatusAccepted, resp.StatusCode)
+		status := resp.Header.Get("Content-Location")
+		require.True(t, strings.HasPrefix(status, ts.TestServer.URL+"/fhir/bulk/"), status)
+		statusPath := strings.TrimPrefix(status, ts.TestServer.URL)
+
+		ts.App.Exports.Wait()
+
+		resp = testutils.MakeRequest(t, ts, http.MethodGet, statusPath, nil, doctorToken)
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+		resp = testutils.MakeRequest(t, ts, http.MethodGet, statusPath, nil, adminToken)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var manifest fhir.ExportManifest
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&manifest))
+		assert.True(t, manifest.RequiresAccessToken)
+		require.Len(t, manifest.Output, 1)
+		assert.Equal(t, "Patient", manifest.Output[0].Type)
+		assert.Equal(t, 2, manifest.Output[0].Count)
+
+		resp = testutils.MakeRequest(t, ts, http.MethodGet, strings.TrimPrefix(manifest.Output[0].URL, ts.TestServer.URL), nil, adminToken)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, fhir.NDJSONContentType, resp.Header.Get("Content-Type"))
+		var names []string
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			var patient fhir.Patient
+			require.NoError(t, json.Unmarshal(scanner.Bytes(), &patient))
+			assert.Equal(t, "Patient", patient.ResourceType)
+			names = append(names, patient.Name[0].Text)
+		}
+		require.NoError(t, scanner.Err())
+		assert.ElementsMatch(t, []string{"Jane Doe", "Kim Lee"}, names, "patients without data sharing consent are left out")
+
+		resp = testutils.MakeRequest(t, ts, http.MethodDelete, statusPath, nil, adminToken)
+		assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+
+		resp = testutils.MakeRequest(t, ts, http.MethodGet, statusPath, nil, adminToken)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+}
@@ -0,0 +1,187 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yhwbach/makerble/internal/config"
+	"github.com/yhwbach/makerble/internal/models"
+	"github.com/yhwbach/makerble/internal/schemas"
+	"github.com/yhwbach/makerble/internal/testutils"
+)
+
+// uploadImport posts an import file with the form fields given
+func uploadImport(t *testing.T, ts *testutils.TestServer, token, filename, content string, fields map[string]string) *http.Response {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	for name, value := range fields {
+		require.NoError(t, form.WriteField(name, value))
+	}
+	file, err := form.CreateFormFile("file", filename)
+	require.NoError(t, err)
+	_, err = file.Write([]byte(content))
+	require.NoError(t, err)
+	require.NoError(t, form.Close())
+
+	req, err := http.NewRequest(http.MethodPost, ts.TestServer.URL+"/api/v1/patients/imports", &body)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	return resp
+}
+
+func TestPatientImport(t *testing.T) {
+	for name, rowLevelSecurity := range map[string]bool{"filters": false, "row-level security": true} {
+		t.Run(name, func(t *testing.T) {
+			ts := testutils.NewTestServer(t, func(cfg *config.Config) {
+				cfg.Database.RowLevelSecurity = rowLevelSecurity
+				cfg.Import.MaxFileMB = 1
+			})
+			defer ts.Close()
+
+			adminID := testutils.CreateTestUser(t, ts, models.Admin)
+			adminToken := testutils.GenerateTestToken(t, ts, adminID, string(models.Admin))
+			receptionistID := testutils.CreateTestUser(t, ts, models.Receptionist)
+			receptionistToken := testutils.GenerateTestToken(t, ts, receptionistID, string(models.Receptionist))
+
+			resp := testutils.MakeRequest(t, ts, http.MethodPost, "/api/v1/patients", schemas.PatientCreate{
+				FullName: "Existing Patient", DateOfBirth: "1970-01-01", Gender: models.Male, Email: "existing@example.com",
+			}, testutils.GenerateTestToken(t, ts, testutils.CreateTestUser(t, ts, models.Doctor), string(models.Doctor)))
+			require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+			file := "Full Name,Date of Birth,Sex,Email,Phone,Notes\n" +
+				"Jane Doe,1990-04-12,F,jane@example.com,555-0100,\n" +
+				"Sam Roe,1980-01-01,male,,,\n" +
+				"Bad Date,12/04/1990,m,not-an-email,,\n" +
+				"Jane Doe,1990-04-12,female,,,\n" +
+				"Someone,1975-05-05,m,EXISTING@example.com,,\n"
+
+			t.Run("requires patient.import", func(t *testing.T) {
+				resp := uploadImport(t, ts, receptionistToken, "patients.csv", file, nil)
+				assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+			})
+
+			t.Run("rejects files it cannot import", func(t *testing.T) {
+				resp := uploadImport(t, ts, adminToken, "patients.ods", file, nil)
+				assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+				resp = uploadImport(t, ts, adminToken, "patients.csv", "Name,Email\nJane Doe,jane@example.com\n", nil)
+				require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+				var validation struct{ Errors []string }
+				require.NoError(t, json.NewDecoder(resp.Body).Decode(&validation))
+				assert.Equal(t, []string{"no column for date_of_birth", "no column for gender"}, validation.Errors)
+
+				resp = uploadImport(t, ts, adminToken, "patients.csv", file, map[string]string{"mapping": `{"Notes": "medical_history"}`})
+				assert.Equal(t, http.StatusForbidden, resp.StatusCode, "admins may not write clinical fields")
+
+				resp = uploadImport(t, ts, adminToken, "patients.csv", string(bytes.Repeat([]byte("x"), 2<<20)), nil)
+				assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+			})
+
+			t.Run("imports valid rows and reports the others", func(t *testing.T) {
+				resp := uploadImport(t, ts, adminToken, "patients.csv", file, nil)
+				require.Equal(t, http.StatusAccepted, resp.StatusCode)
+				var started models.PatientImport
+				require.NoError(t, json.NewDecoder(resp.Body).Decode(&started))
+				assert.Equal(t, "/api/v1/patients/imports/"+started.ID.String(), resp.Header.Get("Location"))
+				assert.Equal(t, 5, started.TotalRows)
+
+				ts.App.Imports.Wait()
+
+				resp = testutils.MakeRequest(t, ts, http.MethodGet, "/api/v1/patients/imports/"+started.ID.String(), nil, adminToken)
+				require.Equal(t, http.StatusOK, resp.StatusCode)
+				var finished models.PatientImport
+				require.NoError(t, json.NewDecoder(resp.Body).Decode(&finished))
+				assert.Equal(t, models.PatientImportCompleted, finished.Status)
+				assert.Equal(t, 2, finished.ImportedRows)
+				assert.Equal(t, 1, finished.InvalidRows)
+				assert.Equal(t, 2, finished.DuplicateRows)
+
+				resp = testutils.MakeRequest(t, ts, http.MethodGet, "/api/v1/patients/imports/"+started.ID.String()+"/report", nil, adminToken)
+				require.Equal(t, http.StatusOK, resp.StatusCode)
+				assert.Equal(t, "text/csv; charset=utf-8", resp.Header.Get("Content-Type"))
+				report, err := csv.NewReader(resp.Body).ReadAll()
+				require.NoError(t, err)
+				require.Len(t, report, 5)
+				assert.Equal(t, []string{"row", "field", "error"}, report[0])
+				assert.Equal(t, []string{"4", "date_of_birth", "Date of birth must be a date in the format YYYY-MM-DD"}, report[1])
+				assert.Equal(t, []string{"4", "email", "Email must be a valid email address"}, report[2])
+				assert.Equal(t, []string{"5", "full_name", "Same name and date of birth as row 2"}, report[3])
+				assert.Equal(t, "6", report[4][0])
+				assert.Contains(t, report[4][2], "Email matches existing patient")
+
+				resp = testutils.MakeRequest(t, ts, http.MethodGet, "/api/v1/patients", nil, adminToken)
+				require.Equal(t, http.StatusOK, resp.StatusCode)
+				var list schemas.PatientListResponse
+				require.NoError(t, json.NewDecoder(resp.Body).Decode(&list))
+				var imported *models.Patient
+				for _, patient := range list.Patients {
+					if patient.FullName == "Jane Doe" {
+						imported = patient.Patient
+					}
+				}
+				require.NotNil(t, imported)
+				assert.Equal(t, "jane@example.com", imported.Email)
+				assert.Equal(t, "555-0100", imported.Phone)
+				assert.Equal(t, adminID, imported.RegisteredBy)
+
+				var leaked bool
+				err = ts.DB.DB.QueryRow(`SELECT email IS NOT NULL OR position('555-0100'::bytea in encrypted_phone) > 0
+					FROM patients WHERE id = $1`, imported.ID).Scan(&leaked)
+				require.NoError(t, err)
+				assert.False(t, leaked, "imported patients are encrypted")
+
+				events, err := ts.App.Repo.Audit.ListByPatient(context.Background(), imported.ID)
+				require.NoError(t, err)
+				var imports int
+				for _, event := range events {
+					if event.Action == models.AuditActionPatientImport {
+						imports++
+						assert.Equal(t, adminID, *event.ActorID)
+						assert.Equal(t, started.ID.String(), event.Details["import_id"])
+					}
+				}
+				assert.Equal(t, 1, imports)
+			})
+
+			t.Run("imports are scoped to the clinic", func(t *testing.T) {
+				clinic := &models.Clinic{Name: "Other clinic " + name}
+				require.NoError(t, ts.App.Repo.Clinics.Create(context.Background(), clinic))
+				otherID := testutils.CreateTestUserInClinic(t, ts, models.Admin, clinic.ID)
+				otherToken := testutils.GenerateTestToken(t, ts, otherID, string(models.Admin))
+
+				resp := testutils.MakeRequest(t, ts, http.MethodGet, "/api/v1/patients/imports", nil, otherToken)
+				require.Equal(t, http.StatusOK, resp.StatusCode)
+				var imports []models.PatientImport
+				require.NoError(t, json.NewDecoder(resp.Body).Decode(&imports))
+				assert.Empty(t, imports)
+
+				resp = uploadImport(t, ts, otherToken, "patients.csv", "name,dob,gender,email\nJane Doe,1990-04-12,f,jane@example.com\n", nil)
+				require.Equal(t, http.StatusAccepted, resp.StatusCode)
+				var started models.PatientImport
+				require.NoError(t, json.NewDecoder(resp.Body).Decode(&started))
+				ts.App.Imports.Wait()
+
+				resp = testutils.MakeRequest(t, ts, http.MethodGet, "/api/v1/patients/imports/"+started.ID.String(), nil, adminToken)
+				assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+				resp = testutils.MakeRequest(t, ts, http.MethodGet, "/api/v1/patients/imports/"+started.ID.String(), nil, otherToken)
+				require.Equal(t, http.StatusOK, resp.StatusCode)
+				var finished models.PatientImport
+				require.NoError(t, json.NewDecoder(resp.Body).Decode(&finished))
+				assert.Equal(t, 0, finished.ImportedRows)
+				assert.Equal(t, 1, finished.DuplicateRows, "emails are unique across clinics")
+			})
+		})
+	}
+}
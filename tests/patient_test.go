@@ -6,7 +6,6 @@ import (
 	"testing"
 	"time"
 
-	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/yhwbach/makerble/internal/models"
@@ -19,8 +18,8 @@ func TestCreatePatientHandler(t *testing.T) {
 	defer ts.Close()
 
 	// Create a test user first
-	userID := uuid.New()
-	token := testutils.GenerateTestToken(t, userID, string(models.Receptionist), ts.App.JWTManager)
+	userID := testutils.CreateTestUser(t, ts, models.Receptionist)
+	token := testutils.GenerateTestToken(t, ts, userID, string(models.Receptionist))
 
 	tests := []struct {
 		name         string
@@ -67,3 +66,74 @@ func TestCreatePatientHandler(t *testing.T) {
 	}
 }
 
+
+func TestClinicalFieldAccess(t *testing.T) {
+	ts := testutils.NewTestServer(t)
+	defer ts.Close()
+
+	doctorID := testutils.CreateTestUser(t, ts, models.Doctor)
+	doctorToken := testutils.GenerateTestToken(t, ts, doctorID, string(models.Doctor))
+	receptionistID := testutils.CreateTestUser(t, ts, models.Receptionist)
+	receptionistToken := testutils.GenerateTestToken(t, ts, receptionistID, string(models.Receptionist))
+
+	resp := testutils.MakeRequest(t, ts, http.MethodPost, "/api/v1/patients", schemas.PatientCreate{
+		FullName:       "Jane Doe",
+		DateOfBirth:    "1990-04-12",
+		Gender:         models.Female,
+		Email:          "jane@example.com",
+		MedicalHistory: "Penicillin allergy",
+	}, doctorToken)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	var created schemas.PatientCreateResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&created))
+
+	getPatient := func(token string) models.Patient {
+		resp := testutils.MakeRequest(t, ts, http.MethodGet, "/api/v1/patients/"+created.PatientID, nil, token)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var patient models.Patient
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&patient))
+		return patient
+	}
+
+	t.Run("clinical fields are redacted for receptionists", func(t *testing.T) {
+		patient := getPatient(receptionistToken)
+		assert.Empty(t, patient.MedicalHistory)
+		assert.Equal(t, []string{"medical_history"}, patient.RedactedFields)
+
+		resp := testutils.MakeRequest(t, ts, http.MethodGet, "/api/v1/patients", nil, receptionistToken)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var list schemas.PatientListResponse
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&list))
+		for _, p := range list.Patients {
+			assert.Empty(t, p.MedicalHistory)
+		}
+	})
+
+	t.Run("doctors read clinical fields", func(t *testing.T) {
+		patient := getPatient(doctorToken)
+		assert.Equal(t, "Penicillin allergy", patient.MedicalHistory)
+		assert.Empty(t, patient.RedactedFields)
+	})
+
+	t.Run("receptionists cannot write clinical fields", func(t *testing.T) {
+		history := "Overwritten"
+		phone := "555-0100"
+		resp := testutils.MakeRequest(t, ts, http.MethodPut, "/api/v1/patients/"+created.PatientID, schemas.PatientUpdate{
+			Phone:          &phone,
+			MedicalHistory: &history,
+		}, receptionistToken)
+		require.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+		var body map[string]interface{}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+		assert.Equal(t, []interface{}{"medical_history"}, body["errors"])
+
+		// Nothing was changed
+		patient := getPatient(doctorToken)
+		assert.Equal(t, "Penicillin allergy", patient.MedicalHistory)
+		assert.NotEqual(t, phone, patient.Phone)
+	})
+}
@@ -0,0 +1,119 @@
+package tests
+
+import (
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yhwbach/makerble/internal/config"
+	"github.com/yhwbach/makerble/internal/models"
+	"github.com/yhwbach/makerble/internal/schemas"
+	"github.com/yhwbach/makerble/internal/testutils"
+)
+
+func TestResearchExport(t *testing.T) {
+	t.Run("disabled without a pseudonym key", func(t *testing.T) {
+		ts := testutils.NewTestServer(t)
+		defer ts.Close()
+
+		adminID := testutils.CreateTestUser(t, ts, models.Admin)
+		adminToken := testutils.GenerateTestToken(t, ts, adminID, string(models.Admin))
+
+		resp := testutils.MakeRequest(t, ts, http.MethodGet, "/api/v1/admin/research-export", nil, adminToken)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+
+	ts := testutils.NewTestServer(t, func(cfg *config.Config) {
+		cfg.Research.PseudonymKey = base64.StdEncoding.EncodeToString([]byte("research-pseudonym-key-32-bytes!"))
+	})
+	defer ts.Close()
+
+	doctorID := testutils.CreateTestUser(t, ts, models.Doctor)
+	doctorToken := testutils.GenerateTestToken(t, ts, doctorID, string(models.Doctor))
+	adminID := testutils.CreateTestUser(t, ts, models.Admin)
+	adminToken := testutils.GenerateTestToken(t, ts, adminID, string(models.Admin))
+
+	createPatient := func(patient schemas.PatientCreate) string {
+		resp := testutils.MakeRequest(t, ts, http.MethodPost, "/api/v1/patients", patient, doctorToken)
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+		var created schemas.PatientCreateResponse
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&created))
+		return created.PatientID
+	}
+
+	consenting := createPatient(schemas.PatientCreate{
+		FullName:       "Jane Doe",
+		DateOfBirth:    "1990-04-12",
+		Gender:         models.Female,
+		Address:        "12 Main Street, Springfield, IL 62704",
+		Phone:          "+1 555 0100",
+		Email:          "jane@example.com",
+		MedicalHistory: "Asthma",
+	})
+	createPatient(schemas.PatientCreate{
+		FullName:    "John Roe",
+		DateOfBirth: "1985-09-30",
+		Gender:      models.Male,
+		Email:       "john@example.com",
+	})
+
+	resp := testutils.MakeRequest(t, ts, http.MethodPost, "/api/v1/patients/"+consenting+"/consents", schemas.ConsentCreate{
+		Type: models.ConsentResearch,
+	}, doctorToken)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	t.Run("requires research.export", func(t *testing.T) {
+		resp := testutils.MakeRequest(t, ts, http.MethodGet, "/api/v1/admin/research-export", nil, doctorToken)
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	})
+
+	t.Run("invalid format", func(t *testing.T) {
+		resp := testutils.MakeRequest(t, ts, http.MethodGet, "/api/v1/admin/research-export?format=xlsx", nil, adminToken)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+
+	t.Run("csv", func(t *testing.T) {
+		resp := testutils.MakeRequest(t, ts, http.MethodGet, "/api/v1/admin/research-export", nil, adminToken)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "text/csv; charset=utf-8", resp.Header.Get("Content-Type"))
+
+		rows, err := csv.NewReader(resp.Body).ReadAll()
+		require.NoError(t, err)
+		require.Len(t, rows, 2, "only patients consenting to research are exported")
+		assert.Equal(t, ts.App.Research.Header(), rows[0])
+		assert.NotContains(t, rows[0], "medical_history", "medical history is left out by default")
+
+		row := strings.Join(rows[1], ",")
+		for _, identifier := range []string{consenting, "Jane Doe", "Main Street", "555 0100", "jane@example.com", "1990-04-12"} {
+			assert.NotContains(t, row, identifier)
+		}
+		assert.Equal(t, "627", rows[1][4])
+		assert.NotContains(t, row, "Asthma")
+
+		resp = testutils.MakeRequest(t, ts, http.MethodGet, "/api/v1/admin/research-export", nil, adminToken)
+		again, err := csv.NewReader(resp.Body).ReadAll()
+		require.NoError(t, err)
+		assert.Equal(t, rows, again, "pseudonyms and date shifts are stable")
+	})
+
+	t.Run("parquet", func(t *testing.T) {
+		resp := testutils.MakeRequest(t, ts, http.MethodGet, "/api/v1/admin/research-export?format=parquet", nil, adminToken)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "application/vnd.apache.parquet", resp.Header.Get("Content-Type"))
+	})
+
+	t.Run("exports are audited", func(t *testing.T) {
+		resp := testutils.MakeRequest(t, ts, http.MethodGet, "/api/v1/audit?action=patient.research_export&patient_id="+consenting, nil, adminToken)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var events []models.AuditEvent
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&events))
+		assert.Len(t, events, 3)
+	})
+}
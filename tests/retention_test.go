@@ -0,0 +1,128 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yhwbach/makerble/internal/config"
+	"github.com/yhwbach/makerble/internal/models"
+	"github.com/yhwbach/makerble/internal/retention"
+	"github.com/yhwbach/makerble/internal/schemas"
+	"github.com/yhwbach/makerble/internal/testutils"
+)
+
+func TestRetention(t *testing.T) {
+	ts := testutils.NewTestServer(t)
+	defer ts.Close()
+
+	doctorID := testutils.CreateTestUser(t, ts, models.Doctor)
+	doctorToken := testutils.GenerateTestToken(t, ts, doctorID, string(models.Doctor))
+	adminID := testutils.CreateTestUser(t, ts, models.Admin)
+	adminToken := testutils.GenerateTestToken(t, ts, adminID, string(models.Admin))
+
+	resp := testutils.MakeRequest(t, ts, http.MethodPost, "/api/v1/patients", schemas.PatientCreate{
+		FullName:    "Jane Doe",
+		DateOfBirth: "1990-04-12",
+		Gender:      models.Female,
+		Email:       "jane@example.com",
+	}, doctorToken)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	var created schemas.PatientCreateResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&created))
+	patientPath := "/api/v1/patients/" + created.PatientID
+
+	ctx := context.Background()
+	scheduler := retention.NewScheduler(config.RetentionConfig{
+		InactivePatientYears: 10,
+		AuditLogYears:        config.MinAuditLogYears,
+	}, ts.App.Repo)
+	// Run the policies as if eleven years had passed
+	scheduler.Now = func() time.Time { return time.Now().AddDate(11, 0, 0) }
+
+	counts := func(run *models.RetentionRun) map[string]int64 {
+		counts := map[string]int64{}
+		for _, result := range run.Results {
+			assert.Empty(t, result.Error)
+			counts[result.Policy] = result.Count
+		}
+		return counts
+	}
+
+	listPatients := func() []models.Patient {
+		resp := testutils.MakeRequest(t, ts, http.MethodGet, "/api/v1/patients", nil, doctorToken)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var patients []models.Patient
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&patients))
+		return patients
+	}
+
+	t.Run("dry run", func(t *testing.T) {
+		run := scheduler.RunOnce(ctx, true)
+		assert.False(t, run.Failed())
+
+		result := counts(run)
+		assert.EqualValues(t, 1, result[models.RetentionInactivePatients])
+		assert.Positive(t, result[models.RetentionAuditLog])
+		assert.Positive(t, result[models.RetentionExpiredSessions], "the test sessions expire within eleven years")
+
+		verification, err := ts.App.Repo.Audit.Verify(ctx)
+		require.NoError(t, err)
+		assert.Nil(t, verification.PurgedThrough, "dry runs change nothing")
+	})
+
+	t.Run("run", func(t *testing.T) {
+		// Sessions expire by then, so keep them out of this run
+		scheduler.Policies = scheduler.Policies[2:]
+
+		run := scheduler.RunOnce(ctx, false)
+		assert.False(t, run.Failed())
+		assert.EqualValues(t, 1, counts(run)[models.RetentionInactivePatients])
+	})
+
+	t.Run("archived patients", func(t *testing.T) {
+		assert.Empty(t, listPatients(), "archived patients are left out of lists")
+
+		resp := testutils.MakeRequest(t, ts, http.MethodGet, patientPath, nil, doctorToken)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var patient models.Patient
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&patient))
+		assert.NotNil(t, patient.ArchivedAt)
+
+		phone := "+1 555 0100"
+		resp = testutils.MakeRequest(t, ts, http.MethodPatch, patientPath, schemas.PatientUpdate{Phone: &phone}, doctorToken)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Len(t, listPatients(), 1, "updating a patient reactivates them")
+	})
+
+	t.Run("purged audit log still verifies", func(t *testing.T) {
+		verification, err := ts.App.Repo.Audit.Verify(ctx)
+		require.NoError(t, err)
+		assert.True(t, verification.Valid())
+		require.NotNil(t, verification.PurgedThrough)
+
+		_, err = ts.DB.DB.Exec(`DELETE FROM audit_events`)
+		assert.Error(t, err, "only retention can delete audit events")
+	})
+
+	t.Run("reports", func(t *testing.T) {
+		resp := testutils.MakeRequest(t, ts, http.MethodGet, "/api/v1/admin/retention/runs", nil, doctorToken)
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+		resp = testutils.MakeRequest(t, ts, http.MethodGet, "/api/v1/admin/retention/runs?limit=1", nil, adminToken)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var runs []models.RetentionRun
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&runs))
+		require.Len(t, runs, 1)
+		assert.False(t, runs[0].DryRun)
+		assert.Len(t, runs[0].Results, 2)
+	})
+}
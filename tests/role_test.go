@@ -0,0 +1,92 @@
+package tests
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yhwbach/makerble/internal/models"
+	"github.com/yhwbach/makerble/internal/schemas"
+	"github.com/yhwbach/makerble/internal/testutils"
+)
+
+func TestCustomRoles(t *testing.T) {
+	ts := testutils.NewTestServer(t)
+	defer ts.Close()
+
+	adminID := testutils.CreateTestUser(t, ts, models.Admin)
+	adminToken := testutils.GenerateTestToken(t, ts, adminID, string(models.Admin))
+	operatorID := testutils.CreateTestUser(t, ts, models.Operator)
+	operatorToken := testutils.GenerateTestToken(t, ts, operatorID, string(models.Operator))
+
+	nurse := schemas.RoleCreate{
+		Name:        "nurse",
+		Description: "Reads patient records",
+		Permissions: []string{models.PermissionPatientRead},
+	}
+	resp := testutils.MakeRequest(t, ts, http.MethodPost, "/api/v1/admin/roles", nurse, adminToken)
+	require.Equal(t, http.StatusForbidden, resp.StatusCode, "roles apply to every clinic, so operators manage them")
+
+	resp = testutils.MakeRequest(t, ts, http.MethodPost, "/api/v1/admin/roles", nurse, operatorToken)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	nurseID := testutils.CreateTestUser(t, ts, "nurse")
+	nurseToken := testutils.GenerateTestToken(t, ts, nurseID, "nurse")
+
+	t.Run("permissions of the role apply", func(t *testing.T) {
+		resp := testutils.MakeRequest(t, ts, http.MethodGet, "/api/v1/patients", nil, nurseToken)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		resp = testutils.MakeRequest(t, ts, http.MethodDelete, "/api/v1/patients/"+nurseID.String(), nil, nurseToken)
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+		resp = testutils.MakeRequest(t, ts, http.MethodGet, "/api/v1/admin/users", nil, nurseToken)
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	})
+
+	t.Run("edits take effect", func(t *testing.T) {
+		resp := testutils.MakeRequest(t, ts, http.MethodPut, "/api/v1/admin/roles/nurse", schemas.RoleUpdate{
+			Permissions: []string{models.PermissionPatientRead, models.PermissionPatientDelete},
+		}, operatorToken)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		resp = testutils.MakeRequest(t, ts, http.MethodDelete, "/api/v1/patients/"+nurseID.String(), nil, nurseToken)
+		assert.NotEqual(t, http.StatusForbidden, resp.StatusCode)
+	})
+
+	t.Run("unknown permissions are rejected", func(t *testing.T) {
+		resp := testutils.MakeRequest(t, ts, http.MethodPut, "/api/v1/admin/roles/nurse", schemas.RoleUpdate{
+			Permissions: []string{"patient.everything"},
+		}, operatorToken)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+
+	t.Run("operators keep role management", func(t *testing.T) {
+		resp := testutils.MakeRequest(t, ts, http.MethodPut, "/api/v1/admin/roles/operator", schemas.RoleUpdate{
+			Permissions: []string{models.PermissionUserManage},
+		}, operatorToken)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+
+	t.Run("roles in use cannot be deleted", func(t *testing.T) {
+		resp := testutils.MakeRequest(t, ts, http.MethodDelete, "/api/v1/admin/roles/nurse", nil, operatorToken)
+		assert.Equal(t, http.StatusConflict, resp.StatusCode)
+
+		resp = testutils.MakeRequest(t, ts, http.MethodDelete, "/api/v1/admin/roles/doctor", nil, operatorToken)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+
+	t.Run("custom roles can be assigned", func(t *testing.T) {
+		doctorID := testutils.CreateTestUser(t, ts, models.Doctor)
+		resp := testutils.MakeRequest(t, ts, http.MethodPut, "/api/v1/admin/users/"+doctorID.String()+"/role", schemas.RoleChange{
+			UserType: "nurse",
+		}, adminToken)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		resp = testutils.MakeRequest(t, ts, http.MethodPut, "/api/v1/admin/users/"+doctorID.String()+"/role", schemas.RoleChange{
+			UserType: "surgeon",
+		}, adminToken)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+}
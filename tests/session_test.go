@@ -0,0 +1,103 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yhwbach/makerble/internal/models"
+	"github.com/yhwbach/makerble/internal/schemas"
+	"github.com/yhwbach/makerble/internal/testutils"
+)
+
+func TestSessions(t *testing.T) {
+	ts := testutils.NewTestServer(t)
+	defer ts.Close()
+
+	userID := testutils.CreateTestUser(t, ts, models.Doctor)
+	otherToken := testutils.GenerateTestToken(t, ts, testutils.CreateTestUser(t, ts, models.Doctor), string(models.Doctor))
+
+	listSessions := func(t *testing.T, token string) []schemas.SessionResponse {
+		resp := testutils.MakeRequest(t, ts, http.MethodGet, "/api/v1/sessions", nil, token)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var sessions []schemas.SessionResponse
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&sessions))
+		return sessions
+	}
+	currentSession := func(t *testing.T, token string) uuid.UUID {
+		for _, session := range listSessions(t, token) {
+			if session.Current {
+				return session.ID
+			}
+		}
+		t.Fatal("no current session")
+		return uuid.Nil
+	}
+
+	t.Run("list", func(t *testing.T) {
+		token := testutils.GenerateTestToken(t, ts, userID, string(models.Doctor))
+		testutils.GenerateTestToken(t, ts, userID, string(models.Doctor))
+
+		sessions := listSessions(t, token)
+		require.Len(t, sessions, 2)
+		current := 0
+		for _, session := range sessions {
+			assert.Equal(t, userID, session.UserID)
+			if session.Current {
+				current++
+			}
+		}
+		assert.Equal(t, 1, current, "only the session making the request is current")
+	})
+
+	t.Run("revoke one", func(t *testing.T) {
+		token := testutils.GenerateTestToken(t, ts, userID, string(models.Doctor))
+		revokedToken := testutils.GenerateTestToken(t, ts, userID, string(models.Doctor))
+		revokedID := currentSession(t, revokedToken)
+
+		resp := testutils.MakeRequest(t, ts, http.MethodDelete, "/api/v1/sessions/"+revokedID.String(), nil, otherToken)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode, "sessions of other users are not found")
+
+		resp = testutils.MakeRequest(t, ts, http.MethodDelete, "/api/v1/sessions/"+revokedID.String(), nil, token)
+		require.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+		resp = testutils.MakeRequest(t, ts, http.MethodGet, "/api/v1/me", nil, revokedToken)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode, "the token of a revoked session is rejected")
+
+		resp = testutils.MakeRequest(t, ts, http.MethodGet, "/api/v1/me", nil, token)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		resp = testutils.MakeRequest(t, ts, http.MethodDelete, "/api/v1/sessions/"+uuid.NewString(), nil, token)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+
+	t.Run("revoke all but the current one", func(t *testing.T) {
+		token := testutils.GenerateTestToken(t, ts, userID, string(models.Doctor))
+		otherDevice := testutils.GenerateTestToken(t, ts, userID, string(models.Doctor))
+
+		resp := testutils.MakeRequest(t, ts, http.MethodDelete, "/api/v1/sessions?keep_current=true", nil, token)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var revoked schemas.SessionsRevokedResponse
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&revoked))
+		assert.Positive(t, revoked.Revoked)
+
+		resp = testutils.MakeRequest(t, ts, http.MethodGet, "/api/v1/me", nil, otherDevice)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+		sessions := listSessions(t, token)
+		require.Len(t, sessions, 1, "the current session is kept")
+		assert.True(t, sessions[0].Current)
+
+		resp = testutils.MakeRequest(t, ts, http.MethodDelete, "/api/v1/sessions", nil, token)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		resp = testutils.MakeRequest(t, ts, http.MethodGet, "/api/v1/me", nil, token)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode, "without keep_current the current session goes too")
+	})
+
+	resp := testutils.MakeRequest(t, ts, http.MethodGet, "/api/v1/me", nil, otherToken)
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "sessions of other users are left alone")
+}